@@ -1,8 +1,19 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
 	"github.com/charmbracelet/log"
 	"github.com/vieitesss/ticketer/internal/app"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/services/importer"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
 	"github.com/vieitesss/ticketer/pkg/logger"
 )
 
@@ -10,12 +21,148 @@ func main() {
 	// Initialize logger
 	logger.Init()
 
+	// The command defaults to serving the HTTP API
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
 	application, err := app.New()
 	if err != nil {
 		log.Fatal("Failed to initialize application", "error", err)
 	}
 
-	if err := application.Run(); err != nil {
-		log.Fatal("Application error", "error", err)
+	switch command {
+	case "serve":
+		if err := application.Run(); err != nil {
+			log.Fatal("Application error", "error", err)
+		}
+	case "watch":
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		defer application.Close()
+
+		if err := application.Watch(ctx); err != nil {
+			log.Fatal("Watcher error", "error", err)
+		}
+	case "email":
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		defer application.Close()
+
+		if err := application.Email(ctx); err != nil {
+			log.Fatal("Email ingester error", "error", err)
+		}
+	case "import":
+		ok := runImport(application, os.Args[2:])
+		application.Close()
+		if !ok {
+			os.Exit(1)
+		}
+	case "token":
+		ok := runToken(application, os.Args[2:])
+		application.Close()
+		if !ok {
+			os.Exit(1)
+		}
+	default:
+		log.Fatal("Unknown command (expected serve, watch, email, import or token)", "command", command)
+	}
+}
+
+// runImport imports the files given on the command line and prints a JSON
+// report per file. It returns whether every file was imported.
+//
+//	ticketer import [-dry-run] [-format csv|json] [-mapping spec.json] [-owner name] [-household id] FILE...
+func runImport(application *app.App, args []string) bool {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would be imported without saving anything")
+	format := flags.String("format", "auto", "file format: csv, json (a ticketer backup) or auto, by extension")
+	mappingPath := flags.String("mapping", "", "JSON file with the column mapping of CSV files")
+	owner := flags.String("owner", "", "owner of the imported receipts")
+	household := flags.String("household", "", "household of the imported receipts, by default the only one the owner edits in")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		log.Error("No files to import", "usage", "ticketer import [-dry-run] [-format csv|json] [-mapping spec.json] [-owner name] [-household id] FILE...")
+		return false
+	}
+
+	opts := services.ImportOptions{
+		Format:      *format,
+		Mapping:     importer.DefaultMapping(),
+		Owner:       *owner,
+		DryRun:      *dryRun,
+		HouseholdID: *household,
+	}
+	if *mappingPath != "" {
+		spec, err := os.ReadFile(*mappingPath)
+		if err != nil {
+			log.Error("Failed to read mapping", "path", *mappingPath, "error", err)
+			return false
+		}
+		if opts.Mapping, err = importer.ParseMapping(spec); err != nil {
+			log.Error("Failed to read mapping", "path", *mappingPath, "error", err)
+			return false
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	reports, err := application.Import(ctx, flags.Args(), opts)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(reports); err != nil {
+		log.Error("Failed to write report", "error", err)
+		return false
+	}
+	if err != nil {
+		log.Error("Import failed", "error", err)
+		return false
+	}
+
+	ok := true
+	for _, report := range reports {
+		if report.Error != "" {
+			log.Error("File not imported", "file", report.File, "error", report.Error)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// runToken creates an API token and prints it, with its details, as JSON.
+// It is the way to get the first admin token, as creating tokens through the
+// API needs one.
+// It returns whether the token was created.
+//
+//	ticketer token -name NAME -owner USER [-scopes read,upload,write,admin] [-expires YYYY-MM-DD]
+func runToken(application *app.App, args []string) bool {
+	flags := flag.NewFlagSet("token", flag.ExitOnError)
+	name := flags.String("name", "", "name of the token")
+	owner := flags.String("owner", "", "user the token is for")
+	scopes := flags.String("scopes", "admin", "comma-separated scopes: read, upload, write and/or admin")
+	expires := flags.String("expires", "", "expiry, as YYYY-MM-DD or RFC 3339 (never expires when empty)")
+	flags.Parse(args)
+
+	req := dto.APITokenRequest{
+		Name:      *name,
+		Owner:     *owner,
+		Scopes:    strings.Split(*scopes, ","),
+		ExpiresAt: *expires,
+	}
+	token, err := application.CreateAPIToken(context.Background(), req)
+	if err != nil {
+		log.Error("Failed to create API token", "error", err)
+		return false
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(token); err != nil {
+		log.Error("Failed to write token", "error", err)
+		return false
 	}
+	return true
 }
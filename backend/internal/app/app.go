@@ -2,22 +2,37 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 
 	"github.com/charmbracelet/log"
 	"github.com/gofiber/fiber/v3"
 	"github.com/vieitesss/ticketer/internal/config"
 	"github.com/vieitesss/ticketer/internal/database"
 	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/services/ai"
+	"github.com/vieitesss/ticketer/internal/services/webhooks"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+	"github.com/vieitesss/ticketer/internal/transport/email"
+	"github.com/vieitesss/ticketer/internal/transport/hotfolder"
 	"github.com/vieitesss/ticketer/internal/transport/http"
 	"github.com/vieitesss/ticketer/internal/transport/http/handlers"
 	"github.com/vieitesss/ticketer/internal/transport/http/routers"
+	"google.golang.org/genai"
 )
 
+// errNoModelClient is returned when the model client cannot be created, as
+// when there are no credentials: the server then runs without an extractor
+var errNoModelClient = errors.New("failed to create model client")
+
 type App struct {
-	config *config.Config
-	db     database.ReceiptRepository
-	server *fiber.App
+	config         *config.Config
+	db             database.Repository
+	server         *fiber.App
+	receiptService *services.ReceiptService
+	webhookService *services.WebhookService
+	tokenService   *services.TokenService
 }
 
 func New() (*App, error) {
@@ -28,7 +43,7 @@ func New() (*App, error) {
 	ctx := context.Background()
 
 	// Initialize database (optional - only if DATABASE_URL is set)
-	var db database.ReceiptRepository
+	var db database.Repository
 	if cfg.DatabaseURL != "" {
 		var err error
 		db, err = database.NewPostgres(ctx, cfg.DatabaseURL)
@@ -40,30 +55,104 @@ func New() (*App, error) {
 		log.Warn("No DATABASE_URL provided, running without database")
 	}
 
-	// Initialize services
-	receiptService, err := services.NewReceiptService(ctx, db)
-	if err != nil {
-		log.Error("Failed to initialize receipt service", "error", err)
-		return nil, fmt.Errorf("Failed to initialize receipt service: %w", err)
+	// Initialize AI service (optional - without it only manual entry and browsing work).
+	// Only a missing client falls back to no extractor: misconfiguration is fatal.
+	var aiService *ai.GeminiService
+	switch cfg.AIProvider {
+	case "none":
+		log.Warn("AI provider disabled, running without extractor")
+	case "gemini":
+		var err error
+		aiService, err = newGeminiService(ctx, cfg)
+		if errors.Is(err, errNoModelClient) {
+			log.Warn("Failed to initialize AI service, running without extractor", "error", err)
+			aiService = nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to initialize AI service: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown AI_PROVIDER %q (expected gemini or none)", cfg.AIProvider)
 	}
 
-	// Create HTTP server
-	server := http.NewServer()
+	// Initialize services, sharing the queue of webhook events
+	var dispatcher *webhooks.Dispatcher
+	if db != nil {
+		dispatcher = webhooks.NewDispatcher(db, cfg)
+	}
+	budgetService := services.NewBudgetService(db, db, dispatcher, cfg)
+	receiptService := services.NewReceiptService(aiService, db, dispatcher, budgetService, cfg)
+	webhookService := services.NewWebhookService(db, dispatcher)
+	splitService := services.NewSplitService(db)
+	householdService := services.NewHouseholdService(db, receiptService)
+	tokenService := services.NewTokenService(db, cfg)
 
-	// Initialize HTTP handler
+	// Initialize HTTP handlers
 	receiptHandler := handlers.NewReceiptHandler(receiptService)
+	budgetHandler := handlers.NewBudgetHandler(budgetService)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	splitHandler := handlers.NewSplitHandler(splitService)
+	householdHandler := handlers.NewHouseholdHandler(householdService)
+	tokenHandler := handlers.NewTokenHandler(tokenService)
+
+	// Create HTTP server, authenticating every request
+	server := http.NewServer(tokenHandler.Authenticate)
 
 	// Setup routes
-	routers.NewReceiptRouter(server, receiptHandler)
+	routers.NewReceiptRouter(server, receiptHandler, householdHandler)
+	routers.NewBudgetRouter(server, budgetHandler)
+	routers.NewWebhookRouter(server, webhookHandler)
+	routers.NewSplitRouter(server, splitHandler, householdHandler)
+	routers.NewHouseholdRouter(server, householdHandler)
+	routers.NewTokenRouter(server, tokenHandler)
 
 	return &App{
-		config: cfg,
-		db:     db,
-		server: server,
+		config:         cfg,
+		db:             db,
+		server:         server,
+		receiptService: receiptService,
+		webhookService: webhookService,
+		tokenService:   tokenService,
 	}, nil
 }
 
+// newGeminiService creates the Gemini service, wrapping the model client in a
+// cassette recorder or replayer when AI_CASSETTE_MODE is set
+func newGeminiService(ctx context.Context, cfg *config.Config) (*ai.GeminiService, error) {
+	switch cfg.AICassetteMode {
+	case "":
+		service, err := ai.NewGeminiService(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errNoModelClient, err)
+		}
+		return service, nil
+	case "replay":
+		replayer, err := ai.NewReplayer(cfg.AICassettePath)
+		if err != nil {
+			return nil, err
+		}
+		log.Warn("Replaying model responses from cassette", "path", cfg.AICassettePath)
+		return ai.NewGeminiServiceWithClient(replayer, cfg), nil
+	case "record":
+		if cfg.AICassettePath == "" {
+			return nil, fmt.Errorf("AI_CASSETTE_PATH is required to record")
+		}
+		client, err := genai.NewClient(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create genai client: %w", err)
+		}
+		log.Warn("Recording model responses to cassette", "path", cfg.AICassettePath)
+		return ai.NewGeminiServiceWithClient(ai.NewRecorder(client.Models, cfg.AICassettePath), cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown AI_CASSETTE_MODE %q (expected record or replay)", cfg.AICassetteMode)
+	}
+}
+
 func (a *App) Run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.deliverWebhooks(ctx)
+	a.listenEvents(ctx)
+
 	log.Info("Server starting", "port", a.config.ServerPort)
 	if err := a.server.Listen(fmt.Sprint(":" + a.config.ServerPort)); err != nil {
 		return fmt.Errorf("server failed to start: %w", err)
@@ -71,6 +160,103 @@ func (a *App) Run() error {
 	return nil
 }
 
+// Watch runs the hot folder daemon until ctx is cancelled
+func (a *App) Watch(ctx context.Context) error {
+	if a.config.WatchDir == "" {
+		return fmt.Errorf("WATCH_DIR is required in watch mode")
+	}
+	if a.db == nil {
+		return fmt.Errorf("watch mode requires a database")
+	}
+
+	a.deliverWebhooks(ctx)
+	return hotfolder.NewWatcher(a.receiptService, a.config).Run(ctx)
+}
+
+// Email runs the email ingester until ctx is cancelled
+func (a *App) Email(ctx context.Context) error {
+	if a.config.EmailMaildir == "" {
+		return fmt.Errorf("EMAIL_MAILDIR is required in email mode")
+	}
+	if len(a.config.EmailSenders) == 0 {
+		return fmt.Errorf("EMAIL_SENDERS is required in email mode")
+	}
+	if a.db == nil {
+		return fmt.Errorf("email mode requires a database")
+	}
+
+	a.deliverWebhooks(ctx)
+	return email.NewIngester(a.receiptService, a.config).Run(ctx)
+}
+
+// deliverWebhooks delivers the queued webhook events in the background until
+// ctx is cancelled. Every long-running mode delivers them, so events reach
+// their webhooks whichever modes are deployed.
+func (a *App) deliverWebhooks(ctx context.Context) {
+	if a.db == nil {
+		return
+	}
+
+	go func() {
+		if err := a.webhookService.DeliverWebhooks(ctx); err != nil {
+			log.Error("Webhook dispatcher stopped", "error", err)
+		}
+	}()
+}
+
+// listenEvents fans out the events of every instance to the clients of the
+// event stream in the background until ctx is cancelled
+func (a *App) listenEvents(ctx context.Context) {
+	if a.db == nil {
+		return
+	}
+
+	go func() {
+		if err := a.receiptService.ListenEvents(ctx); err != nil {
+			log.Error("Event listener stopped", "error", err)
+		}
+	}()
+}
+
+// Import imports CSV files or JSON backups, each one all or nothing. A file
+// that cannot be read or imported is reported without stopping the others.
+func (a *App) Import(ctx context.Context, paths []string, opts services.ImportOptions) ([]*dto.ImportReport, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("import requires a database")
+	}
+
+	reports := make([]*dto.ImportReport, 0, len(paths))
+	for _, path := range paths {
+		report, err := a.importFile(ctx, path, opts)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// importFile imports a single file
+func (a *App) importFile(ctx context.Context, path string, opts services.ImportOptions) (*dto.ImportReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return &dto.ImportReport{File: path, DryRun: opts.DryRun, Error: err.Error(), Receipts: []dto.ImportedReceipt{}}, nil
+	}
+	defer file.Close()
+
+	return a.receiptService.ImportFile(ctx, path, file, opts)
+}
+
+// CreateAPIToken creates an API token, so the first one can be made when
+// the API already requires them
+func (a *App) CreateAPIToken(ctx context.Context, req dto.APITokenRequest) (*dto.APITokenResponse, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("API tokens require a database")
+	}
+
+	return a.tokenService.CreateAPIToken(ctx, req)
+}
+
 func (a *App) Close() {
 	if a.db != nil {
 		a.db.Close()
@@ -2,13 +2,113 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
 )
 
+// AIStageConfig configures the model call of a single extraction stage
+type AIStageConfig struct {
+	Model       string
+	Temperature *float32 // nil uses the model's default
+	Timeout     time.Duration
+	MaxRetries  int
+}
+
 type Config struct {
 	GeminiAPIKey string
 	LogLevel     string
 	ServerPort   string
 	DatabaseURL  string
+
+	// UploadsDir is where uploaded files are saved, and kept for saved receipts
+	UploadsDir string
+
+	// BatchConcurrency bounds how many files of a batch upload are processed at once
+	BatchConcurrency int
+
+	// Hot folder: the watch mode imports files dropped into WatchDir, looking
+	// for new ones every WatchPollInterval and once unmodified for
+	// WatchSettleTime, as receipts of WatchOwner
+	WatchDir          string
+	WatchPollInterval time.Duration
+	WatchSettleTime   time.Duration
+	WatchOwner        string
+
+	// Email: the email mode imports receipts from the messages of EmailMaildir,
+	// delivered there by an external MTA or by the embedded SMTP listener on
+	// EmailSMTPAddr. Only EmailSenders (address => user) are accepted.
+	EmailMaildir      string
+	EmailPollInterval time.Duration
+	EmailSMTPAddr     string
+	EmailMaxSizeMB    int
+	EmailSenders      map[string]string
+
+	// Replies with the processing outcome are sent through this SMTP relay
+	EmailReplySMTP     string
+	EmailReplyFrom     string
+	EmailReplyUsername string
+	EmailReplyPassword string
+
+	// Accounting exports (ledger, hledger, beancount): items are posted to the
+	// account of their category, else of their store, else
+	// AccountingDefaultAccount, and paid from AccountingPaymentAccount
+	AccountingPaymentAccount   string
+	AccountingDefaultAccount   string
+	AccountingDiscountAccount  string
+	AccountingCommodity        string
+	AccountingStoreAccounts    map[string]string
+	AccountingCategoryAccounts map[string]string
+
+	// Notifications (budget alerts) go through NotifyChannels, a
+	// comma-separated list of log, webhook and smtp
+	NotifyChannels     string
+	NotifyWebhookURL   string
+	NotifySMTPRelay    string
+	NotifySMTPFrom     string
+	NotifySMTPTo       string // Comma-separated recipients
+	NotifySMTPUsername string
+	NotifySMTPPassword string
+
+	// Webhooks: queued deliveries are looked for every WebhookPollInterval and
+	// retried with exponential backoff up to WebhookMaxAttempts times. Finished
+	// ones are kept in the delivery log for WebhookRetention.
+	WebhookPollInterval time.Duration
+	WebhookTimeout      time.Duration
+	WebhookMaxAttempts  int
+	WebhookRetention    time.Duration
+
+	// APIAuthRequired makes every route of the API require a token, even
+	// before the first one is created. Otherwise requests without one are let
+	// through until then, except to admin routes.
+	APIAuthRequired bool
+
+	// AIProvider selects the receipt extractor: "gemini" or "none" for
+	// manual entry and browsing only
+	AIProvider string
+
+	// AICassettePath and AICassetteMode ("record" or "replay") route model
+	// calls through a golden file instead of (or in addition to) the network
+	AICassettePath string
+	AICassetteMode string
+
+	// Per-stage model settings
+	AIStoreStage AIStageConfig
+	AIItemsStage AIStageConfig
+
+	// Escalation: extractions failing validation are retried up to
+	// AIEscalationBudget times, with AIEscalationModel as the stronger model.
+	// The default covers every step: a fix turn, the stronger model asked to
+	// fix, then the stronger model with the generic prompt.
+	AIEscalationBudget int
+	AIEscalationModel  string
+
+	// Circuit breaker: after AIBreakerThreshold consecutive failures, model
+	// calls fail fast for AIBreakerCooldown
+	AIBreakerThreshold int
+	AIBreakerCooldown  time.Duration
 }
 
 func Load() *Config {
@@ -17,6 +117,71 @@ func Load() *Config {
 		LogLevel:     getEnvOrDefault("LOG_LEVEL", "info"),
 		ServerPort:   getEnvOrDefault("PORT", "8080"),
 		DatabaseURL:  getEnvOrDefault("DATABASE_URL", ""),
+
+		UploadsDir:       getEnvOrDefault("UPLOADS_DIR", "/app/uploads"),
+		BatchConcurrency: getEnvInt("BATCH_CONCURRENCY", 3),
+
+		WatchDir:          getEnvOrDefault("WATCH_DIR", ""),
+		WatchPollInterval: getEnvDuration("WATCH_POLL_INTERVAL", 10*time.Second),
+		WatchSettleTime:   getEnvDuration("WATCH_SETTLE_TIME", 3*time.Second),
+		WatchOwner:        getEnvOrDefault("WATCH_OWNER", ""),
+
+		EmailMaildir:      getEnvOrDefault("EMAIL_MAILDIR", ""),
+		EmailPollInterval: getEnvDuration("EMAIL_POLL_INTERVAL", 30*time.Second),
+		EmailSMTPAddr:     getEnvOrDefault("EMAIL_SMTP_ADDR", ""),
+		EmailMaxSizeMB:    getEnvInt("EMAIL_MAX_SIZE_MB", 25),
+		EmailSenders:      getEnvMap("EMAIL_SENDERS"),
+
+		EmailReplySMTP:     getEnvOrDefault("EMAIL_REPLY_SMTP", ""),
+		EmailReplyFrom:     getEnvOrDefault("EMAIL_REPLY_FROM", ""),
+		EmailReplyUsername: getEnvOrDefault("EMAIL_REPLY_USERNAME", ""),
+		EmailReplyPassword: getEnvOrDefault("EMAIL_REPLY_PASSWORD", ""),
+
+		AccountingPaymentAccount:   getEnvOrDefault("ACCOUNTING_PAYMENT_ACCOUNT", "Assets:Checking"),
+		AccountingDefaultAccount:   getEnvOrDefault("ACCOUNTING_DEFAULT_ACCOUNT", "Expenses:Shopping"),
+		AccountingDiscountAccount:  getEnvOrDefault("ACCOUNTING_DISCOUNT_ACCOUNT", "Expenses:Discounts"),
+		AccountingCommodity:        getEnvOrDefault("ACCOUNTING_COMMODITY", "EUR"),
+		AccountingStoreAccounts:    getEnvMap("ACCOUNTING_STORE_ACCOUNTS"),
+		AccountingCategoryAccounts: getEnvMap("ACCOUNTING_CATEGORY_ACCOUNTS"),
+
+		NotifyChannels:     getEnvOrDefault("NOTIFY_CHANNELS", "log"),
+		NotifyWebhookURL:   getEnvOrDefault("NOTIFY_WEBHOOK_URL", ""),
+		NotifySMTPRelay:    getEnvOrDefault("NOTIFY_SMTP_RELAY", ""),
+		NotifySMTPFrom:     getEnvOrDefault("NOTIFY_SMTP_FROM", ""),
+		NotifySMTPTo:       getEnvOrDefault("NOTIFY_SMTP_TO", ""),
+		NotifySMTPUsername: getEnvOrDefault("NOTIFY_SMTP_USERNAME", ""),
+		NotifySMTPPassword: getEnvOrDefault("NOTIFY_SMTP_PASSWORD", ""),
+
+		WebhookPollInterval: getEnvDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second),
+		WebhookTimeout:      getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookMaxAttempts:  getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookRetention:    getEnvDuration("WEBHOOK_RETENTION", 30*24*time.Hour),
+
+		APIAuthRequired: getEnvBool("API_AUTH_REQUIRED", false),
+
+		AIProvider: getEnvOrDefault("AI_PROVIDER", "gemini"),
+
+		AICassettePath: getEnvOrDefault("AI_CASSETTE_PATH", ""),
+		AICassetteMode: getEnvOrDefault("AI_CASSETTE_MODE", ""),
+
+		AIStoreStage: AIStageConfig{
+			Model:       getEnvOrDefault("AI_STORE_MODEL", "gemini-2.5-flash-lite"),
+			Temperature: getEnvFloat32("AI_STORE_TEMPERATURE"),
+			Timeout:     getEnvDuration("AI_STORE_TIMEOUT", 30*time.Second),
+			MaxRetries:  getEnvInt("AI_STORE_MAX_RETRIES", 2),
+		},
+		AIItemsStage: AIStageConfig{
+			Model:       getEnvOrDefault("AI_ITEMS_MODEL", "gemini-2.5-flash"),
+			Temperature: getEnvFloat32("AI_ITEMS_TEMPERATURE"),
+			Timeout:     getEnvDuration("AI_ITEMS_TIMEOUT", 90*time.Second),
+			MaxRetries:  getEnvInt("AI_ITEMS_MAX_RETRIES", 2),
+		},
+
+		AIEscalationBudget: getEnvInt("AI_ESCALATION_BUDGET", 3),
+		AIEscalationModel:  getEnvOrDefault("AI_ESCALATION_MODEL", "gemini-2.5-pro"),
+
+		AIBreakerThreshold: getEnvInt("AI_BREAKER_THRESHOLD", 5),
+		AIBreakerCooldown:  getEnvDuration("AI_BREAKER_COOLDOWN", 30*time.Second),
 	}
 }
 
@@ -26,3 +191,80 @@ func getEnvOrDefault(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Warn("Invalid integer in environment, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Warn("Invalid duration in environment, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Warn("Invalid boolean in environment, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// getEnvFloat32 returns nil when the variable is unset or invalid
+func getEnvFloat32(key string) *float32 {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	parsed, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		log.Warn("Invalid number in environment, ignoring", "key", key, "value", value)
+		return nil
+	}
+	result := float32(parsed)
+	return &result
+}
+
+// getEnvMap parses a comma-separated list of key=value pairs, keys lowercased
+func getEnvMap(key string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			log.Warn("Invalid key=value pair in environment, ignoring", "key", key, "pair", pair)
+			continue
+		}
+		result[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+	return result
+}
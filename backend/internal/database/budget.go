@@ -0,0 +1,253 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// ErrBudgetNotFound is returned when a budget does not exist
+var ErrBudgetNotFound = errors.New("budget not found")
+
+// budgetColumns are the columns scanned by scanBudget
+const budgetColumns = `b.id, b.name, b.period, b.amount, COALESCE(b.category_id::text, ''), COALESCE(c.path, ''),
+	COALESCE(b.store_name, ''), COALESCE(b.tag, ''), COALESCE(b.owner, ''), COALESCE(b.household_id::text, ''),
+	b.created_at, b.updated_at`
+
+// budgetReceiptsSQL matches the receipts r a budget b adds up: those of its
+// household, or else of its owner, or all when it has none
+const budgetReceiptsSQL = `(CASE WHEN b.household_id IS NULL
+			THEN b.owner IS NULL OR b.owner = r.owner
+			ELSE b.household_id = r.household_id
+		  END)`
+
+// ListBudgets retrieves the budgets of a scope, by name
+func (r *PostgresRepository) ListBudgets(ctx context.Context, scope Scope) ([]models.Budget, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT `+budgetColumns+`
+		FROM budgets b
+		LEFT JOIN category_paths c ON b.category_id = c.id
+		WHERE `+ownedSQL("b", 1)+`
+		ORDER BY LOWER(b.name), b.created_at
+	`, scope.args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list budgets: %w", err)
+	}
+
+	return collectBudgets(rows)
+}
+
+// ListReceiptBudgets retrieves the budgets that add up a receipt, by name
+func (r *PostgresRepository) ListReceiptBudgets(ctx context.Context, receiptID string) ([]models.Budget, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT `+budgetColumns+`
+		FROM budgets b
+		LEFT JOIN category_paths c ON b.category_id = c.id
+		JOIN receipts r ON r.id = $1
+		WHERE `+budgetReceiptsSQL+`
+		ORDER BY LOWER(b.name), b.created_at
+	`, receiptID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list receipt budgets: %w", err)
+	}
+
+	return collectBudgets(rows)
+}
+
+// collectBudgets scans the rows of a query of budgetColumns
+func collectBudgets(rows pgx.Rows) ([]models.Budget, error) {
+	defer rows.Close()
+
+	budgets := []models.Budget{}
+	for rows.Next() {
+		budget, err := scanBudget(rows)
+		if err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, *budget)
+	}
+
+	return budgets, nil
+}
+
+// GetBudget retrieves a budget of a scope by ID
+func (r *PostgresRepository) GetBudget(ctx context.Context, id string, scope Scope) (*models.Budget, error) {
+	row := r.Pool.QueryRow(ctx, `
+		SELECT `+budgetColumns+`
+		FROM budgets b
+		LEFT JOIN category_paths c ON b.category_id = c.id
+		WHERE b.id = $1 AND `+ownedSQL("b", 2)+`
+	`, append([]any{id}, scope.args()...)...)
+
+	budget, err := scanBudget(row)
+	if errors.Is(err, pgx.ErrNoRows) || pgErrorCode(err) == pgInvalidText {
+		return nil, ErrBudgetNotFound
+	}
+	return budget, err
+}
+
+// scanBudget scans a row of budgetColumns
+func scanBudget(row pgx.Row) (*models.Budget, error) {
+	var budget models.Budget
+	err := row.Scan(&budget.ID, &budget.Name, &budget.Period, &budget.Amount, &budget.CategoryID, &budget.CategoryPath,
+		&budget.StoreName, &budget.Tag, &budget.Owner, &budget.HouseholdID, &budget.CreatedAt, &budget.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan budget: %w", err)
+	}
+
+	return &budget, nil
+}
+
+// CreateBudget inserts a budget, returns its ID. Returns
+// ErrHouseholdNotFound if its household does not exist.
+func (r *PostgresRepository) CreateBudget(ctx context.Context, budget *models.Budget) (string, error) {
+	budgetID := uuid.New().String()
+	_, err := r.Pool.Exec(ctx, `
+		INSERT INTO budgets (id, name, period, amount, category_id, store_name, tag, owner, household_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, '')::uuid)
+	`, budgetID, budget.Name, budget.Period, budget.Amount, budget.CategoryID, budget.StoreName, budget.Tag,
+		budget.Owner, budget.HouseholdID)
+	if err != nil {
+		if pgErrorCode(err) == pgForeignKeyViolation {
+			return "", ErrHouseholdNotFound
+		}
+		return "", fmt.Errorf("failed to create budget: %w", err)
+	}
+
+	return budgetID, nil
+}
+
+// UpdateBudget replaces a budget of a scope, keeping its owner and household.
+// The thresholds it crossed are forgotten, so they are notified again when
+// crossed under the new amount.
+func (r *PostgresRepository) UpdateBudget(ctx context.Context, budget *models.Budget, scope Scope) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `
+		UPDATE budgets b
+		SET name = $1, period = $2, amount = $3, category_id = NULLIF($4, '')::uuid,
+			store_name = NULLIF($5, ''), tag = NULLIF($6, ''), updated_at = NOW()
+		WHERE b.id = $7 AND `+ownedSQL("b", 8)+`
+	`, append([]any{budget.Name, budget.Period, budget.Amount, budget.CategoryID, budget.StoreName, budget.Tag, budget.ID},
+		scope.args()...)...)
+	if pgErrorCode(err) == pgInvalidText {
+		return ErrBudgetNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update budget: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrBudgetNotFound
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM budget_alerts WHERE budget_id = $1`, budget.ID); err != nil {
+		return fmt.Errorf("failed to clear budget alerts: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteBudget deletes a budget of a scope with its alerts
+func (r *PostgresRepository) DeleteBudget(ctx context.Context, id string, scope Scope) error {
+	result, err := r.Pool.Exec(ctx, `
+		DELETE FROM budgets b WHERE b.id = $1 AND `+ownedSQL("b", 2)+`
+	`, append([]any{id}, scope.args()...)...)
+	if pgErrorCode(err) == pgInvalidText {
+		return ErrBudgetNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete budget: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrBudgetNotFound
+	}
+
+	return nil
+}
+
+// BudgetSpending sums what was spent on a budget from start up to, but not
+// including, end (YYYY-MM-DD), leaving out the receipt excludeID when not
+// empty. Only the receipts of its household count, or else those of its
+// owner. Category and tag budgets add up line totals, like their spending
+// reports; store budgets take the receipt discounts off.
+func (r *PostgresRepository) BudgetSpending(ctx context.Context, budget *models.Budget, start, end, excludeID string) (float64, error) {
+	var spent float64
+	err := r.Pool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(i.quantity * i.price_paid), 0)
+		FROM items i
+		JOIN receipts r ON i.receipt_id = r.id
+		JOIN stores s ON r.store_id = s.id
+		JOIN products p ON i.product_id = p.id
+		LEFT JOIN category_paths c ON p.category_id = c.id
+		WHERE r.bought_date >= $1::date AND r.bought_date < $2::date
+		  AND r.id::text <> $3
+		  AND ($4 = '' OR c.ancestors @> ARRAY[NULLIF($4, '')::uuid])
+		  AND ($5 = '' OR s.name = $5)
+		  AND ($6 = ''
+			OR EXISTS (SELECT 1 FROM receipt_tags rt WHERE rt.receipt_id = r.id AND rt.tag = $6)
+			OR EXISTS (SELECT 1 FROM item_tags it WHERE it.item_id = i.id AND it.tag = $6))
+		  AND `+budgetOwnerSQL(7)+`
+	`, start, end, excludeID, budget.CategoryID, budget.StoreName, budget.Tag, budget.Owner, budget.HouseholdID).Scan(&spent)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum budget spending: %w", err)
+	}
+
+	if budget.StoreName == "" {
+		return spent, nil
+	}
+
+	var discounts float64
+	err = r.Pool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(r.discounts), 0)
+		FROM receipts r
+		JOIN stores s ON r.store_id = s.id
+		WHERE r.bought_date >= $1::date AND r.bought_date < $2::date
+		  AND r.id::text <> $3
+		  AND s.name = $4
+		  AND `+budgetOwnerSQL(5)+`
+	`, start, end, excludeID, budget.StoreName, budget.Owner, budget.HouseholdID).Scan(&discounts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum budget discounts: %w", err)
+	}
+
+	return spent - discounts, nil
+}
+
+// budgetOwnerSQL matches the receipts r of a budget with its owner and
+// household ID as arguments $n and $n+1, as budgetReceiptsSQL
+func budgetOwnerSQL(n int) string {
+	return fmt.Sprintf(`(CASE WHEN $%[2]d = ''
+			THEN $%[1]d = '' OR r.owner = $%[1]d
+			ELSE r.household_id = NULLIF($%[2]d, '')::uuid
+		  END)`, n, n+1)
+}
+
+// RecordBudgetAlert records that a budget crossed a threshold in the period
+// starting on periodStart. It returns false when it was already recorded.
+func (r *PostgresRepository) RecordBudgetAlert(ctx context.Context, budgetID, periodStart string, threshold int, receiptID string, spent float64) (bool, error) {
+	result, err := r.Pool.Exec(ctx, `
+		INSERT INTO budget_alerts (budget_id, period_start, threshold, receipt_id, spent)
+		VALUES ($1, $2::date, $3, NULLIF($4, '')::uuid, $5)
+		ON CONFLICT DO NOTHING
+	`, budgetID, periodStart, threshold, receiptID, spent)
+	if err != nil {
+		return false, fmt.Errorf("failed to record budget alert: %w", err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
@@ -0,0 +1,493 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// ErrCategoryNotFound is returned when a category, or the parent of a new one, does not exist
+var ErrCategoryNotFound = errors.New("category not found")
+
+// ErrCategoryConflict is returned when a category would collide with a
+// sibling of the same name, or become its own ancestor
+var ErrCategoryConflict = errors.New("category conflict")
+
+// ErrInvalidPattern is returned when a category rule pattern is not a valid regular expression
+var ErrInvalidPattern = errors.New("invalid pattern")
+
+// PostgreSQL error codes
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+	pgInvalidRegex        = "2201B"
+	pgInvalidText         = "22P02" // Such as IDs that are not UUIDs
+)
+
+// ErrStoreNotFound is returned when a rule is limited to a store that does not exist
+var ErrStoreNotFound = errors.New("store not found")
+
+// pgErrorCode returns the PostgreSQL error code of err, if any
+func pgErrorCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// pgErrorMessage returns the PostgreSQL message of err, without its code
+func pgErrorMessage(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Message
+	}
+	return err.Error()
+}
+
+// CategoryScope selects the products a recategorisation applies to. Empty
+// fields are ignored.
+type CategoryScope struct {
+	StoreName         string
+	ReceiptID         string   // Products of a receipt
+	ProductIDs        []string // Only these products
+	OnlyUncategorized bool     // Leave products with a category alone
+}
+
+// CategorySpending is what was spent on a category in a period
+type CategorySpending struct {
+	CategoryID string // Empty for uncategorised items
+	Name       string
+	ParentID   string
+	Path       string
+	Own        float64 // Spent on items of the category itself
+	Total      float64 // Spent on items of the category and its subcategories
+	ItemCount  int     // Items of the category and its subcategories
+}
+
+// ListCategories retrieves the whole taxonomy, ordered by path
+func (r *PostgresRepository) ListCategories(ctx context.Context) ([]models.Category, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT id, COALESCE(parent_id::text, ''), name, path
+		FROM category_paths
+		ORDER BY path
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list categories: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []models.Category{}
+	for rows.Next() {
+		var category models.Category
+		if err := rows.Scan(&category.ID, &category.ParentID, &category.Name, &category.Path); err != nil {
+			return nil, fmt.Errorf("failed to scan category: %w", err)
+		}
+		categories = append(categories, category)
+	}
+
+	return categories, nil
+}
+
+// GetCategory retrieves a category by ID
+func (r *PostgresRepository) GetCategory(ctx context.Context, id string) (*models.Category, error) {
+	var category models.Category
+	err := r.Pool.QueryRow(ctx, `
+		SELECT id, COALESCE(parent_id::text, ''), name, path
+		FROM category_paths
+		WHERE id = $1
+	`, id).Scan(&category.ID, &category.ParentID, &category.Name, &category.Path)
+	if err == pgx.ErrNoRows {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get category: %w", err)
+	}
+
+	return &category, nil
+}
+
+// CreateCategory inserts a category under its parent (or at the top level), returns its ID
+func (r *PostgresRepository) CreateCategory(ctx context.Context, category *models.Category) (string, error) {
+	categoryID := uuid.New().String()
+	_, err := r.Pool.Exec(ctx, `
+		INSERT INTO categories (id, name, parent_id)
+		VALUES ($1, $2, NULLIF($3, '')::uuid)
+	`, categoryID, category.Name, category.ParentID)
+	if err != nil {
+		return "", categoryWriteError(err)
+	}
+
+	return categoryID, nil
+}
+
+// UpdateCategory renames a category and moves it under another parent (or to
+// the top level). A category cannot be moved under one of its descendants.
+func (r *PostgresRepository) UpdateCategory(ctx context.Context, category *models.Category) error {
+	if category.ParentID != "" {
+		var cycle bool
+		err := r.Pool.QueryRow(ctx, `
+			SELECT $1::uuid = ANY(ancestors) FROM category_paths WHERE id = $2
+		`, category.ID, category.ParentID).Scan(&cycle)
+		if err == pgx.ErrNoRows {
+			return ErrCategoryNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check category parent: %w", err)
+		}
+		if cycle {
+			return fmt.Errorf("%w: a category cannot be moved under itself or its subcategories", ErrCategoryConflict)
+		}
+	}
+
+	result, err := r.Pool.Exec(ctx, `
+		UPDATE categories
+		SET name = $1, parent_id = NULLIF($2, '')::uuid
+		WHERE id = $3
+	`, category.Name, category.ParentID, category.ID)
+	if err != nil {
+		return categoryWriteError(err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrCategoryNotFound
+	}
+
+	return nil
+}
+
+// DeleteCategory deletes a category with its subcategories and rules; their
+// products become uncategorised
+func (r *PostgresRepository) DeleteCategory(ctx context.Context, id string) error {
+	result, err := r.Pool.Exec(ctx, `DELETE FROM categories WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete category: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrCategoryNotFound
+	}
+
+	return nil
+}
+
+// categoryWriteError maps the constraint violations of a category write
+func categoryWriteError(err error) error {
+	switch pgErrorCode(err) {
+	case pgUniqueViolation:
+		return fmt.Errorf("%w: a sibling category has the same name", ErrCategoryConflict)
+	case pgForeignKeyViolation:
+		return fmt.Errorf("%w: the parent does not exist", ErrCategoryNotFound)
+	default:
+		return fmt.Errorf("failed to save category: %w", err)
+	}
+}
+
+// ListCategoryRules retrieves every category rule, highest priority first
+func (r *PostgresRepository) ListCategoryRules(ctx context.Context) ([]models.CategoryRule, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT cr.id, cr.category_id, COALESCE(cr.pattern, ''), COALESCE(s.name, ''),
+			COALESCE(cr.product_id::text, ''), cr.priority, cr.created_at
+		FROM category_rules cr
+		LEFT JOIN stores s ON cr.store_id = s.id
+		ORDER BY cr.priority DESC, cr.created_at
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list category rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []models.CategoryRule{}
+	for rows.Next() {
+		var rule models.CategoryRule
+		if err := rows.Scan(&rule.ID, &rule.CategoryID, &rule.Pattern, &rule.StoreName,
+			&rule.ProductID, &rule.Priority, &rule.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan category rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// CreateCategoryRule inserts a category rule, returns its ID. Patterns are
+// checked by PostgreSQL, which evaluates them.
+func (r *PostgresRepository) CreateCategoryRule(ctx context.Context, rule *models.CategoryRule) (string, error) {
+	if rule.Pattern != "" {
+		if _, err := r.Pool.Exec(ctx, `SELECT '' ~* $1`, rule.Pattern); err != nil {
+			if pgErrorCode(err) == pgInvalidRegex {
+				return "", fmt.Errorf("%w: %s", ErrInvalidPattern, pgErrorMessage(err))
+			}
+			return "", fmt.Errorf("failed to check pattern: %w", err)
+		}
+	}
+
+	var storeID *string
+	if rule.StoreName != "" {
+		err := r.Pool.QueryRow(ctx, `SELECT id FROM stores WHERE name = $1`, rule.StoreName).Scan(&storeID)
+		if err == pgx.ErrNoRows {
+			return "", fmt.Errorf("%w: %s", ErrStoreNotFound, rule.StoreName)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to get store: %w", err)
+		}
+	}
+
+	ruleID := uuid.New().String()
+	_, err := r.Pool.Exec(ctx, `
+		INSERT INTO category_rules (id, category_id, pattern, store_id, product_id, priority)
+		VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, '')::uuid, $6)
+	`, ruleID, rule.CategoryID, rule.Pattern, storeID, rule.ProductID, rule.Priority)
+	if err != nil {
+		if pgErrorCode(err) == pgForeignKeyViolation {
+			return "", fmt.Errorf("%w: the category or product does not exist", ErrCategoryNotFound)
+		}
+		return "", fmt.Errorf("failed to insert category rule: %w", err)
+	}
+
+	return ruleID, nil
+}
+
+// DeleteCategoryRule deletes a category rule. The categories it assigned stay
+// until the products are recategorised.
+func (r *PostgresRepository) DeleteCategoryRule(ctx context.Context, id string) error {
+	result, err := r.Pool.Exec(ctx, `DELETE FROM category_rules WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete category rule: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("category rule not found")
+	}
+
+	return nil
+}
+
+// scopeSQL selects the products of a CategoryScope, with its arguments in
+// positions $1 to $4. Products categorised manually, or by an automation
+// rule, are never in scope.
+const scopeSQL = `
+	COALESCE(p.category_source, '') NOT IN ('manual', 'automation')
+	AND ($1 = '' OR p.store_id IN (SELECT id FROM stores WHERE name = $1))
+	AND ($2 = '' OR p.id IN (SELECT product_id FROM items WHERE receipt_id::text = $2))
+	AND (CARDINALITY($3::text[]) = 0 OR p.id = ANY($3::text[]::uuid[]))
+	AND (NOT $4 OR p.category_id IS NULL)`
+
+// scopeArgs returns the arguments of scopeSQL
+func scopeArgs(scope CategoryScope) []any {
+	productIDs := scope.ProductIDs
+	if productIDs == nil {
+		productIDs = []string{}
+	}
+	return []any{scope.StoreName, scope.ReceiptID, productIDs, scope.OnlyUncategorized}
+}
+
+// ApplyCategoryRules categorises the products in scope with the best
+// matching rule: a rule for the store product beats any pattern, then the
+// highest priority and the oldest rule win. Rules override categories
+// assigned by the AI, and products whose rule no longer matches lose the
+// category it gave them. Returns how many products got a category from a rule.
+func (r *PostgresRepository) ApplyCategoryRules(ctx context.Context, scope CategoryScope) (int64, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	categorized, err := applyCategoryRulesTx(ctx, tx, scope)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return categorized, nil
+}
+
+// applyCategoryRulesTx applies the category rules within a transaction
+func applyCategoryRulesTx(ctx context.Context, tx pgx.Tx, scope CategoryScope) (int64, error) {
+	args := scopeArgs(scope)
+
+	if !scope.OnlyUncategorized {
+		_, err := tx.Exec(ctx, `
+			UPDATE products p
+			SET category_id = NULL, category_source = NULL
+			WHERE p.category_source = 'rule' AND `+scopeSQL, args...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to reset rule categories: %w", err)
+		}
+	}
+
+	result, err := tx.Exec(ctx, `
+		UPDATE products target
+		SET category_id = matched.category_id, category_source = 'rule'
+		FROM (
+			SELECT DISTINCT ON (p.id) p.id AS product_id, cr.category_id
+			FROM products p
+			JOIN category_rules cr ON cr.product_id = p.id
+				OR (cr.pattern IS NOT NULL AND p.name ~* cr.pattern AND (cr.store_id IS NULL OR cr.store_id = p.store_id))
+			WHERE `+scopeSQL+`
+			ORDER BY p.id, (cr.product_id IS NOT NULL) DESC, cr.priority DESC, cr.created_at
+		) matched
+		WHERE target.id = matched.product_id
+	`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to apply category rules: %w", err)
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// ListUncategorizedProducts retrieves up to limit products in scope that have no category
+func (r *PostgresRepository) ListUncategorizedProducts(ctx context.Context, scope CategoryScope, limit int) ([]models.Product, error) {
+	args := append(scopeArgs(scope), limit)
+	rows, err := r.Pool.Query(ctx, `
+		SELECT p.id, p.name, p.store_id, s.name
+		FROM products p
+		JOIN stores s ON p.store_id = s.id
+		WHERE p.category_id IS NULL AND `+scopeSQL+`
+		ORDER BY s.name, p.name
+		LIMIT $5
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list uncategorised products: %w", err)
+	}
+	defer rows.Close()
+
+	products := []models.Product{}
+	for rows.Next() {
+		var product models.Product
+		if err := rows.Scan(&product.ID, &product.Name, &product.StoreID, &product.StoreName); err != nil {
+			return nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+		products = append(products, product)
+	}
+
+	return products, nil
+}
+
+// SetSuggestedCategories records the categories the AI suggested, by product
+// ID, for the products that are still uncategorised
+func (r *PostgresRepository) SetSuggestedCategories(ctx context.Context, categories map[string]string) (int64, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	categorized := int64(0)
+	for productID, categoryID := range categories {
+		result, err := tx.Exec(ctx, `
+			UPDATE products
+			SET category_id = $1, category_source = 'ai'
+			WHERE id = $2 AND category_id IS NULL AND category_source IS DISTINCT FROM 'manual'
+		`, categoryID, productID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to set product category: %w", err)
+		}
+		categorized += result.RowsAffected()
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return categorized, nil
+}
+
+// SetProductCategories manually sets the category of products, which rules
+// and the AI never change afterwards. An empty categoryID removes the manual
+// category, and the rules apply again.
+func (r *PostgresRepository) SetProductCategories(ctx context.Context, productIDs []string, categoryID string) (int64, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var result pgconn.CommandTag
+	if categoryID == "" {
+		result, err = tx.Exec(ctx, `
+			UPDATE products
+			SET category_id = NULL, category_source = NULL
+			WHERE id = ANY($1::text[]::uuid[])
+		`, productIDs)
+	} else {
+		result, err = tx.Exec(ctx, `
+			UPDATE products
+			SET category_id = $1, category_source = 'manual'
+			WHERE id = ANY($2::text[]::uuid[])
+		`, categoryID, productIDs)
+	}
+	if err != nil {
+		if pgErrorCode(err) == pgForeignKeyViolation {
+			return 0, ErrCategoryNotFound
+		}
+		return 0, fmt.Errorf("failed to set product categories: %w", err)
+	}
+
+	if categoryID == "" {
+		if _, err := applyCategoryRulesTx(ctx, tx, CategoryScope{ProductIDs: productIDs}); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// CategorySpending sums what was spent per category on the receipts matching
+// a filter, rolling subcategories up into their ancestors. Categories with no
+// spending are included, and uncategorised items come last.
+func (r *PostgresRepository) CategorySpending(ctx context.Context, filter ReceiptFilter) ([]CategorySpending, error) {
+	rows, err := r.Pool.Query(ctx, `
+		WITH spent AS (
+			SELECT p.category_id, SUM(i.quantity * i.price_paid) AS amount, COUNT(i.id) AS item_count
+			FROM receipts r
+			JOIN stores s ON r.store_id = s.id
+			JOIN items i ON r.id = i.receipt_id
+			JOIN products p ON i.product_id = p.id
+			WHERE `+receiptFilterSQL+`
+			GROUP BY p.category_id
+		)
+		SELECT id, name, parent_id, path, own, total, item_count
+		FROM (
+			SELECT c.id::text AS id, c.name, COALESCE(c.parent_id::text, '') AS parent_id, c.path,
+				COALESCE(SUM(sp.amount) FILTER (WHERE sp.category_id = c.id), 0) AS own,
+				COALESCE(SUM(sp.amount), 0) AS total,
+				COALESCE(SUM(sp.item_count), 0)::int AS item_count
+			FROM category_paths c
+			LEFT JOIN category_paths d ON c.id = ANY(d.ancestors)
+			LEFT JOIN spent sp ON sp.category_id = d.id
+			GROUP BY c.id, c.name, c.parent_id, c.path
+			UNION ALL
+			SELECT '', '', '', '', amount, amount, item_count::int
+			FROM spent
+			WHERE category_id IS NULL
+		) spending
+		ORDER BY path = '', path
+	`, receiptFilterArgs(filter)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum category spending: %w", err)
+	}
+	defer rows.Close()
+
+	spending := []CategorySpending{}
+	for rows.Next() {
+		var category CategorySpending
+		if err := rows.Scan(&category.CategoryID, &category.Name, &category.ParentID, &category.Path,
+			&category.Own, &category.Total, &category.ItemCount); err != nil {
+			return nil, fmt.Errorf("failed to scan category spending: %w", err)
+		}
+		spending = append(spending, category)
+	}
+
+	return spending, nil
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// eventsChannel is the Postgres channel events are notified on, so every
+// server instance sees the changes made by any of them
+const eventsChannel = "ticketer_events"
+
+// execer runs statements on the pool or within a transaction
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+// notifyReceiptEvent notifies an event of a receipt to the listeners, with
+// its owner and household. Within a transaction it is only sent once the
+// transaction commits, and never if it rolls back.
+func notifyReceiptEvent(ctx context.Context, db execer, event, receiptID string) error {
+	_, err := db.Exec(ctx, `
+		SELECT pg_notify($1, json_build_object('type', $2::text, 'receipt_id', id, 'owner', COALESCE(owner, ''),
+			'household_id', COALESCE(household_id::text, ''))::text)
+		FROM receipts
+		WHERE id = $3
+	`, eventsChannel, event, receiptID)
+	if err != nil {
+		return fmt.Errorf("failed to notify receipt event: %w", err)
+	}
+
+	return nil
+}
+
+// notifyHouseholdEvent notifies an event of a receipt to the members of a
+// household it is no longer in, so they drop it
+func notifyHouseholdEvent(ctx context.Context, db execer, event, receiptID, householdID string) error {
+	_, err := db.Exec(ctx, `
+		SELECT pg_notify($1, json_build_object('type', $2::text, 'receipt_id', $3::text, 'household_id', $4::text)::text)
+	`, eventsChannel, event, receiptID, householdID)
+	if err != nil {
+		return fmt.Errorf("failed to notify receipt event: %w", err)
+	}
+
+	return nil
+}
+
+// PublishEvent notifies an event to the listeners of every instance
+func (r *PostgresRepository) PublishEvent(ctx context.Context, event *models.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	if _, err := r.Pool.Exec(ctx, `SELECT pg_notify($1, $2)`, eventsChannel, string(payload)); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+
+	return nil
+}
+
+// ListenEvents calls fn with every event notified by any instance until ctx
+// is cancelled or the connection fails. The connection is taken out of the
+// pool while listening, and closed afterwards.
+func (r *PostgresRepository) ListenEvents(ctx context.Context, fn func(models.Event)) error {
+	pooled, err := r.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+eventsChannel); err != nil {
+		return fmt.Errorf("failed to listen for events: %w", err)
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to wait for events: %w", err)
+		}
+
+		var event models.Event
+		if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			continue
+		}
+		fn(event)
+	}
+}
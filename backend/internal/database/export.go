@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ExportRow is an item of a receipt, flattened with its receipt for exports
+type ExportRow struct {
+	ReceiptID  string
+	StoreName  string
+	BoughtDate string
+	Product    string
+	Quantity   float64
+	UnitPrice  float64
+	Discounts  float64 // Discounts of the whole receipt, repeated on each of its items
+	TaxRate    *float64
+	Category   string // Category path, empty if uncategorised
+	ItemTags   []string
+	ItemNotes  string
+
+	// Receipt details, repeated on each of its items
+	Owner          string
+	DocumentNumber string
+	StoreTaxID     string
+	ReceiptTags    []string
+	ReceiptNotes   string
+}
+
+// ExportItems calls fn with every item of the receipts matching a filter,
+// oldest receipt first. Rows are streamed from the database, so exports of
+// any size use constant memory. An error from fn stops the export.
+func (r *PostgresRepository) ExportItems(ctx context.Context, filter ReceiptFilter, fn func(ExportRow) error) error {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT r.id, s.name, r.bought_date, p.name, i.quantity, i.price_paid, COALESCE(r.discounts, 0), i.tax_rate,
+			COALESCE(r.owner, ''), COALESCE(r.document_number, ''), COALESCE(s.tax_id, ''), COALESCE(c.path, ''),
+			ARRAY(SELECT tag FROM item_tags WHERE item_id = i.id ORDER BY tag), i.notes,
+			ARRAY(SELECT tag FROM receipt_tags WHERE receipt_id = r.id ORDER BY tag), r.notes
+		FROM receipts r
+		JOIN stores s ON r.store_id = s.id
+		JOIN items i ON r.id = i.receipt_id
+		JOIN products p ON i.product_id = p.id
+		LEFT JOIN category_paths c ON p.category_id = c.id
+		WHERE `+receiptFilterSQL+`
+		ORDER BY r.bought_date, r.id, p.name
+	`, receiptFilterArgs(filter)...)
+	if err != nil {
+		return fmt.Errorf("failed to export items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row ExportRow
+		var boughtDate time.Time
+		if err := rows.Scan(&row.ReceiptID, &row.StoreName, &boughtDate, &row.Product, &row.Quantity, &row.UnitPrice, &row.Discounts, &row.TaxRate,
+			&row.Owner, &row.DocumentNumber, &row.StoreTaxID, &row.Category, &row.ItemTags, &row.ItemNotes,
+			&row.ReceiptTags, &row.ReceiptNotes); err != nil {
+			return fmt.Errorf("failed to scan item: %w", err)
+		}
+		row.BoughtDate = boughtDate.Format("2006-01-02")
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to export items: %w", err)
+	}
+	return nil
+}
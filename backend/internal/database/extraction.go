@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// extractionColumns lists the columns read by scanExtraction, in order
+const extractionColumns = `
+	id, receipt_id, COALESCE(store_answer, ''),
+	COALESCE(store_model, ''), COALESCE(store_prompt_version, ''), COALESCE(store_latency_ms, 0),
+	COALESCE(store_prompt_tokens, 0), COALESCE(store_output_tokens, 0),
+	COALESCE(items_model, ''), COALESCE(items_prompt_version, ''), COALESCE(items_latency_ms, 0),
+	COALESCE(items_prompt_tokens, 0), COALESCE(items_output_tokens, 0),
+	attempt, strategy, issues, selected,
+	COALESCE(raw_response, ''), COALESCE(error_message, ''), created_at`
+
+// scanExtraction scans a row selected with extractionColumns
+func scanExtraction(row pgx.Row) (*models.Extraction, error) {
+	var extraction models.Extraction
+	var receiptID *string
+	err := row.Scan(
+		&extraction.ID, &receiptID, &extraction.StoreAnswer,
+		&extraction.StoreStage.Model, &extraction.StoreStage.PromptVersion, &extraction.StoreStage.LatencyMs,
+		&extraction.StoreStage.PromptTokens, &extraction.StoreStage.OutputTokens,
+		&extraction.ItemsStage.Model, &extraction.ItemsStage.PromptVersion, &extraction.ItemsStage.LatencyMs,
+		&extraction.ItemsStage.PromptTokens, &extraction.ItemsStage.OutputTokens,
+		&extraction.Attempt, &extraction.Strategy, &extraction.Issues, &extraction.Selected,
+		&extraction.RawResponse, &extraction.Error, &extraction.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if receiptID != nil {
+		extraction.ReceiptID = *receiptID
+	}
+
+	return &extraction, nil
+}
+
+// CreateExtraction stores the provenance of an extraction, returns the extraction ID
+func (r *PostgresRepository) CreateExtraction(ctx context.Context, extraction *models.Extraction) (string, error) {
+	// Extractions that never produced a receipt are kept unlinked
+	var receiptID *string
+	if extraction.ReceiptID != "" {
+		receiptID = &extraction.ReceiptID
+	}
+
+	attempt := extraction.Attempt
+	if attempt == 0 {
+		attempt = 1
+	}
+	strategy := extraction.Strategy
+	if strategy == "" {
+		strategy = "initial"
+	}
+	issues := extraction.Issues
+	if issues == nil {
+		issues = []string{}
+	}
+
+	extractionID := uuid.New().String()
+	_, err := r.Pool.Exec(ctx, `
+		INSERT INTO extractions (
+			id, receipt_id, store_answer,
+			store_model, store_prompt_version, store_latency_ms, store_prompt_tokens, store_output_tokens,
+			items_model, items_prompt_version, items_latency_ms, items_prompt_tokens, items_output_tokens,
+			attempt, strategy, issues, selected,
+			raw_response, error_message
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+	`, extractionID, receiptID, extraction.StoreAnswer,
+		extraction.StoreStage.Model, extraction.StoreStage.PromptVersion, extraction.StoreStage.LatencyMs,
+		extraction.StoreStage.PromptTokens, extraction.StoreStage.OutputTokens,
+		extraction.ItemsStage.Model, extraction.ItemsStage.PromptVersion, extraction.ItemsStage.LatencyMs,
+		extraction.ItemsStage.PromptTokens, extraction.ItemsStage.OutputTokens,
+		attempt, strategy, issues, extraction.Selected,
+		extraction.RawResponse, extraction.Error)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert extraction: %w", err)
+	}
+
+	return extractionID, nil
+}
+
+// GetExtractionByReceipt retrieves the extraction whose result was kept for a receipt
+func (r *PostgresRepository) GetExtractionByReceipt(ctx context.Context, receiptID string) (*models.Extraction, error) {
+	row := r.Pool.QueryRow(ctx, `
+		SELECT `+extractionColumns+`
+		FROM extractions
+		WHERE receipt_id = $1
+		ORDER BY selected DESC, created_at DESC
+		LIMIT 1
+	`, receiptID)
+
+	extraction, err := scanExtraction(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("extraction not found")
+		}
+		return nil, fmt.Errorf("failed to get extraction: %w", err)
+	}
+
+	return extraction, nil
+}
+
+// ListExtractionsByReceipt retrieves every extraction attempt linked to a receipt, oldest first
+func (r *PostgresRepository) ListExtractionsByReceipt(ctx context.Context, receiptID string) ([]models.Extraction, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT `+extractionColumns+`
+		FROM extractions
+		WHERE receipt_id = $1
+		ORDER BY created_at, attempt
+	`, receiptID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extractions: %w", err)
+	}
+	defer rows.Close()
+
+	extractions := []models.Extraction{}
+	for rows.Next() {
+		extraction, err := scanExtraction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan extraction: %w", err)
+		}
+		extractions = append(extractions, *extraction)
+	}
+
+	return extractions, nil
+}
+
+// LinkExtraction links an extraction to a receipt
+func (r *PostgresRepository) LinkExtraction(ctx context.Context, extractionID, receiptID string) error {
+	result, err := r.Pool.Exec(ctx, `
+		UPDATE extractions
+		SET receipt_id = $1
+		WHERE id = $2
+	`, receiptID, extractionID)
+	if err != nil {
+		return fmt.Errorf("failed to link extraction: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("extraction not found")
+	}
+
+	return nil
+}
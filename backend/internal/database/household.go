@@ -0,0 +1,544 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// ErrHouseholdNotFound is returned when a household does not exist
+var ErrHouseholdNotFound = errors.New("household not found")
+
+// ErrHouseholdNotEmpty is returned when deleting a household that still has receipts
+var ErrHouseholdNotEmpty = errors.New("the household still has receipts")
+
+// ErrMemberNotFound is returned when a user is not a member of a household
+var ErrMemberNotFound = errors.New("household member not found")
+
+// ErrMemberConflict is returned when inviting a user who is already a member
+// of the household, or already invited to it
+var ErrMemberConflict = errors.New("member conflict")
+
+// ErrLastOwner is returned when a change would leave a household without owners
+var ErrLastOwner = errors.New("a household needs at least one owner")
+
+// ErrInvitationNotFound is returned when an invitation does not exist
+var ErrInvitationNotFound = errors.New("invitation not found")
+
+// Scope is who the rules, budgets, participants and settlements listed or
+// changed are for. Like receipts, each is kept by an owner, in a household or
+// none, and matched as the receipts of a ReceiptFilter are.
+type Scope struct {
+	Member string // User: theirs, and those of the households they are a member of
+	Role   string // Least role of Member in their households (viewer when empty)
+	Admin  bool   // Member is an admin, who also gets those of no one outside households
+}
+
+// ownedSQL matches the rows of a table alias, with an owner and a household
+// ID, a Scope is for, with its fields as arguments $n to $n+2 (see
+// Scope.args)
+func ownedSQL(alias string, n int) string {
+	return fmt.Sprintf(`(CASE WHEN %[1]s.household_id IS NULL
+			THEN $%[2]d = '' OR %[1]s.owner = $%[2]d OR ($%[4]d AND %[1]s.owner IS NULL) OR NOT EXISTS (SELECT 1 FROM households)
+			ELSE EXISTS (
+				SELECT 1
+				FROM household_members sm
+				WHERE sm.household_id = %[1]s.household_id AND sm.member = $%[2]d AND sm.role = ANY($%[3]d::text[])
+			)
+		END)`, alias, n, n+1, n+2)
+}
+
+// args returns the arguments of ownedSQL
+func (s Scope) args() []any {
+	role := s.Role
+	if role == "" {
+		role = models.RoleViewer
+	}
+	return []any{s.Member, models.RolesFrom(role), s.Admin}
+}
+
+// ListHouseholds retrieves the households of a member with their role, by name
+func (r *PostgresRepository) ListHouseholds(ctx context.Context, member string) ([]models.Household, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT h.id, h.name, m.role, h.created_at
+		FROM households h
+		JOIN household_members m ON h.id = m.household_id
+		WHERE m.member = $1
+		ORDER BY LOWER(h.name), h.created_at
+	`, member)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list households: %w", err)
+	}
+	defer rows.Close()
+
+	households := []models.Household{}
+	for rows.Next() {
+		var household models.Household
+		if err := rows.Scan(&household.ID, &household.Name, &household.Role, &household.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan household: %w", err)
+		}
+		households = append(households, household)
+	}
+
+	return households, nil
+}
+
+// ListMemberHouseholds retrieves the IDs of the households where a member has
+// at least a role
+func (r *PostgresRepository) ListMemberHouseholds(ctx context.Context, member, role string) ([]string, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT household_id::text FROM household_members WHERE member = $1 AND role = ANY($2::text[])
+	`, member, models.RolesFrom(role))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list member households: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan household ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list member households: %w", err)
+	}
+
+	return ids, nil
+}
+
+// GetHousehold retrieves a household by ID
+func (r *PostgresRepository) GetHousehold(ctx context.Context, id string) (*models.Household, error) {
+	var household models.Household
+	err := r.Pool.QueryRow(ctx, `SELECT id, name, created_at FROM households WHERE id = $1`, id).
+		Scan(&household.ID, &household.Name, &household.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) || pgErrorCode(err) == pgInvalidText {
+		return nil, ErrHouseholdNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get household: %w", err)
+	}
+
+	return &household, nil
+}
+
+// CreateHousehold inserts a household with its first owner, returns its ID
+func (r *PostgresRepository) CreateHousehold(ctx context.Context, name, owner string) (string, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	householdID := uuid.New().String()
+	if _, err := tx.Exec(ctx, `INSERT INTO households (id, name) VALUES ($1, $2)`, householdID, name); err != nil {
+		return "", fmt.Errorf("failed to create household: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO household_members (household_id, member, role)
+		VALUES ($1, $2, $3)
+	`, householdID, owner, models.RoleOwner)
+	if err != nil {
+		return "", fmt.Errorf("failed to add household owner: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return householdID, nil
+}
+
+// RenameHousehold renames a household
+func (r *PostgresRepository) RenameHousehold(ctx context.Context, id, name string) error {
+	result, err := r.Pool.Exec(ctx, `UPDATE households SET name = $1 WHERE id = $2`, name, id)
+	if err != nil {
+		return fmt.Errorf("failed to rename household: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrHouseholdNotFound
+	}
+
+	return nil
+}
+
+// DeleteHousehold deletes a household with its members and invitations. It
+// fails while the household has receipts.
+func (r *PostgresRepository) DeleteHousehold(ctx context.Context, id string) error {
+	result, err := r.Pool.Exec(ctx, `DELETE FROM households WHERE id = $1`, id)
+	if pgErrorCode(err) == pgForeignKeyViolation {
+		return fmt.Errorf("%w: move them to another household first", ErrHouseholdNotEmpty)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete household: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrHouseholdNotFound
+	}
+
+	return nil
+}
+
+// GetHouseholdRole retrieves the role of a user in a household, empty when
+// they are not a member (or the household does not exist)
+func (r *PostgresRepository) GetHouseholdRole(ctx context.Context, id, member string) (string, error) {
+	var role string
+	err := r.Pool.QueryRow(ctx, `
+		SELECT role FROM household_members WHERE household_id = $1 AND member = $2
+	`, id, member).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) || pgErrorCode(err) == pgInvalidText {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get household role: %w", err)
+	}
+
+	return role, nil
+}
+
+// ListHouseholdMembers retrieves the members of a household, owners first
+func (r *PostgresRepository) ListHouseholdMembers(ctx context.Context, id string) ([]models.HouseholdMember, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT member, role, created_at
+		FROM household_members
+		WHERE household_id = $1
+		ORDER BY array_position($2::text[], role::text) DESC, LOWER(member)
+	`, id, models.Roles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list household members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []models.HouseholdMember{}
+	for rows.Next() {
+		var member models.HouseholdMember
+		if err := rows.Scan(&member.Member, &member.Role, &member.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan household member: %w", err)
+		}
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// SetHouseholdRole changes the role of a member of a household
+func (r *PostgresRepository) SetHouseholdRole(ctx context.Context, id, member, role string) error {
+	return r.changeMemberTx(ctx, id, func(tx pgx.Tx) (pgconn.CommandTag, error) {
+		return tx.Exec(ctx, `
+			UPDATE household_members SET role = $1 WHERE household_id = $2 AND member = $3
+		`, role, id, member)
+	})
+}
+
+// RemoveHouseholdMember removes a member from a household
+func (r *PostgresRepository) RemoveHouseholdMember(ctx context.Context, id, member string) error {
+	return r.changeMemberTx(ctx, id, func(tx pgx.Tx) (pgconn.CommandTag, error) {
+		return tx.Exec(ctx, `DELETE FROM household_members WHERE household_id = $1 AND member = $2`, id, member)
+	})
+}
+
+// changeMemberTx changes a member of a household within a transaction,
+// failing when the member does not exist or the household is left without
+// owners. The household is locked, so concurrent changes cannot remove its
+// owners between them.
+func (r *PostgresRepository) changeMemberTx(ctx context.Context, id string, change func(pgx.Tx) (pgconn.CommandTag, error)) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var locked string
+	err = tx.QueryRow(ctx, `SELECT id FROM households WHERE id = $1 FOR UPDATE`, id).Scan(&locked)
+	if errors.Is(err, pgx.ErrNoRows) || pgErrorCode(err) == pgInvalidText {
+		return ErrHouseholdNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock household: %w", err)
+	}
+
+	result, err := change(tx)
+	if err != nil {
+		return fmt.Errorf("failed to change household member: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrMemberNotFound
+	}
+
+	var owners int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM household_members WHERE household_id = $1 AND role = $2
+	`, id, models.RoleOwner).Scan(&owners)
+	if err != nil {
+		return fmt.Errorf("failed to count household owners: %w", err)
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// invitationColumns are the columns scanned by scanInvitation
+const invitationColumns = `i.id, i.household_id, h.name, i.invitee, i.role, i.invited_by, i.created_at`
+
+// ListInvitations retrieves the pending invitations of a user, newest first
+func (r *PostgresRepository) ListInvitations(ctx context.Context, invitee string) ([]models.HouseholdInvitation, error) {
+	return r.listInvitations(ctx, `i.invitee = $1`, invitee)
+}
+
+// ListHouseholdInvitations retrieves the pending invitations to a household, newest first
+func (r *PostgresRepository) ListHouseholdInvitations(ctx context.Context, id string) ([]models.HouseholdInvitation, error) {
+	return r.listInvitations(ctx, `i.household_id = $1`, id)
+}
+
+// listInvitations retrieves the invitations matching a condition on $1
+func (r *PostgresRepository) listInvitations(ctx context.Context, condition string, arg string) ([]models.HouseholdInvitation, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT `+invitationColumns+`
+		FROM household_invitations i
+		JOIN households h ON i.household_id = h.id
+		WHERE `+condition+`
+		ORDER BY i.created_at DESC
+	`, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invitations: %w", err)
+	}
+	defer rows.Close()
+
+	invitations := []models.HouseholdInvitation{}
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *invitation)
+	}
+
+	return invitations, nil
+}
+
+// GetInvitation retrieves an invitation by ID
+func (r *PostgresRepository) GetInvitation(ctx context.Context, id string) (*models.HouseholdInvitation, error) {
+	row := r.Pool.QueryRow(ctx, `
+		SELECT `+invitationColumns+`
+		FROM household_invitations i
+		JOIN households h ON i.household_id = h.id
+		WHERE i.id = $1
+	`, id)
+
+	invitation, err := scanInvitation(row)
+	if errors.Is(err, pgx.ErrNoRows) || pgErrorCode(err) == pgInvalidText {
+		return nil, ErrInvitationNotFound
+	}
+	return invitation, err
+}
+
+// scanInvitation scans a row of invitationColumns
+func scanInvitation(row pgx.Row) (*models.HouseholdInvitation, error) {
+	var invitation models.HouseholdInvitation
+	err := row.Scan(&invitation.ID, &invitation.HouseholdID, &invitation.HouseholdName, &invitation.Invitee,
+		&invitation.Role, &invitation.InvitedBy, &invitation.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan invitation: %w", err)
+	}
+
+	return &invitation, nil
+}
+
+// CreateInvitation inserts an invitation, returns its ID. It fails when the
+// invitee is already a member of the household or already invited to it.
+func (r *PostgresRepository) CreateInvitation(ctx context.Context, invitation *models.HouseholdInvitation) (string, error) {
+	invitationID := uuid.New().String()
+	result, err := r.Pool.Exec(ctx, `
+		INSERT INTO household_invitations (id, household_id, invitee, role, invited_by)
+		SELECT $1, $2, $3, $4, $5
+		WHERE NOT EXISTS (SELECT 1 FROM household_members WHERE household_id = $2 AND member = $3)
+	`, invitationID, invitation.HouseholdID, invitation.Invitee, invitation.Role, invitation.InvitedBy)
+	if pgErrorCode(err) == pgUniqueViolation {
+		return "", fmt.Errorf("%w: %q is already invited", ErrMemberConflict, invitation.Invitee)
+	}
+	if pgErrorCode(err) == pgForeignKeyViolation {
+		return "", ErrHouseholdNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create invitation: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return "", fmt.Errorf("%w: %q is already a member", ErrMemberConflict, invitation.Invitee)
+	}
+
+	return invitationID, nil
+}
+
+// AcceptInvitation makes the invitee a member of the household with the role
+// of the invitation, deleting it
+func (r *PostgresRepository) AcceptInvitation(ctx context.Context, id string) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var householdID, invitee, role string
+	err = tx.QueryRow(ctx, `
+		DELETE FROM household_invitations WHERE id = $1
+		RETURNING household_id, invitee, role
+	`, id).Scan(&householdID, &invitee, &role)
+	if errors.Is(err, pgx.ErrNoRows) || pgErrorCode(err) == pgInvalidText {
+		return ErrInvitationNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete invitation: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO household_members (household_id, member, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (household_id, member) DO NOTHING
+	`, householdID, invitee, role)
+	if err != nil {
+		return fmt.Errorf("failed to add household member: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteInvitation deletes an invitation, declining or cancelling it
+func (r *PostgresRepository) DeleteInvitation(ctx context.Context, id string) error {
+	result, err := r.Pool.Exec(ctx, `DELETE FROM household_invitations WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete invitation: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrInvitationNotFound
+	}
+
+	return nil
+}
+
+// GetReceiptHousehold retrieves the household of a receipt, empty when it is
+// in none, and its owner, empty when it has none
+func (r *PostgresRepository) GetReceiptHousehold(ctx context.Context, receiptID string) (string, string, error) {
+	var householdID, owner string
+	err := r.Pool.QueryRow(ctx, `
+		SELECT COALESCE(household_id::text, ''), COALESCE(owner, '') FROM receipts WHERE id = $1
+	`, receiptID).Scan(&householdID, &owner)
+	if errors.Is(err, pgx.ErrNoRows) || pgErrorCode(err) == pgInvalidText {
+		return "", "", ErrReceiptNotFound
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get receipt household: %w", err)
+	}
+
+	return householdID, owner, nil
+}
+
+// HasHouseholds reports whether any household exists
+func (r *PostgresRepository) HasHouseholds(ctx context.Context) (bool, error) {
+	var exists bool
+	if err := r.Pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM households)`).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check for households: %w", err)
+	}
+
+	return exists, nil
+}
+
+// GetItemReceiptID retrieves the receipt of an item
+func (r *PostgresRepository) GetItemReceiptID(ctx context.Context, itemID string) (string, error) {
+	var receiptID string
+	err := r.Pool.QueryRow(ctx, `SELECT receipt_id FROM items WHERE id = $1`, itemID).Scan(&receiptID)
+	if errors.Is(err, pgx.ErrNoRows) || pgErrorCode(err) == pgInvalidText {
+		return "", ErrItemNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get item receipt: %w", err)
+	}
+
+	return receiptID, nil
+}
+
+// GetReprocessingReceiptID retrieves the receipt of a reprocessing
+func (r *PostgresRepository) GetReprocessingReceiptID(ctx context.Context, id string) (string, error) {
+	var receiptID string
+	err := r.Pool.QueryRow(ctx, `SELECT receipt_id FROM reprocessings WHERE id = $1`, id).Scan(&receiptID)
+	if errors.Is(err, pgx.ErrNoRows) || pgErrorCode(err) == pgInvalidText {
+		return "", ErrReprocessingNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get reprocessing receipt: %w", err)
+	}
+
+	return receiptID, nil
+}
+
+// MoveReceipt moves a receipt to a household, or out of any household when
+// householdID is empty
+func (r *PostgresRepository) MoveReceipt(ctx context.Context, receiptID, householdID string) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var previousID string
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE(household_id::text, '') FROM receipts WHERE id = $1 FOR UPDATE
+	`, receiptID).Scan(&previousID)
+	if errors.Is(err, pgx.ErrNoRows) || pgErrorCode(err) == pgInvalidText {
+		return ErrReceiptNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get receipt household: %w", err)
+	}
+
+	result, err := tx.Exec(ctx, `
+		UPDATE receipts SET household_id = NULLIF($1, '')::uuid WHERE id = $2
+	`, householdID, receiptID)
+	if pgErrorCode(err) == pgForeignKeyViolation {
+		return ErrHouseholdNotFound
+	}
+	if pgErrorCode(err) == pgUniqueViolation {
+		// The duplicate may be one of the owner's the caller does not see
+		return &DuplicateReceiptError{}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to move receipt: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrReceiptNotFound
+	}
+
+	if err := notifyReceiptEvent(ctx, tx, models.EventReceiptUpdated, receiptID); err != nil {
+		return err
+	}
+	if previousID != "" && previousID != householdID {
+		if err := notifyHouseholdEvent(ctx, tx, models.EventReceiptUpdated, receiptID, previousID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/charmbracelet/log"
@@ -14,6 +16,37 @@ import (
 	"github.com/vieitesss/ticketer/internal/models"
 )
 
+// DuplicateReceiptError is returned when a receipt with the same contents already
+// exists in the same household, or else for the same owner
+type DuplicateReceiptError struct {
+	ExistingID string // Empty when the caller may not see it
+}
+
+func (e *DuplicateReceiptError) Error() string {
+	if e.ExistingID == "" {
+		return "duplicate receipt: this receipt has already been uploaded"
+	}
+	return fmt.Sprintf("duplicate receipt: this receipt has already been uploaded (ID: %s)", e.ExistingID)
+}
+
+// ledgerSQL keys the receipts duplicates are looked for among: those of the
+// same household, or else of the same owner
+const ledgerSQL = `COALESCE(household_id::text, owner, '')`
+
+// receiptLedger returns the ledger key of a receipt, as ledgerSQL
+func receiptLedger(receipt *models.Receipt) string {
+	if receipt.HouseholdID != "" {
+		return receipt.HouseholdID
+	}
+	return receipt.Owner
+}
+
+// ErrReceiptNotFound is returned when a receipt does not exist
+var ErrReceiptNotFound = errors.New("receipt not found")
+
+// ErrItemNotFound is returned when an item does not exist
+var ErrItemNotFound = errors.New("item not found")
+
 // calculateReceiptHash generates a unique hash for a receipt based on store, date, and items
 func calculateReceiptHash(storeName, boughtDate string, items []models.Item) string {
 	// Sort items to ensure consistent hash regardless of order
@@ -45,21 +78,78 @@ func (r *PostgresRepository) CreateReceipt(ctx context.Context, receipt *models.
 	}
 	defer tx.Rollback(ctx)
 
+	receiptID, err := createReceiptTx(ctx, tx, receipt)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return receiptID, nil
+}
+
+// ImportOutcome is what happened to one receipt of an import
+type ImportOutcome struct {
+	ReceiptID   string // Empty for duplicates
+	DuplicateOf string // ID of the existing receipt with the same contents
+}
+
+// ImportReceipts inserts receipts in a single transaction, so either all of
+// them are saved or none is. Duplicates, of stored receipts or of an earlier
+// receipt of the same import, are skipped and reported. A dry run does the
+// same work and then rolls it back.
+func (r *PostgresRepository) ImportReceipts(ctx context.Context, receipts []*models.Receipt, dryRun bool) ([]ImportOutcome, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	outcomes := make([]ImportOutcome, len(receipts))
+	for i, receipt := range receipts {
+		receiptID, err := createReceiptTx(ctx, tx, receipt)
+		var duplicateErr *DuplicateReceiptError
+		switch {
+		case errors.As(err, &duplicateErr):
+			outcomes[i].DuplicateOf = duplicateErr.ExistingID
+		case err != nil:
+			return nil, fmt.Errorf("receipt %d: %w", i+1, err)
+		default:
+			outcomes[i].ReceiptID = receiptID
+		}
+	}
+
+	if dryRun {
+		return outcomes, nil
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return outcomes, nil
+}
+
+// createReceiptTx inserts a receipt and its items within a transaction,
+// unless a receipt with the same contents already exists
+func createReceiptTx(ctx context.Context, tx pgx.Tx, receipt *models.Receipt) (string, error) {
 	// Calculate receipt hash for duplicate detection
 	receiptHash := calculateReceiptHash(receipt.StoreName, receipt.BoughtDate, receipt.Items)
 
 	// Log hash for debugging
 	log.Debug("Receipt hash", "hash", receiptHash)
 
-	// Check if receipt already exists
+	// Check if receipt already exists in its ledger
 	var existingID string
-	err = tx.QueryRow(ctx, `
-		SELECT id FROM receipts WHERE receipt_hash = $1
-	`, receiptHash).Scan(&existingID)
+	err := tx.QueryRow(ctx, `
+		SELECT id FROM receipts WHERE receipt_hash = $1 AND `+ledgerSQL+` = $2
+	`, receiptHash, receiptLedger(receipt)).Scan(&existingID)
 	if err == nil {
 		// Receipt already exists
 		log.Debug("Duplicate receipt detected", "existing_id", existingID)
-		return "", fmt.Errorf("duplicate receipt: this receipt has already been uploaded (ID: %s)", existingID)
+		return "", &DuplicateReceiptError{ExistingID: existingID}
 	} else if err != pgx.ErrNoRows {
 		return "", fmt.Errorf("failed to check for duplicate receipt: %w", err)
 	}
@@ -67,15 +157,12 @@ func (r *PostgresRepository) CreateReceipt(ctx context.Context, receipt *models.
 	log.Debug("No duplicate found, creating new receipt")
 
 	// UPSERT store and get store ID
-	var storeID string
-	err = tx.QueryRow(ctx, `
-		INSERT INTO stores (id, name)
-		VALUES ($1, $2)
-		ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
-		RETURNING id
-	`, uuid.New().String(), receipt.StoreName).Scan(&storeID)
+	storeID, err := upsertStoreTx(ctx, tx, receipt.StoreName)
 	if err != nil {
-		return "", fmt.Errorf("failed to upsert store: %w", err)
+		return "", err
+	}
+	if err := setStoreTaxIDTx(ctx, tx, storeID, receipt.StoreTaxID); err != nil {
+		return "", err
 	}
 
 	// Parse bought_date
@@ -87,42 +174,103 @@ func (r *PostgresRepository) CreateReceipt(ctx context.Context, receipt *models.
 	// Insert receipt
 	receiptID := uuid.New().String()
 	_, err = tx.Exec(ctx, `
-		INSERT INTO receipts (id, store_id, discounts, receipt_hash, bought_date)
-		VALUES ($1, $2, $3, $4, $5)
-	`, receiptID, storeID, receipt.Discounts, receiptHash, boughtDate)
+		INSERT INTO receipts (id, store_id, discounts, receipt_hash, bought_date, image_path, owner, document_number, notes, business,
+			household_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9, $10, NULLIF($11, '')::uuid)
+	`, receiptID, storeID, receipt.Discounts, receiptHash, boughtDate, receipt.ImagePath, receipt.Owner, receipt.DocumentNumber,
+		receipt.Notes, receipt.Business, receipt.HouseholdID)
+	if pgErrorCode(err) == pgForeignKeyViolation {
+		return "", ErrHouseholdNotFound
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to insert receipt: %w", err)
 	}
+	if len(receipt.Tags) > 0 {
+		if err := addReceiptTagsTx(ctx, tx, receiptID, receipt.Tags); err != nil {
+			return "", err
+		}
+	}
 
 	// Insert items with product UPSERT
-	for _, item := range receipt.Items {
+	if _, err := insertItems(ctx, tx, receiptID, storeID, receipt.Items); err != nil {
+		return "", err
+	}
+
+	// Categorise the products seen for the first time
+	if _, err := applyCategoryRulesTx(ctx, tx, CategoryScope{ReceiptID: receiptID, OnlyUncategorized: true}); err != nil {
+		return "", err
+	}
+
+	if err := notifyReceiptEvent(ctx, tx, models.EventReceiptCreated, receiptID); err != nil {
+		return "", err
+	}
+
+	return receiptID, nil
+}
+
+// upsertStoreTx inserts a store by name within a transaction, returns the store ID
+func upsertStoreTx(ctx context.Context, tx pgx.Tx, name string) (string, error) {
+	var storeID string
+	err := tx.QueryRow(ctx, `
+		INSERT INTO stores (id, name)
+		VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+		RETURNING id
+	`, uuid.New().String(), name).Scan(&storeID)
+	if err != nil {
+		return "", fmt.Errorf("failed to upsert store: %w", err)
+	}
+
+	return storeID, nil
+}
+
+// setStoreTaxIDTx records the tax identification number of a store within a
+// transaction. An empty taxID leaves the stored one untouched.
+func setStoreTaxIDTx(ctx context.Context, tx pgx.Tx, storeID, taxID string) error {
+	if taxID == "" {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `UPDATE stores SET tax_id = $1 WHERE id = $2`, taxID, storeID); err != nil {
+		return fmt.Errorf("failed to set store tax ID: %w", err)
+	}
+	return nil
+}
+
+// insertItems inserts the items of a receipt within a transaction, upserting
+// their products, and returns the IDs of the inserted items in order
+func insertItems(ctx context.Context, tx pgx.Tx, receiptID, storeID string, items []models.Item) ([]string, error) {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
 		// UPSERT product and get product ID
 		var productID string
-		err = tx.QueryRow(ctx, `
+		err := tx.QueryRow(ctx, `
 			INSERT INTO products (id, name, store_id)
 			VALUES ($1, $2, $3)
 			ON CONFLICT (name, store_id) DO UPDATE SET name = EXCLUDED.name
 			RETURNING id
 		`, uuid.New().String(), item.Name, storeID).Scan(&productID)
 		if err != nil {
-			return "", fmt.Errorf("failed to upsert product: %w", err)
+			return nil, fmt.Errorf("failed to upsert product: %w", err)
 		}
 
 		// Insert item
+		itemID := uuid.New().String()
 		_, err = tx.Exec(ctx, `
-			INSERT INTO items (id, receipt_id, product_id, quantity, price_paid)
-			VALUES ($1, $2, $3, $4, $5)
-		`, uuid.New().String(), receiptID, productID, item.Quantity, item.Price)
+			INSERT INTO items (id, receipt_id, product_id, quantity, price_paid, tax_rate, notes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, itemID, receiptID, productID, item.Quantity, item.Price, item.TaxRate, item.Notes)
 		if err != nil {
-			return "", fmt.Errorf("failed to insert item: %w", err)
+			return nil, fmt.Errorf("failed to insert item: %w", err)
 		}
+		if len(item.Tags) > 0 {
+			if err := addItemTagsTx(ctx, tx, itemID, item.Tags); err != nil {
+				return nil, err
+			}
+		}
+		ids = append(ids, itemID)
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		return "", fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
-	return receiptID, nil
+	return ids, nil
 }
 
 // GetReceipt retrieves a receipt by ID with all its items
@@ -132,14 +280,17 @@ func (r *PostgresRepository) GetReceipt(ctx context.Context, id string) (*models
 	var discounts *float64
 	var boughtDate time.Time
 	err := r.Pool.QueryRow(ctx, `
-		SELECT r.id, s.name, r.discounts, r.bought_date
+		SELECT r.id, s.name, r.discounts, r.bought_date, COALESCE(r.image_path, ''), COALESCE(r.owner, ''),
+			COALESCE(r.document_number, ''), COALESCE(s.tax_id, ''), r.notes, r.business,
+			ARRAY(SELECT tag FROM receipt_tags WHERE receipt_id = r.id ORDER BY tag), COALESCE(r.household_id::text, '')
 		FROM receipts r
 		JOIN stores s ON r.store_id = s.id
 		WHERE r.id = $1
-	`, id).Scan(&receipt.ID, &receipt.StoreName, &discounts, &boughtDate)
+	`, id).Scan(&receipt.ID, &receipt.StoreName, &discounts, &boughtDate, &receipt.ImagePath, &receipt.Owner,
+		&receipt.DocumentNumber, &receipt.StoreTaxID, &receipt.Notes, &receipt.Business, &receipt.Tags, &receipt.HouseholdID)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("receipt not found")
+			return nil, ErrReceiptNotFound
 		}
 		return nil, fmt.Errorf("failed to get receipt: %w", err)
 	}
@@ -153,9 +304,12 @@ func (r *PostgresRepository) GetReceipt(ctx context.Context, id string) (*models
 
 	// Get items with product information
 	rows, err := r.Pool.Query(ctx, `
-		SELECT i.id, p.name, i.quantity, i.price_paid
+		SELECT i.id, p.name, i.quantity, i.price_paid, i.tax_rate, p.id,
+			COALESCE(c.id::text, ''), COALESCE(c.path, ''), COALESCE(p.category_source, ''), i.notes,
+			ARRAY(SELECT tag FROM item_tags WHERE item_id = i.id ORDER BY tag)
 		FROM items i
 		JOIN products p ON i.product_id = p.id
+		LEFT JOIN category_paths c ON p.category_id = c.id
 		WHERE i.receipt_id = $1
 		ORDER BY p.name
 	`, id)
@@ -167,7 +321,8 @@ func (r *PostgresRepository) GetReceipt(ctx context.Context, id string) (*models
 	receipt.Items = []models.Item{}
 	for rows.Next() {
 		var item models.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Quantity, &item.Price); err != nil {
+		if err := rows.Scan(&item.ID, &item.Name, &item.Quantity, &item.Price, &item.TaxRate, &item.ProductID,
+			&item.CategoryID, &item.Category, &item.CategorySource, &item.Notes, &item.Tags); err != nil {
 			return nil, fmt.Errorf("failed to scan item: %w", err)
 		}
 		receipt.Items = append(receipt.Items, item)
@@ -185,10 +340,12 @@ type ReceiptListItem struct {
 	Subtotal    float64
 	Discounts   float64
 	TotalAmount float64
+	Categories  []string // Paths of the categories of its items
+	Tags        []string
 }
 
-// ListReceipts retrieves all receipts (without items, but with calculated totals)
-func (r *PostgresRepository) ListReceipts(ctx context.Context, limit, offset int) ([]ReceiptListItem, error) {
+// ListReceipts retrieves the receipts matching a filter (without items, but with calculated totals)
+func (r *PostgresRepository) ListReceipts(ctx context.Context, filter ReceiptFilter, limit, offset int) ([]ReceiptListItem, error) {
 	rows, err := r.Pool.Query(ctx, `
 		SELECT
 			r.id,
@@ -196,14 +353,19 @@ func (r *PostgresRepository) ListReceipts(ctx context.Context, limit, offset int
 			COALESCE(COUNT(i.id), 0) as item_count,
 			r.bought_date,
 			COALESCE(SUM(i.quantity * i.price_paid), 0) as subtotal,
-			COALESCE(r.discounts, 0) as discounts
+			COALESCE(r.discounts, 0) as discounts,
+			ARRAY_REMOVE(ARRAY_AGG(DISTINCT c.path), NULL) as categories,
+			ARRAY(SELECT tag FROM receipt_tags WHERE receipt_id = r.id ORDER BY tag) as tags
 		FROM receipts r
 		JOIN stores s ON r.store_id = s.id
 		LEFT JOIN items i ON r.id = i.receipt_id
+		LEFT JOIN products p ON i.product_id = p.id
+		LEFT JOIN category_paths c ON p.category_id = c.id
+		WHERE `+receiptFilterSQL+`
 		GROUP BY r.id, s.name, r.discounts, r.bought_date
 		ORDER BY r.bought_date DESC
-		LIMIT $1 OFFSET $2
-	`, limit, offset)
+		LIMIT $10 OFFSET $11
+	`, receiptFilterArgs(filter, limit, offset)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list receipts: %w", err)
 	}
@@ -213,7 +375,7 @@ func (r *PostgresRepository) ListReceipts(ctx context.Context, limit, offset int
 	for rows.Next() {
 		var receipt ReceiptListItem
 		var boughtDate time.Time
-		if err := rows.Scan(&receipt.ID, &receipt.StoreName, &receipt.ItemCount, &boughtDate, &receipt.Subtotal, &receipt.Discounts); err != nil {
+		if err := rows.Scan(&receipt.ID, &receipt.StoreName, &receipt.ItemCount, &boughtDate, &receipt.Subtotal, &receipt.Discounts, &receipt.Categories, &receipt.Tags); err != nil {
 			return nil, fmt.Errorf("failed to scan receipt: %w", err)
 		}
 		receipt.BoughtDate = boughtDate.Format("2006-01-02")
@@ -310,36 +472,258 @@ func (r *PostgresRepository) GetReceiptsByStore(ctx context.Context, storeID str
 	return receipts, nil
 }
 
-// DeleteReceipt deletes a receipt and all its items (CASCADE)
+// ReceiptFilter narrows down which receipts a query returns. Empty fields are
+// ignored, except for Member: receipts in a household are only returned to
+// its members and, once any household exists, receipts in none to their owner.
+type ReceiptFilter struct {
+	StoreName   string
+	StartDate   string // ISO 8601: YYYY-MM-DD, inclusive
+	EndDate     string // ISO 8601: YYYY-MM-DD, inclusive
+	CategoryID  string // Receipts with an item of the category or its subcategories
+	Tag         string // Receipts with the tag, or with an item with it
+	Member      string // User the receipts are for: their own, and those in the households they are a member of
+	Role        string // Least role of Member in the households of the receipts (viewer when empty)
+	HouseholdID string // Receipts in the household
+	Admin       bool   // Member is an admin, who also gets the receipts of no one outside households
+}
+
+// receiptFilterSQL matches the receipts r (of store s) of a ReceiptFilter,
+// with its fields as arguments $1 to $9 (see receiptFilterArgs). Until a
+// household exists, every receipt outside households is matched, as is on
+// installs without API tokens (no Member).
+const receiptFilterSQL = `($1 = '' OR s.name = $1)
+		  AND ($2 = '' OR r.bought_date >= $2::date)
+		  AND ($3 = '' OR r.bought_date <= $3::date)
+		  AND ($4 = '' OR EXISTS (
+			SELECT 1
+			FROM items fi
+			JOIN products fp ON fi.product_id = fp.id
+			JOIN category_paths fc ON fp.category_id = fc.id
+			WHERE fi.receipt_id = r.id AND fc.ancestors @> ARRAY[NULLIF($4, '')::uuid]
+		  ))
+		  AND ($5 = ''
+			OR EXISTS (SELECT 1 FROM receipt_tags ft WHERE ft.receipt_id = r.id AND ft.tag = $5)
+			OR EXISTS (
+				SELECT 1
+				FROM item_tags ft
+				JOIN items fi ON ft.item_id = fi.id
+				WHERE fi.receipt_id = r.id AND ft.tag = $5
+			))
+		  AND (CASE WHEN r.household_id IS NULL
+			THEN $6 = '' OR r.owner = $6 OR ($9 AND r.owner IS NULL) OR NOT EXISTS (SELECT 1 FROM households)
+			ELSE EXISTS (
+				SELECT 1
+				FROM household_members fm
+				WHERE fm.household_id = r.household_id AND fm.member = $6 AND fm.role = ANY($7::text[])
+			)
+		  END)
+		  AND ($8 = '' OR r.household_id = NULLIF($8, '')::uuid)`
+
+// filteredReceiptsSQL selects the IDs of the receipts matching a
+// ReceiptFilter, with its fields as arguments $1 to $9 (see receiptFilterArgs)
+const filteredReceiptsSQL = `SELECT r.id FROM receipts r JOIN stores s ON r.store_id = s.id WHERE ` + receiptFilterSQL
+
+// receiptFilterArgs returns the arguments of receiptFilterSQL, followed by extra ones
+func receiptFilterArgs(filter ReceiptFilter, extra ...any) []any {
+	role := filter.Role
+	if role == "" {
+		role = models.RoleViewer
+	}
+	return append([]any{filter.StoreName, filter.StartDate, filter.EndDate, filter.CategoryID, filter.Tag,
+		filter.Member, models.RolesFrom(role), filter.HouseholdID, filter.Admin}, extra...)
+}
+
+// ListReceiptIDs retrieves the IDs of all receipts matching a filter, oldest first
+func (r *PostgresRepository) ListReceiptIDs(ctx context.Context, filter ReceiptFilter) ([]string, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT r.id
+		FROM receipts r
+		JOIN stores s ON r.store_id = s.id
+		WHERE `+receiptFilterSQL+`
+		ORDER BY r.bought_date
+	`, receiptFilterArgs(filter)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list receipt IDs: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan receipt ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// replaceReceiptContentsTx replaces the store, date, discounts and items of an
+// existing receipt, keeping its ID and original image, within a transaction
+func replaceReceiptContentsTx(ctx context.Context, tx pgx.Tx, id string, receipt *models.Receipt) error {
+	receiptHash := calculateReceiptHash(receipt.StoreName, receipt.BoughtDate, receipt.Items)
+
+	// The new contents must not collide with a different receipt of its ledger
+	var existingID string
+	err := tx.QueryRow(ctx, `
+		SELECT id FROM receipts WHERE receipt_hash = $1 AND id <> $2
+			AND `+ledgerSQL+` = (SELECT `+ledgerSQL+` FROM receipts WHERE id = $2)
+	`, receiptHash, id).Scan(&existingID)
+	if err == nil {
+		return &DuplicateReceiptError{ExistingID: existingID}
+	} else if err != pgx.ErrNoRows {
+		return fmt.Errorf("failed to check for duplicate receipt: %w", err)
+	}
+
+	storeID, err := upsertStoreTx(ctx, tx, receipt.StoreName)
+	if err != nil {
+		return err
+	}
+
+	boughtDate, err := time.Parse("2006-01-02", receipt.BoughtDate)
+	if err != nil {
+		return fmt.Errorf("invalid bought_date format (expected YYYY-MM-DD): %w", err)
+	}
+
+	result, err := tx.Exec(ctx, `
+		UPDATE receipts
+		SET store_id = $1, discounts = $2, receipt_hash = $3, bought_date = $4
+		WHERE id = $5
+	`, storeID, receipt.Discounts, receiptHash, boughtDate, id)
+	if err != nil {
+		return fmt.Errorf("failed to update receipt: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrReceiptNotFound
+	}
+
+	items, err := keepItemAnnotationsTx(ctx, tx, id, receipt.Items)
+	if err != nil {
+		return err
+	}
+
+	// Deleting the items drops their shares, so they are moved to the new ones
+	shared, err := itemSharesTx(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM items WHERE receipt_id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete items: %w", err)
+	}
+
+	itemIDs, err := insertItems(ctx, tx, id, storeID, items)
+	if err != nil {
+		return err
+	}
+
+	remapped, dropped := remapItemShares(shared, items)
+	if len(dropped) > 0 {
+		return fmt.Errorf("%w: %s", ErrSplitItemsDropped, strings.Join(dropped, ", "))
+	}
+	for i, shares := range remapped {
+		if err := setItemSharesTx(ctx, tx, id, itemIDs[i], shares); err != nil {
+			return err
+		}
+	}
+
+	if _, err := applyCategoryRulesTx(ctx, tx, CategoryScope{ReceiptID: id, OnlyUncategorized: true}); err != nil {
+		return err
+	}
+
+	return notifyReceiptEvent(ctx, tx, models.EventReceiptUpdated, id)
+}
+
+// keepItemAnnotationsTx returns a copy of the new items of a receipt with the
+// tags and notes of its current items of the same product name, within a
+// transaction, so replacing the items keeps them
+func keepItemAnnotationsTx(ctx context.Context, tx pgx.Tx, receiptID string, items []models.Item) ([]models.Item, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT p.name, i.notes, ARRAY(SELECT tag FROM item_tags WHERE item_id = i.id ORDER BY tag)
+		FROM items i
+		JOIN products p ON i.product_id = p.id
+		WHERE i.receipt_id = $1
+	`, receiptID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item annotations: %w", err)
+	}
+	defer rows.Close()
+
+	current := map[string]models.Item{}
+	for rows.Next() {
+		var item models.Item
+		if err := rows.Scan(&item.Name, &item.Notes, &item.Tags); err != nil {
+			return nil, fmt.Errorf("failed to scan item annotations: %w", err)
+		}
+		if item.Notes != "" || len(item.Tags) > 0 {
+			current[item.Name] = item
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get item annotations: %w", err)
+	}
+
+	kept := make([]models.Item, len(items))
+	for i, item := range items {
+		if old, ok := current[item.Name]; ok {
+			item.Notes = old.Notes
+			item.Tags = old.Tags
+		}
+		kept[i] = item
+	}
+	return kept, nil
+}
+
+// DeleteReceipt deletes a receipt and all its items (CASCADE), notifying
+// the deletion with the owner and household it had
 func (r *PostgresRepository) DeleteReceipt(ctx context.Context, id string) error {
 	result, err := r.Pool.Exec(ctx, `
-		DELETE FROM receipts WHERE id = $1
-	`, id)
+		WITH deleted AS (
+			DELETE FROM receipts WHERE id = $1 RETURNING id, owner, household_id
+		)
+		SELECT pg_notify($2, json_build_object('type', $3::text, 'receipt_id', id, 'owner', COALESCE(owner, ''),
+			'household_id', COALESCE(household_id::text, ''))::text)
+		FROM deleted
+	`, id, eventsChannel, models.EventReceiptDeleted)
 	if err != nil {
 		return fmt.Errorf("failed to delete receipt: %w", err)
 	}
 
 	if result.RowsAffected() == 0 {
-		return fmt.Errorf("receipt not found")
+		return ErrReceiptNotFound
 	}
 
 	return nil
 }
 
-// UpdateItem updates an item's quantity and price_paid
-func (r *PostgresRepository) UpdateItem(ctx context.Context, itemID string, quantity, pricePaid float64) error {
-	result, err := r.Pool.Exec(ctx, `
-		UPDATE items
-		SET quantity = $1, price_paid = $2
-		WHERE id = $3
-	`, quantity, pricePaid, itemID)
+// UpdateItem updates an item's quantity and price_paid, returns the ID of its receipt
+func (r *PostgresRepository) UpdateItem(ctx context.Context, itemID string, quantity, pricePaid float64) (string, error) {
+	// Pending reprocessings were compared against the old contents: they are superseded
+	var receiptID string
+	err := r.Pool.QueryRow(ctx, `
+		WITH updated AS (
+			UPDATE items
+			SET quantity = $1, price_paid = $2
+			WHERE id = $3
+			RETURNING receipt_id
+		), superseded AS (
+			UPDATE reprocessings
+			SET status = $4
+			WHERE receipt_id IN (SELECT receipt_id FROM updated) AND status = $5
+		)
+		SELECT receipt_id FROM updated
+	`, quantity, pricePaid, itemID, models.ReprocessingSuperseded, models.ReprocessingPending).Scan(&receiptID)
 	if err != nil {
-		return fmt.Errorf("failed to update item: %w", err)
+		if err == pgx.ErrNoRows {
+			return "", ErrItemNotFound
+		}
+		return "", fmt.Errorf("failed to update item: %w", err)
 	}
 
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("item not found")
+	if err := notifyReceiptEvent(ctx, r.Pool, models.EventReceiptUpdated, receiptID); err != nil {
+		return "", err
 	}
 
-	return nil
+	return receiptID, nil
 }
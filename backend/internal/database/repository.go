@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"github.com/vieitesss/ticketer/internal/models"
 )
@@ -12,18 +13,333 @@ type ReceiptRepository interface {
 	// CreateReceipt inserts a new receipt and its items into the database
 	CreateReceipt(ctx context.Context, receipt *models.Receipt) (string, error)
 
+	// ImportReceipts inserts receipts all or nothing, skipping duplicates; a dry run rolls back
+	ImportReceipts(ctx context.Context, receipts []*models.Receipt, dryRun bool) ([]ImportOutcome, error)
+
 	// GetReceipt retrieves a receipt by ID with all its items
 	GetReceipt(ctx context.Context, id string) (*models.Receipt, error)
 
-	// ListReceipts retrieves all receipts (without items, but with calculated totals)
-	ListReceipts(ctx context.Context, limit, offset int) ([]ReceiptListItem, error)
+	// ListReceipts retrieves the receipts matching a filter (without items, but with calculated totals)
+	ListReceipts(ctx context.Context, filter ReceiptFilter, limit, offset int) ([]ReceiptListItem, error)
+
+	// ExportItems calls fn with every item of the receipts matching a filter, as they are read
+	ExportItems(ctx context.Context, filter ReceiptFilter, fn func(ExportRow) error) error
+
+	// ListReceiptIDs retrieves the IDs of all receipts matching a filter
+	ListReceiptIDs(ctx context.Context, filter ReceiptFilter) ([]string, error)
 
 	// DeleteReceipt deletes a receipt by ID
 	DeleteReceipt(ctx context.Context, id string) error
 
-	// UpdateItem updates an item's quantity and price
-	UpdateItem(ctx context.Context, itemID string, quantity, pricePaid float64) error
+	// UpdateItem updates an item's quantity and price, returns the ID of its receipt
+	UpdateItem(ctx context.Context, itemID string, quantity, pricePaid float64) (string, error)
+
+	// CreateExtraction stores the provenance of an extraction
+	CreateExtraction(ctx context.Context, extraction *models.Extraction) (string, error)
+
+	// GetExtractionByReceipt retrieves the extraction whose result was kept for a receipt
+	GetExtractionByReceipt(ctx context.Context, receiptID string) (*models.Extraction, error)
+
+	// ListExtractionsByReceipt retrieves every extraction attempt linked to a receipt
+	ListExtractionsByReceipt(ctx context.Context, receiptID string) ([]models.Extraction, error)
+
+	// LinkExtraction links an extraction to a receipt
+	LinkExtraction(ctx context.Context, extractionID, receiptID string) error
+
+	// CreateReprocessing stores a proposed re-extraction of a receipt
+	CreateReprocessing(ctx context.Context, reprocessing *models.Reprocessing) (string, error)
+
+	// AcceptReprocessing applies a pending reprocessing to its receipt, superseding the other pending ones
+	AcceptReprocessing(ctx context.Context, id string) (*models.Reprocessing, error)
+
+	// RejectReprocessing resolves a pending reprocessing as rejected
+	RejectReprocessing(ctx context.Context, id string) error
+
+	// ListCategories retrieves the whole category taxonomy
+	ListCategories(ctx context.Context) ([]models.Category, error)
+
+	// GetCategory retrieves a category by ID
+	GetCategory(ctx context.Context, id string) (*models.Category, error)
+
+	// CreateCategory inserts a category, returns its ID
+	CreateCategory(ctx context.Context, category *models.Category) (string, error)
+
+	// UpdateCategory renames and moves a category
+	UpdateCategory(ctx context.Context, category *models.Category) error
+
+	// DeleteCategory deletes a category with its subcategories
+	DeleteCategory(ctx context.Context, id string) error
+
+	// ListCategoryRules retrieves every category rule
+	ListCategoryRules(ctx context.Context) ([]models.CategoryRule, error)
+
+	// CreateCategoryRule inserts a category rule, returns its ID
+	CreateCategoryRule(ctx context.Context, rule *models.CategoryRule) (string, error)
+
+	// DeleteCategoryRule deletes a category rule
+	DeleteCategoryRule(ctx context.Context, id string) error
+
+	// ApplyCategoryRules categorises the products in scope by the rules, except manually categorised ones
+	ApplyCategoryRules(ctx context.Context, scope CategoryScope) (int64, error)
+
+	// ListUncategorizedProducts retrieves up to limit products in scope without a category
+	ListUncategorizedProducts(ctx context.Context, scope CategoryScope, limit int) ([]models.Product, error)
+
+	// SetSuggestedCategories records AI suggested categories of uncategorised products
+	SetSuggestedCategories(ctx context.Context, categories map[string]string) (int64, error)
+
+	// SetProductCategories manually sets (or, with an empty category, clears) the category of products
+	SetProductCategories(ctx context.Context, productIDs []string, categoryID string) (int64, error)
+
+	// CategorySpending sums what was spent per category on the receipts matching a filter
+	CategorySpending(ctx context.Context, filter ReceiptFilter) ([]CategorySpending, error)
+
+	// ListRules retrieves the automation rules of a scope, highest priority first
+	ListRules(ctx context.Context, scope Scope) ([]models.Rule, error)
+
+	// ListReceiptRules retrieves the enabled automation rules that run on a receipt, highest priority first
+	ListReceiptRules(ctx context.Context, receiptID string) ([]models.Rule, error)
+
+	// GetRule retrieves an automation rule of a scope by ID
+	GetRule(ctx context.Context, id string, scope Scope) (*models.Rule, error)
+
+	// CreateRule inserts an automation rule, returns its ID
+	CreateRule(ctx context.Context, rule *models.Rule) (string, error)
+
+	// UpdateRule replaces an automation rule of a scope
+	UpdateRule(ctx context.Context, rule *models.Rule, scope Scope) error
+
+	// DeleteRule deletes an automation rule of a scope
+	DeleteRule(ctx context.Context, id string, scope Scope) error
+
+	// ApplyRuleEffects applies the tags, notes, flags and categories of the rules matching a receipt
+	ApplyRuleEffects(ctx context.Context, receiptID string, effects *models.RuleEffects) error
+
+	// UpdateReceiptAnnotations sets the tags, notes and business flag of a receipt
+	UpdateReceiptAnnotations(ctx context.Context, id string, annotations ReceiptAnnotations) error
+
+	// UpdateItemAnnotations sets the tags and notes of an item, returns the ID of its receipt
+	UpdateItemAnnotations(ctx context.Context, itemID string, annotations ItemAnnotations) (string, error)
+
+	// ListTags retrieves every tag in use on the receipts matching a filter with its number of receipts and items
+	ListTags(ctx context.Context, filter ReceiptFilter) ([]TagUsage, error)
+
+	// RenameTags renames (or merges) tags on the receipts matching a filter, their items and rules
+	RenameTags(ctx context.Context, filter ReceiptFilter, from []string, to string) (*TagChanges, error)
+
+	// DeleteTag removes a tag from the receipts matching a filter and their items
+	DeleteTag(ctx context.Context, filter ReceiptFilter, tag string) (*TagChanges, error)
+
+	// TagSpending sums what was spent per tag on the receipts matching a filter
+	TagSpending(ctx context.Context, filter ReceiptFilter) ([]TagSpending, error)
+
+	// ListMemberHouseholds retrieves the IDs of the households where a member has at least a role
+	ListMemberHouseholds(ctx context.Context, member, role string) ([]string, error)
+
+	// PublishEvent notifies an event to the listeners of every instance
+	PublishEvent(ctx context.Context, event *models.Event) error
+
+	// ListenEvents calls fn with every event notified by any instance until ctx is cancelled
+	ListenEvents(ctx context.Context, fn func(models.Event)) error
 
 	// Close closes the database connection
 	Close()
 }
+
+// SplitRepository defines the data access of bill splitting: participants,
+// how receipts are split between them and the settlements they make
+type SplitRepository interface {
+	// ListParticipants retrieves the participants of a scope
+	ListParticipants(ctx context.Context, scope Scope) ([]models.Participant, error)
+
+	// CreateParticipant inserts a participant, returns its ID
+	CreateParticipant(ctx context.Context, participant *models.Participant) (string, error)
+
+	// DeleteParticipant deletes a participant of a scope who is on no receipt or settlement
+	DeleteParticipant(ctx context.Context, id string, scope Scope) error
+
+	// GetReceiptSplit retrieves how a receipt is split
+	GetReceiptSplit(ctx context.Context, receiptID string) (*models.ReceiptSplit, error)
+
+	// ListReceiptSplits retrieves the split receipts matching a filter
+	ListReceiptSplits(ctx context.Context, filter ReceiptFilter) ([]models.ReceiptSplit, error)
+
+	// SetReceiptSplit replaces the participants, payer and item shares of a receipt
+	SetReceiptSplit(ctx context.Context, split *models.ReceiptSplit) error
+
+	// SetItemShares replaces the shares of an item, returns the ID of its receipt
+	SetItemShares(ctx context.Context, itemID string, shares map[string]float64) (string, error)
+
+	// ListSettlements retrieves the settlements of a scope
+	ListSettlements(ctx context.Context, scope Scope) ([]models.Settlement, error)
+
+	// CreateSettlement inserts a settlement, returns its ID
+	CreateSettlement(ctx context.Context, settlement *models.Settlement) (string, error)
+
+	// DeleteSettlement deletes a settlement of a scope
+	DeleteSettlement(ctx context.Context, id string, scope Scope) error
+
+	// ListMemberHouseholds retrieves the IDs of the households where a member has at least a role
+	ListMemberHouseholds(ctx context.Context, member, role string) ([]string, error)
+}
+
+// TokenRepository defines the data access of API tokens
+type TokenRepository interface {
+	// ListAPITokens retrieves the API tokens of an owner, or every one when
+	// owner is empty
+	ListAPITokens(ctx context.Context, owner string) ([]models.APIToken, error)
+
+	// GetAPITokenByHash retrieves an API token by the hash of the token
+	GetAPITokenByHash(ctx context.Context, hash string) (*models.APIToken, error)
+
+	// HasAPITokens reports whether any API token exists
+	HasAPITokens(ctx context.Context) (bool, error)
+
+	// CreateAPIToken inserts an API token, returns its ID
+	CreateAPIToken(ctx context.Context, token *models.APIToken) (string, error)
+
+	// DeleteAPIToken deletes (revokes) an API token of an owner, of anyone
+	// when owner is empty
+	DeleteAPIToken(ctx context.Context, id, owner string) error
+
+	// TouchAPIToken records that an API token was used
+	TouchAPIToken(ctx context.Context, id string) error
+}
+
+// BudgetRepository defines the data access of budgets and their alerts
+type BudgetRepository interface {
+	// ListBudgets retrieves the budgets of a scope
+	ListBudgets(ctx context.Context, scope Scope) ([]models.Budget, error)
+
+	// ListReceiptBudgets retrieves the budgets that add up a receipt
+	ListReceiptBudgets(ctx context.Context, receiptID string) ([]models.Budget, error)
+
+	// GetBudget retrieves a budget of a scope by ID
+	GetBudget(ctx context.Context, id string, scope Scope) (*models.Budget, error)
+
+	// CreateBudget inserts a budget, returns its ID
+	CreateBudget(ctx context.Context, budget *models.Budget) (string, error)
+
+	// UpdateBudget replaces a budget of a scope
+	UpdateBudget(ctx context.Context, budget *models.Budget, scope Scope) error
+
+	// DeleteBudget deletes a budget of a scope
+	DeleteBudget(ctx context.Context, id string, scope Scope) error
+
+	// BudgetSpending sums what was spent on a budget between two dates, optionally leaving a receipt out
+	BudgetSpending(ctx context.Context, budget *models.Budget, start, end, excludeID string) (float64, error)
+
+	// RecordBudgetAlert records a threshold crossed by a budget in a period, returns false if it already was
+	RecordBudgetAlert(ctx context.Context, budgetID, periodStart string, threshold int, receiptID string, spent float64) (bool, error)
+}
+
+// WebhookRepository defines the data access of webhooks and the queue of
+// their deliveries
+type WebhookRepository interface {
+	// ListWebhooks retrieves every webhook
+	ListWebhooks(ctx context.Context) ([]models.Webhook, error)
+
+	// GetWebhook retrieves a webhook by ID
+	GetWebhook(ctx context.Context, id string) (*models.Webhook, error)
+
+	// CreateWebhook inserts a webhook, returns its ID
+	CreateWebhook(ctx context.Context, webhook *models.Webhook) (string, error)
+
+	// UpdateWebhook replaces a webhook, keeping its secret when none is given
+	UpdateWebhook(ctx context.Context, webhook *models.Webhook) error
+
+	// DeleteWebhook deletes a webhook with its deliveries
+	DeleteWebhook(ctx context.Context, id string) error
+
+	// EnqueueWebhookEvent queues an event for the webhooks subscribed to it, returns how many deliveries were queued
+	EnqueueWebhookEvent(ctx context.Context, eventID, event string, payload []byte) (int64, error)
+
+	// ClaimWebhookDeliveries takes pending deliveries that are due, hiding them from other instances for lease
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+
+	// RecordWebhookAttempt records the outcome of an attempt to deliver an event
+	RecordWebhookAttempt(ctx context.Context, attempt WebhookAttempt) error
+
+	// ListWebhookDeliveries retrieves the latest deliveries of a webhook
+	ListWebhookDeliveries(ctx context.Context, webhookID, status string, limit int) ([]models.WebhookDelivery, error)
+
+	// RetryWebhookDelivery queues a delivery again
+	RetryWebhookDelivery(ctx context.Context, webhookID, deliveryID string) error
+
+	// PruneWebhookDeliveries deletes the finished deliveries created before a time
+	PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
+
+// HouseholdRepository defines the data access of households: their members,
+// invitations and receipts
+type HouseholdRepository interface {
+	// ListHouseholds retrieves the households of a member with their role
+	ListHouseholds(ctx context.Context, member string) ([]models.Household, error)
+
+	// GetHousehold retrieves a household by ID
+	GetHousehold(ctx context.Context, id string) (*models.Household, error)
+
+	// CreateHousehold inserts a household with its first owner, returns its ID
+	CreateHousehold(ctx context.Context, name, owner string) (string, error)
+
+	// RenameHousehold renames a household
+	RenameHousehold(ctx context.Context, id, name string) error
+
+	// DeleteHousehold deletes a household without receipts
+	DeleteHousehold(ctx context.Context, id string) error
+
+	// GetHouseholdRole retrieves the role of a user in a household, empty when they are not a member
+	GetHouseholdRole(ctx context.Context, id, member string) (string, error)
+
+	// ListHouseholdMembers retrieves the members of a household
+	ListHouseholdMembers(ctx context.Context, id string) ([]models.HouseholdMember, error)
+
+	// SetHouseholdRole changes the role of a member, keeping an owner
+	SetHouseholdRole(ctx context.Context, id, member, role string) error
+
+	// RemoveHouseholdMember removes a member, keeping an owner
+	RemoveHouseholdMember(ctx context.Context, id, member string) error
+
+	// ListInvitations retrieves the pending invitations of a user
+	ListInvitations(ctx context.Context, invitee string) ([]models.HouseholdInvitation, error)
+
+	// ListHouseholdInvitations retrieves the pending invitations to a household
+	ListHouseholdInvitations(ctx context.Context, id string) ([]models.HouseholdInvitation, error)
+
+	// GetInvitation retrieves an invitation by ID
+	GetInvitation(ctx context.Context, id string) (*models.HouseholdInvitation, error)
+
+	// CreateInvitation inserts an invitation of a user who is not a member yet, returns its ID
+	CreateInvitation(ctx context.Context, invitation *models.HouseholdInvitation) (string, error)
+
+	// AcceptInvitation makes the invitee a member, deleting the invitation
+	AcceptInvitation(ctx context.Context, id string) error
+
+	// DeleteInvitation deletes (declines or cancels) an invitation
+	DeleteInvitation(ctx context.Context, id string) error
+
+	// GetReceiptHousehold retrieves the household of a receipt and its owner, empty when it has none
+	GetReceiptHousehold(ctx context.Context, receiptID string) (householdID, owner string, err error)
+
+	// HasHouseholds reports whether any household exists
+	HasHouseholds(ctx context.Context) (bool, error)
+
+	// GetItemReceiptID retrieves the receipt of an item
+	GetItemReceiptID(ctx context.Context, itemID string) (string, error)
+
+	// GetReprocessingReceiptID retrieves the receipt of a reprocessing
+	GetReprocessingReceiptID(ctx context.Context, id string) (string, error)
+
+	// MoveReceipt moves a receipt to a household, or out of any when empty
+	MoveReceipt(ctx context.Context, receiptID, householdID string) error
+}
+
+// Repository is every data access interface, as the database implements them
+type Repository interface {
+	ReceiptRepository
+	BudgetRepository
+	WebhookRepository
+	SplitRepository
+	TokenRepository
+	HouseholdRepository
+}
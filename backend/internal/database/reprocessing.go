@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// ErrReprocessingNotFound is returned when a reprocessing does not exist
+var ErrReprocessingNotFound = errors.New("reprocessing not found")
+
+// ErrReprocessingResolved is returned when a reprocessing is no longer pending
+var ErrReprocessingResolved = errors.New("reprocessing is no longer pending")
+
+// querier runs queries on the pool or within a transaction
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// CreateReprocessing stores a proposed re-extraction, returns the reprocessing ID
+func (r *PostgresRepository) CreateReprocessing(ctx context.Context, reprocessing *models.Reprocessing) (string, error) {
+	proposed, err := json.Marshal(reprocessing.Proposed)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal proposed receipt: %w", err)
+	}
+
+	diff, err := json.Marshal(reprocessing.Diff)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal diff: %w", err)
+	}
+
+	var extractionID *string
+	if reprocessing.ExtractionID != "" {
+		extractionID = &reprocessing.ExtractionID
+	}
+
+	var reprocessingID string
+	err = r.Pool.QueryRow(ctx, `
+		INSERT INTO reprocessings (id, receipt_id, extraction_id, status, proposed, diff)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, uuid.New().String(), reprocessing.ReceiptID, extractionID, models.ReprocessingPending, proposed, diff).Scan(&reprocessingID)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert reprocessing: %w", err)
+	}
+
+	return reprocessingID, nil
+}
+
+// scanReprocessing scans a row of the reprocessings table
+func scanReprocessing(row pgx.Row) (*models.Reprocessing, error) {
+	var reprocessing models.Reprocessing
+	var extractionID *string
+	var proposed, diff []byte
+	err := row.Scan(&reprocessing.ID, &reprocessing.ReceiptID, &extractionID, &reprocessing.Status, &proposed, &diff, &reprocessing.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to get reprocessing: %w", err)
+	}
+
+	if extractionID != nil {
+		reprocessing.ExtractionID = *extractionID
+	}
+	if err := json.Unmarshal(proposed, &reprocessing.Proposed); err != nil {
+		return nil, fmt.Errorf("failed to parse proposed receipt: %w", err)
+	}
+	if err := json.Unmarshal(diff, &reprocessing.Diff); err != nil {
+		return nil, fmt.Errorf("failed to parse diff: %w", err)
+	}
+
+	return &reprocessing, nil
+}
+
+// AcceptReprocessing applies a pending reprocessing to its receipt in a
+// single transaction: it claims the reprocessing, replaces the contents of
+// the receipt and supersedes the other pending reprocessings of the receipt,
+// which were compared against the contents being replaced. The receipt is
+// locked first, so concurrent accepts for a receipt run one after the other
+// and only the first one applies.
+func (r *PostgresRepository) AcceptReprocessing(ctx context.Context, id string) (*models.Reprocessing, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var receiptID string
+	err = tx.QueryRow(ctx, `
+		SELECT r.id
+		FROM receipts r
+		JOIN reprocessings rp ON rp.receipt_id = r.id
+		WHERE rp.id = $1
+		FOR UPDATE OF r
+	`, id).Scan(&receiptID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrReprocessingNotFound
+		}
+		return nil, fmt.Errorf("failed to lock receipt: %w", err)
+	}
+
+	row := tx.QueryRow(ctx, `
+		UPDATE reprocessings
+		SET status = $1
+		WHERE id = $2 AND status = $3
+		RETURNING id, receipt_id, extraction_id, status, proposed, diff, created_at
+	`, models.ReprocessingAccepted, id, models.ReprocessingPending)
+	reprocessing, err := scanReprocessing(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, unresolvableReprocessing(ctx, tx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := replaceReceiptContentsTx(ctx, tx, receiptID, &reprocessing.Proposed); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE reprocessings
+		SET status = $1
+		WHERE receipt_id = $2 AND status = $3
+	`, models.ReprocessingSuperseded, receiptID, models.ReprocessingPending); err != nil {
+		return nil, fmt.Errorf("failed to supersede reprocessings: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return reprocessing, nil
+}
+
+// RejectReprocessing resolves a pending reprocessing as rejected
+func (r *PostgresRepository) RejectReprocessing(ctx context.Context, id string) error {
+	result, err := r.Pool.Exec(ctx, `
+		UPDATE reprocessings
+		SET status = $1
+		WHERE id = $2 AND status = $3
+	`, models.ReprocessingRejected, id, models.ReprocessingPending)
+	if err != nil {
+		return fmt.Errorf("failed to update reprocessing: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return unresolvableReprocessing(ctx, r.Pool, id)
+	}
+
+	return nil
+}
+
+// unresolvableReprocessing explains why a reprocessing could not be
+// resolved: it does not exist, or it already was
+func unresolvableReprocessing(ctx context.Context, db querier, id string) error {
+	var status string
+	err := db.QueryRow(ctx, `SELECT status FROM reprocessings WHERE id = $1`, id).Scan(&status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrReprocessingNotFound
+		}
+		return fmt.Errorf("failed to get reprocessing: %w", err)
+	}
+
+	return fmt.Errorf("%w: already %s", ErrReprocessingResolved, status)
+}
@@ -0,0 +1,294 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// ErrRuleNotFound is returned when a rule does not exist
+var ErrRuleNotFound = errors.New("rule not found")
+
+// ruleColumns are the columns of the rules table u scanned by scanRule
+const ruleColumns = `u.id, u.name, u.scope, u.condition, u.actions, u.enabled, u.priority,
+		COALESCE(u.owner, ''), COALESCE(u.household_id::text, ''), u.created_at, u.updated_at`
+
+// ListRules retrieves the automation rules of a scope, highest priority first
+func (r *PostgresRepository) ListRules(ctx context.Context, scope Scope) ([]models.Rule, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT `+ruleColumns+`
+		FROM rules u
+		WHERE `+ownedSQL("u", 1)+`
+		ORDER BY u.priority DESC, u.created_at
+	`, scope.args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules: %w", err)
+	}
+
+	return collectRules(rows)
+}
+
+// ListReceiptRules retrieves the enabled automation rules that run on a
+// receipt, highest priority first
+func (r *PostgresRepository) ListReceiptRules(ctx context.Context, receiptID string) ([]models.Rule, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT `+ruleColumns+`
+		FROM rules u
+		JOIN receipts r ON r.id = $1
+		WHERE u.enabled
+		  AND (CASE WHEN u.household_id IS NULL
+			THEN u.owner IS NULL OR u.owner = r.owner
+			ELSE u.household_id = r.household_id
+		  END)
+		ORDER BY u.priority DESC, u.created_at
+	`, receiptID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list receipt rules: %w", err)
+	}
+
+	return collectRules(rows)
+}
+
+// collectRules scans the rows of a query of rules
+func collectRules(rows pgx.Rows) ([]models.Rule, error) {
+	defer rows.Close()
+
+	rules := []models.Rule{}
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+
+	return rules, nil
+}
+
+// GetRule retrieves an automation rule of a scope by ID
+func (r *PostgresRepository) GetRule(ctx context.Context, id string, scope Scope) (*models.Rule, error) {
+	row := r.Pool.QueryRow(ctx, `
+		SELECT `+ruleColumns+`
+		FROM rules u
+		WHERE u.id = $1 AND `+ownedSQL("u", 2)+`
+	`, append([]any{id}, scope.args()...)...)
+
+	rule, err := scanRule(row)
+	if errors.Is(err, pgx.ErrNoRows) || pgErrorCode(err) == pgInvalidText {
+		return nil, ErrRuleNotFound
+	}
+	return rule, err
+}
+
+// scanRule scans a row of the rules table
+func scanRule(row pgx.Row) (*models.Rule, error) {
+	var rule models.Rule
+	var actions []byte
+	err := row.Scan(&rule.ID, &rule.Name, &rule.Scope, &rule.Condition, &actions, &rule.Enabled, &rule.Priority,
+		&rule.Owner, &rule.HouseholdID, &rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan rule: %w", err)
+	}
+
+	if err := json.Unmarshal(actions, &rule.Actions); err != nil {
+		return nil, fmt.Errorf("failed to decode rule actions: %w", err)
+	}
+
+	return &rule, nil
+}
+
+// CreateRule inserts an automation rule, returns its ID. Returns
+// ErrHouseholdNotFound if its household does not exist.
+func (r *PostgresRepository) CreateRule(ctx context.Context, rule *models.Rule) (string, error) {
+	actions, err := json.Marshal(rule.Actions)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode rule actions: %w", err)
+	}
+
+	ruleID := uuid.New().String()
+	_, err = r.Pool.Exec(ctx, `
+		INSERT INTO rules (id, name, scope, condition, actions, enabled, priority, owner, household_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, '')::uuid)
+	`, ruleID, rule.Name, rule.Scope, rule.Condition, actions, rule.Enabled, rule.Priority, rule.Owner, rule.HouseholdID)
+	if err != nil {
+		if pgErrorCode(err) == pgForeignKeyViolation {
+			return "", ErrHouseholdNotFound
+		}
+		return "", fmt.Errorf("failed to create rule: %w", err)
+	}
+
+	return ruleID, nil
+}
+
+// UpdateRule replaces an automation rule of a scope. Its owner and household
+// stay as they are.
+func (r *PostgresRepository) UpdateRule(ctx context.Context, rule *models.Rule, scope Scope) error {
+	actions, err := json.Marshal(rule.Actions)
+	if err != nil {
+		return fmt.Errorf("failed to encode rule actions: %w", err)
+	}
+
+	result, err := r.Pool.Exec(ctx, `
+		UPDATE rules u
+		SET name = $1, scope = $2, condition = $3, actions = $4, enabled = $5, priority = $6, updated_at = NOW()
+		WHERE u.id = $7 AND `+ownedSQL("u", 8)+`
+	`, append([]any{rule.Name, rule.Scope, rule.Condition, actions, rule.Enabled, rule.Priority, rule.ID}, scope.args()...)...)
+	if pgErrorCode(err) == pgInvalidText {
+		return ErrRuleNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update rule: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrRuleNotFound
+	}
+
+	return nil
+}
+
+// DeleteRule deletes an automation rule of a scope. What it already changed stays.
+func (r *PostgresRepository) DeleteRule(ctx context.Context, id string, scope Scope) error {
+	result, err := r.Pool.Exec(ctx, `
+		DELETE FROM rules u WHERE u.id = $1 AND `+ownedSQL("u", 2)+`
+	`, append([]any{id}, scope.args()...)...)
+	if pgErrorCode(err) == pgInvalidText {
+		return ErrRuleNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete rule: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrRuleNotFound
+	}
+
+	return nil
+}
+
+// ApplyRuleEffects applies what the rules matching a receipt change on it, in
+// one transaction. Tags and notes are only added, never removed, and a note
+// already present is not repeated. Categories replace those assigned by
+// category rules or the AI, but never manual ones.
+func (r *PostgresRepository) ApplyRuleEffects(ctx context.Context, receiptID string, effects *models.RuleEffects) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if len(effects.ReceiptTags) > 0 {
+		if err := addReceiptTagsTx(ctx, tx, receiptID, effects.ReceiptTags); err != nil {
+			return err
+		}
+	}
+	for itemID, tags := range effects.ItemTags {
+		if err := addItemTagsTx(ctx, tx, itemID, tags); err != nil {
+			return err
+		}
+	}
+
+	if len(effects.ReceiptNotes) > 0 {
+		if err := appendNotesTx(ctx, tx, "receipts", receiptID, effects.ReceiptNotes); err != nil {
+			return err
+		}
+	}
+	for itemID, notes := range effects.ItemNotes {
+		if err := appendNotesTx(ctx, tx, "items", itemID, notes); err != nil {
+			return err
+		}
+	}
+
+	if effects.Business != nil {
+		_, err := tx.Exec(ctx, `UPDATE receipts SET business = $1 WHERE id = $2`, *effects.Business, receiptID)
+		if err != nil {
+			return fmt.Errorf("failed to flag receipt: %w", err)
+		}
+	}
+
+	for productID, categoryID := range effects.ProductCategories {
+		_, err := tx.Exec(ctx, `
+			UPDATE products
+			SET category_id = c.id, category_source = 'automation'
+			FROM categories c
+			WHERE products.id = $1 AND c.id = $2 AND products.category_source IS DISTINCT FROM 'manual'
+		`, productID, categoryID)
+		if err != nil {
+			return fmt.Errorf("failed to set product category: %w", err)
+		}
+	}
+
+	if err := notifyReceiptEvent(ctx, tx, models.EventReceiptUpdated, receiptID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// addReceiptTagsTx tags a receipt within a transaction, skipping the tags it has
+func addReceiptTagsTx(ctx context.Context, tx pgx.Tx, receiptID string, tags []string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO receipt_tags (receipt_id, tag)
+		SELECT $1, UNNEST($2::text[])
+		ON CONFLICT DO NOTHING
+	`, receiptID, tags)
+	if err != nil {
+		return fmt.Errorf("failed to tag receipt: %w", err)
+	}
+	return nil
+}
+
+// addItemTagsTx tags an item within a transaction, skipping the tags it has
+func addItemTagsTx(ctx context.Context, tx pgx.Tx, itemID string, tags []string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO item_tags (item_id, tag)
+		SELECT $1, UNNEST($2::text[])
+		ON CONFLICT DO NOTHING
+	`, itemID, tags)
+	if err != nil {
+		return fmt.Errorf("failed to tag item: %w", err)
+	}
+	return nil
+}
+
+// appendNotesTx appends notes, one per line, to the notes of a receipt or an
+// item within a transaction, skipping those already present. table is
+// "receipts" or "items".
+func appendNotesTx(ctx context.Context, tx pgx.Tx, table, id string, notes []string) error {
+	var current string
+	err := tx.QueryRow(ctx, `SELECT notes FROM `+table+` WHERE id = $1 FOR UPDATE`, id).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to read notes: %w", err)
+	}
+
+	lines := []string{}
+	if current != "" {
+		lines = strings.Split(current, "\n")
+	}
+	present := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		present[line] = true
+	}
+	for _, note := range notes {
+		if !present[note] {
+			lines = append(lines, note)
+			present[note] = true
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE `+table+` SET notes = $1 WHERE id = $2`, strings.Join(lines, "\n"), id); err != nil {
+		return fmt.Errorf("failed to update notes: %w", err)
+	}
+	return nil
+}
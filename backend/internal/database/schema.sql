@@ -17,10 +17,22 @@ CREATE TABLE IF NOT EXISTS receipts (
     id UUID PRIMARY KEY,
     store_id UUID NOT NULL REFERENCES stores(id) ON DELETE RESTRICT,
     discounts NUMERIC(10, 2) DEFAULT 0,
-    receipt_hash VARCHAR(64) UNIQUE,
+    receipt_hash VARCHAR(64),
     bought_date DATE NOT NULL
 );
 
+-- Keep the original image so receipts can be reprocessed
+ALTER TABLE receipts ADD COLUMN IF NOT EXISTS image_path TEXT;
+
+-- User who submitted the receipt (e.g. mapped from an email sender)
+ALTER TABLE receipts ADD COLUMN IF NOT EXISTS owner VARCHAR(255);
+
+-- Invoice number of receipts imported from e-invoices (Facturae, UBL)
+ALTER TABLE receipts ADD COLUMN IF NOT EXISTS document_number VARCHAR(100);
+
+-- Tax identification number (NIF/VAT ID) of the seller, known from e-invoices
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS tax_id VARCHAR(32);
+
 -- Create items table (line items on receipts)
 CREATE TABLE IF NOT EXISTS items (
     id UUID PRIMARY KEY,
@@ -30,11 +42,299 @@ CREATE TABLE IF NOT EXISTS items (
     price_paid NUMERIC(10, 2) NOT NULL
 );
 
+-- VAT rate of the line in percent, known from e-invoices
+ALTER TABLE items ADD COLUMN IF NOT EXISTS tax_rate NUMERIC(5, 2);
+
+-- Create extractions table (provenance of each AI extraction)
+CREATE TABLE IF NOT EXISTS extractions (
+    id UUID PRIMARY KEY,
+    receipt_id UUID REFERENCES receipts(id) ON DELETE CASCADE,
+    store_answer VARCHAR(255),
+    store_model VARCHAR(100),
+    store_prompt_version VARCHAR(50),
+    store_latency_ms INTEGER,
+    store_prompt_tokens INTEGER,
+    store_output_tokens INTEGER,
+    items_model VARCHAR(100),
+    items_prompt_version VARCHAR(50),
+    items_latency_ms INTEGER,
+    items_prompt_tokens INTEGER,
+    items_output_tokens INTEGER,
+    raw_response TEXT,
+    error_message TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Escalation attempts of an extraction
+ALTER TABLE extractions ADD COLUMN IF NOT EXISTS attempt INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE extractions ADD COLUMN IF NOT EXISTS strategy VARCHAR(50) NOT NULL DEFAULT 'initial';
+ALTER TABLE extractions ADD COLUMN IF NOT EXISTS issues TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE extractions ADD COLUMN IF NOT EXISTS selected BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- Create reprocessings table (proposed re-extractions awaiting review)
+CREATE TABLE IF NOT EXISTS reprocessings (
+    id UUID PRIMARY KEY,
+    receipt_id UUID NOT NULL REFERENCES receipts(id) ON DELETE CASCADE,
+    extraction_id UUID REFERENCES extractions(id) ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    proposed JSONB NOT NULL,
+    diff JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Create categories table (user-editable taxonomy, nested through parent_id)
+CREATE TABLE IF NOT EXISTS categories (
+    id UUID PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    parent_id UUID REFERENCES categories(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Sibling categories have distinct names
+CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_parent_name
+    ON categories (COALESCE(parent_id, '00000000-0000-0000-0000-000000000000'), LOWER(name));
+
+-- Every category with its slash-separated path (e.g. "Groceries/Dairy") and
+-- its ancestors, itself included
+CREATE OR REPLACE VIEW category_paths AS
+WITH RECURSIVE tree AS (
+    SELECT id, parent_id, name, name::TEXT AS path, ARRAY[id] AS ancestors
+    FROM categories
+    WHERE parent_id IS NULL
+    UNION ALL
+    SELECT c.id, c.parent_id, c.name, t.path || '/' || c.name, t.ancestors || c.id
+    FROM categories c
+    JOIN tree t ON c.parent_id = t.id
+)
+SELECT id, parent_id, name, path, ancestors FROM tree;
+
+-- Category of each store product, and what assigned it: a rule, the AI or a
+-- person (manual assignments are never overwritten)
+ALTER TABLE products ADD COLUMN IF NOT EXISTS category_id UUID REFERENCES categories(id) ON DELETE SET NULL;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS category_source VARCHAR(20);
+
+-- Create category_rules table (assign a category by product name pattern, or to a store product)
+CREATE TABLE IF NOT EXISTS category_rules (
+    id UUID PRIMARY KEY,
+    category_id UUID NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+    pattern TEXT,
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    priority INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((pattern IS NULL) <> (product_id IS NULL))
+);
+
+-- Create rules table (automation rules: a condition on a receipt or its items, and actions)
+CREATE TABLE IF NOT EXISTS rules (
+    id UUID PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    scope VARCHAR(20) NOT NULL,
+    condition TEXT NOT NULL,
+    actions JSONB NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    priority INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Tags of receipts and items
+CREATE TABLE IF NOT EXISTS receipt_tags (
+    receipt_id UUID NOT NULL REFERENCES receipts(id) ON DELETE CASCADE,
+    tag VARCHAR(64) NOT NULL,
+    PRIMARY KEY (receipt_id, tag)
+);
+
+CREATE TABLE IF NOT EXISTS item_tags (
+    item_id UUID NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    tag VARCHAR(64) NOT NULL,
+    PRIMARY KEY (item_id, tag)
+);
+
+-- Notes, and the business expense flag
+ALTER TABLE receipts ADD COLUMN IF NOT EXISTS notes TEXT NOT NULL DEFAULT '';
+ALTER TABLE receipts ADD COLUMN IF NOT EXISTS business BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE items ADD COLUMN IF NOT EXISTS notes TEXT NOT NULL DEFAULT '';
+
+-- Create budgets table (spending limit per period on a category, a store or a tag)
+CREATE TABLE IF NOT EXISTS budgets (
+    id UUID PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    period VARCHAR(20) NOT NULL,
+    amount NUMERIC(10, 2) NOT NULL,
+    category_id UUID REFERENCES categories(id) ON DELETE CASCADE,
+    store_name VARCHAR(255),
+    tag VARCHAR(64),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((category_id IS NOT NULL)::int + (store_name IS NOT NULL)::int + (tag IS NOT NULL)::int = 1)
+);
+
+-- Thresholds each budget crossed per period, so each is notified once
+CREATE TABLE IF NOT EXISTS budget_alerts (
+    budget_id UUID NOT NULL REFERENCES budgets(id) ON DELETE CASCADE,
+    period_start DATE NOT NULL,
+    threshold INTEGER NOT NULL,
+    receipt_id UUID REFERENCES receipts(id) ON DELETE SET NULL,
+    spent NUMERIC(10, 2) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (budget_id, period_start, threshold)
+);
+
+-- Create webhooks table (subscriptions of URLs to events)
+CREATE TABLE IF NOT EXISTS webhooks (
+    id UUID PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret VARCHAR(128) NOT NULL,
+    events TEXT[] NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Create webhook_deliveries table (queue and log of the events sent to webhooks)
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id UUID PRIMARY KEY,
+    webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id UUID NOT NULL,
+    event VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_status_code INTEGER,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMPTZ
+);
+
+-- Create participants table (people receipts are split between)
+CREATE TABLE IF NOT EXISTS participants (
+    id UUID PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Participants a receipt is split between, and which one paid it
+CREATE TABLE IF NOT EXISTS receipt_participants (
+    receipt_id UUID NOT NULL REFERENCES receipts(id) ON DELETE CASCADE,
+    participant_id UUID NOT NULL REFERENCES participants(id),
+    paid BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (receipt_id, participant_id)
+);
+
+-- Fractions of items assigned to participants (the rest is shared equally)
+CREATE TABLE IF NOT EXISTS item_shares (
+    item_id UUID NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    participant_id UUID NOT NULL REFERENCES participants(id),
+    share NUMERIC(7, 6) NOT NULL CHECK (share > 0 AND share <= 1),
+    PRIMARY KEY (item_id, participant_id)
+);
+
+-- Create settlements table (payments between participants)
+CREATE TABLE IF NOT EXISTS settlements (
+    id UUID PRIMARY KEY,
+    from_participant_id UUID NOT NULL REFERENCES participants(id),
+    to_participant_id UUID NOT NULL REFERENCES participants(id),
+    amount NUMERIC(10, 2) NOT NULL CHECK (amount > 0),
+    settled_on DATE NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (from_participant_id <> to_participant_id)
+);
+
+-- Create api_tokens table (personal tokens for the API, stored hashed)
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id UUID PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    owner VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- User whose token created each API token, empty for the command line
+ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS created_by VARCHAR(255);
+
+-- Create households table (shared ledgers of receipts)
+CREATE TABLE IF NOT EXISTS households (
+    id UUID PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Create household_members table (users of a household, by API token owner)
+CREATE TABLE IF NOT EXISTS household_members (
+    household_id UUID NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+    member VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (household_id, member)
+);
+
+-- Create household_invitations table (pending invitations to join a household)
+CREATE TABLE IF NOT EXISTS household_invitations (
+    id UUID PRIMARY KEY,
+    household_id UUID NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+    invitee VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    invited_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (household_id, invitee)
+);
+
+-- Household the receipt belongs to, only seen by its members. Households
+-- with receipts cannot be deleted.
+ALTER TABLE receipts ADD COLUMN IF NOT EXISTS household_id UUID REFERENCES households(id) ON DELETE RESTRICT;
+
+-- Owner and household of automation rules. Rules of a household run on its
+-- receipts, other rules on those of their owner, or on all without an owner.
+ALTER TABLE rules ADD COLUMN IF NOT EXISTS owner VARCHAR(255);
+ALTER TABLE rules ADD COLUMN IF NOT EXISTS household_id UUID REFERENCES households(id) ON DELETE CASCADE;
+
+-- Owner and household of budgets. Budgets of a household add up its
+-- receipts, other budgets those of their owner, or all without an owner.
+ALTER TABLE budgets ADD COLUMN IF NOT EXISTS owner VARCHAR(255);
+ALTER TABLE budgets ADD COLUMN IF NOT EXISTS household_id UUID REFERENCES households(id) ON DELETE CASCADE;
+
+-- Owner and household of participants and settlements. Names of participants
+-- are unique per household, or else per owner.
+ALTER TABLE participants ADD COLUMN IF NOT EXISTS owner VARCHAR(255);
+ALTER TABLE participants ADD COLUMN IF NOT EXISTS household_id UUID REFERENCES households(id) ON DELETE CASCADE;
+ALTER TABLE settlements ADD COLUMN IF NOT EXISTS owner VARCHAR(255);
+ALTER TABLE settlements ADD COLUMN IF NOT EXISTS household_id UUID REFERENCES households(id) ON DELETE CASCADE;
+DROP INDEX IF EXISTS idx_participants_name;
+
+-- Receipts are duplicates within a household, or else for the same owner
+ALTER TABLE receipts DROP CONSTRAINT IF EXISTS receipts_receipt_hash_key;
+
 -- Create indexes for better query performance
 CREATE INDEX IF NOT EXISTS idx_receipts_store_id ON receipts(store_id);
 CREATE INDEX IF NOT EXISTS idx_receipts_bought_date ON receipts(bought_date DESC);
-CREATE INDEX IF NOT EXISTS idx_receipts_hash ON receipts(receipt_hash);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_receipts_ledger_hash ON receipts(COALESCE(household_id::text, owner, ''), receipt_hash);
 CREATE INDEX IF NOT EXISTS idx_products_store_id ON products(store_id);
 CREATE INDEX IF NOT EXISTS idx_products_name ON products(name);
 CREATE INDEX IF NOT EXISTS idx_items_receipt_id ON items(receipt_id);
 CREATE INDEX IF NOT EXISTS idx_items_product_id ON items(product_id);
+CREATE INDEX IF NOT EXISTS idx_extractions_receipt_id ON extractions(receipt_id);
+CREATE INDEX IF NOT EXISTS idx_reprocessings_receipt_id ON reprocessings(receipt_id);
+CREATE INDEX IF NOT EXISTS idx_products_category_id ON products(category_id);
+CREATE INDEX IF NOT EXISTS idx_category_rules_category_id ON category_rules(category_id);
+CREATE INDEX IF NOT EXISTS idx_receipt_tags_tag ON receipt_tags(tag);
+CREATE INDEX IF NOT EXISTS idx_item_tags_tag ON item_tags(tag);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_participants_scope_name ON participants(COALESCE(household_id::text, owner, ''), LOWER(name));
+CREATE UNIQUE INDEX IF NOT EXISTS idx_receipt_participants_payer ON receipt_participants(receipt_id) WHERE paid;
+CREATE INDEX IF NOT EXISTS idx_receipt_participants_participant_id ON receipt_participants(participant_id);
+CREATE INDEX IF NOT EXISTS idx_item_shares_participant_id ON item_shares(participant_id);
+CREATE INDEX IF NOT EXISTS idx_receipts_household_id ON receipts(household_id);
+CREATE INDEX IF NOT EXISTS idx_household_members_member ON household_members(member);
+CREATE INDEX IF NOT EXISTS idx_household_invitations_invitee ON household_invitations(invitee);
+CREATE INDEX IF NOT EXISTS idx_rules_household_id ON rules(household_id);
+CREATE INDEX IF NOT EXISTS idx_budgets_household_id ON budgets(household_id);
+CREATE INDEX IF NOT EXISTS idx_settlements_household_id ON settlements(household_id);
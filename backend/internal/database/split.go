@@ -0,0 +1,502 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// ErrParticipantNotFound is returned when a participant does not exist
+var ErrParticipantNotFound = errors.New("participant not found")
+
+// ErrParticipantConflict is returned when a participant would share the name
+// of another, or is deleted while receipts or settlements refer to them
+var ErrParticipantConflict = errors.New("participant conflict")
+
+// ErrNotParticipant is returned when an item is assigned to someone who is
+// not a participant of its receipt
+var ErrNotParticipant = errors.New("not a participant of the receipt")
+
+// ErrSettlementNotFound is returned when a settlement does not exist
+var ErrSettlementNotFound = errors.New("settlement not found")
+
+// ErrSplitItemsDropped is returned when the items of a receipt are replaced by
+// items that leave out some that were assigned to participants
+var ErrSplitItemsDropped = errors.New("the new items leave out items assigned to participants")
+
+// ListParticipants retrieves the participants of a scope, by name
+func (r *PostgresRepository) ListParticipants(ctx context.Context, scope Scope) ([]models.Participant, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT p.id, p.name, COALESCE(p.owner, ''), COALESCE(p.household_id::text, ''), p.created_at
+		FROM participants p
+		WHERE `+ownedSQL("p", 1)+`
+		ORDER BY LOWER(p.name)
+	`, scope.args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list participants: %w", err)
+	}
+	defer rows.Close()
+
+	participants := []models.Participant{}
+	for rows.Next() {
+		var participant models.Participant
+		if err := rows.Scan(&participant.ID, &participant.Name, &participant.Owner, &participant.HouseholdID,
+			&participant.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan participant: %w", err)
+		}
+		participants = append(participants, participant)
+	}
+
+	return participants, nil
+}
+
+// CreateParticipant inserts a participant, returns its ID
+func (r *PostgresRepository) CreateParticipant(ctx context.Context, participant *models.Participant) (string, error) {
+	participantID := uuid.New().String()
+	_, err := r.Pool.Exec(ctx, `
+		INSERT INTO participants (id, name, owner, household_id) VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, '')::uuid)
+	`, participantID, participant.Name, participant.Owner, participant.HouseholdID)
+	switch pgErrorCode(err) {
+	case pgUniqueViolation:
+		return "", fmt.Errorf("%w: a participant named %q already exists", ErrParticipantConflict, participant.Name)
+	case pgForeignKeyViolation:
+		return "", ErrHouseholdNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create participant: %w", err)
+	}
+
+	return participantID, nil
+}
+
+// DeleteParticipant deletes a participant of a scope who is on no receipt or settlement
+func (r *PostgresRepository) DeleteParticipant(ctx context.Context, id string, scope Scope) error {
+	result, err := r.Pool.Exec(ctx, `
+		DELETE FROM participants p WHERE p.id = $1 AND `+ownedSQL("p", 2)+`
+	`, append([]any{id}, scope.args()...)...)
+	switch pgErrorCode(err) {
+	case pgForeignKeyViolation:
+		return fmt.Errorf("%w: the participant is on split receipts or settlements", ErrParticipantConflict)
+	case pgInvalidText:
+		return ErrParticipantNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete participant: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrParticipantNotFound
+	}
+
+	return nil
+}
+
+// GetReceiptSplit retrieves how a receipt is split, with no participants
+// when it is not
+func (r *PostgresRepository) GetReceiptSplit(ctx context.Context, receiptID string) (*models.ReceiptSplit, error) {
+	splits, err := r.loadSplits(ctx, []string{receiptID})
+	if err != nil {
+		return nil, err
+	}
+	if len(splits) == 0 {
+		return nil, ErrReceiptNotFound
+	}
+
+	return &splits[0], nil
+}
+
+// ListReceiptSplits retrieves the split receipts matching a filter, oldest first
+func (r *PostgresRepository) ListReceiptSplits(ctx context.Context, filter ReceiptFilter) ([]models.ReceiptSplit, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT receipt_id FROM receipt_participants WHERE paid AND receipt_id IN (`+filteredReceiptsSQL+`)
+	`, receiptFilterArgs(filter)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list split receipts: %w", err)
+	}
+	defer rows.Close()
+
+	receiptIDs := []string{}
+	for rows.Next() {
+		var receiptID string
+		if err := rows.Scan(&receiptID); err != nil {
+			return nil, fmt.Errorf("failed to scan split receipt: %w", err)
+		}
+		receiptIDs = append(receiptIDs, receiptID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list split receipts: %w", err)
+	}
+
+	return r.loadSplits(ctx, receiptIDs)
+}
+
+// loadSplits retrieves the splits of receipts, oldest first, skipping those
+// that do not exist
+func (r *PostgresRepository) loadSplits(ctx context.Context, receiptIDs []string) ([]models.ReceiptSplit, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT r.id, s.name, r.bought_date, COALESCE(r.discounts, 0)
+		FROM receipts r
+		JOIN stores s ON r.store_id = s.id
+		WHERE r.id = ANY($1::uuid[])
+		ORDER BY r.bought_date, r.id
+	`, receiptIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get split receipts: %w", err)
+	}
+	defer rows.Close()
+
+	splits := []models.ReceiptSplit{}
+	index := map[string]int{}
+	for rows.Next() {
+		split := models.ReceiptSplit{Participants: []models.Participant{}, Items: []models.SplitItem{}}
+		var boughtDate time.Time
+		if err := rows.Scan(&split.ReceiptID, &split.StoreName, &boughtDate, &split.Discounts); err != nil {
+			return nil, fmt.Errorf("failed to scan split receipt: %w", err)
+		}
+		split.BoughtDate = boughtDate.Format("2006-01-02")
+		index[split.ReceiptID] = len(splits)
+		splits = append(splits, split)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get split receipts: %w", err)
+	}
+
+	rows, err = r.Pool.Query(ctx, `
+		SELECT rp.receipt_id, rp.paid, p.id, p.name, p.created_at
+		FROM receipt_participants rp
+		JOIN participants p ON rp.participant_id = p.id
+		WHERE rp.receipt_id = ANY($1::uuid[])
+		ORDER BY LOWER(p.name)
+	`, receiptIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get receipt participants: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var receiptID string
+		var paid bool
+		var participant models.Participant
+		if err := rows.Scan(&receiptID, &paid, &participant.ID, &participant.Name, &participant.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan receipt participant: %w", err)
+		}
+		split := &splits[index[receiptID]]
+		split.Participants = append(split.Participants, participant)
+		if paid {
+			split.PayerID = participant.ID
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get receipt participants: %w", err)
+	}
+
+	rows, err = r.Pool.Query(ctx, `
+		SELECT i.receipt_id, i.id, p.name, i.quantity * i.price_paid,
+			COALESCE(ARRAY_AGG(sh.participant_id::text ORDER BY sh.participant_id) FILTER (WHERE sh.item_id IS NOT NULL), '{}'),
+			COALESCE(ARRAY_AGG(sh.share::float8 ORDER BY sh.participant_id) FILTER (WHERE sh.item_id IS NOT NULL), '{}')
+		FROM items i
+		JOIN products p ON i.product_id = p.id
+		LEFT JOIN item_shares sh ON sh.item_id = i.id
+		WHERE i.receipt_id = ANY($1::uuid[])
+		GROUP BY i.receipt_id, i.id, p.name
+		ORDER BY p.name, i.id
+	`, receiptIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get split items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var receiptID string
+		var participantIDs []string
+		var shares []float64
+		item := models.SplitItem{Shares: map[string]float64{}}
+		if err := rows.Scan(&receiptID, &item.ItemID, &item.Name, &item.Total, &participantIDs, &shares); err != nil {
+			return nil, fmt.Errorf("failed to scan split item: %w", err)
+		}
+		for i, participantID := range participantIDs {
+			item.Shares[participantID] = shares[i]
+		}
+		split := &splits[index[receiptID]]
+		split.Items = append(split.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get split items: %w", err)
+	}
+
+	return splits, nil
+}
+
+// SetReceiptSplit replaces the participants, payer and item shares of a
+// receipt. Items missing from split.Items lose their shares; no participants
+// stops splitting the receipt.
+func (r *PostgresRepository) SetReceiptSplit(ctx context.Context, split *models.ReceiptSplit) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM receipts WHERE id = $1)`, split.ReceiptID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check receipt: %w", err)
+	}
+	if !exists {
+		return ErrReceiptNotFound
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM item_shares WHERE item_id IN (SELECT id FROM items WHERE receipt_id = $1)
+	`, split.ReceiptID)
+	if err != nil {
+		return fmt.Errorf("failed to clear item shares: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM receipt_participants WHERE receipt_id = $1`, split.ReceiptID); err != nil {
+		return fmt.Errorf("failed to clear receipt participants: %w", err)
+	}
+
+	for _, participant := range split.Participants {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO receipt_participants (receipt_id, participant_id, paid) VALUES ($1, $2, $3)
+		`, split.ReceiptID, participant.ID, participant.ID == split.PayerID)
+		if pgErrorCode(err) == pgForeignKeyViolation {
+			return ErrParticipantNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add receipt participant: %w", err)
+		}
+	}
+
+	for _, item := range split.Items {
+		if err := setItemSharesTx(ctx, tx, split.ReceiptID, item.ItemID, item.Shares); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// SetItemShares replaces the shares of an item, returns the ID of its receipt
+func (r *PostgresRepository) SetItemShares(ctx context.Context, itemID string, shares map[string]float64) (string, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var receiptID string
+	err = tx.QueryRow(ctx, `SELECT receipt_id FROM items WHERE id = $1`, itemID).Scan(&receiptID)
+	if err == pgx.ErrNoRows {
+		return "", ErrItemNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM item_shares WHERE item_id = $1`, itemID); err != nil {
+		return "", fmt.Errorf("failed to clear item shares: %w", err)
+	}
+	if err := setItemSharesTx(ctx, tx, receiptID, itemID, shares); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return receiptID, nil
+}
+
+// setItemSharesTx assigns fractions of an item of a receipt to participants of
+// that receipt, within a transaction
+func setItemSharesTx(ctx context.Context, tx pgx.Tx, receiptID, itemID string, shares map[string]float64) error {
+	for participantID, share := range shares {
+		result, err := tx.Exec(ctx, `
+			INSERT INTO item_shares (item_id, participant_id, share)
+			SELECT i.id, rp.participant_id, $3
+			FROM items i
+			JOIN receipt_participants rp ON rp.receipt_id = i.receipt_id AND rp.participant_id = $2
+			WHERE i.id = $1 AND i.receipt_id = $4
+		`, itemID, participantID, share, receiptID)
+		if err != nil {
+			return fmt.Errorf("failed to assign item: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			var onReceipt bool
+			err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM items WHERE id = $1 AND receipt_id = $2)`, itemID, receiptID).Scan(&onReceipt)
+			if err != nil {
+				return fmt.Errorf("failed to check item: %w", err)
+			}
+			if !onReceipt {
+				return fmt.Errorf("%w: %s is not an item of the receipt", ErrItemNotFound, itemID)
+			}
+			return ErrNotParticipant
+		}
+	}
+
+	return nil
+}
+
+// ListSettlements retrieves the settlements of a scope, newest first
+func (r *PostgresRepository) ListSettlements(ctx context.Context, scope Scope) ([]models.Settlement, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT s.id, s.from_participant_id, f.name, s.to_participant_id, t.name, s.amount, s.settled_on::text, s.note,
+			COALESCE(s.owner, ''), COALESCE(s.household_id::text, ''), s.created_at
+		FROM settlements s
+		JOIN participants f ON s.from_participant_id = f.id
+		JOIN participants t ON s.to_participant_id = t.id
+		WHERE `+ownedSQL("s", 1)+`
+		ORDER BY s.settled_on DESC, s.created_at DESC
+	`, scope.args()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list settlements: %w", err)
+	}
+	defer rows.Close()
+
+	settlements := []models.Settlement{}
+	for rows.Next() {
+		var settlement models.Settlement
+		if err := rows.Scan(&settlement.ID, &settlement.FromID, &settlement.FromName, &settlement.ToID, &settlement.ToName,
+			&settlement.Amount, &settlement.Date, &settlement.Note, &settlement.Owner, &settlement.HouseholdID,
+			&settlement.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan settlement: %w", err)
+		}
+		settlements = append(settlements, settlement)
+	}
+
+	return settlements, nil
+}
+
+// CreateSettlement inserts a settlement, returns its ID
+func (r *PostgresRepository) CreateSettlement(ctx context.Context, settlement *models.Settlement) (string, error) {
+	settlementID := uuid.New().String()
+	_, err := r.Pool.Exec(ctx, `
+		INSERT INTO settlements (id, from_participant_id, to_participant_id, amount, settled_on, note, owner, household_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, '')::uuid)
+	`, settlementID, settlement.FromID, settlement.ToID, settlement.Amount, settlement.Date, settlement.Note,
+		settlement.Owner, settlement.HouseholdID)
+	if pgErrorCode(err) == pgForeignKeyViolation {
+		return "", ErrParticipantNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create settlement: %w", err)
+	}
+
+	return settlementID, nil
+}
+
+// DeleteSettlement deletes a settlement of a scope
+func (r *PostgresRepository) DeleteSettlement(ctx context.Context, id string, scope Scope) error {
+	result, err := r.Pool.Exec(ctx, `
+		DELETE FROM settlements s WHERE s.id = $1 AND `+ownedSQL("s", 2)+`
+	`, append([]any{id}, scope.args()...)...)
+	if pgErrorCode(err) == pgInvalidText {
+		return ErrSettlementNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete settlement: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrSettlementNotFound
+	}
+
+	return nil
+}
+
+// sharedItem is an item of a receipt assigned to participants
+type sharedItem struct {
+	name     string
+	quantity float64
+	price    float64
+	shares   map[string]float64
+}
+
+// itemSharesTx returns the items of a receipt assigned to participants, within
+// a transaction
+func itemSharesTx(ctx context.Context, tx pgx.Tx, receiptID string) ([]sharedItem, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT p.name, i.quantity::float8, i.price_paid::float8,
+			ARRAY_AGG(sh.participant_id::text ORDER BY sh.participant_id),
+			ARRAY_AGG(sh.share::float8 ORDER BY sh.participant_id)
+		FROM items i
+		JOIN products p ON i.product_id = p.id
+		JOIN item_shares sh ON sh.item_id = i.id
+		WHERE i.receipt_id = $1
+		GROUP BY i.id, p.name
+		ORDER BY p.name, i.id
+	`, receiptID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item shares: %w", err)
+	}
+	defer rows.Close()
+
+	var items []sharedItem
+	for rows.Next() {
+		var item sharedItem
+		var participantIDs []string
+		var shares []float64
+		if err := rows.Scan(&item.name, &item.quantity, &item.price, &participantIDs, &shares); err != nil {
+			return nil, fmt.Errorf("failed to scan item shares: %w", err)
+		}
+		item.shares = make(map[string]float64, len(participantIDs))
+		for i, participantID := range participantIDs {
+			item.shares[participantID] = shares[i]
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get item shares: %w", err)
+	}
+
+	return items, nil
+}
+
+// remapItemShares moves the shares of the shared items of a receipt to the new
+// items replacing them, returning the shares by index of the new item. Each
+// shared item goes to an unassigned new item of the same product, preferring
+// one with the same quantity and price, and then the first in order. The names
+// of shared items left without a new item are returned as dropped.
+func remapItemShares(shared []sharedItem, items []models.Item) (map[int]map[string]float64, []string) {
+	remapped := map[int]map[string]float64{}
+	var dropped []string
+
+	find := func(item sharedItem, exact bool) int {
+		for i, candidate := range items {
+			if _, taken := remapped[i]; taken || candidate.Name != item.name {
+				continue
+			}
+			if !exact || (candidate.Quantity == item.quantity && candidate.Price == item.price) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	// Exact matches first, so a changed line does not take the place of an
+	// unchanged one
+	var unmatched []sharedItem
+	for _, item := range shared {
+		if i := find(item, true); i >= 0 {
+			remapped[i] = item.shares
+		} else {
+			unmatched = append(unmatched, item)
+		}
+	}
+	for _, item := range unmatched {
+		if i := find(item, false); i >= 0 {
+			remapped[i] = item.shares
+		} else {
+			dropped = append(dropped, item.name)
+		}
+	}
+
+	return remapped, dropped
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+func TestRemapItemShares(t *testing.T) {
+	ana := map[string]float64{"ana": 1}
+	bea := map[string]float64{"bea": 1}
+	halves := map[string]float64{"ana": 0.5, "bea": 0.5}
+
+	tests := []struct {
+		name        string
+		shared      []sharedItem
+		items       []models.Item
+		want        map[int]map[string]float64
+		wantDropped []string
+	}{
+		{
+			name:   "same items",
+			shared: []sharedItem{{"LECHE", 1, 0.99, ana}, {"PAN", 1, 1.20, halves}},
+			items:  []models.Item{{Name: "LECHE", Quantity: 1, Price: 0.99}, {Name: "PAN", Quantity: 1, Price: 1.20}},
+			want:   map[int]map[string]float64{0: ana, 1: halves},
+		},
+		{
+			name:   "reordered items",
+			shared: []sharedItem{{"LECHE", 1, 0.99, ana}, {"PAN", 1, 1.20, bea}},
+			items:  []models.Item{{Name: "PAN", Quantity: 1, Price: 1.20}, {Name: "HUEVOS", Quantity: 1, Price: 2.10}, {Name: "LECHE", Quantity: 1, Price: 0.99}},
+			want:   map[int]map[string]float64{0: bea, 2: ana},
+		},
+		{
+			name:   "repeated products keep their quantity",
+			shared: []sharedItem{{"LECHE", 1, 0.99, ana}, {"LECHE", 6, 0.99, bea}},
+			items:  []models.Item{{Name: "LECHE", Quantity: 6, Price: 0.99}, {Name: "LECHE", Quantity: 1, Price: 0.99}},
+			want:   map[int]map[string]float64{0: bea, 1: ana},
+		},
+		{
+			name:   "corrected price",
+			shared: []sharedItem{{"LECHE", 1, 0.99, ana}, {"LECHE", 2, 0.99, bea}},
+			items:  []models.Item{{Name: "LECHE", Quantity: 1, Price: 1.09}, {Name: "LECHE", Quantity: 2, Price: 0.99}},
+			want:   map[int]map[string]float64{0: ana, 1: bea},
+		},
+		{
+			name:        "dropped item",
+			shared:      []sharedItem{{"LECHE", 1, 0.99, ana}, {"PAN", 1, 1.20, bea}},
+			items:       []models.Item{{Name: "LECHE", Quantity: 1, Price: 0.99}},
+			want:        map[int]map[string]float64{0: ana},
+			wantDropped: []string{"PAN"},
+		},
+		{
+			name:        "fewer repeats",
+			shared:      []sharedItem{{"LECHE", 1, 0.99, ana}, {"LECHE", 1, 0.99, bea}},
+			items:       []models.Item{{Name: "LECHE", Quantity: 2, Price: 0.99}},
+			want:        map[int]map[string]float64{0: ana},
+			wantDropped: []string{"LECHE"},
+		},
+		{
+			name:  "no split",
+			items: []models.Item{{Name: "LECHE", Quantity: 1, Price: 0.99}},
+			want:  map[int]map[string]float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped := remapItemShares(tt.shared, tt.items)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("shares = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(dropped, tt.wantDropped) {
+				t.Errorf("dropped = %v, want %v", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// ReceiptAnnotations are the fields of a receipt its owner edits by hand.
+// Nil fields are left as they are; Tags replaces the whole set.
+type ReceiptAnnotations struct {
+	Tags     *[]string
+	Notes    *string
+	Business *bool
+}
+
+// ItemAnnotations are the fields of an item its owner edits by hand. Nil
+// fields are left as they are; Tags replaces the whole set.
+type ItemAnnotations struct {
+	Tags  *[]string
+	Notes *string
+}
+
+// TagUsage is how many receipts and items have a tag
+type TagUsage struct {
+	Tag      string
+	Receipts int
+	Items    int
+}
+
+// TagSpending is what was spent on the items of a tag in a period
+type TagSpending struct {
+	Tag      string
+	Receipts int     // Receipts with the tag or with a tagged item
+	Items    int     // Items with the tag, or on a receipt with it
+	Total    float64 // Line totals of those items, before receipt discounts
+}
+
+// TagChanges counts what a tag rename, merge or deletion changed
+type TagChanges struct {
+	Receipts int64
+	Items    int64
+	Rules    int64 // Rules whose tag actions were updated
+}
+
+// UpdateReceiptAnnotations sets the tags, notes and business flag of a receipt
+func (r *PostgresRepository) UpdateReceiptAnnotations(ctx context.Context, id string, annotations ReceiptAnnotations) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `
+		UPDATE receipts
+		SET notes = COALESCE($1, notes), business = COALESCE($2, business)
+		WHERE id = $3
+	`, annotations.Notes, annotations.Business, id)
+	if err != nil {
+		return fmt.Errorf("failed to update receipt: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrReceiptNotFound
+	}
+
+	if annotations.Tags != nil {
+		if _, err := tx.Exec(ctx, `DELETE FROM receipt_tags WHERE receipt_id = $1`, id); err != nil {
+			return fmt.Errorf("failed to clear receipt tags: %w", err)
+		}
+		if err := addReceiptTagsTx(ctx, tx, id, *annotations.Tags); err != nil {
+			return err
+		}
+	}
+
+	if err := notifyReceiptEvent(ctx, tx, models.EventReceiptUpdated, id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateItemAnnotations sets the tags and notes of an item, returns the ID of its receipt
+func (r *PostgresRepository) UpdateItemAnnotations(ctx context.Context, itemID string, annotations ItemAnnotations) (string, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var receiptID string
+	err = tx.QueryRow(ctx, `
+		UPDATE items
+		SET notes = COALESCE($1, notes)
+		WHERE id = $2
+		RETURNING receipt_id
+	`, annotations.Notes, itemID).Scan(&receiptID)
+	if err == pgx.ErrNoRows {
+		return "", ErrItemNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to update item: %w", err)
+	}
+
+	if annotations.Tags != nil {
+		if _, err := tx.Exec(ctx, `DELETE FROM item_tags WHERE item_id = $1`, itemID); err != nil {
+			return "", fmt.Errorf("failed to clear item tags: %w", err)
+		}
+		if err := addItemTagsTx(ctx, tx, itemID, *annotations.Tags); err != nil {
+			return "", err
+		}
+	}
+
+	if err := notifyReceiptEvent(ctx, tx, models.EventReceiptUpdated, receiptID); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return receiptID, nil
+}
+
+// filteredItemsSQL selects the IDs of the items of the receipts matching a
+// ReceiptFilter, as filteredReceiptsSQL
+const filteredItemsSQL = `SELECT i.id FROM items i WHERE i.receipt_id IN (` + filteredReceiptsSQL + `)`
+
+// ListTags retrieves every tag in use on the receipts matching a filter, with
+// how many of them and of their items have it
+func (r *PostgresRepository) ListTags(ctx context.Context, filter ReceiptFilter) ([]TagUsage, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT tag, SUM(receipts)::int, SUM(items)::int
+		FROM (
+			SELECT tag, COUNT(*) AS receipts, 0 AS items
+			FROM receipt_tags
+			WHERE receipt_id IN (`+filteredReceiptsSQL+`)
+			GROUP BY tag
+			UNION ALL
+			SELECT tag, 0, COUNT(*)
+			FROM item_tags
+			WHERE item_id IN (`+filteredItemsSQL+`)
+			GROUP BY tag
+		) usage
+		GROUP BY tag
+		ORDER BY tag
+	`, receiptFilterArgs(filter)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []TagUsage{}
+	for rows.Next() {
+		var usage TagUsage
+		if err := rows.Scan(&usage.Tag, &usage.Receipts, &usage.Items); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags = append(tags, usage)
+	}
+
+	return tags, nil
+}
+
+// RenameTags replaces the tags in from with to on the receipts matching a
+// filter and their items, and in the tag actions of the automation rules of
+// the same member and role. Renaming several tags, or into a tag in use,
+// merges them.
+func (r *PostgresRepository) RenameTags(ctx context.Context, filter ReceiptFilter, from []string, to string) (*TagChanges, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	changes := &TagChanges{}
+	err = tx.QueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(DISTINCT receipt_id) FROM receipt_tags
+				WHERE tag = ANY($10::text[]) AND tag <> $11 AND receipt_id IN (`+filteredReceiptsSQL+`)),
+			(SELECT COUNT(DISTINCT item_id) FROM item_tags
+				WHERE tag = ANY($10::text[]) AND tag <> $11 AND item_id IN (`+filteredItemsSQL+`))
+	`, receiptFilterArgs(filter, from, to)...).Scan(&changes.Receipts, &changes.Items)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count tags: %w", err)
+	}
+
+	// Receipts and items that already have the new tag only lose the old ones
+	_, err = tx.Exec(ctx, `
+		INSERT INTO receipt_tags (receipt_id, tag)
+		SELECT DISTINCT receipt_id, $11 FROM receipt_tags
+		WHERE tag = ANY($10::text[]) AND receipt_id IN (`+filteredReceiptsSQL+`)
+		ON CONFLICT DO NOTHING
+	`, receiptFilterArgs(filter, from, to)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rename receipt tags: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO item_tags (item_id, tag)
+		SELECT DISTINCT item_id, $11 FROM item_tags
+		WHERE tag = ANY($10::text[]) AND item_id IN (`+filteredItemsSQL+`)
+		ON CONFLICT DO NOTHING
+	`, receiptFilterArgs(filter, from, to)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rename item tags: %w", err)
+	}
+
+	if _, err := deleteTagsTx(ctx, tx, filter, from, to); err != nil {
+		return nil, err
+	}
+
+	scope := Scope{Member: filter.Member, Role: filter.Role, Admin: filter.Admin}
+	result, err := tx.Exec(ctx, `
+		UPDATE rules u
+		SET actions = (
+			SELECT jsonb_agg(
+				CASE WHEN action->>'type' = 'tag' AND action->>'value' = ANY($1::text[])
+					THEN jsonb_set(action, '{value}', to_jsonb($2::text))
+					ELSE action
+				END ORDER BY position)
+			FROM jsonb_array_elements(u.actions) WITH ORDINALITY AS a(action, position)
+		), updated_at = NOW()
+		WHERE EXISTS (
+			SELECT 1 FROM jsonb_array_elements(u.actions) AS a(action)
+			WHERE action->>'type' = 'tag' AND action->>'value' = ANY($1::text[])
+		) AND `+ownedSQL("u", 3)+`
+	`, append([]any{from, to}, scope.args()...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rename tags in rules: %w", err)
+	}
+	changes.Rules = result.RowsAffected()
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return changes, nil
+}
+
+// DeleteTag removes a tag from the receipts matching a filter and their
+// items. Rules that add it are left as they are.
+func (r *PostgresRepository) DeleteTag(ctx context.Context, filter ReceiptFilter, tag string) (*TagChanges, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	changes, err := deleteTagsTx(ctx, tx, filter, []string{tag}, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return changes, nil
+}
+
+// deleteTagsTx removes tags, except keep, from the receipts matching a filter
+// and their items within a transaction
+func deleteTagsTx(ctx context.Context, tx pgx.Tx, filter ReceiptFilter, tags []string, keep string) (*TagChanges, error) {
+	changes := &TagChanges{}
+
+	result, err := tx.Exec(ctx, `
+		DELETE FROM receipt_tags
+		WHERE tag = ANY($10::text[]) AND tag <> $11 AND receipt_id IN (`+filteredReceiptsSQL+`)
+	`, receiptFilterArgs(filter, tags, keep)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete receipt tags: %w", err)
+	}
+	changes.Receipts = result.RowsAffected()
+
+	result, err = tx.Exec(ctx, `
+		DELETE FROM item_tags
+		WHERE tag = ANY($10::text[]) AND tag <> $11 AND item_id IN (`+filteredItemsSQL+`)
+	`, receiptFilterArgs(filter, tags, keep)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete item tags: %w", err)
+	}
+	changes.Items = result.RowsAffected()
+
+	return changes, nil
+}
+
+// TagSpending sums what was spent per tag on the receipts matching a filter.
+// A receipt tag covers all the items of the receipt; an item tag, only that
+// item. An item counts once per tag even if it has it both ways.
+func (r *PostgresRepository) TagSpending(ctx context.Context, filter ReceiptFilter) ([]TagSpending, error) {
+	rows, err := r.Pool.Query(ctx, `
+		WITH tagged AS (
+			SELECT rt.tag, i.id AS item_id
+			FROM receipt_tags rt
+			JOIN items i ON i.receipt_id = rt.receipt_id
+			UNION
+			SELECT it.tag, it.item_id
+			FROM item_tags it
+		)
+		SELECT t.tag, COUNT(DISTINCT r.id)::int, COUNT(i.id)::int, COALESCE(SUM(i.quantity * i.price_paid), 0)
+		FROM tagged t
+		JOIN items i ON t.item_id = i.id
+		JOIN receipts r ON i.receipt_id = r.id
+		JOIN stores s ON r.store_id = s.id
+		WHERE `+receiptFilterSQL+`
+		GROUP BY t.tag
+		ORDER BY t.tag
+	`, receiptFilterArgs(filter)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum tag spending: %w", err)
+	}
+	defer rows.Close()
+
+	spending := []TagSpending{}
+	for rows.Next() {
+		var tag TagSpending
+		if err := rows.Scan(&tag.Tag, &tag.Receipts, &tag.Items, &tag.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan tag spending: %w", err)
+		}
+		spending = append(spending, tag)
+	}
+
+	return spending, nil
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// ErrTokenNotFound is returned when an API token does not exist
+var ErrTokenNotFound = errors.New("API token not found")
+
+// ListAPITokens retrieves the API tokens of an owner, or every one when owner
+// is empty, newest first
+func (r *PostgresRepository) ListAPITokens(ctx context.Context, owner string) ([]models.APIToken, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT id, name, owner, prefix, token_hash, scopes, expires_at, last_used_at, created_at, COALESCE(created_by, '')
+		FROM api_tokens
+		WHERE $1 = '' OR owner = $1
+		ORDER BY created_at DESC
+	`, owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+
+	return tokens, nil
+}
+
+// GetAPITokenByHash retrieves an API token by the hash of the token
+func (r *PostgresRepository) GetAPITokenByHash(ctx context.Context, hash string) (*models.APIToken, error) {
+	row := r.Pool.QueryRow(ctx, `
+		SELECT id, name, owner, prefix, token_hash, scopes, expires_at, last_used_at, created_at, COALESCE(created_by, '')
+		FROM api_tokens
+		WHERE token_hash = $1
+	`, hash)
+
+	token, err := scanAPIToken(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTokenNotFound
+	}
+	return token, err
+}
+
+// HasAPITokens reports whether any API token exists
+func (r *PostgresRepository) HasAPITokens(ctx context.Context) (bool, error) {
+	var exists bool
+	if err := r.Pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM api_tokens)`).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check for API tokens: %w", err)
+	}
+
+	return exists, nil
+}
+
+// scanAPIToken scans a row of the api_tokens table
+func scanAPIToken(row pgx.Row) (*models.APIToken, error) {
+	var token models.APIToken
+	err := row.Scan(&token.ID, &token.Name, &token.Owner, &token.Prefix, &token.Hash, &token.Scopes, &token.ExpiresAt,
+		&token.LastUsedAt, &token.CreatedAt, &token.CreatedBy)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan API token: %w", err)
+	}
+
+	return &token, nil
+}
+
+// CreateAPIToken inserts an API token, returns its ID
+func (r *PostgresRepository) CreateAPIToken(ctx context.Context, token *models.APIToken) (string, error) {
+	tokenID := uuid.New().String()
+	_, err := r.Pool.Exec(ctx, `
+		INSERT INTO api_tokens (id, name, owner, prefix, token_hash, scopes, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))
+	`, tokenID, token.Name, token.Owner, token.Prefix, token.Hash, token.Scopes, token.ExpiresAt, token.CreatedBy)
+	if err != nil {
+		return "", fmt.Errorf("failed to create API token: %w", err)
+	}
+
+	return tokenID, nil
+}
+
+// DeleteAPIToken deletes an API token of an owner, of anyone when owner is
+// empty, revoking it
+func (r *PostgresRepository) DeleteAPIToken(ctx context.Context, id, owner string) error {
+	result, err := r.Pool.Exec(ctx, `DELETE FROM api_tokens WHERE id = $1 AND ($2 = '' OR owner = $2)`, id, owner)
+	if pgErrorCode(err) == pgInvalidText {
+		return ErrTokenNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete API token: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
+
+// TouchAPIToken records that an API token was used now. It is only written
+// once a minute, so busy tokens don't write on every request.
+func (r *PostgresRepository) TouchAPIToken(ctx context.Context, id string) error {
+	_, err := r.Pool.Exec(ctx, `
+		UPDATE api_tokens
+		SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`, id)
+	if err != nil {
+		return fmt.Errorf("failed to touch API token: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// ErrWebhookNotFound is returned when a webhook does not exist
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// ErrDeliveryNotFound is returned when a webhook delivery does not exist
+var ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+// WebhookAttempt is the outcome of an attempt to deliver an event to a webhook
+type WebhookAttempt struct {
+	DeliveryID    string
+	StatusCode    int    // 0 when there was no response
+	Error         string // Empty when delivered
+	Status        string // Delivery status after the attempt
+	NextAttemptAt time.Time
+}
+
+// ListWebhooks retrieves every webhook, oldest first
+func (r *PostgresRepository) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	rows, err := r.Pool.Query(ctx, `
+		SELECT id, url, secret, events, description, enabled, created_at, updated_at
+		FROM webhooks
+		ORDER BY created_at
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+
+	return webhooks, nil
+}
+
+// GetWebhook retrieves a webhook by ID
+func (r *PostgresRepository) GetWebhook(ctx context.Context, id string) (*models.Webhook, error) {
+	row := r.Pool.QueryRow(ctx, `
+		SELECT id, url, secret, events, description, enabled, created_at, updated_at
+		FROM webhooks
+		WHERE id = $1
+	`, id)
+
+	webhook, err := scanWebhook(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	return webhook, err
+}
+
+// scanWebhook scans a row of the webhooks table
+func scanWebhook(row pgx.Row) (*models.Webhook, error) {
+	var webhook models.Webhook
+	err := row.Scan(&webhook.ID, &webhook.URL, &webhook.Secret, &webhook.Events, &webhook.Description, &webhook.Enabled,
+		&webhook.CreatedAt, &webhook.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan webhook: %w", err)
+	}
+
+	return &webhook, nil
+}
+
+// CreateWebhook inserts a webhook, returns its ID
+func (r *PostgresRepository) CreateWebhook(ctx context.Context, webhook *models.Webhook) (string, error) {
+	webhookID := uuid.New().String()
+	_, err := r.Pool.Exec(ctx, `
+		INSERT INTO webhooks (id, url, secret, events, description, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, webhookID, webhook.URL, webhook.Secret, webhook.Events, webhook.Description, webhook.Enabled)
+	if err != nil {
+		return "", fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	return webhookID, nil
+}
+
+// UpdateWebhook replaces a webhook. An empty secret keeps the current one.
+func (r *PostgresRepository) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	result, err := r.Pool.Exec(ctx, `
+		UPDATE webhooks
+		SET url = $1, secret = COALESCE(NULLIF($2, ''), secret), events = $3, description = $4, enabled = $5,
+			updated_at = NOW()
+		WHERE id = $6
+	`, webhook.URL, webhook.Secret, webhook.Events, webhook.Description, webhook.Enabled, webhook.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+// DeleteWebhook deletes a webhook with its deliveries
+func (r *PostgresRepository) DeleteWebhook(ctx context.Context, id string) error {
+	result, err := r.Pool.Exec(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+// EnqueueWebhookEvent queues an event for every enabled webhook subscribed
+// to it, returns how many deliveries were queued
+func (r *PostgresRepository) EnqueueWebhookEvent(ctx context.Context, eventID, event string, payload []byte) (int64, error) {
+	result, err := r.Pool.Exec(ctx, `
+		INSERT INTO webhook_deliveries (id, webhook_id, event_id, event, payload)
+		SELECT gen_random_uuid(), id, $1, $2, $3
+		FROM webhooks
+		WHERE enabled AND ($2 = ANY(events) OR $4 = ANY(events))
+	`, eventID, event, payload, models.WebhookAllEvents)
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue webhook event: %w", err)
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// ClaimWebhookDeliveries takes up to limit pending deliveries that are due,
+// oldest first, with the URL and secret of their webhook. Their next attempt
+// is pushed back by lease, so other instances skip them while they are being
+// delivered, and pick them up again if this one dies.
+func (r *PostgresRepository) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	rows, err := r.Pool.Query(ctx, `
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM webhooks w
+		WHERE d.webhook_id = w.id AND d.id IN (
+			SELECT pd.id
+			FROM webhook_deliveries pd
+			JOIN webhooks pw ON pd.webhook_id = pw.id
+			WHERE pd.status = 'pending' AND pd.next_attempt_at <= NOW() AND pw.enabled
+			ORDER BY pd.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF pd SKIP LOCKED
+		)
+		RETURNING d.id, d.webhook_id, d.event_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+			COALESCE(d.last_status_code, 0), COALESCE(d.last_error, ''), d.created_at, d.delivered_at, w.url, w.secret
+	`, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.EventID, &delivery.Event, &delivery.Payload,
+			&delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastStatusCode, &delivery.LastError,
+			&delivery.CreatedAt, &delivery.DeliveredAt, &delivery.URL, &delivery.Secret); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt records the outcome of an attempt to deliver an event
+func (r *PostgresRepository) RecordWebhookAttempt(ctx context.Context, attempt WebhookAttempt) error {
+	_, err := r.Pool.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET attempts = attempts + 1, status = $1, next_attempt_at = $2,
+			last_status_code = NULLIF($3, 0), last_error = NULLIF($4, ''),
+			delivered_at = CASE WHEN $1 = 'delivered' THEN NOW() END
+		WHERE id = $5
+	`, attempt.Status, attempt.NextAttemptAt, attempt.StatusCode, attempt.Error, attempt.DeliveryID)
+	if err != nil {
+		return fmt.Errorf("failed to record webhook attempt: %w", err)
+	}
+
+	return nil
+}
+
+// ListWebhookDeliveries retrieves the latest deliveries of a webhook, newest
+// first, optionally only those with a status
+func (r *PostgresRepository) ListWebhookDeliveries(ctx context.Context, webhookID, status string, limit int) ([]models.WebhookDelivery, error) {
+	if _, err := r.GetWebhook(ctx, webhookID); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, `
+		SELECT id, webhook_id, event_id, event, payload, status, attempts, next_attempt_at,
+			COALESCE(last_status_code, 0), COALESCE(last_error, ''), created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT $3
+	`, webhookID, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.EventID, &delivery.Event, &delivery.Payload,
+			&delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastStatusCode, &delivery.LastError,
+			&delivery.CreatedAt, &delivery.DeliveredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// RetryWebhookDelivery queues a delivery of a webhook again, with a fresh
+// set of attempts
+func (r *PostgresRepository) RetryWebhookDelivery(ctx context.Context, webhookID, deliveryID string) error {
+	result, err := r.Pool.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), delivered_at = NULL
+		WHERE id = $1 AND webhook_id = $2
+	`, deliveryID, webhookID)
+	if err != nil {
+		return fmt.Errorf("failed to retry webhook delivery: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrDeliveryNotFound
+	}
+
+	return nil
+}
+
+// PruneWebhookDeliveries deletes the delivered and failed deliveries created
+// before a time, returns how many were deleted
+func (r *PostgresRepository) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.Pool.Exec(ctx, `
+		DELETE FROM webhook_deliveries
+		WHERE status <> 'pending' AND created_at < $1
+	`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune webhook deliveries: %w", err)
+	}
+
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Budget periods
+const (
+	BudgetPeriodMonthly = "monthly" // Calendar months
+	BudgetPeriodWeekly  = "weekly"  // Weeks from Monday to Sunday
+)
+
+// Budget limits what is spent per period on a category (with its
+// subcategories), at a store or on a tag. Exactly one of them is set.
+type Budget struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Period       string    `json:"period"`
+	Amount       float64   `json:"amount"`
+	CategoryID   string    `json:"category_id,omitempty"`
+	CategoryPath string    `json:"category_path,omitempty"` // Read only
+	StoreName    string    `json:"store_name,omitempty"`
+	Tag          string    `json:"tag,omitempty"`
+	Owner        string    `json:"owner,omitempty"`        // User who created the budget, if known
+	HouseholdID  string    `json:"household_id,omitempty"` // Household the budget is shared in
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Category sources: what assigned the category of a product
+const (
+	CategorySourceRule       = "rule"
+	CategorySourceAI         = "ai"
+	CategorySourceAutomation = "automation" // Set by an automation rule
+	CategorySourceManual     = "manual"
+)
+
+// Category is a node of the category taxonomy
+type Category struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	ParentID string `json:"parent_id,omitempty"` // Empty for top-level categories
+	Path     string `json:"path"`                // Names from the top level down, separated by "/"
+}
+
+// CategoryRule assigns a category to the products whose name matches a
+// pattern (optionally only at one store), or to a single store product
+type CategoryRule struct {
+	ID         string    `json:"id"`
+	CategoryID string    `json:"category_id"`
+	Pattern    string    `json:"pattern,omitempty"`    // Case-insensitive POSIX regular expression
+	StoreName  string    `json:"store_name,omitempty"` // Limits a pattern to one store
+	ProductID  string    `json:"product_id,omitempty"` // Store product the rule is for, instead of a pattern
+	Priority   int       `json:"priority"`             // Higher wins when several rules match
+	CreatedAt  time.Time `json:"created_at"`
+}
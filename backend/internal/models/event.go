@@ -0,0 +1,35 @@
+package models
+
+import "encoding/json"
+
+// Events of receipts and budgets, sent to webhooks and to clients
+const (
+	EventReceiptCreated   = "receipt.created"
+	EventReceiptUpdated   = "receipt.updated"
+	EventReceiptDeleted   = "receipt.deleted"
+	EventExtractionFailed = "extraction.failed"
+	EventBudgetWarning    = "budget.warning"  // 80% of a budget spent
+	EventBudgetExceeded   = "budget.exceeded" // 100% of a budget spent
+
+	// EventProcessingProgress reports the stages of processing an upload, to clients only
+	EventProcessingProgress = "processing.progress"
+)
+
+// Events lists every event a webhook can subscribe to
+var Events = []string{
+	EventReceiptCreated,
+	EventReceiptUpdated,
+	EventReceiptDeleted,
+	EventExtractionFailed,
+	EventBudgetWarning,
+	EventBudgetExceeded,
+}
+
+// Event is a change streamed to clients as it happens
+type Event struct {
+	Type        string          `json:"type"`
+	ReceiptID   string          `json:"receipt_id,omitempty"`
+	Owner       string          `json:"owner,omitempty"`        // User the event is for, empty for everyone
+	HouseholdID string          `json:"household_id,omitempty"` // Household the event is for, whose members only see it
+	Data        json.RawMessage `json:"data,omitempty"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// ExtractionStage describes a single model call made while extracting a receipt
+type ExtractionStage struct {
+	Model         string `json:"model"`
+	PromptVersion string `json:"prompt_version"`
+	LatencyMs     int64  `json:"latency_ms"`
+	PromptTokens  int32  `json:"prompt_tokens"`
+	OutputTokens  int32  `json:"output_tokens"`
+}
+
+// Extraction records how a receipt was extracted (provenance)
+type Extraction struct {
+	ID          string          `json:"id"`
+	ReceiptID   string          `json:"receipt_id"` // Empty when the extraction never produced a saved receipt
+	StoreAnswer string          `json:"store_answer"`
+	StoreStage  ExtractionStage `json:"store_stage"`
+	ItemsStage  ExtractionStage `json:"items_stage"`
+	Attempt     int             `json:"attempt"`  // 1 for the initial extraction, then one per escalation
+	Strategy    string          `json:"strategy"` // How the attempt was configured, e.g. "initial" or "escalated-fix"
+	Issues      []string        `json:"issues"`   // Validation issues found in the result
+	Selected    bool            `json:"selected"` // Whether this attempt's result was kept
+	RawResponse string          `json:"raw_response"`
+	Error       string          `json:"error"` // Call or parse error that stopped the extraction
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// TotalTokens returns the tokens used across all stages
+func (e *Extraction) TotalTokens() int32 {
+	return e.StoreStage.PromptTokens + e.StoreStage.OutputTokens +
+		e.ItemsStage.PromptTokens + e.ItemsStage.OutputTokens
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Household roles, from the least to the most privileged
+const (
+	RoleViewer = "viewer" // See the receipts of the household
+	RoleEditor = "editor" // Change them, and move receipts in and out
+	RoleOwner  = "owner"  // Manage the household, its members and invitations
+)
+
+// Roles lists every household role, from the least to the most privileged
+var Roles = []string{RoleViewer, RoleEditor, RoleOwner}
+
+// RolesFrom lists the roles that grant at least a role
+func RolesFrom(role string) []string {
+	i := slices.Index(Roles, role)
+	if i < 0 {
+		return []string{}
+	}
+	return Roles[i:]
+}
+
+// Household is a shared ledger: its receipts are only seen by its members.
+// Members are users, identified by the owner of their API tokens.
+type Household struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role,omitempty"` // Role of the user it was retrieved for
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HouseholdMember is a user of a household with their role
+type HouseholdMember struct {
+	Member    string    `json:"member"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HouseholdInvitation invites a user to join a household with a role
+type HouseholdInvitation struct {
+	ID            string    `json:"id"`
+	HouseholdID   string    `json:"household_id"`
+	HouseholdName string    `json:"household_name"` // Read only
+	Invitee       string    `json:"invitee"`
+	Role          string    `json:"role"`
+	InvitedBy     string    `json:"invited_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
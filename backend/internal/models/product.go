@@ -4,4 +4,8 @@ type Product struct {
 	ID      string `json:"id"`
 	Name    string `json:"name"`
 	StoreID string `json:"store_id"`
+
+	StoreName      string `json:"store_name,omitempty"`
+	CategoryID     string `json:"category_id,omitempty"`
+	CategorySource string `json:"category_source,omitempty"`
 }
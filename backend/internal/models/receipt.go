@@ -1,16 +1,38 @@
 package models
 
 type Item struct {
-	ID       string  `json:"id"`
-	Name     string  `json:"name"`
-	Quantity float64 `json:"quantity"`
-	Price    float64 `json:"price"`
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Quantity float64  `json:"quantity"`
+	Price    float64  `json:"price"`
+	TaxRate  *float64 `json:"tax_rate,omitempty"` // VAT rate in percent, if known
+
+	// Set when read from the database
+	ProductID      string   `json:"product_id,omitempty"`
+	CategoryID     string   `json:"category_id,omitempty"`
+	Category       string   `json:"category,omitempty"`        // Category path, e.g. "Groceries/Dairy"
+	CategorySource string   `json:"category_source,omitempty"` // rule, ai, automation or manual
+	Tags           []string `json:"tags,omitempty"`
+	Notes          string   `json:"notes,omitempty"`
 }
 
 type Receipt struct {
-	ID         string    `json:"id"`
-	StoreName  string    `json:"store_name"`
-	BoughtDate string    `json:"bought_date"` // ISO 8601 format: YYYY-MM-DD
-	Items      []Item    `json:"items"`
-	Discounts  float64   `json:"discounts"`
+	ID         string   `json:"id"`
+	StoreName  string   `json:"store_name"`
+	BoughtDate string   `json:"bought_date"` // ISO 8601 format: YYYY-MM-DD
+	Items      []Item   `json:"items"`
+	Discounts  float64  `json:"discounts"`
+	Total      *float64 `json:"total,omitempty"` // Printed total, used for validation only
+	ImagePath  string   `json:"-"`               // Original image kept for reprocessing
+	Owner      string   `json:"owner,omitempty"` // User who submitted the receipt, if known
+
+	// Set for receipts imported from e-invoices
+	DocumentNumber string `json:"document_number,omitempty"` // Invoice series and number
+	StoreTaxID     string `json:"store_tax_id,omitempty"`    // Seller's tax identification number
+
+	// Set when read from the database
+	Tags        []string `json:"tags,omitempty"`
+	Notes       string   `json:"notes,omitempty"`
+	Business    bool     `json:"business,omitempty"`     // Flagged as a business expense
+	HouseholdID string   `json:"household_id,omitempty"` // Household the receipt is shared in
 }
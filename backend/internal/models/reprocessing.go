@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Reprocessing statuses
+const (
+	ReprocessingPending    = "pending"
+	ReprocessingAccepted   = "accepted"
+	ReprocessingRejected   = "rejected"
+	ReprocessingSuperseded = "superseded" // The receipt changed after it was proposed
+)
+
+// ItemChange describes a line whose quantity or price changed
+type ItemChange struct {
+	Name   string `json:"name"`
+	Before Item   `json:"before"`
+	After  Item   `json:"after"`
+}
+
+// ValueChange describes a receipt-level value that changed
+type ValueChange[T any] struct {
+	Before T `json:"before"`
+	After  T `json:"after"`
+}
+
+// ReceiptDiff is the structured difference between a stored receipt and a new extraction
+type ReceiptDiff struct {
+	Added      []Item                `json:"added"`
+	Removed    []Item                `json:"removed"`
+	Changed    []ItemChange          `json:"changed"`
+	StoreName  *ValueChange[string]  `json:"store_name,omitempty"`
+	BoughtDate *ValueChange[string]  `json:"bought_date,omitempty"`
+	Discounts  *ValueChange[float64] `json:"discounts,omitempty"`
+	Subtotal   *ValueChange[float64] `json:"subtotal,omitempty"`
+	Total      *ValueChange[float64] `json:"total,omitempty"`
+}
+
+// IsEmpty reports whether the diff has no changes
+func (d *ReceiptDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 &&
+		d.StoreName == nil && d.BoughtDate == nil && d.Discounts == nil
+}
+
+// Reprocessing is a proposed re-extraction of a stored receipt awaiting review
+type Reprocessing struct {
+	ID           string      `json:"id"`
+	ReceiptID    string      `json:"receipt_id"`
+	ExtractionID string      `json:"extraction_id"`
+	Status       string      `json:"status"`
+	Proposed     Receipt     `json:"proposed"`
+	Diff         ReceiptDiff `json:"diff"`
+	CreatedAt    time.Time   `json:"created_at"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// Rule scopes: what a rule's condition is evaluated against
+const (
+	RuleScopeReceipt = "receipt"
+	RuleScopeItem    = "item"
+)
+
+// Rule action types
+const (
+	RuleActionTag      = "tag"      // Adds a tag to the receipt or the matching items
+	RuleActionCategory = "category" // Sets the category of the matching items' products
+	RuleActionNote     = "note"     // Appends a note to the receipt or the matching items
+	RuleActionBusiness = "business" // Flags the receipt as a business expense, or not
+)
+
+// Rule is an automation rule: when its condition holds for a receipt, or for
+// an item of it, its actions are applied. Rules of a household run on its
+// receipts; other rules, on the receipts of their owner, or on every receipt
+// when they have none.
+type Rule struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Scope       string       `json:"scope"`
+	Condition   string       `json:"condition"`
+	Actions     []RuleAction `json:"actions"`
+	Enabled     bool         `json:"enabled"`
+	Priority    int          `json:"priority"`
+	Owner       string       `json:"owner,omitempty"`        // User who created the rule, if known
+	HouseholdID string       `json:"household_id,omitempty"` // Household the rule is shared in
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// RuleAction is an action of a rule. Category actions hold a category ID.
+type RuleAction struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// RuleEffects collects what the rules matching a receipt change on it
+type RuleEffects struct {
+	ReceiptTags       []string
+	ItemTags          map[string][]string // By item ID
+	ReceiptNotes      []string
+	ItemNotes         map[string][]string // By item ID
+	Business          *bool
+	ProductCategories map[string]string // Category ID by product ID
+}
+
+// Empty reports whether the effects change nothing
+func (e *RuleEffects) Empty() bool {
+	return len(e.ReceiptTags) == 0 && len(e.ItemTags) == 0 && len(e.ReceiptNotes) == 0 &&
+		len(e.ItemNotes) == 0 && e.Business == nil && len(e.ProductCategories) == 0
+}
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Participant is a person receipts are split between
+type Participant struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Owner       string    `json:"owner,omitempty"`        // User who added the participant, if known
+	HouseholdID string    `json:"household_id,omitempty"` // Household the participant is shared in
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ReceiptSplit is how a receipt is split between participants, one of whom
+// paid it
+type ReceiptSplit struct {
+	ReceiptID    string        `json:"receipt_id"`
+	StoreName    string        `json:"store_name"`  // Read only
+	BoughtDate   string        `json:"bought_date"` // Read only
+	Discounts    float64       `json:"discounts"`   // Read only
+	PayerID      string        `json:"payer_id"`
+	Participants []Participant `json:"participants"` // Empty when the receipt is not split
+	Items        []SplitItem   `json:"items"`
+}
+
+// SplitItem is an item of a receipt with the fractions of it assigned to
+// participants. What is not assigned is shared equally by every participant.
+type SplitItem struct {
+	ItemID string             `json:"item_id"`
+	Name   string             `json:"name"`   // Read only
+	Total  float64            `json:"total"`  // Read only: quantity × price paid
+	Shares map[string]float64 `json:"shares"` // Fraction per participant ID
+}
+
+// Settlement is a payment from a participant to another, settling what they owe
+type Settlement struct {
+	ID          string    `json:"id"`
+	FromID      string    `json:"from_id"`
+	FromName    string    `json:"from_name"` // Read only
+	ToID        string    `json:"to_id"`
+	ToName      string    `json:"to_name"` // Read only
+	Amount      float64   `json:"amount"`
+	Date        string    `json:"date"`
+	Note        string    `json:"note"`
+	Owner       string    `json:"owner,omitempty"`        // User who recorded the settlement, if known
+	HouseholdID string    `json:"household_id,omitempty"` // Household the settlement is shared in
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// API token scopes. Each route requires one of them, and admin grants all.
+const (
+	ScopeRead   = "read"   // Browse receipts, spending and exports
+	ScopeUpload = "upload" // Upload and import receipts
+	ScopeWrite  = "write"  // Create, edit and delete data
+	ScopeAdmin  = "admin"  // Manage API tokens and webhooks, and everything else
+)
+
+// Scopes lists every scope a token can have
+var Scopes = []string{ScopeRead, ScopeUpload, ScopeWrite, ScopeAdmin}
+
+// APIToken is a named personal token for the API. Only a hash of the token
+// itself is stored.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner"`  // User the token was created for
+	Prefix     string     `json:"prefix"` // First characters of the token, to tell tokens apart
+	Hash       string     `json:"-"`      // SHA-256 of the token, hex encoded
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // Nil for tokens that never expire
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	CreatedBy  string     `json:"created_by,omitempty"` // User whose token created it, empty from the command line
+}
+
+// Expired reports whether the token has expired at a time
+func (t *APIToken) Expired(at time.Time) bool {
+	return t.ExpiresAt != nil && !at.Before(*t.ExpiresAt)
+}
+
+// Allows reports whether the token grants a scope
+func (t *APIToken) Allows(scope string) bool {
+	return slices.Contains(t.Scopes, ScopeAdmin) || slices.Contains(t.Scopes, scope)
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebhookAllEvents subscribes a webhook to every event, present and future
+const WebhookAllEvents = "*"
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"   // Waiting for its first or next attempt
+	DeliveryDelivered = "delivered" // Answered with a 2xx status
+	DeliveryFailed    = "failed"    // Out of attempts
+)
+
+// Webhook is a subscription of a URL to events
+type Webhook struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"-"` // Key of the HMAC signature of payloads
+	Events      []string  `json:"events"`
+	Description string    `json:"description,omitempty"`
+	Enabled     bool      `json:"enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is an event queued for, or delivered to, a webhook
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	WebhookID      string          `json:"webhook_id"`
+	EventID        string          `json:"event_id"` // Shared by the deliveries of an event to every webhook
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode int             `json:"last_status_code,omitempty"` // HTTP status of the last attempt
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+
+	// URL and Secret of the webhook, when claimed for delivery
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
@@ -0,0 +1,218 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"google.golang.org/genai"
+)
+
+// CassetteRequest is the recorded form of a GenerateContent call.
+// Inline data is stored as a SHA-256 digest to keep golden files small.
+type CassetteRequest struct {
+	Model    string   `json:"model"`
+	Prompts  []string `json:"prompts"`
+	Blobs    []string `json:"blobs"`
+	Config   string   `json:"config,omitempty"`
+	MatchKey string   `json:"match_key"`
+}
+
+// Interaction is a single recorded request/response pair
+type Interaction struct {
+	Request  CassetteRequest                `json:"request"`
+	Response *genai.GenerateContentResponse `json:"response,omitempty"`
+	Error    string                         `json:"error,omitempty"`
+}
+
+// Cassette is the golden file format shared by Recorder and Replayer
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette reads a cassette from a golden file
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+
+	return &cassette, nil
+}
+
+// Save writes the cassette to a golden file, replacing it atomically
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// newCassetteRequest builds the recorded form of a request and its match key.
+// The key covers the model, every part (text and blob digests) and the config,
+// so a prompt or schema change invalidates previously recorded answers.
+func newCassetteRequest(model string, contents []*genai.Content, config *genai.GenerateContentConfig) (CassetteRequest, error) {
+	req := CassetteRequest{Model: model, Prompts: []string{}, Blobs: []string{}}
+
+	hasher := sha256.New()
+	hasher.Write([]byte(model))
+	for _, content := range contents {
+		for _, part := range content.Parts {
+			if part.Text != "" {
+				req.Prompts = append(req.Prompts, part.Text)
+				hasher.Write([]byte("text:" + part.Text))
+			}
+			if part.InlineData != nil {
+				sum := sha256.Sum256(part.InlineData.Data)
+				digest := part.InlineData.MIMEType + ":" + hex.EncodeToString(sum[:])
+				req.Blobs = append(req.Blobs, digest)
+				hasher.Write([]byte("blob:" + digest))
+			}
+		}
+	}
+
+	if config != nil {
+		configJSON, err := json.Marshal(config)
+		if err != nil {
+			return req, fmt.Errorf("failed to marshal config: %w", err)
+		}
+		req.Config = string(configJSON)
+		hasher.Write(configJSON)
+	}
+
+	req.MatchKey = hex.EncodeToString(hasher.Sum(nil))
+	return req, nil
+}
+
+// Recorder forwards calls to a real client and records every
+// request/response pair into a cassette golden file
+type Recorder struct {
+	client   ModelClient
+	path     string
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// Ensure Recorder implements ModelClient interface
+var _ ModelClient = (*Recorder)(nil)
+
+// NewRecorder creates a recorder that writes to the cassette at path.
+// Any existing cassette at path is overwritten on the first recorded call.
+func NewRecorder(client ModelClient, path string) *Recorder {
+	return &Recorder{
+		client:   client,
+		path:     path,
+		cassette: Cassette{Interactions: []Interaction{}},
+	}
+}
+
+func (r *Recorder) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	req, err := newCassetteRequest(model, contents, config)
+	if err != nil {
+		return nil, err
+	}
+
+	result, callErr := r.client.GenerateContent(ctx, model, contents, config)
+
+	interaction := Interaction{Request: req, Response: result}
+	if callErr != nil {
+		interaction.Error = callErr.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	if err := r.cassette.Save(r.path); err != nil {
+		log.Warn("Failed to save cassette", "path", r.path, "error", err)
+	} else {
+		log.Debug("Recorded model interaction", "model", model, "path", r.path)
+	}
+
+	return result, callErr
+}
+
+// ErrNoInteraction is returned by the Replayer when a request has no recording
+var ErrNoInteraction = errors.New("no recorded interaction for request")
+
+// Replayer serves responses from a cassette golden file without network access.
+// Requests are matched by key; repeated identical requests are served in
+// recording order.
+type Replayer struct {
+	mu      sync.Mutex
+	pending map[string][]Interaction
+}
+
+// Ensure Replayer implements ModelClient interface
+var _ ModelClient = (*Replayer)(nil)
+
+// NewReplayer creates a replayer from the cassette at path
+func NewReplayer(path string) (*Replayer, error) {
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReplayerFromCassette(cassette), nil
+}
+
+// NewReplayerFromCassette creates a replayer from an in-memory cassette
+func NewReplayerFromCassette(cassette *Cassette) *Replayer {
+	pending := make(map[string][]Interaction)
+	for _, interaction := range cassette.Interactions {
+		key := interaction.Request.MatchKey
+		pending[key] = append(pending[key], interaction)
+	}
+
+	return &Replayer{pending: pending}
+}
+
+func (r *Replayer) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	req, err := newCassetteRequest(model, contents, config)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	queue := r.pending[req.MatchKey]
+	if len(queue) == 0 {
+		return nil, fmt.Errorf("%w (model: %s, key: %s)", ErrNoInteraction, model, req.MatchKey)
+	}
+
+	interaction := queue[0]
+	// Keep the last interaction around so repeated calls stay deterministic
+	if len(queue) > 1 {
+		r.pending[req.MatchKey] = queue[1:]
+	}
+
+	if interaction.Error != "" {
+		return nil, errors.New(interaction.Error)
+	}
+
+	return interaction.Response, nil
+}
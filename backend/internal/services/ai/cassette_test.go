@@ -0,0 +1,122 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// clientFunc adapts a function to ModelClient
+type clientFunc func(ctx context.Context, model string) (*genai.GenerateContentResponse, error)
+
+func (f clientFunc) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	return f(ctx, model)
+}
+
+// textContents builds the contents of a request with a prompt and a blob
+func textContents(prompt string, blob []byte) []*genai.Content {
+	return []*genai.Content{{Role: genai.RoleUser, Parts: []*genai.Part{
+		{Text: prompt},
+		{InlineData: &genai.Blob{Data: blob, MIMEType: "image/png"}},
+	}}}
+}
+
+func TestRecorderReplayerRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "round-trip.cassette.json")
+	jsonConfig := &genai.GenerateContentConfig{ResponseMIMEType: "application/json"}
+
+	// The recorded client answers each call differently, and fails on "broken"
+	calls := 0
+	recorder := NewRecorder(clientFunc(func(ctx context.Context, model string) (*genai.GenerateContentResponse, error) {
+		calls++
+		if model == "broken" {
+			return nil, errors.New("model unavailable")
+		}
+		return &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{
+			Content: &genai.Content{Parts: []*genai.Part{{Text: fmt.Sprintf("answer %d", calls)}}},
+		}}}, nil
+	}), path)
+
+	ctx := context.Background()
+	requests := []struct {
+		model    string
+		contents []*genai.Content
+		config   *genai.GenerateContentConfig
+	}{
+		{"model", textContents("identify", []byte("receipt")), nil},
+		{"model", textContents("identify", []byte("receipt")), nil}, // Repeated identical request
+		{"model", textContents("extract", []byte("receipt")), jsonConfig},
+		{"broken", textContents("identify", []byte("receipt")), nil},
+	}
+	for _, req := range requests {
+		recorder.GenerateContent(ctx, req.model, req.contents, req.config)
+	}
+
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatalf("failed to load the recorded cassette: %v", err)
+	}
+
+	// Repeated identical requests are answered in recording order, and the
+	// last answer keeps being served once they run out
+	for _, want := range []string{"answer 1", "answer 2", "answer 2"} {
+		result, err := replayer.GenerateContent(ctx, "model", textContents("identify", []byte("receipt")), nil)
+		if err != nil {
+			t.Fatalf("replaying a recorded request failed: %v", err)
+		}
+		if result.Text() != want {
+			t.Errorf("replayed %q, want %q", result.Text(), want)
+		}
+	}
+
+	result, err := replayer.GenerateContent(ctx, "model", textContents("extract", []byte("receipt")), jsonConfig)
+	if err != nil || result.Text() != "answer 3" {
+		t.Errorf("replayed (%v, %v), want answer 3", result, err)
+	}
+
+	// Recorded errors are replayed as errors
+	if _, err := replayer.GenerateContent(ctx, "broken", textContents("identify", []byte("receipt")), nil); err == nil || err.Error() != "model unavailable" {
+		t.Errorf("replayed error %v, want model unavailable", err)
+	}
+
+	// Anything in the match key that differs is a miss
+	misses := []struct {
+		name     string
+		model    string
+		contents []*genai.Content
+		config   *genai.GenerateContentConfig
+	}{
+		{"other model", "stronger-model", textContents("identify", []byte("receipt")), nil},
+		{"other prompt", "model", textContents("identify the store", []byte("receipt")), nil},
+		{"other file", "model", textContents("identify", []byte("another receipt")), nil},
+		{"other config", "model", textContents("extract", []byte("receipt")), nil},
+	}
+	for _, miss := range misses {
+		t.Run(miss.name, func(t *testing.T) {
+			_, err := replayer.GenerateContent(ctx, miss.model, miss.contents, miss.config)
+			if !errors.Is(err, ErrNoInteraction) {
+				t.Errorf("got %v, want ErrNoInteraction", err)
+			}
+		})
+	}
+}
+
+func TestCassetteStoresBlobDigests(t *testing.T) {
+	req, err := newCassetteRequest("model", textContents("identify", []byte("receipt")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(req.Prompts) != 1 || req.Prompts[0] != "identify" {
+		t.Errorf("prompts = %v, want [identify]", req.Prompts)
+	}
+	// SHA-256 of "receipt"
+	want := "image/png:6f32860910ca0fb2a20c7fda143666b09dbf8db5238195c90a586fb542ff0cad"
+	if len(req.Blobs) != 1 || req.Blobs[0] != want {
+		t.Errorf("blobs = %v, want [%s]", req.Blobs, want)
+	}
+}
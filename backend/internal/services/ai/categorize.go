@@ -0,0 +1,117 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"google.golang.org/genai"
+)
+
+// categorizePromptVersion identifies the product classification prompt
+const categorizePromptVersion = "categorize-v1"
+
+// ProductToClassify is a product name as printed on a receipt, with its store
+type ProductToClassify struct {
+	Name  string
+	Store string
+}
+
+// ClassifyProducts asks the model for the category of each product, chosen
+// among the given category paths. It returns the category of each product by
+// its index; products the model could not place, or placed in a category
+// that is not in the list, are left out. Runs on the store stage model, the
+// lighter one.
+func (s *GeminiService) ClassifyProducts(ctx context.Context, products []ProductToClassify, categories []string) (map[int]string, error) {
+	if len(products) == 0 || len(categories) == 0 {
+		return map[int]string{}, nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`## ROLE
+
+You are a product classifier for Spanish shopping receipts.
+
+## INSTRUCTION
+
+Assign each product to one of the categories below.
+
+## CATEGORIES
+
+`)
+	for _, category := range categories {
+		sb.WriteString("- " + category + "\n")
+	}
+	sb.WriteString(`
+## PRODUCTS
+
+`)
+	for i, product := range products {
+		fmt.Fprintf(&sb, "%d. %s (%s)\n", i, product.Name, product.Store)
+	}
+	sb.WriteString(`
+## EXPECTATION
+
+Answer with one entry per product: its "index" and its "category".
+
+## NARROWING
+
+- "category" must be copied EXACTLY from the list of categories, including the "/" separators
+- Prefer the most specific category that fits (e.g. "Groceries/Dairy" over "Groceries")
+- Product names are abbreviated: "LECHE SEMI" is milk, "DETERG" is detergent
+- If no category fits or you cannot tell what the product is, use an empty "category"`)
+
+	schema := &genai.Schema{
+		Type: genai.TypeArray,
+		Items: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"index":    {Type: genai.TypeInteger},
+				"category": {Type: genai.TypeString},
+			},
+			PropertyOrdering: []string{"index", "category"},
+		},
+	}
+
+	log.Info("Classifying products", "products", len(products), "categories", len(categories), "model", s.storeStage.Model, "prompt", categorizePromptVersion)
+
+	result, err := s.generate(
+		ctx,
+		s.storeStage,
+		[]*genai.Content{{Role: genai.RoleUser, Parts: []*genai.Part{{Text: sb.String()}}}},
+		&genai.GenerateContentConfig{
+			ResponseMIMEType: "application/json",
+			ResponseSchema:   schema,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to classify products: %w", err)
+	}
+
+	var answers []struct {
+		Index    int    `json:"index"`
+		Category string `json:"category"`
+	}
+	if err := json.Unmarshal([]byte(result.Text()), &answers); err != nil {
+		return nil, fmt.Errorf("failed to parse classification: %w", err)
+	}
+
+	known := make(map[string]string, len(categories))
+	for _, category := range categories {
+		known[strings.ToLower(category)] = category
+	}
+
+	classified := map[int]string{}
+	for _, answer := range answers {
+		category, ok := known[strings.ToLower(strings.TrimSpace(answer.Category))]
+		if !ok || answer.Index < 0 || answer.Index >= len(products) {
+			continue
+		}
+		classified[answer.Index] = category
+	}
+
+	log.Info("Products classified", "classified", len(classified), "products", len(products))
+	return classified, nil
+}
@@ -0,0 +1,16 @@
+package ai
+
+import (
+	"context"
+
+	"google.golang.org/genai"
+)
+
+// ModelClient is the subset of the genai client used by GeminiService.
+// *genai.Models satisfies it, as do the cassette Recorder and Replayer.
+type ModelClient interface {
+	GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error)
+}
+
+// Ensure the genai models client implements ModelClient
+var _ ModelClient = (*genai.Models)(nil)
@@ -7,24 +7,75 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/config"
 	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/parser"
 	"github.com/vieitesss/ticketer/pkg/logger"
 	"google.golang.org/genai"
 )
 
+// Prompt versions are recorded with every extraction. Bump the matching
+// constant whenever a prompt's text changes.
+const (
+	storePromptVersion     = "store-v1"
+	aldiPromptVersion      = "aldi-v2"
+	carrefourPromptVersion = "carrefour-v2"
+	genericPromptVersion   = "generic-v2"
+	fixPromptVersion       = "fix-v1"
+)
+
 type GeminiService struct {
-	client *genai.Client
+	client     ModelClient
+	storeStage config.AIStageConfig
+	itemsStage config.AIStageConfig
+	breaker    *circuitBreaker
+
+	// escalationModel replaces the items model when an extraction is escalated
+	escalationModel string
 }
 
-func NewGeminiService(ctx context.Context) (*GeminiService, error) {
+func NewGeminiService(ctx context.Context, cfg *config.Config) (*GeminiService, error) {
 	client, err := genai.NewClient(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create genai client: %w", err)
 	}
 
-	return &GeminiService{client: client}, nil
+	return NewGeminiServiceWithClient(client.Models, cfg), nil
+}
+
+// NewGeminiServiceWithClient creates a Gemini service backed by the given model client
+func NewGeminiServiceWithClient(client ModelClient, cfg *config.Config) *GeminiService {
+	return &GeminiService{
+		client:     client,
+		storeStage: cfg.AIStoreStage,
+		itemsStage: cfg.AIItemsStage,
+		breaker:    newCircuitBreaker(cfg.AIBreakerThreshold, cfg.AIBreakerCooldown),
+
+		escalationModel: cfg.AIEscalationModel,
+	}
+}
+
+// readReceipt returns the content of a receipt file and its MIME type. HTML
+// pages and emails are sent as their rendered text.
+func (s *GeminiService) readReceipt(imagePath string) ([]byte, string, error) {
+	if parser.IsMarkup(imagePath) {
+		text, err := parser.ExtractText(imagePath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read e-receipt: %w", err)
+		}
+		return []byte(text), "text/plain", nil
+	}
+
+	imageData, err := os.ReadFile(imagePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read image: %w", err)
+	}
+
+	// Determine MIME type from file extension
+	return imageData, s.getMimeType(imagePath), nil
 }
 
 func (s *GeminiService) getMimeType(imagePath string) string {
@@ -34,12 +85,26 @@ func (s *GeminiService) getMimeType(imagePath string) string {
 		return "image/jpeg"
 	case ".png":
 		return "image/png"
+	case ".pdf":
+		return "application/pdf"
+	case ".txt":
+		return "text/plain"
 	default:
 		return "image/jpeg" // default fallback
 	}
 }
 
-func (s *GeminiService) identifyStore(ctx context.Context, imageData []byte, mimeType string) (string, error) {
+// recordUsage fills the latency and token usage of a stage from a model response
+func recordUsage(stage *models.ExtractionStage, start time.Time, result *genai.GenerateContentResponse) {
+	stage.LatencyMs = time.Since(start).Milliseconds()
+	if result == nil || result.UsageMetadata == nil {
+		return
+	}
+	stage.PromptTokens = result.UsageMetadata.PromptTokenCount
+	stage.OutputTokens = result.UsageMetadata.CandidatesTokenCount + result.UsageMetadata.ThoughtsTokenCount
+}
+
+func (s *GeminiService) identifyStore(ctx context.Context, imageData []byte, mimeType string) (string, models.ExtractionStage, error) {
 	log.Info("Identifying store from receipt")
 
 	prompt := `## ROLE
@@ -71,24 +136,32 @@ Answer JUST with the store name in UPPERCASE letters.
 		{InlineData: &genai.Blob{Data: imageData, MIMEType: mimeType}},
 	}
 
-	result, err := s.client.Models.GenerateContent(
+	stage := models.ExtractionStage{
+		Model:         s.storeStage.Model,
+		PromptVersion: storePromptVersion,
+	}
+
+	start := time.Now()
+	result, err := s.generate(
 		ctx,
-		"gemini-2.5-flash-lite",
+		s.storeStage,
 		[]*genai.Content{{Parts: parts}},
 		nil,
 	)
+	recordUsage(&stage, start, result)
 	if err != nil {
-		return "", fmt.Errorf("failed to identify store: %w", err)
+		return "", stage, fmt.Errorf("failed to identify store: %w", err)
 	}
 
 	storeName := strings.TrimSpace(result.Text())
 	storeName = strings.ToUpper(storeName)
 
 	log.Info("Store identified", "store", storeName)
-	return storeName, nil
+	return storeName, stage, nil
 }
 
-func (s *GeminiService) getStorePrompt(storeName string) string {
+// getStorePrompt returns the extraction instructions for a store and their version
+func (s *GeminiService) getStorePrompt(storeName string) (string, string) {
 	switch storeName {
 	case "ALDI":
 		log.Debug("Using ALDI-specific prompt template")
@@ -135,7 +208,7 @@ Extract all products with their correct "name", "quantity" and "price".
   - For products with line "Type A" BEFORE: the number BETWEEN "x" and "€" in the line BEFORE
   - For products WITHOUT line "Type A" BEFORE: the number BEFORE "€" in the product line
 - NO invent products or quantities
-- THINK step by step`
+- THINK step by step`, aldiPromptVersion
 
 	case "CARREFOUR EXPRESS", "CARREFOUR":
 		log.Debug("Using CARREFOUR-specific prompt template")
@@ -197,7 +270,7 @@ Extract all products with their correct "name", "quantity" and "price", and the
 - For products "Type A" with PRICE on the same line: "price" is the number before € and "quantity" = 1
 - "discounts" is the number in the "DESCUENTOS" line in BOLD, or null if it doesn't exist
 - DO NOT invent products or quantities
-- THINK step by step`
+- THINK step by step`, carrefourPromptVersion
 
 	default:
 		log.Debug("Using generic prompt template")
@@ -239,30 +312,77 @@ Extract all products with "{name, quantity, price}" and the "discounts" of the r
 - If there is no explicit quantity, always use "quantity" = 1
 - "price" is the number before the € symbol (or the last number on the line)
 - DO NOT invent products or quantities that you don't see on the receipt
-- THINK step by step`
+- THINK step by step`, genericPromptVersion
 	}
 }
 
-func (s *GeminiService) ProcessReceipt(ctx context.Context, imagePath string) (*models.Receipt, error) {
+// ExtractOptions escalates an extraction beyond the default configuration
+type ExtractOptions struct {
+	// StoreName skips store identification when already known
+	StoreName string
+	// Escalate uses the stronger escalation model for the items stage
+	Escalate bool
+	// GenericPrompt uses the generic prompt instead of the store-specific one
+	GenericPrompt bool
+	// PreviousResponse and Issues turn the extraction into a follow-up that
+	// asks the model to fix the inconsistencies of its previous answer
+	PreviousResponse string
+	Issues           []string
+	// OnStoreIdentified is called with the name of the store once identified,
+	// before its items are extracted
+	OnStoreIdentified func(storeName string)
+}
+
+// ProcessReceipt extracts a receipt from an image. The returned extraction
+// records the provenance of the result and is non-nil whenever a model was
+// reached, even if processing failed afterwards.
+func (s *GeminiService) ProcessReceipt(ctx context.Context, imagePath string) (*models.Receipt, *models.Extraction, error) {
+	return s.ExtractReceipt(ctx, imagePath, ExtractOptions{})
+}
+
+// ExtractReceipt extracts a receipt from an image with the given options
+func (s *GeminiService) ExtractReceipt(ctx context.Context, imagePath string, opts ExtractOptions) (*models.Receipt, *models.Extraction, error) {
 	log.Info("Starting receipt processing", "path", imagePath)
 
-	imageData, err := os.ReadFile(imagePath)
+	imageData, mimeType, err := s.readReceipt(imagePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read image: %w", err)
+		return nil, nil, err
 	}
-
-	// Determine MIME type from file extension
-	mimeType := s.getMimeType(imagePath)
 	log.Debug("Detected image format", "mimeType", mimeType)
 
+	extraction := &models.Extraction{}
+
 	// Step 1: Identify store
-	storeName, err := s.identifyStore(ctx, imageData, mimeType)
-	if err != nil {
-		return nil, fmt.Errorf("failed to identify store: %w", err)
+	storeName := opts.StoreName
+	if storeName == "" {
+		var storeStage models.ExtractionStage
+		storeName, storeStage, err = s.identifyStore(ctx, imageData, mimeType)
+		extraction.StoreStage = storeStage
+		if err != nil {
+			extraction.Error = err.Error()
+			return nil, extraction, fmt.Errorf("failed to identify store: %w", err)
+		}
+		if opts.OnStoreIdentified != nil {
+			opts.OnStoreIdentified(storeName)
+		}
 	}
+	extraction.StoreAnswer = storeName
 
 	// Step 2: Get store-specific prompt
-	storePrompt := s.getStorePrompt(storeName)
+	promptStore := storeName
+	if opts.GenericPrompt {
+		promptStore = ""
+	}
+	storePrompt, promptVersion := s.getStorePrompt(promptStore)
+
+	itemsStage := s.itemsStage
+	if opts.Escalate && s.escalationModel != "" {
+		itemsStage.Model = s.escalationModel
+	}
+	extraction.ItemsStage = models.ExtractionStage{
+		Model:         itemsStage.Model,
+		PromptVersion: promptVersion,
+	}
 
 	fullPrompt := fmt.Sprintf(`## ROLE
 
@@ -275,7 +395,8 @@ You are a specialized processor for supermarket receipts, in this case from the
   "store_name": string,
   "bought_date": string,
   "items": [{"name": string, "quantity": float, "price": float}],
-  "discounts": float | null
+  "discounts": float | null,
+  "total": float | null
 }
 
 ## NORMALIZATION RULES
@@ -284,14 +405,25 @@ You are a specialized processor for supermarket receipts, in this case from the
 - **Product names**: Convert to UPPERCASE exactly as they appear on the receipt (keep sizes, brands, everything)
 - **Date**: Extract "bought_date" from receipt, format as ISO 8601: "YYYY-MM-DD" (e.g., "2024-03-15")
   - Look for date formats like "DD/MM/YYYY", "DD-MM-YYYY", or similar
-  - If you cannot find a date, use null`, storeName, storePrompt)
+  - If you cannot find a date, use null
+- **Total**: The final amount paid as printed on the receipt ("TOTAL", "A PAGAR", "IMPORTE"), or null if you cannot find it`, storeName, storePrompt)
 
-	log.Info("Sending receipt to model for extraction", "store", storeName)
+	log.Info("Sending receipt to model for extraction", "store", storeName, "model", itemsStage.Model)
 
 	parts := []*genai.Part{
 		{Text: fullPrompt},
 		{InlineData: &genai.Blob{Data: imageData, MIMEType: mimeType}},
 	}
+	contents := []*genai.Content{{Role: genai.RoleUser, Parts: parts}}
+
+	// Follow-up turn: show the model its previous answer and what is wrong with it
+	if opts.PreviousResponse != "" {
+		contents = append(contents,
+			&genai.Content{Role: genai.RoleModel, Parts: []*genai.Part{{Text: opts.PreviousResponse}}},
+			&genai.Content{Role: genai.RoleUser, Parts: []*genai.Part{{Text: fixPrompt(opts.Issues)}}},
+		)
+		extraction.ItemsStage.PromptVersion += "+" + fixPromptVersion
+	}
 
 	// Define response schema for structured output
 	schema := &genai.Schema{
@@ -318,36 +450,43 @@ You are a specialized processor for supermarket receipts, in this case from the
 				},
 			},
 			"discounts": {Type: genai.TypeNumber, Nullable: genai.Ptr(true)},
+			"total":     {Type: genai.TypeNumber, Nullable: genai.Ptr(true)},
 		},
 		PropertyOrdering: []string{
 			"store_name",
 			"bought_date",
 			"items",
 			"discounts",
+			"total",
 		},
 	}
 
-	config := &genai.GenerateContentConfig{
+	genConfig := &genai.GenerateContentConfig{
 		ResponseMIMEType: "application/json",
 		ResponseSchema:   schema,
 	}
 
-	result, err := s.client.Models.GenerateContent(
+	start := time.Now()
+	result, err := s.generate(
 		ctx,
-		"gemini-2.5-flash",
-		[]*genai.Content{{Parts: parts}},
-		config,
+		itemsStage,
+		contents,
+		genConfig,
 	)
+	recordUsage(&extraction.ItemsStage, start, result)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate content: %w", err)
+		extraction.Error = err.Error()
+		return nil, extraction, fmt.Errorf("failed to generate content: %w", err)
 	}
 
 	responseText := result.Text()
+	extraction.RawResponse = responseText
 	log.Info("Received response from model")
 
 	var receipt models.Receipt
 	if err := json.Unmarshal([]byte(responseText), &receipt); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w\nResponse: %s", err, responseText)
+		extraction.Error = err.Error()
+		return nil, extraction, fmt.Errorf("failed to parse response: %w\nResponse: %s", err, responseText)
 	}
 
 	logger.DebugJSON("Raw model response", "response", receipt)
@@ -356,7 +495,38 @@ You are a specialized processor for supermarket receipts, in this case from the
 		"bought_date", receipt.BoughtDate,
 		"items", len(receipt.Items),
 		"discounts", receipt.Discounts)
-	log.Info("Receipt processing completed")
+	log.Info("Receipt processing completed", "tokens", extraction.TotalTokens())
+
+	return &receipt, extraction, nil
+}
+
+// fixPrompt asks the model to correct the inconsistencies found in its previous answer
+func fixPrompt(issues []string) string {
+	var sb strings.Builder
+	sb.WriteString(`## INSTRUCTION
+
+Your previous answer has the following inconsistencies:
+
+`)
+	for _, issue := range issues {
+		sb.WriteString("- " + issue + "\n")
+	}
+	sb.WriteString(`
+## STEPS
+
+1. Read the receipt again line by line, from top to bottom
+2. Check every product, quantity and price against your previous answer
+3. Fix the lines that are wrong, add the missing ones and remove the invented ones
+
+## EXPECTATION
+
+Answer with the FULL corrected JSON, in the same format as before.
+
+## NARROWING
+
+- The items minus the discounts must add up to the printed total
+- DO NOT invent products to make the total match
+- THINK step by step`)
 
-	return &receipt, nil
+	return sb.String()
 }
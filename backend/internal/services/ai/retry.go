@@ -0,0 +1,180 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/config"
+	"google.golang.org/genai"
+)
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// ErrCircuitOpen is returned without calling the model while the provider is considered down
+var ErrCircuitOpen = errors.New("AI provider unavailable (circuit breaker open)")
+
+// circuitBreaker fails fast after a number of consecutive failures, and lets a
+// single probe call through once the cooldown has elapsed
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a call may be attempted
+func (b *circuitBreaker) allow() bool {
+	if b == nil || b.threshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) {
+		return false
+	}
+
+	// Half-open: let one probe through and re-open if it fails
+	b.openUntil = time.Now().Add(b.cooldown)
+	return true
+}
+
+// record updates the breaker with the outcome of a call
+func (b *circuitBreaker) record(err error) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		if b.failures >= b.threshold {
+			log.Info("AI provider recovered, closing circuit breaker")
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures == b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		log.Warn("AI provider failing, opening circuit breaker", "failures", b.failures, "cooldown", b.cooldown)
+	}
+}
+
+// isRetryable reports whether a failed call is worth retrying
+func isRetryable(err error) bool {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case 408, 429, 500, 502, 503, 504:
+			return true
+		default:
+			return false
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// IsTransient reports whether an extraction failed because of the provider,
+// so that it may succeed later: the circuit breaker was open, or a retryable
+// failure outlasted the retries
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || isRetryable(err)
+}
+
+// backoff returns the delay before a retry: exponential with full jitter
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return rand.N(delay) + retryBaseDelay/2
+}
+
+// generate calls the model for a stage, applying its per-attempt timeout,
+// retrying retryable errors with backoff and honouring the circuit breaker
+func (s *GeminiService) generate(ctx context.Context, stage config.AIStageConfig, contents []*genai.Content, genConfig *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	if stage.Temperature != nil {
+		if genConfig == nil {
+			genConfig = &genai.GenerateContentConfig{}
+		}
+		genConfig.Temperature = stage.Temperature
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= stage.MaxRetries; attempt++ {
+		if attempt > 0 {
+			delay := backoff(attempt - 1)
+			log.Warn("Retrying model call", "model", stage.Model, "attempt", attempt, "delay", delay, "error", lastErr)
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		if !s.breaker.allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		result, err := s.generateOnce(ctx, stage, contents, genConfig)
+		if err == nil {
+			s.breaker.record(nil)
+			return result, nil
+		}
+
+		// The caller gave up, retrying would only fail again. It says
+		// nothing about the provider, so the breaker doesn't count it.
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		// Only failures of the provider count towards opening the breaker:
+		// a rejected request (bad schema or prompt) would fail on any retry
+		lastErr = err
+		if !isRetryable(err) {
+			return nil, err
+		}
+		s.breaker.record(err)
+	}
+
+	return nil, fmt.Errorf("model %s failed after %d attempts: %w", stage.Model, stage.MaxRetries+1, lastErr)
+}
+
+// generateOnce makes a single model call bounded by the stage timeout
+func (s *GeminiService) generateOnce(ctx context.Context, stage config.AIStageConfig, contents []*genai.Content, genConfig *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	if stage.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, stage.Timeout)
+		defer cancel()
+	}
+
+	return s.client.GenerateContent(ctx, stage.Model, contents, genConfig)
+}
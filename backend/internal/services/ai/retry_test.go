@@ -0,0 +1,179 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vieitesss/ticketer/internal/config"
+	"google.golang.org/genai"
+)
+
+func TestBackoff(t *testing.T) {
+	for attempt := range 10 {
+		ceiling := min(retryBaseDelay<<attempt, retryMaxDelay)
+		for range 100 {
+			delay := backoff(attempt)
+			if delay < retryBaseDelay/2 || delay >= ceiling+retryBaseDelay/2 {
+				t.Fatalf("backoff(%d) = %v, want within [%v, %v)", attempt, delay, retryBaseDelay/2, ceiling+retryBaseDelay/2)
+			}
+		}
+	}
+
+	// Shifts past the width of a duration are capped rather than overflowing
+	if delay := backoff(80); delay <= 0 || delay >= retryMaxDelay+retryBaseDelay/2 {
+		t.Fatalf("backoff(80) = %v, want capped at %v", delay, retryMaxDelay)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unavailable", genai.APIError{Code: 503}, true},
+		{"rate limited", genai.APIError{Code: 429}, true},
+		{"request timeout", genai.APIError{Code: 408}, true},
+		{"internal error", genai.APIError{Code: 500}, true},
+		{"wrapped gateway timeout", fmt.Errorf("call failed: %w", genai.APIError{Code: 504}), true},
+		{"bad request", genai.APIError{Code: 400}, false},
+		{"forbidden", genai.APIError{Code: 403}, false},
+		{"not found", genai.APIError{Code: 404}, false},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"cancelled", context.Canceled, false},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"other error", errors.New("invalid JSON"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	cooldown := 20 * time.Millisecond
+	breaker := newCircuitBreaker(2, cooldown)
+	failure := errors.New("unavailable")
+
+	breaker.record(failure)
+	if !breaker.allow() {
+		t.Fatal("breaker opened before reaching the threshold")
+	}
+	breaker.record(failure)
+	if breaker.allow() {
+		t.Fatal("breaker still closed after reaching the threshold")
+	}
+
+	// Once the cooldown elapses a single probe goes through
+	time.Sleep(cooldown + 5*time.Millisecond)
+	if !breaker.allow() {
+		t.Fatal("breaker let no probe through after the cooldown")
+	}
+	if breaker.allow() {
+		t.Fatal("breaker let a second call through while half-open")
+	}
+
+	// A failed probe opens it for another cooldown
+	breaker.record(failure)
+	if breaker.allow() {
+		t.Fatal("breaker closed after a failed probe")
+	}
+
+	// A successful probe closes it
+	time.Sleep(cooldown + 5*time.Millisecond)
+	if !breaker.allow() {
+		t.Fatal("breaker let no probe through after the second cooldown")
+	}
+	breaker.record(nil)
+	for range 3 {
+		if !breaker.allow() {
+			t.Fatal("breaker still open after a successful probe")
+		}
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	breaker := newCircuitBreaker(0, time.Minute)
+	for range 10 {
+		breaker.record(errors.New("unavailable"))
+	}
+	if !breaker.allow() {
+		t.Fatal("disabled breaker opened")
+	}
+
+	var missing *circuitBreaker
+	missing.record(errors.New("unavailable"))
+	if !missing.allow() {
+		t.Fatal("nil breaker opened")
+	}
+}
+
+func TestGenerateBreakerCounting(t *testing.T) {
+	stage := config.AIStageConfig{Model: "test-model", MaxRetries: 0}
+
+	tests := []struct {
+		name     string
+		err      error
+		cancel   bool
+		wantOpen bool
+	}{
+		{"provider unavailable", genai.APIError{Code: 503}, false, true},
+		{"rejected request", genai.APIError{Code: 400, Message: "invalid schema"}, false, false},
+		{"caller cancelled", context.Canceled, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &GeminiService{
+				client: clientFunc(func(ctx context.Context, model string) (*genai.GenerateContentResponse, error) {
+					return nil, tt.err
+				}),
+				breaker: newCircuitBreaker(1, time.Minute),
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.cancel {
+				cancel()
+			} else {
+				defer cancel()
+			}
+
+			if _, err := service.generate(ctx, stage, nil, nil); err == nil {
+				t.Fatal("generate succeeded, want an error")
+			}
+			if open := !service.breaker.allow(); open != tt.wantOpen {
+				t.Fatalf("breaker open = %v, want %v", open, tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestGenerateRetriesRetryableErrors(t *testing.T) {
+	calls := 0
+	service := &GeminiService{
+		client: clientFunc(func(ctx context.Context, model string) (*genai.GenerateContentResponse, error) {
+			calls++
+			if calls < 3 {
+				return nil, genai.APIError{Code: 503}
+			}
+			return &genai.GenerateContentResponse{}, nil
+		}),
+		breaker: newCircuitBreaker(5, time.Minute),
+	}
+
+	stage := config.AIStageConfig{Model: "test-model", MaxRetries: 2}
+	if _, err := service.generate(context.Background(), stage, nil, nil); err != nil {
+		t.Fatalf("generate failed after retries: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("model called %d times, want 3", calls)
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"github.com/charmbracelet/log"
+)
+
+// BatchFile is one file of a batch upload, already saved to disk
+type BatchFile struct {
+	Name string // Name as uploaded, or path inside the ZIP archive
+	Path string
+}
+
+// BatchResult is the outcome of processing one file of a batch
+type BatchResult struct {
+	File   BatchFile
+	Result *ProcessResult
+	Err    error
+}
+
+// ProcessBatch processes files with bounded concurrency, each with the same
+// options but for their progress, and sends each result as soon as it
+// completes. The returned channel is closed once every file has been
+// processed, or after cancelling ctx stops the remaining ones; callers must
+// drain it.
+func (s *ReceiptService) ProcessBatch(ctx context.Context, files []BatchFile, opts ProcessOptions) <-chan BatchResult {
+	results := make(chan BatchResult)
+
+	concurrency := max(s.batchConcurrency, 1)
+	log.Info("Processing batch", "files", len(files), "concurrency", concurrency)
+
+	go func() {
+		defer close(results)
+
+		semaphore := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+
+		for _, file := range files {
+			select {
+			case <-ctx.Done():
+				results <- BatchResult{File: file, Err: ctx.Err()}
+				continue
+			case semaphore <- struct{}{}:
+			}
+
+			wg.Add(1)
+			go func(file BatchFile) {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+
+				result, err := s.ProcessReceiptFileWith(ctx, file.Path, ProcessOptions{Owner: opts.Owner, HouseholdID: opts.HouseholdID})
+				results <- BatchResult{File: file, Result: result, Err: err}
+			}(file)
+		}
+
+		wg.Wait()
+		log.Info("Batch processed", "files", len(files))
+	}()
+
+	return results
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// batchFiles are e-receipts the parser reads without AI, one of them twice, and
+// a file that does not exist
+func batchFiles() []BatchFile {
+	testdata := filepath.Join("parser", "testdata")
+	return []BatchFile{
+		{Name: "mercadona.eml", Path: filepath.Join(testdata, "mercadona.eml")},
+		{Name: "carrefour.html", Path: filepath.Join(testdata, "carrefour.html")},
+		{Name: "copy/mercadona.eml", Path: filepath.Join(testdata, "mercadona.eml")},
+		{Name: "missing.eml", Path: filepath.Join(testdata, "missing.eml")},
+	}
+}
+
+func TestProcessBatch(t *testing.T) {
+	service, repository := newMemoryService()
+	service.batchConcurrency = 2
+
+	results := map[string]BatchResult{}
+	for result := range service.ProcessBatch(context.Background(), batchFiles(), ProcessOptions{}) {
+		if _, ok := results[result.File.Name]; ok {
+			t.Errorf("%s reported twice", result.File.Name)
+		}
+		results[result.File.Name] = result
+	}
+	if len(results) != 4 {
+		t.Fatalf("got %d results, want one per file", len(results))
+	}
+
+	if result := results["carrefour.html"]; result.Err != nil || result.Result.Receipt.ID == "" {
+		t.Errorf("carrefour.html = %+v, want saved", result)
+	}
+	if result := results["missing.eml"]; result.Err == nil {
+		t.Error("missing.eml did not fail")
+	}
+
+	// Whichever copy is processed first is saved, the other is its duplicate
+	first, second := results["mercadona.eml"], results["copy/mercadona.eml"]
+	if first.Err != nil || second.Err != nil {
+		t.Fatalf("mercadona failed: %v, %v", first.Err, second.Err)
+	}
+	if first.Result.DuplicateOf() != "" {
+		first, second = second, first
+	}
+	if first.Result.Receipt.ID == "" || second.Result.DuplicateOf() != first.Result.Receipt.ID {
+		t.Errorf("copies saved as %q and duplicate of %q, want one saved and the other its duplicate",
+			first.Result.Receipt.ID, second.Result.DuplicateOf())
+	}
+	if len(repository.receipts) != 2 {
+		t.Errorf("saved %d receipts, want 2", len(repository.receipts))
+	}
+}
+
+func TestProcessBatchCancelled(t *testing.T) {
+	service, repository := newMemoryService()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Every file is still reported, so callers know what was not processed
+	count := 0
+	for result := range service.ProcessBatch(ctx, batchFiles(), ProcessOptions{}) {
+		count++
+		if !errors.Is(result.Err, context.Canceled) {
+			t.Errorf("%s = %v, want cancelled", result.File.Name, result.Err)
+		}
+	}
+	if count != 4 {
+		t.Errorf("got %d results, want 4", count)
+	}
+	if len(repository.receipts) != 0 {
+		t.Errorf("saved %d receipts after cancelling", len(repository.receipts))
+	}
+}
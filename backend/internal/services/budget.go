@@ -0,0 +1,363 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/config"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/notify"
+	"github.com/vieitesss/ticketer/internal/services/webhooks"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// budgetThresholds are the percentages of a budget notified when a new
+// receipt crosses them, each once per period
+var budgetThresholds = []int{80, 100}
+
+// BudgetService manages budgets, and alerts when new receipts push them past
+// their thresholds
+type BudgetService struct {
+	db database.BudgetRepository
+
+	// receipts resolves the categories of budgets and the receipts checked against them
+	receipts database.ReceiptRepository
+
+	// notifier delivers budget alerts
+	notifier notify.Notifier
+
+	// webhooks queues budget alerts for webhooks (nil without a database)
+	webhooks *webhooks.Dispatcher
+}
+
+func NewBudgetService(db database.BudgetRepository, receipts database.ReceiptRepository, dispatcher *webhooks.Dispatcher, cfg *config.Config) *BudgetService {
+	return &BudgetService{
+		db:       db,
+		receipts: receipts,
+		notifier: notify.New(cfg),
+		webhooks: dispatcher,
+	}
+}
+
+// ListBudgets retrieves the budgets of a scope with their progress in the
+// period containing date (today when zero)
+func (s *BudgetService) ListBudgets(ctx context.Context, scope database.Scope, date time.Time) ([]dto.BudgetResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	budgets, err := s.db.ListBudgets(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.BudgetResponse, len(budgets))
+	for i := range budgets {
+		progress, err := s.budgetProgress(ctx, &budgets[i], date)
+		if err != nil {
+			return nil, err
+		}
+		response[i] = *progress
+	}
+	return response, nil
+}
+
+// CreateBudget adds a budget of the member of a scope, shared in the
+// household of the request, if any
+func (s *BudgetService) CreateBudget(ctx context.Context, scope database.Scope, req dto.BudgetRequest) (*dto.BudgetResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	budget, err := s.budgetFromRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	budget.Owner = scope.Member
+	if budget.HouseholdID, err = sharedHousehold(ctx, s.receipts, scope.Member, req.HouseholdID); err != nil {
+		return nil, err
+	}
+
+	id, err := s.db.CreateBudget(ctx, budget)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Budget created", "id", id, "name", budget.Name, "period", budget.Period, "amount", budget.Amount,
+		"owner", budget.Owner, "household", budget.HouseholdID)
+
+	return s.getBudget(ctx, id, scope)
+}
+
+// UpdateBudget replaces a budget of a scope, keeping its owner and household
+func (s *BudgetService) UpdateBudget(ctx context.Context, scope database.Scope, id string, req dto.BudgetRequest) (*dto.BudgetResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	budget, err := s.budgetFromRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	budget.ID = id
+
+	if err := s.db.UpdateBudget(ctx, budget, scope); err != nil {
+		return nil, err
+	}
+
+	return s.getBudget(ctx, id, scope)
+}
+
+// DeleteBudget deletes a budget of a scope
+func (s *BudgetService) DeleteBudget(ctx context.Context, scope database.Scope, id string) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+
+	return s.db.DeleteBudget(ctx, id, scope)
+}
+
+// getBudget retrieves a budget of a scope with its progress in the current period
+func (s *BudgetService) getBudget(ctx context.Context, id string, scope database.Scope) (*dto.BudgetResponse, error) {
+	budget, err := s.db.GetBudget(ctx, id, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.budgetProgress(ctx, budget, time.Now())
+}
+
+// budgetFromRequest validates a budget request, resolving its category
+func (s *BudgetService) budgetFromRequest(ctx context.Context, req dto.BudgetRequest) (*models.Budget, error) {
+	budget := &models.Budget{
+		Name:      strings.Join(strings.Fields(req.Name), " "),
+		Period:    strings.ToLower(strings.TrimSpace(req.Period)),
+		Amount:    math.Round(req.Amount*100) / 100,
+		StoreName: normalizeName(req.Store),
+		Tag:       NormalizeTag(req.Tag),
+	}
+	if budget.Period == "" {
+		budget.Period = models.BudgetPeriodMonthly
+	}
+
+	issues := []string{}
+	switch {
+	case budget.Name == "":
+		issues = append(issues, "the budget name is missing")
+	case len(budget.Name) > 100:
+		issues = append(issues, "the budget name is longer than 100 characters")
+	}
+	if budget.Period != models.BudgetPeriodMonthly && budget.Period != models.BudgetPeriodWeekly {
+		issues = append(issues, fmt.Sprintf("unknown period %q (expected monthly or weekly)", req.Period))
+	}
+	if budget.Amount <= 0 {
+		issues = append(issues, "the amount must be greater than 0")
+	}
+	if len(budget.Tag) > maxTagLength {
+		issues = append(issues, fmt.Sprintf("the tag is longer than %d characters", maxTagLength))
+	}
+
+	if category := strings.TrimSpace(req.Category); category != "" {
+		categories, err := s.receipts.ListCategories(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if budget.CategoryID = resolveCategory(categories, category); budget.CategoryID == "" {
+			issues = append(issues, fmt.Sprintf("unknown category %q", category))
+		}
+	}
+
+	targets := 0
+	for _, target := range []string{req.Category, budget.StoreName, budget.Tag} {
+		if strings.TrimSpace(target) != "" {
+			targets++
+		}
+	}
+	if targets != 1 {
+		issues = append(issues, "a budget is for exactly one of a category, a store or a tag")
+	}
+
+	if len(issues) > 0 {
+		return nil, &ValidationError{Issues: issues}
+	}
+	return budget, nil
+}
+
+// budgetPeriod returns the first day of the period of a budget containing a
+// date, and the first day of the next one
+func budgetPeriod(period string, date time.Time) (time.Time, time.Time) {
+	day := dateOf(date)
+	if period == models.BudgetPeriodWeekly {
+		start := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		return start, start.AddDate(0, 0, 7)
+	}
+
+	start := day.AddDate(0, 0, 1-day.Day())
+	return start, start.AddDate(0, 1, 0)
+}
+
+// dateOf returns the calendar day of a time, at midnight UTC like parsed dates
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// budgetProgress computes what was spent on a budget in the period containing
+// date, and projects it linearly to the end of the period
+func (s *BudgetService) budgetProgress(ctx context.Context, budget *models.Budget, date time.Time) (*dto.BudgetResponse, error) {
+	start, end := budgetPeriod(budget.Period, date)
+	spent, err := s.db.BudgetSpending(ctx, budget, start.Format("2006-01-02"), end.Format("2006-01-02"), "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Past and future periods are projected as they are
+	projected := spent
+	today := dateOf(time.Now())
+	if !today.Before(start) && today.Before(end) {
+		elapsed := today.Sub(start).Hours()/24 + 1
+		days := end.Sub(start).Hours() / 24
+		projected = spent / elapsed * days
+	}
+
+	response := budgetToDTO(budget)
+	response.PeriodStart = start.Format("2006-01-02")
+	response.PeriodEnd = end.AddDate(0, 0, -1).Format("2006-01-02")
+	response.Spent = math.Round(spent*100) / 100
+	response.Remaining = math.Round((budget.Amount-spent)*100) / 100
+	response.Percent = math.Round(spent/budget.Amount*1000) / 10
+	response.Projected = math.Round(projected*100) / 100
+	return &response, nil
+}
+
+// checkBudgets notifies the thresholds a new receipt pushes the budgets that
+// add it up past.
+// Only receipts of the current period count, so importing old receipts does
+// not notify about periods that are over. Failures are logged.
+func (s *BudgetService) checkBudgets(ctx context.Context, receiptID string) {
+	if s.db == nil || receiptID == "" {
+		return
+	}
+
+	budgets, err := s.db.ListReceiptBudgets(ctx, receiptID)
+	if err != nil {
+		log.Warn("Failed to load budgets", "error", err)
+		return
+	}
+	if len(budgets) == 0 {
+		return
+	}
+
+	receipt, err := s.receipts.GetReceipt(ctx, receiptID)
+	if err != nil {
+		log.Warn("Failed to load receipt for budgets", "id", receiptID, "error", err)
+		return
+	}
+	bought, err := time.Parse("2006-01-02", receipt.BoughtDate)
+	if err != nil {
+		log.Warn("Invalid receipt date for budgets", "id", receiptID, "date", receipt.BoughtDate)
+		return
+	}
+
+	now := time.Now()
+	for i := range budgets {
+		budget := &budgets[i]
+		start, end := budgetPeriod(budget.Period, now)
+		if bought.Before(start) || !bought.Before(end) {
+			continue
+		}
+
+		if err := s.checkBudget(ctx, budget, receiptID, start, end); err != nil {
+			log.Warn("Failed to check budget", "budget", budget.Name, "receipt", receiptID, "error", err)
+		}
+	}
+}
+
+// checkBudget notifies the thresholds of a budget crossed by a receipt,
+// comparing what was spent in the period with and without it
+func (s *BudgetService) checkBudget(ctx context.Context, budget *models.Budget, receiptID string, start, end time.Time) error {
+	from, to := start.Format("2006-01-02"), end.Format("2006-01-02")
+	before, err := s.db.BudgetSpending(ctx, budget, from, to, receiptID)
+	if err != nil {
+		return err
+	}
+	after, err := s.db.BudgetSpending(ctx, budget, from, to, "")
+	if err != nil {
+		return err
+	}
+
+	for _, threshold := range budgetThresholds {
+		limit := budget.Amount * float64(threshold) / 100
+		if before >= limit || after < limit {
+			continue
+		}
+
+		recorded, err := s.db.RecordBudgetAlert(ctx, budget.ID, from, threshold, receiptID, after)
+		if err != nil {
+			return err
+		}
+		if !recorded {
+			continue
+		}
+
+		s.notifyBudget(ctx, budget, threshold, receiptID, start)
+	}
+	return nil
+}
+
+// notifyBudget notifies that a budget crossed a threshold
+func (s *BudgetService) notifyBudget(ctx context.Context, budget *models.Budget, threshold int, receiptID string, start time.Time) {
+	progress, err := s.budgetProgress(ctx, budget, start)
+	if err != nil {
+		log.Warn("Failed to compute budget progress", "budget", budget.Name, "error", err)
+		return
+	}
+
+	event, subject := models.EventBudgetWarning, fmt.Sprintf("Budget %q at %.0f%%", budget.Name, progress.Percent)
+	if threshold >= 100 {
+		event, subject = models.EventBudgetExceeded, fmt.Sprintf("Budget %q exceeded", budget.Name)
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "%.2f of the %.2f %s budget %q were spent from %s to %s (%.0f%%).\n",
+		progress.Spent, budget.Amount, budget.Period, budget.Name, progress.PeriodStart, progress.PeriodEnd, progress.Percent)
+	fmt.Fprintf(&message, "At this pace, %.2f will be spent by the end of the period.\n", progress.Projected)
+
+	notification := notify.Notification{
+		Event:   event,
+		Subject: subject,
+		Message: message.String(),
+		Data:    dto.BudgetAlert{Budget: *progress, Threshold: threshold, ReceiptID: receiptID},
+		Time:    time.Now(),
+	}
+	emitEvent(ctx, s.webhooks, event, notification.Data)
+	if err := s.notifier.Notify(ctx, notification); err != nil {
+		log.Warn("Failed to notify budget alert", "budget", budget.Name, "threshold", threshold, "error", err)
+		return
+	}
+	log.Info("Budget alert notified", "budget", budget.Name, "threshold", threshold, "spent", progress.Spent)
+}
+
+// budgetToDTO converts a budget to its response, without progress
+func budgetToDTO(budget *models.Budget) dto.BudgetResponse {
+	return dto.BudgetResponse{
+		ID:           budget.ID,
+		Name:         budget.Name,
+		Period:       budget.Period,
+		Amount:       budget.Amount,
+		CategoryID:   budget.CategoryID,
+		CategoryPath: budget.CategoryPath,
+		Store:        budget.StoreName,
+		Tag:          budget.Tag,
+		Owner:        budget.Owner,
+		HouseholdID:  budget.HouseholdID,
+		CreatedAt:    budget.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:    budget.UpdatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/notify"
+)
+
+func TestBudgetPeriod(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+
+	tests := []struct {
+		name      string
+		period    string
+		date      time.Time
+		wantStart string
+		wantEnd   string
+	}{
+		{"monthly", models.BudgetPeriodMonthly, time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC), "2024-03-01", "2024-04-01"},
+		{"monthly, last day", models.BudgetPeriodMonthly, time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), "2024-01-01", "2024-02-01"},
+		{"monthly, December", models.BudgetPeriodMonthly, time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), "2024-12-01", "2025-01-01"},
+		// The day is the local one, even if it is another one in UTC
+		{"monthly, local midnight", models.BudgetPeriodMonthly, time.Date(2024, 4, 1, 0, 30, 0, 0, cet), "2024-04-01", "2024-05-01"},
+		{"weekly, from Monday", models.BudgetPeriodWeekly, time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), "2024-03-11", "2024-03-18"},
+		{"weekly, Sunday", models.BudgetPeriodWeekly, time.Date(2024, 3, 17, 23, 59, 0, 0, time.UTC), "2024-03-11", "2024-03-18"},
+		{"weekly, across months", models.BudgetPeriodWeekly, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "2024-02-26", "2024-03-04"},
+	}
+
+	for _, tt := range tests {
+		start, end := budgetPeriod(tt.period, tt.date)
+		if start.Format("2006-01-02") != tt.wantStart || end.Format("2006-01-02") != tt.wantEnd {
+			t.Errorf("%s: budgetPeriod = %s, %s, want %s, %s", tt.name, start.Format("2006-01-02"), end.Format("2006-01-02"), tt.wantStart, tt.wantEnd)
+		}
+	}
+}
+
+// budgetRepository adds up the receipts of each period and records alerts in
+// memory
+type budgetRepository struct {
+	database.BudgetRepository
+
+	spent  map[string]map[string]float64 // Amount of each receipt, by period start
+	alerts map[string]bool               // Period start and threshold of the recorded alerts
+}
+
+func (r *budgetRepository) BudgetSpending(ctx context.Context, budget *models.Budget, start, end, excludeID string) (float64, error) {
+	sum := 0.0
+	for id, amount := range r.spent[start] {
+		if id != excludeID {
+			sum += amount
+		}
+	}
+	return sum, nil
+}
+
+func (r *budgetRepository) RecordBudgetAlert(ctx context.Context, budgetID, periodStart string, threshold int, receiptID string, spent float64) (bool, error) {
+	key := fmt.Sprintf("%s/%d", periodStart, threshold)
+	if r.alerts[key] {
+		return false, nil
+	}
+	r.alerts[key] = true
+	return true, nil
+}
+
+// recordingNotifier keeps the events of the notifications it is sent
+type recordingNotifier struct {
+	events []string
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, notification notify.Notification) error {
+	n.events = append(n.events, notification.Event)
+	return nil
+}
+
+func TestCheckBudget(t *testing.T) {
+	repository := &budgetRepository{spent: map[string]map[string]float64{}, alerts: map[string]bool{}}
+	notifier := &recordingNotifier{}
+	service := &BudgetService{db: repository, notifier: notify.Multi{notifier}}
+	budget := &models.Budget{ID: "groceries", Name: "Groceries", Period: models.BudgetPeriodMonthly, Amount: 100}
+
+	// add saves a receipt in the period of a date and checks the budget
+	add := func(date time.Time, receiptID string, amount float64) []string {
+		t.Helper()
+		start, end := budgetPeriod(budget.Period, date)
+		from := start.Format("2006-01-02")
+		if repository.spent[from] == nil {
+			repository.spent[from] = map[string]float64{}
+		}
+		repository.spent[from][receiptID] = amount
+
+		notifier.events = nil
+		if err := service.checkBudget(context.Background(), budget, receiptID, start, end); err != nil {
+			t.Fatalf("checkBudget failed: %v", err)
+		}
+		return notifier.events
+	}
+
+	march := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	steps := []struct {
+		receipt string
+		amount  float64
+		want    []string
+	}{
+		{"r1", 50, nil},
+		{"r2", 30, []string{models.EventBudgetWarning}}, // 80, exactly at the threshold
+		{"r3", 10, nil},
+		{"r4", 15, []string{models.EventBudgetExceeded}},
+	}
+	for _, step := range steps {
+		if got := add(march, step.receipt, step.amount); !slices.Equal(got, step.want) {
+			t.Errorf("%s: notified %v, want %v", step.receipt, got, step.want)
+		}
+	}
+
+	// Crossing the thresholds again in the same period, once receipts are
+	// deleted, is not notified again
+	delete(repository.spent["2024-03-01"], "r3")
+	delete(repository.spent["2024-03-01"], "r4")
+	delete(repository.spent["2024-03-01"], "r2")
+	if got := add(march, "r5", 60); len(got) != 0 {
+		t.Errorf("crossing again notified %v", got)
+	}
+
+	// A receipt crossing both thresholds of a new period notifies both
+	april := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	if got := add(april, "r6", 120); !slices.Equal(got, []string{models.EventBudgetWarning, models.EventBudgetExceeded}) {
+		t.Errorf("new period notified %v, want a warning and an exceeded alert", got)
+	}
+}
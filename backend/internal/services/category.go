@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/google/uuid"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/ai"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// AI classification sends products in batches of aiClassifyBatch, and looks
+// at no more than aiClassifyLimit products per recategorisation
+const (
+	aiClassifyBatch = 100
+	aiClassifyLimit = 500
+)
+
+// ListCategories retrieves the category taxonomy, ordered by path
+func (s *ReceiptService) ListCategories(ctx context.Context) ([]dto.CategoryResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	categories, err := s.db.ListCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.CategoryResponse, len(categories))
+	for i := range categories {
+		response[i] = categoryToDTO(&categories[i])
+	}
+	return response, nil
+}
+
+// CreateCategory adds a category to the taxonomy
+func (s *ReceiptService) CreateCategory(ctx context.Context, req dto.CategoryRequest) (*dto.CategoryResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	category, err := categoryFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.db.CreateCategory(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Category created", "id", id, "name", category.Name)
+
+	return s.getCategory(ctx, id)
+}
+
+// UpdateCategory renames a category and moves it under another parent
+func (s *ReceiptService) UpdateCategory(ctx context.Context, id string, req dto.CategoryRequest) (*dto.CategoryResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	category, err := categoryFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	category.ID = id
+
+	if err := s.db.UpdateCategory(ctx, category); err != nil {
+		return nil, err
+	}
+
+	return s.getCategory(ctx, id)
+}
+
+// DeleteCategory deletes a category with its subcategories and rules
+func (s *ReceiptService) DeleteCategory(ctx context.Context, id string) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+
+	return s.db.DeleteCategory(ctx, id)
+}
+
+// getCategory retrieves a category as a response
+func (s *ReceiptService) getCategory(ctx context.Context, id string) (*dto.CategoryResponse, error) {
+	category, err := s.db.GetCategory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	response := categoryToDTO(category)
+	return &response, nil
+}
+
+// categoryFromRequest validates a category request
+func categoryFromRequest(req dto.CategoryRequest) (*models.Category, error) {
+	name := strings.Join(strings.Fields(req.Name), " ")
+
+	issues := []string{}
+	switch {
+	case name == "":
+		issues = append(issues, "the category name is missing")
+	case strings.Contains(name, "/"):
+		issues = append(issues, "the category name cannot contain \"/\", which separates the levels of a path")
+	case len(name) > 100:
+		issues = append(issues, "the category name is longer than 100 characters")
+	}
+	if req.ParentID != "" && uuid.Validate(req.ParentID) != nil {
+		issues = append(issues, fmt.Sprintf("invalid parent ID %q", req.ParentID))
+	}
+	if len(issues) > 0 {
+		return nil, &ValidationError{Issues: issues}
+	}
+
+	return &models.Category{Name: name, ParentID: req.ParentID}, nil
+}
+
+// ListCategoryRules retrieves every category rule, highest priority first
+func (s *ReceiptService) ListCategoryRules(ctx context.Context) ([]dto.CategoryRuleResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rules, err := s.db.ListCategoryRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.CategoryRuleResponse, len(rules))
+	for i := range rules {
+		response[i] = categoryRuleToDTO(&rules[i])
+	}
+	return response, nil
+}
+
+// CreateCategoryRule adds a category rule. It applies to products saved from
+// then on; existing ones change when they are recategorised.
+func (s *ReceiptService) CreateCategoryRule(ctx context.Context, req dto.CategoryRuleRequest) (*dto.CategoryRuleResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rule := &models.CategoryRule{
+		CategoryID: req.CategoryID,
+		Pattern:    strings.TrimSpace(req.Pattern),
+		StoreName:  normalizeName(req.StoreName),
+		ProductID:  req.ProductID,
+		Priority:   req.Priority,
+	}
+
+	issues := []string{}
+	if uuid.Validate(rule.CategoryID) != nil {
+		issues = append(issues, "a valid category ID is required")
+	}
+	switch {
+	case rule.Pattern == "" && rule.ProductID == "":
+		issues = append(issues, "the rule needs a pattern or a product ID")
+	case rule.Pattern != "" && rule.ProductID != "":
+		issues = append(issues, "the rule has both a pattern and a product ID")
+	case rule.ProductID != "" && uuid.Validate(rule.ProductID) != nil:
+		issues = append(issues, fmt.Sprintf("invalid product ID %q", rule.ProductID))
+	case rule.ProductID != "" && rule.StoreName != "":
+		issues = append(issues, "a product rule is already limited to the product's store")
+	}
+	if len(issues) > 0 {
+		return nil, &ValidationError{Issues: issues}
+	}
+
+	id, err := s.db.CreateCategoryRule(ctx, rule)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Category rule created", "id", id, "category", rule.CategoryID, "pattern", rule.Pattern, "product", rule.ProductID)
+
+	rule.ID = id
+	rule.CreatedAt = time.Now()
+	response := categoryRuleToDTO(rule)
+	return &response, nil
+}
+
+// DeleteCategoryRule deletes a category rule
+func (s *ReceiptService) DeleteCategoryRule(ctx context.Context, id string) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+
+	return s.db.DeleteCategoryRule(ctx, id)
+}
+
+// Recategorize applies the category rules again to the products of a store
+// (or all of them), then optionally asks the AI for the products no rule
+// matches. Manually categorised products are never changed.
+func (s *ReceiptService) Recategorize(ctx context.Context, req dto.RecategorizeRequest) (*dto.RecategorizeResult, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+	if req.AI && s.aiService == nil {
+		return nil, ErrNoExtractor
+	}
+
+	scope := database.CategoryScope{
+		StoreName:         normalizeName(req.StoreName),
+		OnlyUncategorized: req.OnlyUncategorized,
+	}
+
+	result := &dto.RecategorizeResult{}
+	var err error
+	if result.ByRules, err = s.db.ApplyCategoryRules(ctx, scope); err != nil {
+		return nil, err
+	}
+
+	if req.AI {
+		if result.ByAI, result.Unclassified, err = s.classifyUncategorized(ctx, scope); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Info("Products recategorised", "store", scope.StoreName, "rules", result.ByRules, "ai", result.ByAI, "unclassified", result.Unclassified)
+	return result, nil
+}
+
+// classifyUncategorized asks the AI for the category of the uncategorised
+// products in scope. Returns how many got one and how many the AI could not place.
+func (s *ReceiptService) classifyUncategorized(ctx context.Context, scope database.CategoryScope) (int64, int, error) {
+	categories, err := s.db.ListCategories(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(categories) == 0 {
+		return 0, 0, &ValidationError{Issues: []string{"there are no categories to classify products into"}}
+	}
+
+	paths := make([]string, len(categories))
+	byPath := make(map[string]string, len(categories))
+	for i, category := range categories {
+		paths[i] = category.Path
+		byPath[category.Path] = category.ID
+	}
+
+	products, err := s.db.ListUncategorizedProducts(ctx, scope, aiClassifyLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	suggested := map[string]string{}
+	for start := 0; start < len(products); start += aiClassifyBatch {
+		batch := products[start:min(start+aiClassifyBatch, len(products))]
+
+		toClassify := make([]ai.ProductToClassify, len(batch))
+		for i, product := range batch {
+			toClassify[i] = ai.ProductToClassify{Name: product.Name, Store: product.StoreName}
+		}
+
+		classified, err := s.aiService.ClassifyProducts(ctx, toClassify, paths)
+		if err != nil {
+			return 0, 0, err
+		}
+		for i, path := range classified {
+			suggested[batch[i].ID] = byPath[path]
+		}
+	}
+
+	categorized, err := s.db.SetSuggestedCategories(ctx, suggested)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return categorized, len(products) - len(suggested), nil
+}
+
+// SetProductCategory manually sets the category of products, which rules and
+// the AI never change. An empty category ID removes the manual category.
+func (s *ReceiptService) SetProductCategory(ctx context.Context, req dto.ProductCategoryRequest) (*dto.ProductCategoryResult, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	issues := []string{}
+	if len(req.ProductIDs) == 0 {
+		issues = append(issues, "no product IDs given")
+	}
+	for _, id := range req.ProductIDs {
+		if uuid.Validate(id) != nil {
+			issues = append(issues, fmt.Sprintf("invalid product ID %q", id))
+		}
+	}
+	if req.CategoryID != "" && uuid.Validate(req.CategoryID) != nil {
+		issues = append(issues, fmt.Sprintf("invalid category ID %q", req.CategoryID))
+	}
+	if len(issues) > 0 {
+		return nil, &ValidationError{Issues: issues}
+	}
+
+	updated, err := s.db.SetProductCategories(ctx, req.ProductIDs, req.CategoryID)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Product categories set", "products", updated, "category", req.CategoryID)
+
+	return &dto.ProductCategoryResult{Updated: updated}, nil
+}
+
+// CategorySpending sums what was spent per category on the receipts matching a filter
+func (s *ReceiptService) CategorySpending(ctx context.Context, filter database.ReceiptFilter) ([]dto.CategorySpendingResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	spending, err := s.db.CategorySpending(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.CategorySpendingResponse, len(spending))
+	for i, category := range spending {
+		response[i] = dto.CategorySpendingResponse{
+			CategoryID: category.CategoryID,
+			Name:       category.Name,
+			ParentID:   category.ParentID,
+			Path:       category.Path,
+			Own:        category.Own,
+			Total:      category.Total,
+			ItemCount:  category.ItemCount,
+		}
+	}
+	return response, nil
+}
+
+// categoryToDTO converts a category to its response
+func categoryToDTO(category *models.Category) dto.CategoryResponse {
+	return dto.CategoryResponse{
+		ID:       category.ID,
+		Name:     category.Name,
+		ParentID: category.ParentID,
+		Path:     category.Path,
+	}
+}
+
+// categoryRuleToDTO converts a category rule to its response
+func categoryRuleToDTO(rule *models.CategoryRule) dto.CategoryRuleResponse {
+	return dto.CategoryRuleResponse{
+		ID:         rule.ID,
+		CategoryID: rule.CategoryID,
+		Pattern:    rule.Pattern,
+		StoreName:  rule.StoreName,
+		ProductID:  rule.ProductID,
+		Priority:   rule.Priority,
+		CreatedAt:  rule.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/ai"
+	"google.golang.org/genai"
+)
+
+// categoryRepository has categories and uncategorised products, and records
+// the suggested categories
+type categoryRepository struct {
+	database.ReceiptRepository
+
+	categories []models.Category
+	products   []models.Product
+	suggested  map[string]string
+}
+
+func (r *categoryRepository) ListCategories(ctx context.Context) ([]models.Category, error) {
+	return r.categories, nil
+}
+
+func (r *categoryRepository) ListUncategorizedProducts(ctx context.Context, scope database.CategoryScope, limit int) ([]models.Product, error) {
+	return r.products[:min(limit, len(r.products))], nil
+}
+
+func (r *categoryRepository) SetSuggestedCategories(ctx context.Context, categories map[string]string) (int64, error) {
+	r.suggested = categories
+	return int64(len(categories)), nil
+}
+
+// classifierClient answers classification prompts with the category of each
+// product by name, as the model would
+type classifierClient struct {
+	answers map[string]string // Category answered for each product name
+	batches []int             // Products in each prompt
+}
+
+var productLine = regexp.MustCompile(`(?m)^(\d+)\. (.+) \(.*\)$`)
+
+func (c *classifierClient) GenerateContent(ctx context.Context, model string, contents []*genai.Content, cfg *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	type answer struct {
+		Index    int    `json:"index"`
+		Category string `json:"category"`
+	}
+
+	lines := productLine.FindAllStringSubmatch(contents[0].Parts[0].Text, -1)
+	c.batches = append(c.batches, len(lines))
+
+	answers := []answer{}
+	for _, line := range lines {
+		var index int
+		fmt.Sscan(line[1], &index)
+		if category, ok := c.answers[line[2]]; ok {
+			answers = append(answers, answer{Index: index, Category: category})
+		}
+	}
+	// An index out of the prompt is ignored
+	answers = append(answers, answer{Index: len(lines), Category: "Groceries"})
+
+	text, err := json.Marshal(answers)
+	if err != nil {
+		return nil, err
+	}
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{Content: genai.NewContentFromText(string(text), genai.RoleModel)}},
+	}, nil
+}
+
+func TestClassifyUncategorized(t *testing.T) {
+	repository := &categoryRepository{
+		categories: []models.Category{
+			{ID: "c-groceries", Name: "Groceries", Path: "Groceries"},
+			{ID: "c-dairy", Name: "Dairy", ParentID: "c-groceries", Path: "Groceries/Dairy"},
+			{ID: "c-cleaning", Name: "Cleaning", Path: "Household/Cleaning"},
+		},
+	}
+
+	// More products than fit in a prompt, so they are classified in batches
+	client := &classifierClient{answers: map[string]string{}}
+	want := map[string]string{}
+	for i := range aiClassifyBatch + 20 {
+		product := models.Product{ID: fmt.Sprintf("p%d", i), Name: fmt.Sprintf("PRODUCT %d", i), StoreName: "LIDL"}
+		repository.products = append(repository.products, product)
+
+		switch i % 4 {
+		case 0:
+			client.answers[product.Name] = "Groceries/Dairy"
+			want[product.ID] = "c-dairy"
+		case 1:
+			// The category is matched whatever its case and spaces
+			client.answers[product.Name] = " household/cleaning "
+			want[product.ID] = "c-cleaning"
+		case 2:
+			// Categories that are not in the list are left out
+			client.answers[product.Name] = "Groceries/Bakery"
+		}
+	}
+
+	service := &ReceiptService{db: repository, aiService: ai.NewGeminiServiceWithClient(client, replayConfig())}
+	categorized, unclassified, err := service.classifyUncategorized(context.Background(), database.CategoryScope{})
+	if err != nil {
+		t.Fatalf("classifyUncategorized failed: %v", err)
+	}
+
+	if len(client.batches) != 2 || client.batches[0] != aiClassifyBatch || client.batches[1] != 20 {
+		t.Errorf("batches = %v, want %d then 20 products", client.batches, aiClassifyBatch)
+	}
+	if !maps.Equal(repository.suggested, want) {
+		t.Errorf("suggested %d categories, want %d: %v", len(repository.suggested), len(want), repository.suggested)
+	}
+	if categorized != int64(len(want)) || unclassified != len(repository.products)-len(want) {
+		t.Errorf("categorized %d and left %d, want %d and %d", categorized, unclassified, len(want), len(repository.products)-len(want))
+	}
+}
+
+func TestClassifyUncategorizedWithoutCategories(t *testing.T) {
+	client := &classifierClient{}
+	service := &ReceiptService{
+		db:        &categoryRepository{products: []models.Product{{ID: "p1", Name: "LECHE"}}},
+		aiService: ai.NewGeminiServiceWithClient(client, replayConfig()),
+	}
+
+	_, _, err := service.classifyUncategorized(context.Background(), database.CategoryScope{})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Errorf("classifyUncategorized = %v, want a validation error", err)
+	}
+	if len(client.batches) != 0 {
+		t.Error("the model was asked without categories")
+	}
+}
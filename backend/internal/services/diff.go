@@ -0,0 +1,85 @@
+package services
+
+import (
+	"math"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// diffReceipts computes the structured difference between a stored receipt and a
+// new extraction of it. Lines are matched by product name; when a name appears
+// several times, occurrences are paired in order.
+func diffReceipts(before, after *models.Receipt) models.ReceiptDiff {
+	diff := models.ReceiptDiff{
+		Added:   []models.Item{},
+		Removed: []models.Item{},
+		Changed: []models.ItemChange{},
+	}
+
+	remaining := make(map[string][]models.Item)
+	for _, item := range before.Items {
+		remaining[item.Name] = append(remaining[item.Name], item)
+	}
+
+	for _, item := range after.Items {
+		candidates := remaining[item.Name]
+		if len(candidates) == 0 {
+			diff.Added = append(diff.Added, item)
+			continue
+		}
+
+		previous := candidates[0]
+		remaining[item.Name] = candidates[1:]
+		if !amountsEqual(previous.Quantity, item.Quantity) || !amountsEqual(previous.Price, item.Price) {
+			diff.Changed = append(diff.Changed, models.ItemChange{
+				Name:   item.Name,
+				Before: previous,
+				After:  item,
+			})
+		}
+	}
+
+	// Keep removed lines in their original order
+	for _, item := range before.Items {
+		candidates := remaining[item.Name]
+		if len(candidates) > 0 && candidates[0].ID == item.ID {
+			diff.Removed = append(diff.Removed, item)
+			remaining[item.Name] = candidates[1:]
+		}
+	}
+
+	if before.StoreName != after.StoreName {
+		diff.StoreName = &models.ValueChange[string]{Before: before.StoreName, After: after.StoreName}
+	}
+	if before.BoughtDate != after.BoughtDate {
+		diff.BoughtDate = &models.ValueChange[string]{Before: before.BoughtDate, After: after.BoughtDate}
+	}
+	if !amountsEqual(before.Discounts, after.Discounts) {
+		diff.Discounts = &models.ValueChange[float64]{Before: before.Discounts, After: after.Discounts}
+	}
+
+	subtotalBefore, subtotalAfter := receiptSubtotal(before), receiptSubtotal(after)
+	if !amountsEqual(subtotalBefore, subtotalAfter) {
+		diff.Subtotal = &models.ValueChange[float64]{Before: subtotalBefore, After: subtotalAfter}
+	}
+	totalBefore, totalAfter := subtotalBefore-before.Discounts, subtotalAfter-after.Discounts
+	if !amountsEqual(totalBefore, totalAfter) {
+		diff.Total = &models.ValueChange[float64]{Before: totalBefore, After: totalAfter}
+	}
+
+	return diff
+}
+
+// receiptSubtotal sums quantity * price over all items
+func receiptSubtotal(receipt *models.Receipt) float64 {
+	subtotal := 0.0
+	for _, item := range receipt.Items {
+		subtotal += item.Quantity * item.Price
+	}
+	return subtotal
+}
+
+// amountsEqual compares two amounts at cent (and gram) precision
+func amountsEqual(a, b float64) bool {
+	return math.Abs(a-b) < 0.0005
+}
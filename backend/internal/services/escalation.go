@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/ai"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// escalationStep is one rung of the escalation ladder
+type escalationStep struct {
+	strategy      string
+	escalate      bool
+	genericPrompt bool
+	followUp      bool
+}
+
+// escalationLadder lists the escalated configurations tried, in order, when an
+// extraction fails validation: first a cheap follow-up turn asking the same model
+// to fix its answer, then the stronger model, then the stronger model with the
+// generic prompt in case the store-specific one is what misleads it.
+var escalationLadder = []escalationStep{
+	{strategy: "fix", followUp: true},
+	{strategy: "escalated-fix", escalate: true, followUp: true},
+	{strategy: "escalated-generic", escalate: true, genericPrompt: true},
+}
+
+// validateReceipt returns the inconsistencies of an extracted receipt, if any
+func validateReceipt(receipt *models.Receipt) []string {
+	issues := []string{}
+
+	if len(receipt.Items) == 0 {
+		issues = append(issues, "no items were extracted")
+	}
+
+	if receipt.BoughtDate == "" {
+		issues = append(issues, "the purchase date is missing")
+	}
+
+	if mismatch := totalMismatch(receipt); mismatch > totalTolerance(receipt) {
+		computed := receiptSubtotal(receipt) - receipt.Discounts
+		issues = append(issues, fmt.Sprintf(
+			"the items minus discounts add up to %.2f but the printed total is %.2f",
+			computed, *receipt.Total))
+	}
+
+	return issues
+}
+
+// totalMismatch returns how far the items are from the printed total (0 if unknown)
+func totalMismatch(receipt *models.Receipt) float64 {
+	if receipt.Total == nil {
+		return 0
+	}
+	return math.Abs(receiptSubtotal(receipt) - receipt.Discounts - *receipt.Total)
+}
+
+// totalTolerance allows for the rounding of weighed items, one cent per line
+func totalTolerance(receipt *models.Receipt) float64 {
+	return math.Max(0.05, 0.01*float64(len(receipt.Items)))
+}
+
+// extractionAttempt is a single extraction and its validation outcome
+type extractionAttempt struct {
+	receipt    *models.Receipt
+	extraction *models.Extraction
+	issues     []string
+}
+
+// betterThan reports whether an attempt reconciles better than another
+func (a *extractionAttempt) betterThan(other *extractionAttempt) bool {
+	if other == nil || other.receipt == nil {
+		return a.receipt != nil
+	}
+	if a.receipt == nil {
+		return false
+	}
+	if len(a.issues) != len(other.issues) {
+		return len(a.issues) < len(other.issues)
+	}
+	return totalMismatch(a.receipt) < totalMismatch(other.receipt)
+}
+
+// extractWithEscalation extracts a receipt and, while the result fails
+// validation and the escalation budget allows, re-runs the extraction with
+// escalated configurations. It returns the attempt that reconciles best along
+// with the provenance of every attempt; the kept one is marked as selected.
+// Attempts are numbered from first, after any attempt already made on the
+// file. Each stage is passed to report as it happens.
+func (s *ReceiptService) extractWithEscalation(ctx context.Context, imagePath string, first int, report func(dto.ProcessingProgress)) (*models.Receipt, []*models.Extraction, error) {
+	receipt, extraction, err := s.aiService.ExtractReceipt(ctx, imagePath, ai.ExtractOptions{
+		OnStoreIdentified: func(storeName string) {
+			report(dto.ProcessingProgress{Stage: dto.StageStoreIdentified, Store: storeName})
+			report(dto.ProcessingProgress{Stage: dto.StageExtractionStarted, Store: storeName, Attempt: first})
+		},
+	})
+	if extraction != nil {
+		extraction.Attempt = first
+		extraction.Strategy = "initial"
+	}
+	if err != nil {
+		return nil, compactExtractions(extraction), err
+	}
+
+	best := &extractionAttempt{receipt: receipt, extraction: extraction, issues: validateReceipt(receipt)}
+	extraction.Issues = best.issues
+	reportExtraction(report, first, receipt, best.issues)
+	extractions := []*models.Extraction{extraction}
+	last := best
+
+	budget := min(s.escalationBudget, len(escalationLadder))
+	for i := 0; i < budget && len(best.issues) > 0; i++ {
+		step := escalationLadder[i]
+		number := first + i + 1
+		log.Warn("Extraction failed validation, escalating",
+			"issues", best.issues,
+			"attempt", number,
+			"strategy", step.strategy)
+
+		opts := ai.ExtractOptions{
+			StoreName:     extraction.StoreAnswer,
+			Escalate:      step.escalate,
+			GenericPrompt: step.genericPrompt,
+		}
+		if step.followUp && last.extraction.RawResponse != "" {
+			opts.PreviousResponse = last.extraction.RawResponse
+			opts.Issues = last.issues
+		}
+
+		report(dto.ProcessingProgress{Stage: dto.StageExtractionStarted, Store: extraction.StoreAnswer, Attempt: number})
+		receipt, attemptExtraction, err := s.aiService.ExtractReceipt(ctx, imagePath, opts)
+		if attemptExtraction == nil {
+			attemptExtraction = &models.Extraction{StoreAnswer: extraction.StoreAnswer}
+		}
+		attemptExtraction.Attempt = number
+		attemptExtraction.Strategy = step.strategy
+		extractions = append(extractions, attemptExtraction)
+
+		if err != nil {
+			log.Warn("Escalated extraction failed", "strategy", step.strategy, "error", err)
+			attemptExtraction.Error = err.Error()
+			continue
+		}
+
+		attempt := &extractionAttempt{receipt: receipt, extraction: attemptExtraction, issues: validateReceipt(receipt)}
+		attemptExtraction.Issues = attempt.issues
+		reportExtraction(report, number, receipt, attempt.issues)
+		last = attempt
+		if attempt.betterThan(best) {
+			best = attempt
+		}
+	}
+
+	best.extraction.Selected = true
+	if len(best.issues) > 0 {
+		log.Warn("Keeping extraction with unresolved issues", "issues", best.issues, "attempt", best.extraction.Attempt)
+	}
+
+	return best.receipt, extractions, nil
+}
+
+// compactExtractions wraps a possibly nil extraction into a slice
+func compactExtractions(extraction *models.Extraction) []*models.Extraction {
+	if extraction == nil {
+		return nil
+	}
+	return []*models.Extraction{extraction}
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/config"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+func ptr(value float64) *float64 {
+	return &value
+}
+
+func TestValidateReceipt(t *testing.T) {
+	items := []models.Item{{Name: "LECHE", Quantity: 2, Price: 0.92}, {Name: "PAN", Quantity: 1, Price: 1.35}}
+
+	tests := []struct {
+		name    string
+		receipt models.Receipt
+		want    []string // Substrings of the expected issues, in order
+	}{
+		{"consistent", models.Receipt{BoughtDate: "2024-03-15", Items: items, Total: ptr(3.19)}, nil},
+		{"unknown total", models.Receipt{BoughtDate: "2024-03-15", Items: items}, nil},
+		{"discounts", models.Receipt{BoughtDate: "2024-03-15", Items: items, Discounts: 0.19, Total: ptr(3.00)}, nil},
+		{"within rounding", models.Receipt{BoughtDate: "2024-03-15", Items: items, Total: ptr(3.23)}, nil},
+		{"no items", models.Receipt{BoughtDate: "2024-03-15", Items: []models.Item{}}, []string{"no items"}},
+		{"no date", models.Receipt{Items: items, Total: ptr(3.19)}, []string{"purchase date is missing"}},
+		{"total mismatch", models.Receipt{BoughtDate: "2024-03-15", Items: items, Total: ptr(4.19)},
+			[]string{"add up to 3.19 but the printed total is 4.19"}},
+		{"everything wrong", models.Receipt{Items: []models.Item{}, Total: ptr(1)},
+			[]string{"no items", "purchase date is missing", "add up to 0.00"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := validateReceipt(&tt.receipt)
+			if len(issues) != len(tt.want) {
+				t.Fatalf("issues = %q, want %d matching %q", issues, len(tt.want), tt.want)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(issues[i], want) {
+					t.Errorf("issue %d = %q, want it to mention %q", i, issues[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestTotalTolerance(t *testing.T) {
+	// One cent per line, and never less than five
+	few := &models.Receipt{Items: make([]models.Item, 2)}
+	many := &models.Receipt{Items: make([]models.Item, 12)}
+	if got := totalTolerance(few); got != 0.05 {
+		t.Errorf("tolerance of 2 items = %v, want 0.05", got)
+	}
+	if got := totalTolerance(many); got != 0.12 {
+		t.Errorf("tolerance of 12 items = %v, want 0.12", got)
+	}
+}
+
+func TestBetterThan(t *testing.T) {
+	items := []models.Item{{Name: "LECHE", Quantity: 1, Price: 1}}
+	attempt := func(total float64, issues ...string) *extractionAttempt {
+		return &extractionAttempt{receipt: &models.Receipt{Items: items, Total: ptr(total)}, issues: issues}
+	}
+	failed := &extractionAttempt{}
+
+	tests := []struct {
+		name  string
+		a, b  *extractionAttempt
+		wantA bool
+	}{
+		{"anything beats nothing", attempt(5, "a", "b"), nil, true},
+		{"anything beats a failed call", attempt(5, "a", "b"), failed, true},
+		{"a failed call never wins", failed, attempt(1), false},
+		{"fewer issues win", attempt(9, "mismatch"), attempt(1, "no date", "mismatch"), true},
+		{"more issues lose", attempt(1, "no date", "mismatch"), attempt(9, "mismatch"), false},
+		{"closer to the total wins a tie", attempt(2, "mismatch"), attempt(5, "mismatch"), true},
+		{"further from the total loses a tie", attempt(5, "mismatch"), attempt(2, "mismatch"), false},
+		{"equal attempts keep the earlier one", attempt(1), attempt(1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.betterThan(tt.b); got != tt.wantA {
+				t.Errorf("betterThan = %v, want %v", got, tt.wantA)
+			}
+		})
+	}
+}
+
+func TestDefaultEscalationBudgetCoversLadder(t *testing.T) {
+	t.Setenv("AI_ESCALATION_BUDGET", "")
+	if budget := config.Load().AIEscalationBudget; budget < len(escalationLadder) {
+		t.Errorf("default escalation budget %d skips steps of the %d-step ladder", budget, len(escalationLadder))
+	}
+}
+
+// extractionSummary describes the extractions saved for a receipt
+func extractionSummary(extractions []*models.Extraction) (strategies []string, attempts []int, selected int) {
+	for _, extraction := range extractions {
+		strategies = append(strategies, extraction.Strategy)
+		attempts = append(attempts, extraction.Attempt)
+		if extraction.Selected {
+			selected = extraction.Attempt
+		}
+	}
+	return strategies, attempts, selected
+}
+
+func TestEscalationStopsOnceResolved(t *testing.T) {
+	service, repository := newReplayService(t, "carrefour_fix")
+
+	receipt, err := service.ProcessReceipt(context.Background(), filepath.Join(cassetteDir, "carrefour_fix.png"))
+	if err != nil {
+		t.Fatalf("ProcessReceipt failed: %v", err)
+	}
+
+	// The fix turn adds the missing item, so the stronger model is not needed
+	strategies, attempts, selected := extractionSummary(repository.extractions)
+	if !slices.Equal(strategies, []string{"initial", "fix"}) || !slices.Equal(attempts, []int{1, 2}) {
+		t.Fatalf("attempts %v %v, want initial and fix", attempts, strategies)
+	}
+	if selected != 2 {
+		t.Errorf("selected attempt %d, want 2", selected)
+	}
+	if len(repository.extractions[0].Issues) == 0 || len(repository.extractions[1].Issues) != 0 {
+		t.Errorf("issues %q then %q, want a mismatch then none", repository.extractions[0].Issues, repository.extractions[1].Issues)
+	}
+	if len(receipt.Items) != 3 || receipt.Discounts != 0.5 {
+		t.Errorf("kept %d items with %.2f of discounts, want the 3 items of the fixed answer with 0.50", len(receipt.Items), receipt.Discounts)
+	}
+}
+
+func TestEscalationKeepsBestUnresolvedAttempt(t *testing.T) {
+	service, repository := newReplayService(t, "dia_unresolved")
+
+	receipt, err := service.ProcessReceipt(context.Background(), filepath.Join(cassetteDir, "dia_unresolved.png"))
+	if err != nil {
+		t.Fatalf("ProcessReceipt failed: %v", err)
+	}
+
+	// Every step of the ladder runs, the generic prompt included
+	strategies, attempts, selected := extractionSummary(repository.extractions)
+	if !slices.Equal(strategies, []string{"initial", "fix", "escalated-fix", "escalated-generic"}) {
+		t.Fatalf("strategies %v, want the whole ladder", strategies)
+	}
+	if !slices.Equal(attempts, []int{1, 2, 3, 4}) {
+		t.Errorf("attempts %v, want 1 to 4", attempts)
+	}
+
+	// None reconciles: the one with fewest issues closest to the total is kept
+	if selected != 3 {
+		t.Errorf("selected attempt %d, want 3", selected)
+	}
+	for _, extraction := range repository.extractions {
+		if len(extraction.Issues) == 0 {
+			t.Errorf("attempt %d has no issues, want all of them unresolved", extraction.Attempt)
+		}
+	}
+	if len(receipt.Items) != 3 || receipt.BoughtDate != "2024-06-20" {
+		t.Errorf("kept %d items on %q, want the 3 items of attempt 3 on 2024-06-20", len(receipt.Items), receipt.BoughtDate)
+	}
+}
+
+func TestEscalationBudgetLimitsAttempts(t *testing.T) {
+	service, repository := newReplayService(t, "dia_unresolved")
+	service.escalationBudget = 1
+
+	if _, err := service.ProcessReceipt(context.Background(), filepath.Join(cassetteDir, "dia_unresolved.png")); err != nil {
+		t.Fatalf("ProcessReceipt failed: %v", err)
+	}
+
+	strategies, _, selected := extractionSummary(repository.extractions)
+	if !slices.Equal(strategies, []string{"initial", "fix"}) {
+		t.Errorf("strategies %v, want initial and fix only", strategies)
+	}
+	if selected != 2 {
+		t.Errorf("selected attempt %d, want 2", selected)
+	}
+}
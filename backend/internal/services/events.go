@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/events"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// ListenEvents fans out the events of every instance to the subscribers of
+// this one until ctx is cancelled
+func (s *ReceiptService) ListenEvents(ctx context.Context) error {
+	if s.events == nil {
+		return ErrNoDatabase
+	}
+
+	return s.events.Run(ctx)
+}
+
+// SubscribeEvents subscribes to the events for a user: those of their
+// receipts and of the households they are a member of now. Unsubscribe with
+// UnsubscribeEvents once done.
+func (s *ReceiptService) SubscribeEvents(ctx context.Context, user string) (*events.Subscription, error) {
+	if s.events == nil {
+		return nil, ErrNoDatabase
+	}
+
+	var households []string
+	if user != "" {
+		var err error
+		if households, err = s.db.ListMemberHouseholds(ctx, user, models.RoleViewer); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.events.Subscribe(user, households), nil
+}
+
+// UnsubscribeEvents stops sending events to a subscription
+func (s *ReceiptService) UnsubscribeEvents(subscription *events.Subscription) {
+	if s.events != nil {
+		s.events.Unsubscribe(subscription)
+	}
+}
+
+// progressReporter returns the function reporting the stages of processing a
+// file: each one is published to the clients of the owner and passed to
+// opts.Progress
+func (s *ReceiptService) progressReporter(ctx context.Context, path string, opts ProcessOptions) func(dto.ProcessingProgress) {
+	file := filepath.Base(path)
+	return func(progress dto.ProcessingProgress) {
+		progress.File = file
+		s.publishProgress(ctx, opts.Owner, progress)
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+}
+
+// publishProgress notifies a stage of processing an upload to the clients
+// of its owner, without the receipt, which they fetch once saved. Progress
+// never fails the processing: failures are logged.
+func (s *ReceiptService) publishProgress(ctx context.Context, owner string, progress dto.ProcessingProgress) {
+	if s.events == nil {
+		return
+	}
+
+	progress.Receipt = nil
+	data, err := json.Marshal(progress)
+	if err != nil {
+		log.Warn("Failed to encode processing progress", "error", err)
+		return
+	}
+
+	event := &models.Event{
+		Type:      models.EventProcessingProgress,
+		ReceiptID: progress.ReceiptID,
+		Owner:     owner,
+		Data:      data,
+	}
+	// The stage of a cancelled processing is still published
+	if err := s.events.Publish(context.WithoutCancel(ctx), event); err != nil {
+		log.Warn("Failed to publish processing progress", "file", progress.File, "stage", progress.Stage, "error", err)
+	}
+}
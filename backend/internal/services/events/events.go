@@ -0,0 +1,135 @@
+// Package events streams receipt and processing events to connected clients.
+// Events are notified through Postgres by whichever instance made the change,
+// and every instance fans them out to its own subscribers, so a client sees
+// all changes whichever instance it is connected to.
+package events
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+const (
+	// bufferSize is how many events a slow subscriber can fall behind before
+	// new ones are dropped for it
+	bufferSize = 64
+
+	// minReconnect and maxReconnect bound the wait before listening again
+	// after the connection to the database fails
+	minReconnect = time.Second
+	maxReconnect = 30 * time.Second
+)
+
+// Subscription receives the events for a user
+type Subscription struct {
+	user       string
+	households []string
+	events     chan models.Event
+}
+
+// Events returns the channel the events of the subscription arrive on
+func (s *Subscription) Events() <-chan models.Event {
+	return s.events
+}
+
+// Broker fans out the events notified by every instance to the subscribers
+// of this one
+type Broker struct {
+	db database.ReceiptRepository
+
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+}
+
+func NewBroker(db database.ReceiptRepository) *Broker {
+	return &Broker{
+		db:          db,
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// receives reports whether an event is for the subscription: events of a
+// household go to its members, other events to their owner, and events of
+// no one in particular to everyone. Subscriptions without a user, of
+// installs without API tokens, receive every event outside households.
+func (s *Subscription) receives(event models.Event) bool {
+	if event.HouseholdID != "" {
+		return slices.Contains(s.households, event.HouseholdID)
+	}
+	return s.user == "" || event.Owner == "" || event.Owner == s.user
+}
+
+// Subscribe subscribes to the events for a user who is a member of some
+// households
+func (b *Broker) Subscribe(user string, households []string) *Subscription {
+	subscription := &Subscription{user: user, households: households, events: make(chan models.Event, bufferSize)}
+
+	b.mu.Lock()
+	b.subscribers[subscription] = struct{}{}
+	b.mu.Unlock()
+
+	return subscription
+}
+
+// Unsubscribe stops sending events to a subscription
+func (b *Broker) Unsubscribe(subscription *Subscription) {
+	b.mu.Lock()
+	delete(b.subscribers, subscription)
+	b.mu.Unlock()
+}
+
+// Publish notifies an event to the subscribers of every instance
+func (b *Broker) Publish(ctx context.Context, event *models.Event) error {
+	return b.db.PublishEvent(ctx, event)
+}
+
+// Run listens for the events of every instance until ctx is cancelled,
+// listening again with backoff when the connection fails
+func (b *Broker) Run(ctx context.Context) error {
+	wait := minReconnect
+	for {
+		started := time.Now()
+		err := b.db.ListenEvents(ctx, b.dispatch)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// A connection that held for a while starts the backoff over
+		if time.Since(started) > maxReconnect {
+			wait = minReconnect
+		}
+		log.Warn("Stopped listening for events, retrying", "error", err, "in", wait)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, maxReconnect)
+	}
+}
+
+// dispatch sends an event to the subscribers it is for, without waiting for
+// any of them
+func (b *Broker) dispatch(event models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for subscription := range b.subscribers {
+		if !subscription.receives(event) {
+			continue
+		}
+
+		select {
+		case subscription.events <- event:
+		default:
+			log.Debug("Dropped event for slow subscriber", "type", event.Type, "user", subscription.user)
+		}
+	}
+}
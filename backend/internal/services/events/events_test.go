@@ -0,0 +1,78 @@
+package events
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// received drains the events a subscription has received
+func received(subscription *Subscription) []string {
+	ids := []string{}
+	for {
+		select {
+		case event := <-subscription.Events():
+			ids = append(ids, event.ReceiptID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	broker := NewBroker(nil)
+	ana := broker.Subscribe("ana", []string{"flat"})
+	bea := broker.Subscribe("bea", []string{"flat", "home"})
+	anonymous := broker.Subscribe("", nil)
+
+	for _, event := range []models.Event{
+		{Type: models.EventReceiptCreated, ReceiptID: "anas", Owner: "ana"},
+		{Type: models.EventReceiptCreated, ReceiptID: "beas", Owner: "bea"},
+		{Type: models.EventReceiptCreated, ReceiptID: "no one's"},
+		{Type: models.EventReceiptUpdated, ReceiptID: "flat's", Owner: "carlos", HouseholdID: "flat"},
+		{Type: models.EventReceiptDeleted, ReceiptID: "home's", Owner: "ana", HouseholdID: "home"},
+		{Type: models.EventProcessingProgress, ReceiptID: "ana's upload", Owner: "ana"},
+	} {
+		broker.dispatch(event)
+	}
+
+	tests := []struct {
+		name         string
+		subscription *Subscription
+		want         []string
+	}{
+		{"ana", ana, []string{"anas", "no one's", "flat's", "ana's upload"}},
+		{"bea", bea, []string{"beas", "no one's", "flat's", "home's"}},
+		{"without a user", anonymous, []string{"anas", "beas", "no one's", "ana's upload"}},
+	}
+
+	for _, tt := range tests {
+		if got := received(tt.subscription); !slices.Equal(got, tt.want) {
+			t.Errorf("%s received %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	broker := NewBroker(nil)
+	subscription := broker.Subscribe("ana", nil)
+	broker.Unsubscribe(subscription)
+
+	broker.dispatch(models.Event{Type: models.EventReceiptCreated, ReceiptID: "anas", Owner: "ana"})
+	if got := received(subscription); len(got) > 0 {
+		t.Errorf("received %q after unsubscribing", got)
+	}
+}
+
+func TestDispatchDropsForSlowSubscribers(t *testing.T) {
+	broker := NewBroker(nil)
+	subscription := broker.Subscribe("ana", nil)
+
+	for range bufferSize + 1 {
+		broker.dispatch(models.Event{Type: models.EventReceiptCreated, ReceiptID: "anas"})
+	}
+	if got := received(subscription); len(got) != bufferSize {
+		t.Errorf("received %d events, want the %d buffered", len(got), bufferSize)
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/services/export"
+)
+
+// ItemExport is an export of the items of the receipts matching a filter,
+// checked and ready to be written
+type ItemExport struct {
+	Format export.Format
+
+	db     database.ReceiptRepository
+	filter database.ReceiptFilter
+	opts   export.Options
+}
+
+// NewItemExport prepares an export in a format (csv, jsonl, xlsx, ledger,
+// hledger, beancount or json). Accounting formats post each item on its own line,
+// or with groupPostings one line per expense account.
+func (s *ReceiptService) NewItemExport(filter database.ReceiptFilter, format string, groupPostings bool) (*ItemExport, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	exportFormat, err := export.LookupFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ItemExport{
+		Format: exportFormat,
+		db:     s.db,
+		filter: filter,
+		opts:   export.Options{Accounts: s.accounts, GroupPostings: groupPostings},
+	}, nil
+}
+
+// WriteTo writes one row per item as it is read from the database
+func (e *ItemExport) WriteTo(ctx context.Context, w io.Writer) error {
+	writer, err := e.Format.NewWriter(w, e.opts)
+	if err != nil {
+		return fmt.Errorf("failed to start export: %w", err)
+	}
+
+	err = e.db.ExportItems(ctx, e.filter, func(row database.ExportRow) error {
+		return writer.WriteRow(export.Row{
+			ReceiptID:  row.ReceiptID,
+			StoreName:  row.StoreName,
+			BoughtDate: row.BoughtDate,
+			Product:    row.Product,
+			Quantity:   row.Quantity,
+			UnitPrice:  row.UnitPrice,
+			LineTotal:  math.Round(row.Quantity*row.UnitPrice*100) / 100,
+			Discounts:  row.Discounts,
+			Category:   row.Category,
+			Tags:       mergeTags(row.ItemTags, row.ReceiptTags),
+			TaxRate:    row.TaxRate,
+
+			Owner:          row.Owner,
+			DocumentNumber: row.DocumentNumber,
+			StoreTaxID:     row.StoreTaxID,
+			ReceiptTags:    row.ReceiptTags,
+			ReceiptNotes:   row.ReceiptNotes,
+			ItemTags:       row.ItemTags,
+			ItemNotes:      row.ItemNotes,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
@@ -0,0 +1,214 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Accounts maps receipts to the accounts of the accounting formats
+type Accounts struct {
+	Payment   string // Where receipts are paid from, e.g. Assets:Checking
+	Default   string // Expense account of items with no mapping
+	Discount  string // Account of the receipt discounts, posted as a negative amount
+	Commodity string // e.g. EUR
+
+	Stores     map[string]string // Lowercased store name => expense account
+	Categories map[string]string // Lowercased category path => expense account
+}
+
+// expense returns the expense account of an item: that of its category or
+// its closest mapped ancestor (e.g. "groceries" for "Groceries/Dairy"), else
+// that of its store, else the default one
+func (a Accounts) expense(row Row) string {
+	for category := strings.ToLower(row.Category); category != ""; {
+		if account := a.Categories[category]; account != "" {
+			return account
+		}
+		i := strings.LastIndex(category, "/")
+		if i < 0 {
+			break
+		}
+		category = category[:i]
+	}
+	if account := a.Stores[strings.ToLower(row.StoreName)]; account != "" {
+		return account
+	}
+	return a.Default
+}
+
+// dialect is the syntax of a plain-text accounting format
+type dialect int
+
+const (
+	dialectLedger dialect = iota
+	dialectHledger
+	dialectBeancount
+)
+
+// posting is a line of a transaction, with its amount in cents
+type posting struct {
+	account string
+	cents   int64
+	comment string
+}
+
+// accountingWriter writes each receipt as a balanced transaction: its items
+// (or their sum per expense account), its discounts and the payment. Rows
+// come ordered by receipt, so only the receipt being written is held in
+// memory. Each transaction carries the receipt ID as metadata, so importers
+// can tell an already imported receipt.
+type accountingWriter struct {
+	out     *bufio.Writer
+	dialect dialect
+	opts    Options
+
+	rows []Row // Items of the receipt being written
+}
+
+func newLedgerWriter(w io.Writer, opts Options) (Writer, error) {
+	return &accountingWriter{out: bufio.NewWriter(w), dialect: dialectLedger, opts: opts}, nil
+}
+
+func newHledgerWriter(w io.Writer, opts Options) (Writer, error) {
+	return &accountingWriter{out: bufio.NewWriter(w), dialect: dialectHledger, opts: opts}, nil
+}
+
+func newBeancountWriter(w io.Writer, opts Options) (Writer, error) {
+	return &accountingWriter{out: bufio.NewWriter(w), dialect: dialectBeancount, opts: opts}, nil
+}
+
+func (a *accountingWriter) WriteRow(row Row) error {
+	if len(a.rows) > 0 && a.rows[0].ReceiptID != row.ReceiptID {
+		if err := a.writeTransaction(); err != nil {
+			return err
+		}
+	}
+	a.rows = append(a.rows, row)
+	return nil
+}
+
+func (a *accountingWriter) Close() error {
+	if len(a.rows) > 0 {
+		if err := a.writeTransaction(); err != nil {
+			return err
+		}
+	}
+	return a.out.Flush()
+}
+
+// writeTransaction writes the transaction of the buffered receipt
+func (a *accountingWriter) writeTransaction() error {
+	receipt := a.rows[0]
+	postings := a.itemPostings()
+
+	total := int64(0)
+	for _, p := range postings {
+		total += p.cents
+	}
+	if discounts := toCents(receipt.Discounts); discounts != 0 {
+		postings = append(postings, posting{account: a.opts.Accounts.Discount, cents: -discounts})
+		total -= discounts
+	}
+	postings = append(postings, posting{account: a.opts.Accounts.Payment, cents: -total})
+
+	a.writeHeader(receipt)
+	for _, p := range postings {
+		fmt.Fprintf(a.out, "    %s  %s %s", p.account, formatCents(p.cents), a.opts.Accounts.Commodity)
+		if p.comment != "" {
+			fmt.Fprintf(a.out, "  ; %s", a.comment(p.comment))
+		}
+		a.out.WriteByte('\n')
+	}
+	_, err := a.out.WriteString("\n")
+
+	a.rows = a.rows[:0]
+	return err
+}
+
+// itemPostings returns one posting per item or, when grouping, one per
+// expense account in order of first appearance
+func (a *accountingWriter) itemPostings() []posting {
+	postings := []posting{}
+	byAccount := map[string]int{}
+
+	for _, row := range a.rows {
+		account := a.opts.Accounts.expense(row)
+		cents := toCents(row.Quantity * row.UnitPrice)
+
+		if !a.opts.GroupPostings {
+			comment := row.Product
+			if row.Quantity != 1 {
+				comment += " x " + strconv.FormatFloat(row.Quantity, 'f', -1, 64)
+			}
+			postings = append(postings, posting{account: account, cents: cents, comment: comment})
+			continue
+		}
+
+		if i, ok := byAccount[account]; ok {
+			postings[i].cents += cents
+			continue
+		}
+		byAccount[account] = len(postings)
+		postings = append(postings, posting{account: account, cents: cents})
+	}
+
+	return postings
+}
+
+// writeHeader writes the first line of a transaction and its metadata
+func (a *accountingWriter) writeHeader(receipt Row) {
+	payee := strings.Join(strings.Fields(receipt.StoreName), " ")
+
+	switch a.dialect {
+	case dialectBeancount:
+		fmt.Fprintf(a.out, "%s * %s %s\n", receipt.BoughtDate, beancountString(payee), beancountString(itemCount(len(a.rows))))
+		fmt.Fprintf(a.out, "    ticketer_id: %s\n", beancountString(receipt.ReceiptID))
+	case dialectHledger:
+		// hledger reads name:value pairs of comments as tags
+		fmt.Fprintf(a.out, "%s * %s  ; ticketer_id:%s\n", receipt.BoughtDate, payee, receipt.ReceiptID)
+	default:
+		fmt.Fprintf(a.out, "%s * %s\n", receipt.BoughtDate, payee)
+		fmt.Fprintf(a.out, "    ; ticketer_id: %s\n", receipt.ReceiptID)
+	}
+}
+
+// comment sanitizes a posting comment. In ledger and hledger a colon would
+// turn the comment into metadata (a tag).
+func (a *accountingWriter) comment(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if a.dialect != dialectBeancount {
+		text = strings.ReplaceAll(text, ":", " ")
+	}
+	return text
+}
+
+// itemCount describes how many items a receipt has
+func itemCount(n int) string {
+	if n == 1 {
+		return "1 item"
+	}
+	return fmt.Sprintf("%d items", n)
+}
+
+// beancountString quotes a beancount string
+func beancountString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// toCents converts an amount to cents, rounding to the nearest
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// formatCents formats an amount in cents with two decimals, e.g. -1.05
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
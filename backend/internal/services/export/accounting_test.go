@@ -0,0 +1,226 @@
+package export
+
+import (
+	"strings"
+	"testing"
+)
+
+var testAccounts = Accounts{
+	Payment:    "Assets:Checking",
+	Default:    "Expenses:Misc",
+	Discount:   "Expenses:Discounts",
+	Commodity:  "EUR",
+	Stores:     map[string]string{`farmacia "el sol"`: "Expenses:Health"},
+	Categories: map[string]string{"groceries": "Expenses:Food"},
+}
+
+// transaction is a transaction of an accounting export, with its postings in
+// cents by account
+type transaction struct {
+	header   string
+	accounts []string
+	cents    []int64
+	comments []string
+}
+
+// parseJournal reads the transactions of an accounting export
+func parseJournal(t *testing.T, journal string) []transaction {
+	t.Helper()
+
+	var transactions []transaction
+	for _, block := range strings.Split(strings.TrimSpace(journal), "\n\n") {
+		lines := strings.Split(block, "\n")
+		tx := transaction{header: lines[0]}
+		for _, line := range lines[1:] {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, ";") || strings.HasPrefix(line, "ticketer_id:") {
+				continue
+			}
+
+			account, rest, _ := strings.Cut(line, "  ")
+			amount, comment, _ := strings.Cut(rest, "  ; ")
+			fields := strings.Fields(amount)
+			if len(fields) != 2 || fields[1] != "EUR" {
+				t.Fatalf("invalid posting %q", line)
+			}
+			tx.accounts = append(tx.accounts, account)
+			tx.cents = append(tx.cents, parseCents(t, fields[0]))
+			tx.comments = append(tx.comments, comment)
+		}
+		transactions = append(transactions, tx)
+	}
+	return transactions
+}
+
+// parseCents reads an amount formatted by formatCents
+func parseCents(t *testing.T, amount string) int64 {
+	t.Helper()
+
+	sign := int64(1)
+	if rest, ok := strings.CutPrefix(amount, "-"); ok {
+		sign, amount = -1, rest
+	}
+	units, decimals, ok := strings.Cut(amount, ".")
+	if !ok || len(decimals) != 2 {
+		t.Fatalf("invalid amount %q", amount)
+	}
+	cents := int64(0)
+	for _, c := range units + decimals {
+		if c < '0' || c > '9' {
+			t.Fatalf("invalid amount %q", amount)
+		}
+		cents = cents*10 + int64(c-'0')
+	}
+	return sign * cents
+}
+
+func TestAccountingBalances(t *testing.T) {
+	for _, format := range []string{"ledger", "hledger", "beancount"} {
+		for _, grouped := range []bool{false, true} {
+			journal := writeRows(t, format, Options{Accounts: testAccounts, GroupPostings: grouped}, sampleRows())
+			transactions := parseJournal(t, journal)
+			if len(transactions) != 2 {
+				t.Fatalf("%s: got %d transactions, want 2:\n%s", format, len(transactions), journal)
+			}
+
+			for i, tx := range transactions {
+				sum := int64(0)
+				for _, cents := range tx.cents {
+					sum += cents
+				}
+				if sum != 0 {
+					t.Errorf("%s (grouped %v): transaction %d adds up to %d cents:\n%s", format, grouped, i, sum, journal)
+				}
+			}
+
+			// The items of the first receipt, less its discount, are paid
+			first := transactions[0]
+			last := len(first.cents) - 1
+			if first.accounts[last] != "Assets:Checking" || first.cents[last] != -260 {
+				t.Errorf("%s (grouped %v): paid %s %d, want Assets:Checking -260", format, grouped, first.accounts[last], first.cents[last])
+			}
+			if first.accounts[last-1] != "Expenses:Discounts" || first.cents[last-1] != -50 {
+				t.Errorf("%s (grouped %v): discount %s %d, want Expenses:Discounts -50", format, grouped, first.accounts[last-1], first.cents[last-1])
+			}
+
+			wantItems := 2
+			if grouped {
+				wantItems = 1
+			}
+			if len(first.cents) != wantItems+2 {
+				t.Errorf("%s (grouped %v): got %d postings, want %d", format, grouped, len(first.cents), wantItems+2)
+			}
+		}
+	}
+}
+
+func TestAccountingPostings(t *testing.T) {
+	transactions := parseJournal(t, writeRows(t, "ledger", Options{Accounts: testAccounts}, sampleRows()))
+
+	// One posting per item, with its product and quantity as the comment
+	first := transactions[0]
+	if first.header != "2024-03-15 * LIDL" {
+		t.Errorf("header = %q", first.header)
+	}
+	want := []struct {
+		account string
+		cents   int64
+		comment string
+	}{
+		{"Expenses:Food", 184, "LECHE ENTERA x 2"},
+		{"Expenses:Food", 126, "PLATANO x 0.508"},
+	}
+	for i, w := range want {
+		if first.accounts[i] != w.account || first.cents[i] != w.cents || first.comments[i] != w.comment {
+			t.Errorf("posting %d = %s %d ; %s, want %s %d ; %s", i, first.accounts[i], first.cents[i], first.comments[i], w.account, w.cents, w.comment)
+		}
+	}
+
+	// A receipt without discounts has no discount posting
+	second := transactions[1]
+	if len(second.accounts) != 2 || second.accounts[0] != "Expenses:Health" || second.comments[0] != "IBUPROFENO 600" {
+		t.Errorf("second transaction = %+v", second)
+	}
+}
+
+func TestAccountingHeaders(t *testing.T) {
+	rows := sampleRows()[2:]
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{"ledger", []string{`2024-03-16 * FARMACIA "EL SOL"`, "    ; ticketer_id: r2"}},
+		{"hledger", []string{`2024-03-16 * FARMACIA "EL SOL"  ; ticketer_id:r2`}},
+		{"beancount", []string{`2024-03-16 * "FARMACIA \"EL SOL\"" "1 item"`, `    ticketer_id: "r2"`}},
+	}
+
+	for _, tt := range tests {
+		lines := strings.Split(writeRows(t, tt.format, Options{Accounts: testAccounts}, rows), "\n")
+		for i, want := range tt.want {
+			if lines[i] != want {
+				t.Errorf("%s: line %d = %q, want %q", tt.format, i, lines[i], want)
+			}
+		}
+	}
+}
+
+func TestAccountsExpense(t *testing.T) {
+	accounts := Accounts{
+		Default:    "Expenses:Misc",
+		Stores:     map[string]string{"lidl": "Expenses:Supermarket"},
+		Categories: map[string]string{"groceries": "Expenses:Food", "groceries/dairy": "Expenses:Food:Dairy"},
+	}
+
+	tests := []struct {
+		category string
+		store    string
+		want     string
+	}{
+		{"Groceries/Dairy", "LIDL", "Expenses:Food:Dairy"},
+		{"Groceries/Dairy/Milk", "LIDL", "Expenses:Food:Dairy"},
+		{"Groceries/Fruit", "LIDL", "Expenses:Food"},
+		{"Household", "LIDL", "Expenses:Supermarket"},
+		{"", "Lidl", "Expenses:Supermarket"},
+		{"Household/Cleaning", "ALDI", "Expenses:Misc"},
+	}
+
+	for _, tt := range tests {
+		if got := accounts.expense(Row{Category: tt.category, StoreName: tt.store}); got != tt.want {
+			t.Errorf("expense(%q at %s) = %s, want %s", tt.category, tt.store, got, tt.want)
+		}
+	}
+}
+
+func TestAccountingComment(t *testing.T) {
+	text := "  AGUA\tMINERAL: 1,5L \n PACK "
+
+	ledger := &accountingWriter{dialect: dialectLedger}
+	if got := ledger.comment(text); got != "AGUA MINERAL  1,5L PACK" {
+		t.Errorf("ledger comment = %q", got)
+	}
+	beancount := &accountingWriter{dialect: dialectBeancount}
+	if got := beancount.comment(text); got != "AGUA MINERAL: 1,5L PACK" {
+		t.Errorf("beancount comment = %q", got)
+	}
+}
+
+func TestFormatCents(t *testing.T) {
+	tests := []struct {
+		cents int64
+		want  string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{123456, "1234.56"},
+		{-5, "-0.05"},
+		{-105, "-1.05"},
+		{-260, "-2.60"},
+	}
+
+	for _, tt := range tests {
+		if got := formatCents(tt.cents); got != tt.want {
+			t.Errorf("formatCents(%d) = %q, want %q", tt.cents, got, tt.want)
+		}
+	}
+}
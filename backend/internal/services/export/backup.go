@@ -0,0 +1,100 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// BackupFormat identifies ticketer backups, and BackupVersion their layout
+const (
+	BackupFormat  = "ticketer-backup"
+	BackupVersion = 1
+)
+
+// Backup is a full JSON export of receipts, which can be imported back
+type Backup struct {
+	Format   string           `json:"format"`
+	Version  int              `json:"version"`
+	Receipts []models.Receipt `json:"receipts"`
+}
+
+// backupWriter writes a Backup, one receipt at a time: rows come ordered by
+// receipt, so only the receipt being written is held in memory
+type backupWriter struct {
+	out     *bufio.Writer
+	receipt *models.Receipt
+	written int
+}
+
+func newBackupWriter(w io.Writer, _ Options) (Writer, error) {
+	out := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(out, "{\"format\":%q,\"version\":%d,\"receipts\":[\n", BackupFormat, BackupVersion); err != nil {
+		return nil, err
+	}
+	return &backupWriter{out: out}, nil
+}
+
+func (b *backupWriter) WriteRow(row Row) error {
+	if b.receipt != nil && b.receipt.ID != row.ReceiptID {
+		if err := b.writeReceipt(); err != nil {
+			return err
+		}
+	}
+
+	if b.receipt == nil {
+		b.receipt = &models.Receipt{
+			ID:             row.ReceiptID,
+			StoreName:      row.StoreName,
+			BoughtDate:     row.BoughtDate,
+			Items:          []models.Item{},
+			Discounts:      row.Discounts,
+			Owner:          row.Owner,
+			DocumentNumber: row.DocumentNumber,
+			StoreTaxID:     row.StoreTaxID,
+			Tags:           row.ReceiptTags,
+			Notes:          row.ReceiptNotes,
+		}
+	}
+	b.receipt.Items = append(b.receipt.Items, models.Item{
+		Name:     row.Product,
+		Quantity: row.Quantity,
+		Price:    row.UnitPrice,
+		TaxRate:  row.TaxRate,
+		Category: row.Category,
+		Tags:     row.ItemTags,
+		Notes:    row.ItemNotes,
+	})
+	return nil
+}
+
+func (b *backupWriter) Close() error {
+	if b.receipt != nil {
+		if err := b.writeReceipt(); err != nil {
+			return err
+		}
+	}
+	if _, err := b.out.WriteString("\n]}\n"); err != nil {
+		return err
+	}
+	return b.out.Flush()
+}
+
+// writeReceipt writes the buffered receipt as an element of the array
+func (b *backupWriter) writeReceipt() error {
+	data, err := json.Marshal(b.receipt)
+	if err != nil {
+		return err
+	}
+	if b.written > 0 {
+		b.out.WriteString(",\n")
+	}
+	b.written++
+	b.receipt = nil
+
+	_, err = b.out.Write(data)
+	return err
+}
@@ -0,0 +1,41 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// csvWriter writes rows as CSV with a header line
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVWriter(w io.Writer, _ Options) (Writer, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return nil, err
+	}
+	return &csvWriter{writer: writer}, nil
+}
+
+func (c *csvWriter) WriteRow(row Row) error {
+	return c.writer.Write([]string{
+		row.ReceiptID,
+		row.StoreName,
+		row.BoughtDate,
+		row.Product,
+		strconv.FormatFloat(row.Quantity, 'f', -1, 64),
+		strconv.FormatFloat(row.UnitPrice, 'f', 2, 64),
+		strconv.FormatFloat(row.LineTotal, 'f', 2, 64),
+		strconv.FormatFloat(row.Discounts, 'f', 2, 64),
+		row.Category,
+		strings.Join(row.Tags, tagSeparator),
+	})
+}
+
+func (c *csvWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
@@ -0,0 +1,91 @@
+// Package export writes receipt items as spreadsheets and data files, one row
+// per item, or as plain-text accounting journals and full backups, one entry
+// per receipt, as they are read.
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrUnknownFormat is returned for an export format that is not supported
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// Row is an item of a receipt, flattened with its receipt
+type Row struct {
+	ReceiptID  string
+	StoreName  string
+	BoughtDate string // ISO 8601: YYYY-MM-DD
+	Product    string
+	Quantity   float64
+	UnitPrice  float64
+	LineTotal  float64
+	Discounts  float64  // Discounts of the whole receipt
+	Category   string   // Empty when the item has no category
+	Tags       []string // Tags of the item and of its receipt
+	TaxRate    *float64
+
+	// Receipt and item details, for backups
+	Owner          string
+	DocumentNumber string
+	StoreTaxID     string
+	ReceiptTags    []string
+	ReceiptNotes   string
+	ItemTags       []string
+	ItemNotes      string
+}
+
+// columns are the header of the tabular formats, in Row order
+var columns = []string{"receipt_id", "store", "date", "product", "quantity", "unit_price", "line_total", "receipt_discounts", "category", "tags"}
+
+// tagSeparator joins the tags of a row in the CSV and XLSX formats
+const tagSeparator = ";"
+
+// Writer writes rows in an export format. Close must be called once all rows
+// are written; it does not close the underlying writer.
+type Writer interface {
+	WriteRow(row Row) error
+	Close() error
+}
+
+// Options configure the accounting formats; the tabular ones ignore them
+type Options struct {
+	Accounts Accounts
+	// GroupPostings posts one line per expense account (that is, per category)
+	// instead of one line per item
+	GroupPostings bool
+}
+
+// Format describes an export format
+type Format struct {
+	Name        string
+	ContentType string
+	Extension   string
+	new         func(w io.Writer, opts Options) (Writer, error)
+}
+
+var formats = map[string]Format{
+	"csv":       {Name: "csv", ContentType: "text/csv; charset=utf-8", Extension: ".csv", new: newCSVWriter},
+	"jsonl":     {Name: "jsonl", ContentType: "application/x-ndjson", Extension: ".jsonl", new: newJSONLWriter},
+	"xlsx":      {Name: "xlsx", ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", Extension: ".xlsx", new: newXLSXWriter},
+	"ledger":    {Name: "ledger", ContentType: "text/plain; charset=utf-8", Extension: ".ledger", new: newLedgerWriter},
+	"hledger":   {Name: "hledger", ContentType: "text/plain; charset=utf-8", Extension: ".journal", new: newHledgerWriter},
+	"beancount": {Name: "beancount", ContentType: "text/plain; charset=utf-8", Extension: ".beancount", new: newBeancountWriter},
+	"json":      {Name: "json", ContentType: "application/json", Extension: ".json", new: newBackupWriter},
+}
+
+// LookupFormat returns an export format by name
+func LookupFormat(name string) (Format, error) {
+	format, ok := formats[strings.ToLower(name)]
+	if !ok {
+		return Format{}, fmt.Errorf("%w %q (expected csv, jsonl, xlsx, ledger, hledger, beancount or json)", ErrUnknownFormat, name)
+	}
+	return format, nil
+}
+
+// NewWriter starts an export in this format
+func (f Format) NewWriter(w io.Writer, opts Options) (Writer, error) {
+	return f.new(w, opts)
+}
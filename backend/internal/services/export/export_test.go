@@ -0,0 +1,193 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sampleRows are two items of a receipt with a discount, and one of another
+// receipt without a category
+func sampleRows() []Row {
+	return []Row{
+		{ReceiptID: "r1", StoreName: "LIDL", BoughtDate: "2024-03-15", Product: "LECHE ENTERA", Quantity: 2, UnitPrice: 0.92,
+			LineTotal: 1.84, Discounts: 0.50, Category: "Groceries/Dairy", Tags: []string{"weekly", "home"}},
+		{ReceiptID: "r1", StoreName: "LIDL", BoughtDate: "2024-03-15", Product: "PLATANO", Quantity: 0.508, UnitPrice: 2.49,
+			LineTotal: 1.26, Discounts: 0.50, Category: "Groceries/Fruit", Tags: []string{"weekly"}},
+		{ReceiptID: "r2", StoreName: "FARMACIA \"EL SOL\"", BoughtDate: "2024-03-16", Product: "IBUPROFENO 600", Quantity: 1, UnitPrice: 2.50,
+			LineTotal: 2.50},
+	}
+}
+
+// writeRows exports rows in a format
+func writeRows(t *testing.T, name string, opts Options, rows []Row) string {
+	t.Helper()
+
+	format, err := LookupFormat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	writer, err := format.NewWriter(&buf, opts)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	for _, row := range rows {
+		if err := writer.WriteRow(row); err != nil {
+			t.Fatalf("WriteRow failed: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestLookupFormat(t *testing.T) {
+	if format, err := LookupFormat("XLSX"); err != nil || format.Extension != ".xlsx" {
+		t.Errorf("LookupFormat(XLSX) = %+v, %v", format, err)
+	}
+	if _, err := LookupFormat("pdf"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("LookupFormat(pdf) = %v, want ErrUnknownFormat", err)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	records, err := csv.NewReader(strings.NewReader(writeRows(t, "csv", Options{}, sampleRows()))).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+
+	want := [][]string{
+		columns,
+		{"r1", "LIDL", "2024-03-15", "LECHE ENTERA", "2", "0.92", "1.84", "0.50", "Groceries/Dairy", "weekly;home"},
+		{"r1", "LIDL", "2024-03-15", "PLATANO", "0.508", "2.49", "1.26", "0.50", "Groceries/Fruit", "weekly"},
+		{"r2", "FARMACIA \"EL SOL\"", "2024-03-16", "IBUPROFENO 600", "1", "2.50", "2.50", "0.00", "", ""},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("CSV = %q, want %q", records, want)
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader(writeRows(t, "jsonl", Options{}, sampleRows())))
+
+	var got []map[string]any
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, line)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d lines, want 3", len(got))
+	}
+
+	// Every line has the columns of the tabular formats as keys
+	for _, column := range columns {
+		if _, ok := got[0][column]; !ok {
+			t.Errorf("line has no %q", column)
+		}
+	}
+	if got[0]["product"] != "LECHE ENTERA" || got[0]["quantity"] != 2.0 || got[0]["receipt_discounts"] != 0.5 {
+		t.Errorf("first line = %v", got[0])
+	}
+	if tags, ok := got[0]["tags"].([]any); !ok || len(tags) != 2 || tags[0] != "weekly" {
+		t.Errorf("tags = %v, want [weekly home]", got[0]["tags"])
+	}
+	if got[2]["store"] != `FARMACIA "EL SOL"` || got[2]["category"] != "" {
+		t.Errorf("last line = %v", got[2])
+	}
+}
+
+// xlsxSheet is the part of a worksheet the tests read
+type xlsxSheet struct {
+	Rows []struct {
+		Ref   string `xml:"r,attr"`
+		Cells []struct {
+			Ref    string `xml:"r,attr"`
+			Style  int    `xml:"s,attr"`
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func TestXLSX(t *testing.T) {
+	data := writeRows(t, "xlsx", Options{}, sampleRows())
+	archive, err := zip.NewReader(strings.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid archive: %v", err)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		if _, err := archive.Open(name); err != nil {
+			t.Errorf("missing %s: %v", name, err)
+		}
+	}
+
+	file, err := archive.Open("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatalf("missing sheet: %v", err)
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sheet xlsxSheet
+	if err := xml.Unmarshal(content, &sheet); err != nil {
+		t.Fatalf("sheet is not XML: %v", err)
+	}
+
+	if len(sheet.Rows) != 4 {
+		t.Fatalf("got %d rows, want 4", len(sheet.Rows))
+	}
+
+	// The header is bold text
+	for i, cell := range sheet.Rows[0].Cells {
+		if cell.Inline != columns[i] || cell.Style != xlsxStyleHeader || cell.Type != "inlineStr" {
+			t.Errorf("header cell %s = %q (style %d), want %q", cell.Ref, cell.Inline, cell.Style, columns[i])
+		}
+	}
+
+	// Dates are spreadsheet dates, and amounts numbers shown with two decimals
+	cells := sheet.Rows[1].Cells
+	if len(cells) != len(columns) {
+		t.Fatalf("got %d cells, want %d", len(cells), len(columns))
+	}
+	checks := []struct {
+		column int
+		ref    string
+		value  string
+		style  int
+	}{
+		{0, "A2", "r1", xlsxStyleDefault},
+		{2, "C2", "45366", xlsxStyleDate},
+		{3, "D2", "LECHE ENTERA", xlsxStyleDefault},
+		{4, "E2", "2", xlsxStyleDefault},
+		{5, "F2", "0.92", xlsxStyleMoney},
+		{7, "H2", "0.5", xlsxStyleMoney},
+		{9, "J2", "weekly;home", xlsxStyleDefault},
+	}
+	for _, check := range checks {
+		cell := cells[check.column]
+		value := cell.Value
+		if cell.Type == "inlineStr" {
+			value = cell.Inline
+		}
+		if cell.Ref != check.ref || value != check.value || cell.Style != check.style {
+			t.Errorf("cell %s = %q (style %d), want %s = %q (style %d)", cell.Ref, value, cell.Style, check.ref, check.value, check.style)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonlRow is a row as a JSON line
+type jsonlRow struct {
+	ReceiptID        string   `json:"receipt_id"`
+	Store            string   `json:"store"`
+	Date             string   `json:"date"`
+	Product          string   `json:"product"`
+	Quantity         float64  `json:"quantity"`
+	UnitPrice        float64  `json:"unit_price"`
+	LineTotal        float64  `json:"line_total"`
+	ReceiptDiscounts float64  `json:"receipt_discounts"`
+	Category         string   `json:"category"`
+	Tags             []string `json:"tags"`
+}
+
+// jsonlWriter writes rows as JSON Lines, one object per row
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer, _ Options) (Writer, error) {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &jsonlWriter{encoder: encoder}, nil
+}
+
+func (j *jsonlWriter) WriteRow(row Row) error {
+	return j.encoder.Encode(jsonlRow{
+		ReceiptID:        row.ReceiptID,
+		Store:            row.StoreName,
+		Date:             row.BoughtDate,
+		Product:          row.Product,
+		Quantity:         row.Quantity,
+		UnitPrice:        row.UnitPrice,
+		LineTotal:        row.LineTotal,
+		ReceiptDiscounts: row.Discounts,
+		Category:         row.Category,
+		Tags:             row.Tags,
+	})
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
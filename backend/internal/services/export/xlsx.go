@@ -0,0 +1,182 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cell styles, indexes into the cellXfs of xlsxStyles
+const (
+	xlsxStyleDefault = 0
+	xlsxStyleHeader  = 1
+	xlsxStyleDate    = 2
+	xlsxStyleMoney   = 3
+)
+
+// xlsxEpoch is day zero of spreadsheet dates
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// xlsxColumns are the cell references of the columns, in Row order
+var xlsxColumns = []string{"A", "B", "C", "D", "E", "F", "G", "H", "I", "J"}
+
+// The fixed parts of a workbook with a single sheet
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`
+
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Items" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`
+
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`
+
+	// Styles: default, bold header, date (built-in format 14) and money (built-in format 2, "0.00")
+	xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="4">` +
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+		`<xf numFmtId="14" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`<xf numFmtId="2" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`</cellXfs>` +
+		`</styleSheet>`
+
+	// The sheet keeps the header row visible while scrolling
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>` +
+		`<sheetData>`
+
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// xlsxWriter writes rows as an Office Open XML workbook. The archive is
+// written as it goes, with the sheet as its last part, so rows are never
+// held in memory.
+type xlsxWriter struct {
+	archive *zip.Writer
+	sheet   *bufio.Writer
+	row     int
+}
+
+func newXLSXWriter(w io.Writer, _ Options) (Writer, error) {
+	archive := zip.NewWriter(w)
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, part := range parts {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", part.name, err)
+		}
+		if _, err := io.WriteString(file, part.content); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", part.name, err)
+		}
+	}
+
+	file, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sheet: %w", err)
+	}
+
+	x := &xlsxWriter{archive: archive, sheet: bufio.NewWriter(file)}
+	x.sheet.WriteString(xlsxSheetStart)
+
+	x.startRow()
+	for i, column := range columns {
+		x.stringCell(i, column, xlsxStyleHeader)
+	}
+	x.sheet.WriteString("</row>")
+
+	return x, nil
+}
+
+func (x *xlsxWriter) WriteRow(row Row) error {
+	x.startRow()
+	x.stringCell(0, row.ReceiptID, xlsxStyleDefault)
+	x.stringCell(1, row.StoreName, xlsxStyleDefault)
+	x.dateCell(2, row.BoughtDate)
+	x.stringCell(3, row.Product, xlsxStyleDefault)
+	x.numberCell(4, row.Quantity, xlsxStyleDefault)
+	x.numberCell(5, row.UnitPrice, xlsxStyleMoney)
+	x.numberCell(6, row.LineTotal, xlsxStyleMoney)
+	x.numberCell(7, row.Discounts, xlsxStyleMoney)
+	x.stringCell(8, row.Category, xlsxStyleDefault)
+	x.stringCell(9, strings.Join(row.Tags, tagSeparator), xlsxStyleDefault)
+	_, err := x.sheet.WriteString("</row>")
+	return err
+}
+
+func (x *xlsxWriter) Close() error {
+	x.sheet.WriteString(xlsxSheetEnd)
+	if err := x.sheet.Flush(); err != nil {
+		return fmt.Errorf("failed to write sheet: %w", err)
+	}
+	return x.archive.Close()
+}
+
+// startRow opens the next row
+func (x *xlsxWriter) startRow() {
+	x.row++
+	fmt.Fprintf(x.sheet, `<row r="%d">`, x.row)
+}
+
+// ref returns the reference of a cell of the current row, e.g. "C12"
+func (x *xlsxWriter) ref(column int) string {
+	return xlsxColumns[column] + strconv.Itoa(x.row)
+}
+
+// stringCell writes a text cell as an inline string
+func (x *xlsxWriter) stringCell(column int, value string, style int) {
+	fmt.Fprintf(x.sheet, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">`, x.ref(column), style)
+	xml.EscapeText(x.sheet, []byte(value))
+	x.sheet.WriteString(`</t></is></c>`)
+}
+
+// numberCell writes a numeric cell
+func (x *xlsxWriter) numberCell(column int, value float64, style int) {
+	fmt.Fprintf(x.sheet, `<c r="%s" s="%d"><v>%s</v></c>`, x.ref(column), style, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// dateCell writes an ISO 8601 date as a spreadsheet date, or as text if it
+// is not one
+func (x *xlsxWriter) dateCell(column int, value string) {
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		x.stringCell(column, value, xlsxStyleDefault)
+		return
+	}
+	days := date.Sub(xlsxEpoch).Hours() / 24
+	x.numberCell(column, days, xlsxStyleDate)
+}
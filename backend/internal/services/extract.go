@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/parser"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// CanProcess reports whether a file can be turned into a receipt: text-layer
+// files can always be parsed, images need the AI extractor
+func (s *ReceiptService) CanProcess(path string) bool {
+	return s.aiService != nil || parser.SupportsFile(path)
+}
+
+// extractReceipt extracts a receipt from a file. Files with a text layer and
+// HTML e-receipts go through the deterministic parser first; images,
+// unparseable text and parsed results that fail validation go through the AI
+// extractor. Each stage is passed to report as it happens.
+func (s *ReceiptService) extractReceipt(ctx context.Context, path string, report func(dto.ProcessingProgress)) (*models.Receipt, []*models.Extraction, error) {
+	var parsed *models.Receipt
+	var parsedExtraction *models.Extraction
+
+	if parser.SupportsFile(path) {
+		receipt, extraction, err := parseReceiptFile(path)
+		if err == nil {
+			report(dto.ProcessingProgress{Stage: dto.StageStoreIdentified, Store: receipt.StoreName})
+			reportExtraction(report, extraction.Attempt, receipt, extraction.Issues)
+		}
+		switch {
+		case err != nil:
+			log.Info("Receipt text could not be parsed", "path", path, "reason", err)
+		case len(extraction.Issues) > 0 && s.aiService != nil:
+			log.Warn("Parsed receipt failed validation", "path", path, "issues", extraction.Issues)
+			parsed, parsedExtraction = receipt, extraction
+		default:
+			extraction.Selected = true
+			return receipt, []*models.Extraction{extraction}, nil
+		}
+	}
+
+	if s.aiService == nil {
+		return nil, nil, ErrNoExtractor
+	}
+
+	// The AI attempts follow the parser's, if it made one
+	first := 1
+	if parsedExtraction != nil {
+		first = parsedExtraction.Attempt + 1
+	}
+
+	log.Info("Extracting receipt with AI", "path", path)
+	receipt, extractions, err := s.extractWithEscalation(ctx, path, first, report)
+	if parsedExtraction == nil {
+		return receipt, extractions, err
+	}
+
+	// Keep the parsed result unless the AI did better
+	extractions = append([]*models.Extraction{parsedExtraction}, extractions...)
+	if err != nil || len(validateReceipt(receipt)) >= len(parsedExtraction.Issues) {
+		for _, extraction := range extractions {
+			extraction.Selected = false
+		}
+		parsedExtraction.Selected = true
+		return parsed, extractions, nil
+	}
+
+	return receipt, extractions, nil
+}
+
+// parseReceiptFile parses a text-layer receipt or an e-receipt without any
+// network call, recording the grammar or extractor used as the extraction's
+// provenance
+func parseReceiptFile(path string) (*models.Receipt, *models.Extraction, error) {
+	start := time.Now()
+
+	parsed, err := parser.ParseFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	extraction := &models.Extraction{
+		StoreAnswer: parsed.Receipt.StoreName,
+		ItemsStage: models.ExtractionStage{
+			Model:         "rule-based",
+			PromptVersion: parsed.Version,
+			LatencyMs:     time.Since(start).Milliseconds(),
+		},
+		Attempt:     1,
+		Strategy:    "parser",
+		Issues:      validateReceipt(parsed.Receipt),
+		RawResponse: parsed.Text,
+	}
+	return parsed.Receipt, extraction, nil
+}
+
+// reportExtraction reports the items and the validation result of an
+// extraction attempt
+func reportExtraction(report func(dto.ProcessingProgress), attempt int, receipt *models.Receipt, issues []string) {
+	report(dto.ProcessingProgress{Stage: dto.StageItemsParsed, Store: receipt.StoreName, Attempt: attempt, Items: len(receipt.Items)})
+
+	valid := len(issues) == 0
+	report(dto.ProcessingProgress{Stage: dto.StageValidated, Attempt: attempt, Valid: &valid, Issues: issues})
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+func TestExtractionNumbersAIAttemptsAfterParser(t *testing.T) {
+	service, repository := newReplayService(t, "aldi_misread")
+
+	// The text layer lost the euro sign of a line, so the parsed items do not
+	// add up to the total and the AI extracts the receipt again
+	var progress []dto.ProcessingProgress
+	result, err := service.ProcessReceiptFileWith(context.Background(), filepath.Join(cassetteDir, "aldi_misread.txt"), ProcessOptions{
+		Progress: func(stage dto.ProcessingProgress) { progress = append(progress, stage) },
+	})
+	if err != nil {
+		t.Fatalf("ProcessReceiptFileWith failed: %v", err)
+	}
+
+	strategies, attempts, selected := extractionSummary(repository.extractions)
+	if !slices.Equal(strategies, []string{"parser", "initial"}) {
+		t.Fatalf("strategies %v, want parser then initial", strategies)
+	}
+	if !slices.Equal(attempts, []int{1, 2}) {
+		t.Errorf("attempts %v, want the AI numbered after the parser", attempts)
+	}
+	if selected != 2 {
+		t.Errorf("selected attempt %d, want the AI's 2", selected)
+	}
+	if len(result.Receipt.Items) != 3 {
+		t.Errorf("kept %d items, want the 3 items of the AI", len(result.Receipt.Items))
+	}
+
+	// Progress carries the same numbers
+	var validated []int
+	for _, stage := range progress {
+		if stage.Stage == dto.StageValidated {
+			validated = append(validated, stage.Attempt)
+		}
+	}
+	if !slices.Equal(validated, []int{1, 2}) {
+		t.Errorf("validated attempts %v, want 1 and 2", validated)
+	}
+}
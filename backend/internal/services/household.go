@@ -0,0 +1,511 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+const (
+	maxHouseholdNameLength = 100
+	maxMemberLength        = 255
+)
+
+// ErrHouseholdRole is returned when the role of a member in a household does
+// not allow a change
+var ErrHouseholdRole = errors.New("your role in the household does not allow this")
+
+// HouseholdService manages households, their members and invitations, and
+// authorises access to the receipts in them. Members are users, identified by
+// the owner of their API tokens, so households need a token.
+type HouseholdService struct {
+	db database.HouseholdRepository
+
+	// receipts retrieves the receipts moved between households, and queues
+	// their events
+	receipts *ReceiptService
+}
+
+func NewHouseholdService(db database.HouseholdRepository, receipts *ReceiptService) *HouseholdService {
+	return &HouseholdService{
+		db:       db,
+		receipts: receipts,
+	}
+}
+
+// authorize checks that a user has at least a role in a household. Households
+// the user is not a member of are not found.
+func (s *HouseholdService) authorize(ctx context.Context, id, user, role string) error {
+	if user == "" {
+		return ErrTokenRequired
+	}
+
+	current, err := s.db.GetHouseholdRole(ctx, id, user)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		return database.ErrHouseholdNotFound
+	}
+	if !slices.Contains(models.RolesFrom(role), current) {
+		return ErrHouseholdRole
+	}
+	return nil
+}
+
+// AuthorizeReceipt checks that a caller has at least a role in the household
+// of a receipt or, when it is in none, may see it (see authorizeUnshared).
+// Receipts the caller may not see are not found.
+func (s *HouseholdService) AuthorizeReceipt(ctx context.Context, caller *Caller, receiptID, role string) error {
+	if s.db == nil {
+		return nil
+	}
+
+	householdID, owner, err := s.db.GetReceiptHousehold(ctx, receiptID)
+	if err != nil {
+		return err
+	}
+	if householdID == "" {
+		return s.authorizeUnshared(ctx, caller, owner)
+	}
+
+	err = s.authorize(ctx, householdID, caller.User(), role)
+	if errors.Is(err, ErrTokenRequired) || errors.Is(err, database.ErrHouseholdNotFound) {
+		return database.ErrReceiptNotFound
+	}
+	return err
+}
+
+// authorizeUnshared checks that a caller may see and change a receipt in no
+// household, of an owner: until a household exists anyone may, as may anyone
+// on installs without API tokens. Then only its owner may, or admins for
+// receipts of no one.
+func (s *HouseholdService) authorizeUnshared(ctx context.Context, caller *Caller, owner string) error {
+	user := caller.User()
+	if user == "" || user == owner || (owner == "" && caller.Authorize(models.ScopeAdmin) == nil) {
+		return nil
+	}
+
+	exists, err := s.db.HasHouseholds(ctx)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return database.ErrReceiptNotFound
+	}
+	return nil
+}
+
+// AuthorizeItem checks that a caller may access the receipt of an item with
+// at least a role, see AuthorizeReceipt
+func (s *HouseholdService) AuthorizeItem(ctx context.Context, caller *Caller, itemID, role string) error {
+	if s.db == nil {
+		return nil
+	}
+
+	receiptID, err := s.db.GetItemReceiptID(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	err = s.AuthorizeReceipt(ctx, caller, receiptID, role)
+	if errors.Is(err, database.ErrReceiptNotFound) {
+		return database.ErrItemNotFound
+	}
+	return err
+}
+
+// AuthorizeReprocessing checks that a caller may access the receipt of a
+// reprocessing with at least a role, see AuthorizeReceipt
+func (s *HouseholdService) AuthorizeReprocessing(ctx context.Context, caller *Caller, reprocessingID, role string) error {
+	if s.db == nil {
+		return nil
+	}
+
+	receiptID, err := s.db.GetReprocessingReceiptID(ctx, reprocessingID)
+	if err != nil {
+		return err
+	}
+	err = s.AuthorizeReceipt(ctx, caller, receiptID, role)
+	if errors.Is(err, database.ErrReceiptNotFound) {
+		return database.ErrReprocessingNotFound
+	}
+	return err
+}
+
+// ListHouseholds retrieves the households of a user with their role
+func (s *HouseholdService) ListHouseholds(ctx context.Context, user string) ([]dto.HouseholdResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+	if user == "" {
+		return nil, ErrTokenRequired
+	}
+
+	households, err := s.db.ListHouseholds(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.HouseholdResponse, len(households))
+	for i := range households {
+		response[i] = householdToDTO(&households[i])
+	}
+	return response, nil
+}
+
+// GetHousehold retrieves a household of a user with its members, and its
+// pending invitations for owners
+func (s *HouseholdService) GetHousehold(ctx context.Context, user, id string) (*dto.HouseholdResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+	if err := s.authorize(ctx, id, user, models.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	household, err := s.db.GetHousehold(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.db.ListHouseholdMembers(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	response := householdToDTO(household)
+	response.Members = make([]dto.HouseholdMemberResponse, len(members))
+	for i, member := range members {
+		response.Members[i] = dto.HouseholdMemberResponse{
+			Member:   member.Member,
+			Role:     member.Role,
+			JoinedAt: member.CreatedAt.Format(time.RFC3339),
+		}
+		if member.Member == user {
+			response.Role = member.Role
+		}
+	}
+
+	if response.Role == models.RoleOwner {
+		invitations, err := s.db.ListHouseholdInvitations(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		response.Invitations = make([]dto.HouseholdInvitationResponse, len(invitations))
+		for i := range invitations {
+			response.Invitations[i] = invitationToDTO(&invitations[i])
+		}
+	}
+
+	return &response, nil
+}
+
+// CreateHousehold creates a household owned by a user
+func (s *HouseholdService) CreateHousehold(ctx context.Context, user string, req dto.HouseholdRequest) (*dto.HouseholdResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+	if user == "" {
+		return nil, ErrTokenRequired
+	}
+
+	name, err := householdName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.db.CreateHousehold(ctx, name, user)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Household created", "id", id, "name", name, "owner", user)
+
+	return s.GetHousehold(ctx, user, id)
+}
+
+// RenameHousehold renames a household, for its owners
+func (s *HouseholdService) RenameHousehold(ctx context.Context, user, id string, req dto.HouseholdRequest) (*dto.HouseholdResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+	if err := s.authorize(ctx, id, user, models.RoleOwner); err != nil {
+		return nil, err
+	}
+
+	name, err := householdName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.RenameHousehold(ctx, id, name); err != nil {
+		return nil, err
+	}
+
+	return s.GetHousehold(ctx, user, id)
+}
+
+// DeleteHousehold deletes a household without receipts, for its owners
+func (s *HouseholdService) DeleteHousehold(ctx context.Context, user, id string) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+	if err := s.authorize(ctx, id, user, models.RoleOwner); err != nil {
+		return err
+	}
+
+	if err := s.db.DeleteHousehold(ctx, id); err != nil {
+		return err
+	}
+	log.Info("Household deleted", "id", id, "by", user)
+
+	return nil
+}
+
+// SetMemberRole changes the role of a member, for the owners of the household
+func (s *HouseholdService) SetMemberRole(ctx context.Context, user, id, member string, req dto.HouseholdMemberRequest) (*dto.HouseholdResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+	if err := s.authorize(ctx, id, user, models.RoleOwner); err != nil {
+		return nil, err
+	}
+
+	role, issue := householdRole(req.Role, "")
+	if issue != "" {
+		return nil, &ValidationError{Issues: []string{issue}}
+	}
+	if err := s.db.SetHouseholdRole(ctx, id, member, role); err != nil {
+		return nil, err
+	}
+	log.Info("Household member role changed", "id", id, "member", member, "role", role, "by", user)
+
+	return s.GetHousehold(ctx, user, id)
+}
+
+// RemoveMember removes a member from a household: any member for its owners,
+// or the user themselves, leaving it
+func (s *HouseholdService) RemoveMember(ctx context.Context, user, id, member string) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+
+	role := models.RoleOwner
+	if member == user {
+		role = models.RoleViewer
+	}
+	if err := s.authorize(ctx, id, user, role); err != nil {
+		return err
+	}
+
+	if err := s.db.RemoveHouseholdMember(ctx, id, member); err != nil {
+		return err
+	}
+	log.Info("Household member removed", "id", id, "member", member, "by", user)
+
+	return nil
+}
+
+// InviteMember invites a user to a household, for its owners
+func (s *HouseholdService) InviteMember(ctx context.Context, user, id string, req dto.HouseholdInvitationRequest) (*dto.HouseholdInvitationResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+	if err := s.authorize(ctx, id, user, models.RoleOwner); err != nil {
+		return nil, err
+	}
+
+	invitation := &models.HouseholdInvitation{
+		HouseholdID: id,
+		Invitee:     strings.TrimSpace(req.Member),
+		InvitedBy:   user,
+	}
+	issues := []string{}
+	if invitation.Invitee == "" {
+		issues = append(issues, "the member is required")
+	} else if len(invitation.Invitee) > maxMemberLength {
+		issues = append(issues, fmt.Sprintf("the member is longer than %d characters", maxMemberLength))
+	}
+	role, issue := householdRole(req.Role, models.RoleViewer)
+	if issue != "" {
+		issues = append(issues, issue)
+	}
+	if len(issues) > 0 {
+		return nil, &ValidationError{Issues: issues}
+	}
+	invitation.Role = role
+
+	invitationID, err := s.db.CreateInvitation(ctx, invitation)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Household invitation created", "id", invitationID, "household", id, "invitee", invitation.Invitee, "role", role)
+
+	created, err := s.db.GetInvitation(ctx, invitationID)
+	if err != nil {
+		return nil, err
+	}
+	response := invitationToDTO(created)
+	return &response, nil
+}
+
+// ListInvitations retrieves the pending invitations of a user
+func (s *HouseholdService) ListInvitations(ctx context.Context, user string) ([]dto.HouseholdInvitationResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+	if user == "" {
+		return nil, ErrTokenRequired
+	}
+
+	invitations, err := s.db.ListInvitations(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.HouseholdInvitationResponse, len(invitations))
+	for i := range invitations {
+		response[i] = invitationToDTO(&invitations[i])
+	}
+	return response, nil
+}
+
+// AcceptInvitation makes a user a member of the household they were invited
+// to, with the role of the invitation
+func (s *HouseholdService) AcceptInvitation(ctx context.Context, user, id string) (*dto.HouseholdResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	invitation, err := s.invitationOf(ctx, user, id)
+	if err != nil {
+		return nil, err
+	}
+	if invitation.Invitee != user {
+		return nil, database.ErrInvitationNotFound
+	}
+
+	if err := s.db.AcceptInvitation(ctx, id); err != nil {
+		return nil, err
+	}
+	log.Info("Household invitation accepted", "id", id, "household", invitation.HouseholdID, "member", user)
+
+	return s.GetHousehold(ctx, user, invitation.HouseholdID)
+}
+
+// DeleteInvitation declines an invitation of a user, or cancels an invitation
+// to a household they own
+func (s *HouseholdService) DeleteInvitation(ctx context.Context, user, id string) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+
+	invitation, err := s.invitationOf(ctx, user, id)
+	if err != nil {
+		return err
+	}
+	if invitation.Invitee != user {
+		err := s.authorize(ctx, invitation.HouseholdID, user, models.RoleOwner)
+		if errors.Is(err, database.ErrHouseholdNotFound) {
+			return database.ErrInvitationNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.db.DeleteInvitation(ctx, id)
+}
+
+// invitationOf retrieves an invitation for a user
+func (s *HouseholdService) invitationOf(ctx context.Context, user, id string) (*models.HouseholdInvitation, error) {
+	if user == "" {
+		return nil, ErrTokenRequired
+	}
+	return s.db.GetInvitation(ctx, id)
+}
+
+// MoveReceipt moves a receipt to a household, or out of any household when
+// the household is empty, where only its owner sees it. The caller edits the
+// receipt in both households.
+func (s *HouseholdService) MoveReceipt(ctx context.Context, caller *Caller, receiptID string, req dto.MoveReceiptRequest) (*dto.ReceiptResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	user := caller.User()
+	if err := s.AuthorizeReceipt(ctx, caller, receiptID, models.RoleEditor); err != nil {
+		return nil, err
+	}
+	householdID := strings.TrimSpace(req.HouseholdID)
+	if householdID != "" {
+		if err := s.authorize(ctx, householdID, user, models.RoleEditor); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.db.MoveReceipt(ctx, receiptID, householdID); err != nil {
+		return nil, err
+	}
+	log.Info("Receipt moved", "id", receiptID, "household", householdID, "by", user)
+
+	receipt, err := s.receipts.GetReceipt(ctx, receiptID)
+	if err != nil {
+		return nil, err
+	}
+	s.receipts.emit(ctx, models.EventReceiptUpdated, receipt)
+	return receipt, nil
+}
+
+// householdName validates the name of a household
+func householdName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", &ValidationError{Issues: []string{"the name is required"}}
+	}
+	if len(name) > maxHouseholdNameLength {
+		return "", &ValidationError{Issues: []string{fmt.Sprintf("the name is longer than %d characters", maxHouseholdNameLength)}}
+	}
+	return name, nil
+}
+
+// householdRole normalises a role, defaulting to fallback when empty. It
+// returns the issue of unknown roles.
+func householdRole(role, fallback string) (string, string) {
+	role = strings.ToLower(strings.TrimSpace(role))
+	if role == "" {
+		role = fallback
+	}
+	if !slices.Contains(models.Roles, role) {
+		return "", fmt.Sprintf("unknown role %q (expected %s)", role, strings.Join(models.Roles, ", "))
+	}
+	return role, ""
+}
+
+// householdToDTO converts a household, without its members
+func householdToDTO(household *models.Household) dto.HouseholdResponse {
+	return dto.HouseholdResponse{
+		ID:        household.ID,
+		Name:      household.Name,
+		Role:      household.Role,
+		CreatedAt: household.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// invitationToDTO converts an invitation
+func invitationToDTO(invitation *models.HouseholdInvitation) dto.HouseholdInvitationResponse {
+	return dto.HouseholdInvitationResponse{
+		ID:            invitation.ID,
+		HouseholdID:   invitation.HouseholdID,
+		HouseholdName: invitation.HouseholdName,
+		Member:        invitation.Invitee,
+		Role:          invitation.Role,
+		InvitedBy:     invitation.InvitedBy,
+		CreatedAt:     invitation.CreatedAt.Format(time.RFC3339),
+	}
+}
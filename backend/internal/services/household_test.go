@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// householdRepository keeps the households of receipts and the roles of
+// their members in memory
+type householdRepository struct {
+	database.HouseholdRepository
+
+	receipts map[string]string            // Household of each receipt
+	owners   map[string]string            // Owner of each receipt
+	items    map[string]string            // Receipt of each item
+	roles    map[string]map[string]string // Role of each member of each household
+	moved    map[string]string
+}
+
+func newHouseholdRepository() *householdRepository {
+	return &householdRepository{
+		receipts: map[string]string{"shared": "flat", "family": "home", "mine": "", "legacy": ""},
+		owners:   map[string]string{"mine": "carlos"},
+		items:    map[string]string{"shared-item": "shared"},
+		roles: map[string]map[string]string{
+			"flat": {"ana": models.RoleOwner, "bea": models.RoleEditor, "carlos": models.RoleViewer},
+			"home": {"ana": models.RoleViewer, "bea": models.RoleOwner},
+		},
+		moved: map[string]string{},
+	}
+}
+
+func (r *householdRepository) GetReceiptHousehold(ctx context.Context, receiptID string) (string, string, error) {
+	householdID, ok := r.receipts[receiptID]
+	if !ok {
+		return "", "", database.ErrReceiptNotFound
+	}
+	return householdID, r.owners[receiptID], nil
+}
+
+func (r *householdRepository) HasHouseholds(ctx context.Context) (bool, error) {
+	return len(r.roles) > 0, nil
+}
+
+func (r *householdRepository) GetItemReceiptID(ctx context.Context, itemID string) (string, error) {
+	receiptID, ok := r.items[itemID]
+	if !ok {
+		return "", database.ErrItemNotFound
+	}
+	return receiptID, nil
+}
+
+func (r *householdRepository) GetHouseholdRole(ctx context.Context, id, member string) (string, error) {
+	return r.roles[id][member], nil
+}
+
+func (r *householdRepository) MoveReceipt(ctx context.Context, receiptID, householdID string) error {
+	r.moved[receiptID] = householdID
+	return nil
+}
+
+// userCaller returns the caller of a user with a token of a scope, or of no one
+func userCaller(user, scope string) *Caller {
+	if user == "" {
+		return &Caller{open: true}
+	}
+	return &Caller{Token: &models.APIToken{Owner: user, Scopes: []string{scope}}}
+}
+
+func TestAuthorizeReceipt(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		receipt string
+		role    string
+		wantErr error
+	}{
+		{"own receipt in no household", "carlos", "mine", models.RoleEditor, nil},
+		{"someone else's in no household", "ana", "mine", models.RoleViewer, database.ErrReceiptNotFound},
+		{"no one's in no household", "ana", "legacy", models.RoleViewer, database.ErrReceiptNotFound},
+		{"in no household without tokens", "", "mine", models.RoleEditor, nil},
+		{"viewer sees", "carlos", "shared", models.RoleViewer, nil},
+		{"viewer cannot edit", "carlos", "shared", models.RoleEditor, ErrHouseholdRole},
+		{"editor edits", "bea", "shared", models.RoleEditor, nil},
+		{"owner edits", "ana", "shared", models.RoleEditor, nil},
+		{"not a member", "carlos", "family", models.RoleViewer, database.ErrReceiptNotFound},
+		{"without a user", "", "shared", models.RoleViewer, database.ErrReceiptNotFound},
+		{"unknown receipt", "ana", "missing", models.RoleViewer, database.ErrReceiptNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewHouseholdService(newHouseholdRepository(), nil)
+			err := service.AuthorizeReceipt(context.Background(), userCaller(tt.user, models.ScopeWrite), tt.receipt, tt.role)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("AuthorizeReceipt = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthorizeUnsharedReceipt(t *testing.T) {
+	repository := newHouseholdRepository()
+	service := NewHouseholdService(repository, nil)
+
+	// Admins see the receipts of no one, to move them into households
+	if err := service.AuthorizeReceipt(context.Background(), userCaller("root", models.ScopeAdmin), "legacy", models.RoleEditor); err != nil {
+		t.Errorf("admin, receipt of no one: %v", err)
+	}
+	if err := service.AuthorizeReceipt(context.Background(), userCaller("root", models.ScopeAdmin), "mine", models.RoleViewer); !errors.Is(err, database.ErrReceiptNotFound) {
+		t.Errorf("admin, receipt of someone: %v, want ErrReceiptNotFound", err)
+	}
+
+	// Until a household exists, receipts are seen by everyone
+	repository.roles = nil
+	if err := service.AuthorizeReceipt(context.Background(), userCaller("ana", models.ScopeRead), "mine", models.RoleViewer); err != nil {
+		t.Errorf("without households: %v", err)
+	}
+}
+
+func TestAuthorizeItem(t *testing.T) {
+	service := NewHouseholdService(newHouseholdRepository(), nil)
+
+	if err := service.AuthorizeItem(context.Background(), userCaller("bea", models.ScopeWrite), "shared-item", models.RoleEditor); err != nil {
+		t.Errorf("editor: %v", err)
+	}
+	if err := service.AuthorizeItem(context.Background(), userCaller("dani", models.ScopeRead), "shared-item", models.RoleViewer); !errors.Is(err, database.ErrItemNotFound) {
+		t.Errorf("not a member: %v, want ErrItemNotFound", err)
+	}
+	if err := service.AuthorizeItem(context.Background(), userCaller("ana", models.ScopeRead), "missing", models.RoleViewer); !errors.Is(err, database.ErrItemNotFound) {
+		t.Errorf("unknown item: %v, want ErrItemNotFound", err)
+	}
+}
+
+func TestMoveReceiptAuthorization(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      string
+		receipt   string
+		household string
+		wantErr   error
+	}{
+		{"editor in both", "bea", "shared", "home", nil},
+		{"out of a household", "bea", "shared", "", nil},
+		{"viewer of the source", "carlos", "shared", "", ErrHouseholdRole},
+		{"viewer of the destination", "ana", "shared", "home", ErrHouseholdRole},
+		{"own receipt into a household", "carlos", "mine", "flat", ErrHouseholdRole},
+		{"someone else's receipt", "ana", "mine", "flat", database.ErrReceiptNotFound},
+		{"not a member of the destination", "carlos", "mine", "elsewhere", database.ErrHouseholdNotFound},
+		{"without a user", "", "mine", "flat", ErrTokenRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := newHouseholdRepository()
+			receipts := newMemoryRepository()
+			receipts.receipts[tt.receipt] = &models.Receipt{ID: tt.receipt, StoreName: "LIDL"}
+			service := NewHouseholdService(repository, NewReceiptService(nil, receipts, nil, nil, replayConfig()))
+
+			receipt, err := service.MoveReceipt(context.Background(), userCaller(tt.user, models.ScopeWrite), tt.receipt, dto.MoveReceiptRequest{HouseholdID: tt.household})
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("MoveReceipt failed: %v", err)
+				}
+				if household, ok := repository.moved[tt.receipt]; !ok || household != tt.household || receipt.ID != tt.receipt {
+					t.Errorf("moved %s to %q (%v), want %s to %q", receipt.ID, household, ok, tt.receipt, tt.household)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("MoveReceipt = %v, want %v", err, tt.wantErr)
+			}
+			if len(repository.moved) > 0 {
+				t.Errorf("moved %v", repository.moved)
+			}
+		})
+	}
+}
+
+func TestHouseholdRole(t *testing.T) {
+	tests := []struct {
+		role      string
+		fallback  string
+		want      string
+		wantIssue bool
+	}{
+		{" Editor ", "", models.RoleEditor, false},
+		{"", models.RoleViewer, models.RoleViewer, false},
+		{"", "", "", true},
+		{"admin", models.RoleViewer, "", true},
+	}
+
+	for _, tt := range tests {
+		role, issue := householdRole(tt.role, tt.fallback)
+		if role != tt.want || (issue != "") != tt.wantIssue {
+			t.Errorf("householdRole(%q, %q) = %q, %q", tt.role, tt.fallback, role, issue)
+		}
+	}
+}
+
+// editorRepository knows the households each user edits in
+type editorRepository struct {
+	database.ReceiptRepository
+
+	households map[string][]string
+}
+
+func (r *editorRepository) ListMemberHouseholds(ctx context.Context, member, role string) ([]string, error) {
+	return r.households[member], nil
+}
+
+func TestReceiptHousehold(t *testing.T) {
+	repository := &editorRepository{households: map[string][]string{
+		"ana": {"flat"},
+		"bea": {"flat", "home"},
+	}}
+	service := NewReceiptService(nil, repository, nil, nil, replayConfig())
+
+	tests := []struct {
+		name      string
+		owner     string
+		requested string
+		want      string
+		wantErr   error
+	}{
+		{"the only household", "ana", "", "flat", nil},
+		{"several households", "bea", "", "", nil},
+		{"no household", "carlos", "", "", nil},
+		{"asked for", "bea", " home ", "home", nil},
+		{"asked for without editing in it", "ana", "home", "", ErrHouseholdRole},
+		{"no owner", "", "", "", nil},
+		{"no owner in a household", "", "flat", "", &ValidationError{}},
+	}
+
+	for _, tt := range tests {
+		household, err := service.receiptHousehold(context.Background(), tt.owner, tt.requested)
+		if validationErr, ok := tt.wantErr.(*ValidationError); ok {
+			if !errors.As(err, &validationErr) {
+				t.Errorf("%s: error = %v, want a ValidationError", tt.name, err)
+			}
+			continue
+		}
+		if household != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: receiptHousehold = %q, %v, want %q, %v", tt.name, household, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSharedHousehold(t *testing.T) {
+	repository := &editorRepository{households: map[string][]string{
+		"ana": {"flat"},
+	}}
+
+	tests := []struct {
+		name      string
+		owner     string
+		requested string
+		want      string
+		wantErr   error
+	}{
+		{"kept by the owner", "ana", "", "", nil},
+		{"asked for", "ana", " flat ", "flat", nil},
+		{"asked for without editing in it", "ana", "home", "", ErrHouseholdRole},
+		{"no owner", "", "", "", nil},
+		{"no owner in a household", "", "flat", "", &ValidationError{}},
+	}
+
+	for _, tt := range tests {
+		household, err := sharedHousehold(context.Background(), repository, tt.owner, tt.requested)
+		if validationErr, ok := tt.wantErr.(*ValidationError); ok {
+			if !errors.As(err, &validationErr) {
+				t.Errorf("%s: error = %v, want a ValidationError", tt.name, err)
+			}
+			continue
+		}
+		if household != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: sharedHousehold = %q, %v, want %q, %v", tt.name, household, err, tt.want, tt.wantErr)
+		}
+	}
+}
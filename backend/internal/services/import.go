@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"io"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/importer"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// ImportOptions configures the import of a file
+type ImportOptions struct {
+	Format  string           // csv, json or empty to tell by the file extension
+	Mapping importer.Mapping // Layout of CSV files
+	Owner   string           // When set, the owner of every imported receipt
+	DryRun  bool             // Report what would be imported without saving anything
+
+	// HouseholdID is the household of every imported receipt, by default the
+	// only one Owner edits in (see receiptHousehold)
+	HouseholdID string
+}
+
+// ImportFile imports the receipts of a CSV file or a ticketer JSON backup.
+// Each file is imported all or nothing: if any receipt is invalid or fails to
+// save, none is saved. Duplicates, detected by the receipt hash, are skipped.
+// Problems with the file are reported in the report instead of failing.
+func (s *ReceiptService) ImportFile(ctx context.Context, name string, r io.Reader, opts ImportOptions) (*dto.ImportReport, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	format, err := importer.DetectFormat(name, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	householdID, err := s.receiptHousehold(ctx, opts.Owner, opts.HouseholdID)
+	if err != nil {
+		return nil, err
+	}
+	report := &dto.ImportReport{File: name, Format: format, DryRun: opts.DryRun, Receipts: []dto.ImportedReceipt{}}
+
+	var receipts []*models.Receipt
+	if format == importer.FormatBackup {
+		receipts, err = importer.ReadBackup(r)
+	} else {
+		receipts, err = importer.ReadCSV(r, opts.Mapping)
+	}
+	if err != nil {
+		report.Error = err.Error()
+		return report, nil
+	}
+
+	report.Total = len(receipts)
+	for i, receipt := range receipts {
+		if opts.Owner != "" {
+			receipt.Owner = opts.Owner
+		}
+		receipt.HouseholdID = householdID
+		normalizeReceipt(receipt)
+
+		imported := dto.ImportedReceipt{
+			Index:      i + 1,
+			Store:      receipt.StoreName,
+			BoughtDate: receipt.BoughtDate,
+			Items:      len(receipt.Items),
+		}
+		if issues := validateManualReceipt(receipt); len(issues) > 0 {
+			imported.Status = "invalid"
+			imported.Issues = issues
+			report.Invalid++
+		}
+		report.Receipts = append(report.Receipts, imported)
+	}
+	if report.Invalid > 0 {
+		report.Error = "the file has invalid receipts, nothing was imported"
+		return report, nil
+	}
+
+	outcomes, err := s.db.ImportReceipts(ctx, receipts, opts.DryRun)
+	if err != nil {
+		log.Error("Failed to import file", "file", name, "error", err)
+		report.Error = err.Error()
+		return report, nil
+	}
+
+	for i, outcome := range outcomes {
+		imported := &report.Receipts[i]
+		if outcome.DuplicateOf != "" {
+			imported.Status = "duplicate"
+			imported.DuplicateOf = outcome.DuplicateOf
+			report.Duplicates++
+			continue
+		}
+		imported.Status = "imported"
+		report.Imported++
+		if !opts.DryRun {
+			imported.ReceiptID = outcome.ReceiptID
+			s.receiptSaved(ctx, outcome.ReceiptID)
+		}
+	}
+	report.Committed = !opts.DryRun
+
+	log.Info("File imported", "file", name, "format", format, "dry_run", opts.DryRun,
+		"imported", report.Imported, "duplicates", report.Duplicates)
+	return report, nil
+}
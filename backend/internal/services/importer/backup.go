@@ -0,0 +1,75 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/export"
+)
+
+// ReadBackup reads the receipts of a ticketer JSON backup (GET /export?format=json).
+// Receipt and item IDs are dropped, since they are assigned again on import,
+// and so are the categories, which belong to the products of this instance.
+// Tags and notes are kept.
+func ReadBackup(r io.Reader) ([]*models.Receipt, error) {
+	var backup export.Backup
+	if err := json.NewDecoder(r).Decode(&backup); err != nil {
+		return nil, fmt.Errorf("invalid backup: %w", err)
+	}
+	if backup.Format != export.BackupFormat {
+		return nil, fmt.Errorf("not a ticketer backup (format %q)", backup.Format)
+	}
+	if backup.Version > export.BackupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d (this version reads up to %d)", backup.Version, export.BackupVersion)
+	}
+
+	receipts := make([]*models.Receipt, len(backup.Receipts))
+	for i := range backup.Receipts {
+		receipt := &backup.Receipts[i]
+		receipt.ID = ""
+		for j := range receipt.Items {
+			receipt.Items[j] = models.Item{
+				Name:     receipt.Items[j].Name,
+				Quantity: receipt.Items[j].Quantity,
+				Price:    receipt.Items[j].Price,
+				TaxRate:  receipt.Items[j].TaxRate,
+				Tags:     receipt.Items[j].Tags,
+				Notes:    receipt.Items[j].Notes,
+			}
+		}
+		receipts[i] = receipt
+	}
+	return receipts, nil
+}
+
+// Import formats
+const (
+	FormatCSV    = "csv"
+	FormatBackup = "json"
+)
+
+// ErrUnknownFormat is returned for an import format other than csv and json
+var ErrUnknownFormat = errors.New("unknown import format (expected csv or json)")
+
+// DetectFormat returns the format of a file: the given one, or by its
+// extension when it is empty or "auto"
+func DetectFormat(name, format string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case FormatCSV:
+		return FormatCSV, nil
+	case FormatBackup:
+		return FormatBackup, nil
+	case "", "auto":
+		if strings.EqualFold(filepath.Ext(name), ".json") {
+			return FormatBackup, nil
+		}
+		return FormatCSV, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
@@ -0,0 +1,292 @@
+// Package importer reads receipts from files made elsewhere: spreadsheets
+// exported as CSV and ticketer's own JSON backups.
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// Columns names the CSV header of each field. Receipt, Quantity, LineTotal,
+// Discounts and Owner are optional; one of UnitPrice and LineTotal is required.
+type Columns struct {
+	Receipt   string `json:"receipt"`    // Identifies the receipt of each row; without it rows are grouped by store and date
+	Store     string `json:"store"`      // Store name
+	Date      string `json:"date"`       // Purchase date
+	Product   string `json:"product"`    // Product name
+	Quantity  string `json:"quantity"`   // Quantity, 1 if missing
+	UnitPrice string `json:"unit_price"` // Price per unit
+	LineTotal string `json:"line_total"` // Price of the line, used when there is no unit price
+	Discounts string `json:"discounts"`  // Discounts of the whole receipt, on any of its rows
+	Owner     string `json:"owner"`      // User the receipt belongs to
+}
+
+// Mapping describes the layout of a CSV file
+type Mapping struct {
+	Columns    Columns `json:"columns"`
+	Delimiter  string  `json:"delimiter"`   // Field separator, "," by default
+	DateFormat string  `json:"date_format"` // Go time layout, "2006-01-02" by default (e.g. "02/01/2006")
+	// DecimalComma reads numbers as "1.234,56" instead of "1,234.56"
+	DecimalComma bool `json:"decimal_comma"`
+}
+
+// DefaultMapping reads the CSV export of ticketer
+func DefaultMapping() Mapping {
+	return Mapping{
+		Columns: Columns{
+			Receipt:   "receipt_id",
+			Store:     "store",
+			Date:      "date",
+			Product:   "product",
+			Quantity:  "quantity",
+			UnitPrice: "unit_price",
+			LineTotal: "line_total",
+			Discounts: "receipt_discounts",
+		},
+		Delimiter:  ",",
+		DateFormat: "2006-01-02",
+	}
+}
+
+// ParseMapping reads a JSON mapping spec. Omitted settings keep the values of
+// DefaultMapping, but the columns are taken as given.
+func ParseMapping(data []byte) (Mapping, error) {
+	mapping := DefaultMapping()
+	mapping.Columns = Columns{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&mapping); err != nil {
+		return Mapping{}, fmt.Errorf("invalid mapping: %w", err)
+	}
+	return mapping, nil
+}
+
+// columnIndexes are the positions of the mapped columns, -1 when not mapped
+type columnIndexes struct {
+	receipt, store, date, product, quantity, unitPrice, lineTotal, discounts, owner int
+}
+
+// ReadCSV reads the receipts of a CSV file with a header row, one row per
+// item. Receipts keep the order in which they first appear.
+func ReadCSV(r io.Reader, mapping Mapping) ([]*models.Receipt, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	if mapping.Delimiter != "" {
+		delimiter := []rune(mapping.Delimiter)
+		if len(delimiter) != 1 {
+			return nil, fmt.Errorf("invalid mapping: the delimiter must be a single character")
+		}
+		reader.Comma = delimiter[0]
+	}
+	dateFormat := mapping.DateFormat
+	if dateFormat == "" {
+		dateFormat = "2006-01-02"
+	}
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns, err := mapColumns(header, mapping.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	receipts := []*models.Receipt{}
+	byKey := map[string]*models.Receipt{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		if isBlank(record) {
+			continue
+		}
+
+		row, err := readRow(record, columns, dateFormat, mapping.DecimalComma)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		key := row.receiptKey
+		if key == "" {
+			key = row.receipt.StoreName + "\x00" + row.receipt.BoughtDate
+		}
+		receipt, ok := byKey[key]
+		if !ok {
+			receipt = row.receipt
+			byKey[key] = receipt
+			receipts = append(receipts, receipt)
+		} else if receipt.Discounts == 0 {
+			receipt.Discounts = row.receipt.Discounts
+		}
+		receipt.Items = append(receipt.Items, row.item)
+	}
+
+	if len(receipts) == 0 {
+		return nil, fmt.Errorf("the CSV file has no rows")
+	}
+	return receipts, nil
+}
+
+// mapColumns finds the mapped columns in the header, by case-insensitive name
+func mapColumns(header []string, names Columns) (columnIndexes, error) {
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff") // Byte order mark of spreadsheet exports
+	}
+
+	// Every mapped column must be in the header, and the required ones mapped
+	missing := []string{}
+	find := func(field, name string, required bool) int {
+		if name == "" {
+			if required {
+				missing = append(missing, field+" (not mapped)")
+			}
+			return -1
+		}
+		for i, column := range header {
+			if strings.EqualFold(strings.TrimSpace(column), strings.TrimSpace(name)) {
+				return i
+			}
+		}
+		missing = append(missing, fmt.Sprintf("%s (%q)", field, name))
+		return -1
+	}
+
+	columns := columnIndexes{
+		receipt:   find("receipt", names.Receipt, false),
+		store:     find("store", names.Store, true),
+		date:      find("date", names.Date, true),
+		product:   find("product", names.Product, true),
+		quantity:  find("quantity", names.Quantity, false),
+		unitPrice: find("unit_price", names.UnitPrice, false),
+		lineTotal: find("line_total", names.LineTotal, false),
+		discounts: find("discounts", names.Discounts, false),
+		owner:     find("owner", names.Owner, false),
+	}
+	if len(missing) > 0 {
+		return columns, fmt.Errorf("the CSV header lacks the columns of %s", strings.Join(missing, ", "))
+	}
+	if columns.unitPrice < 0 && columns.lineTotal < 0 {
+		return columns, fmt.Errorf("the CSV header lacks a price column: map unit_price or line_total")
+	}
+
+	return columns, nil
+}
+
+// csvRow is an item read from a row, with the receipt it belongs to
+type csvRow struct {
+	receiptKey string
+	receipt    *models.Receipt
+	item       models.Item
+}
+
+// readRow reads the item and receipt fields of a row
+func readRow(record []string, columns columnIndexes, dateFormat string, decimalComma bool) (csvRow, error) {
+	field := func(i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	number := func(name string, i int, fallback float64) (float64, error) {
+		value := field(i)
+		if value == "" {
+			return fallback, nil
+		}
+		parsed, err := parseNumber(value, decimalComma)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q", name, value)
+		}
+		return parsed, nil
+	}
+
+	date, err := time.Parse(dateFormat, field(columns.date))
+	if err != nil {
+		return csvRow{}, fmt.Errorf("invalid date %q (expected the format %s)", field(columns.date), dateFormat)
+	}
+
+	quantity, err := number("quantity", columns.quantity, 1)
+	if err != nil {
+		return csvRow{}, err
+	}
+	unitPrice, err := number("unit price", columns.unitPrice, math.NaN())
+	if err != nil {
+		return csvRow{}, err
+	}
+	if math.IsNaN(unitPrice) {
+		lineTotal, err := number("line total", columns.lineTotal, math.NaN())
+		if err != nil {
+			return csvRow{}, err
+		}
+		if math.IsNaN(lineTotal) {
+			return csvRow{}, errors.New("the row has no price")
+		}
+		if quantity == 0 {
+			return csvRow{}, errors.New("the row has a line total but no quantity")
+		}
+		unitPrice = math.Round(lineTotal/quantity*100) / 100
+	}
+	discounts, err := number("discounts", columns.discounts, 0)
+	if err != nil {
+		return csvRow{}, err
+	}
+
+	return csvRow{
+		receiptKey: field(columns.receipt),
+		receipt: &models.Receipt{
+			StoreName:  field(columns.store),
+			BoughtDate: date.Format("2006-01-02"),
+			Items:      []models.Item{},
+			Discounts:  discounts,
+			Owner:      field(columns.owner),
+		},
+		item: models.Item{
+			Name:     field(columns.product),
+			Quantity: quantity,
+			Price:    unitPrice,
+		},
+	}, nil
+}
+
+// parseNumber reads a number written with thousands separators and an
+// optional currency symbol, e.g. "1,234.56", "1.234,56 €" or "-2.50"
+func parseNumber(value string, decimalComma bool) (float64, error) {
+	value = strings.NewReplacer("€", "", "EUR", "", " ", "", " ", "").Replace(value)
+	if decimalComma {
+		value = strings.ReplaceAll(value, ".", "")
+		value = strings.ReplaceAll(value, ",", ".")
+	} else {
+		value = strings.ReplaceAll(value, ",", "")
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// isBlank reports whether every field of a record is empty
+func isBlank(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package importer
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/export"
+)
+
+func TestParseMapping(t *testing.T) {
+	mapping, err := ParseMapping([]byte(`{"columns": {"store": "Tienda", "date": "Fecha", "product": "Producto", "line_total": "Importe"}, "decimal_comma": true}`))
+	if err != nil {
+		t.Fatalf("ParseMapping failed: %v", err)
+	}
+
+	// The settings left out keep their defaults, but the columns do not
+	if mapping.Delimiter != "," || mapping.DateFormat != "2006-01-02" || !mapping.DecimalComma {
+		t.Errorf("settings = %q, %q, %v, want the defaults with a decimal comma", mapping.Delimiter, mapping.DateFormat, mapping.DecimalComma)
+	}
+	want := Columns{Store: "Tienda", Date: "Fecha", Product: "Producto", LineTotal: "Importe"}
+	if mapping.Columns != want {
+		t.Errorf("columns = %+v, want %+v", mapping.Columns, want)
+	}
+
+	for _, spec := range []string{`{"columns": {"price": "Precio"}}`, `{"separator": ";"}`, `{"columns": `} {
+		if _, err := ParseMapping([]byte(spec)); err == nil {
+			t.Errorf("ParseMapping(%s) succeeded", spec)
+		}
+	}
+}
+
+func TestMapColumns(t *testing.T) {
+	header := []string{"\ufeffStore", " Date ", "Product", "Price"}
+
+	tests := []struct {
+		name    string
+		columns Columns
+		wantErr string
+	}{
+		{"found by name, whatever the case", Columns{Store: "store", Date: "DATE", Product: "product", UnitPrice: "price"}, ""},
+		{"required column not mapped", Columns{Store: "store", Product: "product", UnitPrice: "price"}, "date (not mapped)"},
+		{"mapped column not in the header", Columns{Store: "store", Date: "date", Product: "product", UnitPrice: "price", Owner: "user"}, `owner ("user")`},
+		{"no price", Columns{Store: "store", Date: "date", Product: "product"}, "price column"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			columns, err := mapColumns(append([]string(nil), header...), tt.columns)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("mapColumns failed: %v", err)
+				}
+				if columns.store != 0 || columns.date != 1 || columns.unitPrice != 3 || columns.receipt != -1 {
+					t.Errorf("columns = %+v", columns)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("mapColumns = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadCSVDecimalComma(t *testing.T) {
+	// A Spanish bank or spreadsheet export: semicolons, dates with slashes,
+	// decimal commas and only the line totals
+	data := "Tienda;Fecha;Producto;Cantidad;Importe;Descuento\n" +
+		"MERCADONA;15/03/2024;LECHE;6;5,34 €;0,50\n" +
+		"MERCADONA;15/03/2024;ACEITE;1;1.234,56;\n" +
+		";;;;;\n" +
+		"LIDL;16/03/2024;PAN;;1,20;\n"
+	mapping := Mapping{
+		Columns:      Columns{Store: "Tienda", Date: "Fecha", Product: "Producto", Quantity: "Cantidad", LineTotal: "Importe", Discounts: "Descuento"},
+		Delimiter:    ";",
+		DateFormat:   "02/01/2006",
+		DecimalComma: true,
+	}
+
+	receipts, err := ReadCSV(strings.NewReader(data), mapping)
+	if err != nil {
+		t.Fatalf("ReadCSV failed: %v", err)
+	}
+
+	// Without a receipt column, rows are grouped by store and date
+	if len(receipts) != 2 {
+		t.Fatalf("got %d receipts, want 2", len(receipts))
+	}
+	first := receipts[0]
+	if first.StoreName != "MERCADONA" || first.BoughtDate != "2024-03-15" || first.Discounts != 0.50 || len(first.Items) != 2 {
+		t.Errorf("first receipt = %+v", first)
+	}
+	checkItem(t, first.Items[0], "LECHE", 6, 0.89)
+	checkItem(t, first.Items[1], "ACEITE", 1, 1234.56)
+
+	// A missing quantity is one unit
+	checkItem(t, receipts[1].Items[0], "PAN", 1, 1.20)
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	mapping := Mapping{Columns: Columns{Store: "store", Date: "date", Product: "product", Quantity: "quantity", LineTotal: "total"}}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"empty", "", "empty"},
+		{"no rows", "store,date,product,quantity,total\n", "no rows"},
+		{"invalid date", "store,date,product,quantity,total\nLIDL,15/03/2024,PAN,1,1.20\n", "line 2: invalid date"},
+		{"invalid amount", "store,date,product,quantity,total\nLIDL,2024-03-15,PAN,1,gratis\n", `invalid line total "gratis"`},
+		{"line total without quantity", "store,date,product,quantity,total\nLIDL,2024-03-15,PAN,0,1.20\n", "no quantity"},
+		{"no price", "store,date,product,quantity,total\nLIDL,2024-03-15,PAN,1,\n", "no price"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receipts, err := ReadCSV(strings.NewReader(tt.data), mapping)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReadCSV = %v, %v, want an error about %s", receipts, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadCSVRoundTrip(t *testing.T) {
+	rows := []export.Row{
+		{ReceiptID: "r1", StoreName: "LIDL", BoughtDate: "2024-03-15", Product: "LECHE, ENTERA", Quantity: 2, UnitPrice: 0.92, LineTotal: 1.84, Discounts: 0.50},
+		{ReceiptID: "r1", StoreName: "LIDL", BoughtDate: "2024-03-15", Product: "PLATANO", Quantity: 0.508, UnitPrice: 2.49, LineTotal: 1.26, Discounts: 0.50},
+		// Same store and date, but another receipt
+		{ReceiptID: "r2", StoreName: "LIDL", BoughtDate: "2024-03-15", Product: "PAN", Quantity: 1, UnitPrice: 1.35, LineTotal: 1.35},
+	}
+
+	format, err := export.LookupFormat("csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	writer, err := format.NewWriter(&buf, export.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := writer.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	receipts, err := ReadCSV(&buf, DefaultMapping())
+	if err != nil {
+		t.Fatalf("ReadCSV failed: %v", err)
+	}
+
+	// The receipt column keeps receipts of the same store and date apart
+	if len(receipts) != 2 || len(receipts[0].Items) != 2 || len(receipts[1].Items) != 1 {
+		t.Fatalf("receipts = %+v, want 2 with 2 and 1 items", receipts)
+	}
+	if receipts[0].StoreName != "LIDL" || receipts[0].BoughtDate != "2024-03-15" || receipts[0].Discounts != 0.50 || receipts[1].Discounts != 0 {
+		t.Errorf("receipts = %+v, %+v", receipts[0], receipts[1])
+	}
+	checkItem(t, receipts[0].Items[0], "LECHE, ENTERA", 2, 0.92)
+	checkItem(t, receipts[0].Items[1], "PLATANO", 0.508, 2.49)
+	checkItem(t, receipts[1].Items[0], "PAN", 1, 1.35)
+}
+
+func checkItem(t *testing.T, item models.Item, name string, quantity, price float64) {
+	t.Helper()
+	if item.Name != name || math.Abs(item.Quantity-quantity) > 0.001 || math.Abs(item.Price-price) > 0.001 {
+		t.Errorf("item = %s %v x %v, want %s %v x %v", item.Name, item.Quantity, item.Price, name, quantity, price)
+	}
+}
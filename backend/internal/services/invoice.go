@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/parser"
+)
+
+// InvoiceResult is the outcome of importing one invoice of an e-invoice file
+type InvoiceResult struct {
+	ProcessResult
+	DocumentNumber string
+}
+
+// ImportInvoice imports a Facturae or UBL e-invoice of an owner, one receipt
+// per invoice of the file, in a household (see receiptHousehold). The invoices carry exact amounts, so they are saved as they
+// are instead of going through extraction; an invoice that fails validation
+// or is a duplicate is reported in its result without failing the others.
+func (s *ReceiptService) ImportInvoice(ctx context.Context, data []byte, owner, householdID string) ([]InvoiceResult, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	householdID, err := s.receiptHousehold(ctx, owner, householdID)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	invoices, err := parser.ParseInvoice(data)
+	if err != nil {
+		return nil, err
+	}
+	latency := time.Since(start).Milliseconds()
+
+	results := make([]InvoiceResult, len(invoices))
+	for i, parsed := range invoices {
+		receipt := parsed.Receipt
+		receipt.Owner = owner
+		receipt.HouseholdID = householdID
+		normalizeReceipt(receipt)
+
+		issues := validateManualReceipt(receipt)
+		extraction := &models.Extraction{
+			StoreAnswer: receipt.StoreName,
+			ItemsStage: models.ExtractionStage{
+				Model:         "rule-based",
+				PromptVersion: parsed.Version,
+				LatencyMs:     latency,
+			},
+			Attempt:     1,
+			Strategy:    "import",
+			Issues:      issues,
+			Selected:    true,
+			RawResponse: parsed.Text,
+		}
+
+		results[i].DocumentNumber = receipt.DocumentNumber
+		if len(issues) > 0 {
+			results[i].SaveErr = &ValidationError{Issues: issues}
+		} else if receiptID, err := s.db.CreateReceipt(ctx, receipt); err != nil {
+			log.Warn("Failed to save imported invoice", "document", receipt.DocumentNumber, "error", err)
+			results[i].SaveErr = err
+		} else {
+			log.Info("Invoice imported", "id", receiptID, "document", receipt.DocumentNumber, "store", receipt.StoreName, "format", parsed.Version)
+			receipt.ID = receiptID
+		}
+
+		s.saveExtractions(ctx, []*models.Extraction{extraction}, receipt.ID)
+		s.receiptSaved(ctx, receipt.ID)
+		results[i].Receipt = s.modelToDTO(receipt)
+	}
+
+	return results, nil
+}
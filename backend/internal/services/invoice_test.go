@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/services/parser"
+	"github.com/vieitesss/ticketer/internal/services/webhooks"
+)
+
+// newMemoryService creates a receipt service without AI that saves to memory
+func newMemoryService() (*ReceiptService, *memoryRepository) {
+	cfg := replayConfig()
+	repository := newMemoryRepository()
+	dispatcher := webhooks.NewDispatcher(repository, cfg)
+	budgets := NewBudgetService(repository, repository, dispatcher, cfg)
+	return NewReceiptService(nil, repository, dispatcher, budgets, cfg), repository
+}
+
+func TestImportInvoice(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("parser", "testdata", "facturae.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, repository := newMemoryService()
+
+	results, err := service.ImportInvoice(context.Background(), data, "", "")
+	if err != nil {
+		t.Fatalf("ImportInvoice failed: %v", err)
+	}
+
+	// Every invoice of the file is a receipt of its own
+	if len(results) != 2 || len(repository.receipts) != 2 {
+		t.Fatalf("got %d results and %d receipts, want 2", len(results), len(repository.receipts))
+	}
+	for i, want := range []string{"A-0001", "A-0002"} {
+		result := results[i]
+		if result.SaveErr != nil || result.Receipt.ID == "" || result.DocumentNumber != want {
+			t.Errorf("invoice %d = %q saved as %q (%v), want %s saved", i, result.DocumentNumber, result.Receipt.ID, result.SaveErr, want)
+		}
+	}
+
+	// Their provenance is the format of the file, without a model
+	if len(repository.extractions) != 2 {
+		t.Fatalf("got %d extractions, want 2", len(repository.extractions))
+	}
+	for _, extraction := range repository.extractions {
+		if extraction.Strategy != "import" || extraction.ItemsStage.PromptVersion != "facturae-3.2.2" || !extraction.Selected {
+			t.Errorf("extraction = %q with %q (selected %v), want the selected import with facturae-3.2.2",
+				extraction.Strategy, extraction.ItemsStage.PromptVersion, extraction.Selected)
+		}
+	}
+
+	// Importing the file again reports each invoice as a duplicate
+	results, err = service.ImportInvoice(context.Background(), data, "", "")
+	if err != nil {
+		t.Fatalf("importing again failed: %v", err)
+	}
+	for i, result := range results {
+		if result.DuplicateOf() == "" {
+			t.Errorf("invoice %d imported again: %v, want a duplicate", i, result.SaveErr)
+		}
+	}
+	if len(repository.receipts) != 2 {
+		t.Errorf("got %d receipts after importing again, want 2", len(repository.receipts))
+	}
+}
+
+func TestImportInvoiceInvalid(t *testing.T) {
+	service, repository := newMemoryService()
+
+	_, err := service.ImportInvoice(context.Background(), []byte("<CreditNote/>"), "", "")
+	if !errors.Is(err, parser.ErrInvalidInvoice) {
+		t.Errorf("ImportInvoice = %v, want ErrInvalidInvoice", err)
+	}
+	if len(repository.receipts) != 0 {
+		t.Errorf("saved %d receipts", len(repository.receipts))
+	}
+}
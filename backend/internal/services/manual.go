@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// ErrNoExtractor is returned by operations that need an AI provider when none is configured
+var ErrNoExtractor = errors.New("receipt extraction is not available: no AI provider configured")
+
+// ErrNoDatabase is returned by operations that need persistence when no database is configured
+var ErrNoDatabase = errors.New("no database configured")
+
+// ValidationError lists why a receipt was rejected
+type ValidationError struct {
+	Issues []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid receipt: " + strings.Join(e.Issues, "; ")
+}
+
+// CreateReceipt saves a receipt entered by hand (or imported), going through
+// the same normalization, validation, hashing and persistence as extracted ones
+func (s *ReceiptService) CreateReceipt(ctx context.Context, receipt *models.Receipt) (*dto.ReceiptResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	normalizeReceipt(receipt)
+	if issues := validateManualReceipt(receipt); len(issues) > 0 {
+		return nil, &ValidationError{Issues: issues}
+	}
+	householdID, err := s.receiptHousehold(ctx, receipt.Owner, receipt.HouseholdID)
+	if err != nil {
+		return nil, err
+	}
+	receipt.HouseholdID = householdID
+
+	receiptID, err := s.db.CreateReceipt(ctx, receipt)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Manual receipt saved to database", "id", receiptID, "store", receipt.StoreName, "items", len(receipt.Items))
+
+	s.receiptSaved(ctx, receiptID)
+
+	return s.GetReceipt(ctx, receiptID)
+}
+
+// normalizeReceipt applies the normalization rules the extraction prompt asks
+// the model for: UPPERCASE names without extra spaces
+func normalizeReceipt(receipt *models.Receipt) {
+	receipt.StoreName = normalizeName(receipt.StoreName)
+	receipt.BoughtDate = strings.TrimSpace(receipt.BoughtDate)
+	receipt.Tags = normalizeTags(receipt.Tags)
+	receipt.Notes = strings.TrimSpace(receipt.Notes)
+	for i := range receipt.Items {
+		receipt.Items[i].Name = normalizeName(receipt.Items[i].Name)
+		receipt.Items[i].Tags = normalizeTags(receipt.Items[i].Tags)
+		receipt.Items[i].Notes = strings.TrimSpace(receipt.Items[i].Notes)
+	}
+}
+
+// normalizeName uppercases a name and collapses its whitespace
+func normalizeName(name string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(name), " "))
+}
+
+// validateManualReceipt checks the fields a model never gets wrong in shape but
+// a person or an import can, on top of the extraction validation
+func validateManualReceipt(receipt *models.Receipt) []string {
+	issues := []string{}
+
+	if receipt.StoreName == "" {
+		issues = append(issues, "the store name is missing")
+	}
+
+	if receipt.BoughtDate != "" {
+		if _, err := time.Parse("2006-01-02", receipt.BoughtDate); err != nil {
+			issues = append(issues, fmt.Sprintf("the purchase date %q is not in YYYY-MM-DD format", receipt.BoughtDate))
+		}
+	}
+
+	if receipt.Discounts < 0 {
+		issues = append(issues, "discounts must be non-negative")
+	}
+
+	issues = append(issues, tagIssues(receipt.Tags, "")...)
+
+	for i, item := range receipt.Items {
+		if item.Name == "" {
+			issues = append(issues, fmt.Sprintf("item %d has no name", i+1))
+		}
+		if item.Quantity <= 0 {
+			issues = append(issues, fmt.Sprintf("item %d quantity must be greater than 0", i+1))
+		}
+		if item.Price < 0 {
+			issues = append(issues, fmt.Sprintf("item %d price must be non-negative", i+1))
+		}
+		issues = append(issues, tagIssues(item.Tags, fmt.Sprintf("item %d: ", i+1))...)
+	}
+
+	return append(issues, validateReceipt(receipt)...)
+}
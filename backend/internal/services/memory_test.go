@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// memoryRepository keeps what processing a receipt writes in memory. It
+// implements what the processing pipeline uses; any other method of the
+// repositories panics, pointing at the test that needs it.
+type memoryRepository struct {
+	database.ReceiptRepository
+	database.BudgetRepository
+	database.WebhookRepository
+
+	mu          sync.Mutex
+	receipts    map[string]*models.Receipt
+	extractions []*models.Extraction
+	events      []models.Event
+	webhooks    []string
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{receipts: map[string]*models.Receipt{}}
+}
+
+// receiptKey identifies a receipt by its contents, as the receipt hash does
+func receiptKey(receipt *models.Receipt) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s|%s", receipt.StoreName, receipt.BoughtDate)
+	for _, item := range receipt.Items {
+		fmt.Fprintf(&sb, "|%s:%.3f:%.2f", item.Name, item.Quantity, item.Price)
+	}
+	return sb.String()
+}
+
+func (r *memoryRepository) CreateReceipt(ctx context.Context, receipt *models.Receipt) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := receiptKey(receipt)
+	for id, existing := range r.receipts {
+		if receiptKey(existing) == key {
+			return "", &database.DuplicateReceiptError{ExistingID: id}
+		}
+	}
+
+	id := fmt.Sprintf("receipt-%d", len(r.receipts)+1)
+	saved := *receipt
+	saved.ID = id
+	saved.Items = make([]models.Item, len(receipt.Items))
+	for i, item := range receipt.Items {
+		item.ID = fmt.Sprintf("%s-item-%d", id, i+1)
+		saved.Items[i] = item
+	}
+	r.receipts[id] = &saved
+	return id, nil
+}
+
+func (r *memoryRepository) GetReceipt(ctx context.Context, id string) (*models.Receipt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	receipt, ok := r.receipts[id]
+	if !ok {
+		return nil, database.ErrReceiptNotFound
+	}
+	copied := *receipt
+	return &copied, nil
+}
+
+func (r *memoryRepository) CreateExtraction(ctx context.Context, extraction *models.Extraction) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved := *extraction
+	saved.ID = fmt.Sprintf("extraction-%d", len(r.extractions)+1)
+	r.extractions = append(r.extractions, &saved)
+	return saved.ID, nil
+}
+
+func (r *memoryRepository) GetExtractionByReceipt(ctx context.Context, receiptID string) (*models.Extraction, error) {
+	extractions, _ := r.ListExtractionsByReceipt(ctx, receiptID)
+	if len(extractions) == 0 {
+		return nil, fmt.Errorf("extraction not found")
+	}
+
+	// The selected attempt, or else the latest one
+	kept := extractions[len(extractions)-1]
+	for _, extraction := range extractions {
+		if extraction.Selected {
+			kept = extraction
+		}
+	}
+	return &kept, nil
+}
+
+func (r *memoryRepository) ListExtractionsByReceipt(ctx context.Context, receiptID string) ([]models.Extraction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	extractions := []models.Extraction{}
+	for _, extraction := range r.extractions {
+		if extraction.ReceiptID == receiptID {
+			extractions = append(extractions, *extraction)
+		}
+	}
+	return extractions, nil
+}
+
+func (r *memoryRepository) ListReceiptRules(ctx context.Context, receiptID string) ([]models.Rule, error) {
+	return []models.Rule{}, nil
+}
+
+func (r *memoryRepository) ListReceiptBudgets(ctx context.Context, receiptID string) ([]models.Budget, error) {
+	return []models.Budget{}, nil
+}
+
+func (r *memoryRepository) ListMemberHouseholds(ctx context.Context, member, role string) ([]string, error) {
+	return nil, nil
+}
+
+func (r *memoryRepository) EnqueueWebhookEvent(ctx context.Context, eventID, event string, payload []byte) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.webhooks = append(r.webhooks, event)
+	return 0, nil
+}
+
+func (r *memoryRepository) PublishEvent(ctx context.Context, event *models.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func (r *memoryRepository) Close() {}
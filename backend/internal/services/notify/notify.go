@@ -0,0 +1,80 @@
+// Package notify delivers notifications for the household, such as budget
+// alerts, through pluggable channels: the log, a webhook or email sent through
+// an SMTP relay.
+package notify
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/config"
+)
+
+// Notification is a message for the household
+type Notification struct {
+	Event   string    `json:"event"`   // e.g. budget.warning
+	Subject string    `json:"subject"` // One-line summary
+	Message string    `json:"message"` // Plain text body
+	Data    any       `json:"data,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Notifier delivers notifications through one channel
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// New creates a notifier for the channels of NOTIFY_CHANNELS. Unknown or
+// unconfigured channels are logged and skipped.
+func New(cfg *config.Config) Notifier {
+	var notifiers Multi
+	for _, channel := range strings.Split(cfg.NotifyChannels, ",") {
+		switch channel = strings.ToLower(strings.TrimSpace(channel)); channel {
+		case "", "none":
+		case "log":
+			notifiers = append(notifiers, Log{})
+		case "webhook":
+			if cfg.NotifyWebhookURL == "" {
+				log.Warn("NOTIFY_WEBHOOK_URL is not set, skipping the webhook notifier")
+				continue
+			}
+			notifiers = append(notifiers, NewWebhook(cfg.NotifyWebhookURL))
+		case "smtp":
+			if cfg.NotifySMTPRelay == "" || cfg.NotifySMTPFrom == "" || cfg.NotifySMTPTo == "" {
+				log.Warn("NOTIFY_SMTP_RELAY, NOTIFY_SMTP_FROM and NOTIFY_SMTP_TO are required, skipping the SMTP notifier")
+				continue
+			}
+			notifiers = append(notifiers, NewSMTP(cfg))
+		default:
+			log.Warn("Unknown notification channel, ignoring", "channel", channel)
+		}
+	}
+	return notifiers
+}
+
+// Multi delivers notifications through several notifiers, all of them even
+// when some fail
+type Multi []Notifier
+
+// Notify delivers a notification through every notifier
+func (m Multi) Notify(ctx context.Context, notification Notification) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.Notify(ctx, notification); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Log writes notifications to the application log
+type Log struct{}
+
+// Notify logs a notification
+func (Log) Notify(ctx context.Context, notification Notification) error {
+	log.Info("Notification", "event", notification.Event, "subject", notification.Subject)
+	return nil
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/vieitesss/ticketer/internal/config"
+)
+
+// SMTP emails notifications through a relay, typically a local one
+type SMTP struct {
+	relay    string
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+func NewSMTP(cfg *config.Config) *SMTP {
+	to := []string{}
+	for _, address := range strings.Split(cfg.NotifySMTPTo, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			to = append(to, address)
+		}
+	}
+
+	return &SMTP{
+		relay:    cfg.NotifySMTPRelay,
+		from:     cfg.NotifySMTPFrom,
+		to:       to,
+		username: cfg.NotifySMTPUsername,
+		password: cfg.NotifySMTPPassword,
+	}
+}
+
+// Notify emails a notification to every recipient
+func (s *SMTP) Notify(ctx context.Context, notification Notification) error {
+	var data strings.Builder
+	fmt.Fprintf(&data, "From: %s\r\n", s.from)
+	fmt.Fprintf(&data, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&data, "Subject: %s\r\n", mimeHeader(notification.Subject))
+	fmt.Fprintf(&data, "Date: %s\r\n", notification.Time.Format("Mon, 02 Jan 2006 15:04:05 -0700"))
+	fmt.Fprintf(&data, "Message-ID: <%s@ticketer>\r\n", uuid.New().String())
+	data.WriteString("Auto-Submitted: auto-generated\r\n")
+	data.WriteString("MIME-Version: 1.0\r\n")
+	data.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	data.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	data.WriteString(strings.ReplaceAll(notification.Message, "\n", "\r\n"))
+	data.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if s.username != "" {
+		host, _, err := net.SplitHostPort(s.relay)
+		if err != nil {
+			return fmt.Errorf("invalid notification relay %q: %w", s.relay, err)
+		}
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+
+	if err := smtp.SendMail(s.relay, auth, s.from, s.to, []byte(data.String())); err != nil {
+		return fmt.Errorf("failed to send notification email: %w", err)
+	}
+	return nil
+}
+
+// mimeHeader encodes a header value if it is not plain ASCII
+func mimeHeader(value string) string {
+	for _, r := range value {
+		if r > 127 {
+			return mime.QEncoding.Encode("utf-8", value)
+		}
+	}
+	return value
+}
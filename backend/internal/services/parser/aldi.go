@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// aldiGrammar parses ALDI receipts. Products are "NAME PRICE € CODE" lines,
+// optionally preceded by a "QUANTITY [unit] x PRICE €[/unit]" line that gives
+// the quantity or weight and the unit price of the next product. Items end at
+// the "-----" separator.
+type aldiGrammar struct{}
+
+var (
+	aldiQuantityLine = regexp.MustCompile(`^(\d+(?:,\d+)?)\s*(?:kg|KG|g|G|ud|UD|uds|UDS)?\s*[xX]\s*(\d+(?:,\d+)?)\s*€(?:\s*/\s*\w+)?$`)
+	aldiProductLine  = regexp.MustCompile(`^(.+?)\s+(-?\d+,\d{2})\s*€(?:\s+(\w+))?$`)
+)
+
+func (aldiGrammar) Store() string   { return "ALDI" }
+func (aldiGrammar) Version() string { return "aldi-grammar-v1" }
+
+func (aldiGrammar) Matches(header []string) bool {
+	return containsAny(header, "ALDI")
+}
+
+func (g aldiGrammar) Parse(lines []string) (*models.Receipt, error) {
+	receipt := &models.Receipt{Items: []models.Item{}}
+
+	// Skip the header until the first line that looks like a product or quantity
+	start := 0
+	for start < len(lines) && !aldiQuantityLine.MatchString(lines[start]) && !aldiProductLine.MatchString(lines[start]) {
+		start++
+	}
+
+	var pending *models.Item
+	for _, line := range lines[start:] {
+		if strings.HasPrefix(line, "-----") || isTotalLine(line) {
+			break
+		}
+
+		// Type A: quantity line for the next product
+		if match := aldiQuantityLine.FindStringSubmatch(line); match != nil {
+			quantity, err := parseAmount(match[1])
+			if err != nil {
+				return nil, err
+			}
+			price, err := parseAmount(match[2])
+			if err != nil {
+				return nil, err
+			}
+			pending = &models.Item{Quantity: quantity, Price: price}
+			continue
+		}
+
+		// Type B: product line
+		match := aldiProductLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		name := strings.ToUpper(strings.TrimSpace(match[1]))
+		linePrice, err := parseAmount(match[2])
+		if err != nil {
+			return nil, err
+		}
+
+		// Negative lines are discounts on the previous product
+		if linePrice < 0 {
+			receipt.Discounts += math.Abs(linePrice)
+			pending = nil
+			continue
+		}
+
+		item := models.Item{Name: name, Quantity: 1, Price: linePrice}
+		if pending != nil {
+			item.Quantity = pending.Quantity
+			item.Price = pending.Price
+			pending = nil
+		}
+		receipt.Items = append(receipt.Items, item)
+	}
+
+	receipt.BoughtDate = findDate(lines)
+	receipt.Total = findTotal(lines)
+
+	return receipt, nil
+}
@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// carrefourGrammar parses CARREFOUR and CARREFOUR EXPRESS receipts. Products
+// are "NAME PRICE" lines, or "NAME CODE" lines followed by an
+// "X x ( N ) Y" line with the quantity X and unit price N. Lines starting
+// with DESCUENTO are product discounts, and the bold DESCUENTOS line below
+// "A PAGAR" holds the receipt discounts.
+type carrefourGrammar struct{}
+
+var (
+	carrefourQuantityLine = regexp.MustCompile(`^(\d+(?:,\d+)?)\s*[xX]\s*\(\s*(\d+(?:,\d+)?)\s*\)\s*(-?\d+,\d{2})$`)
+	carrefourPriceLine    = regexp.MustCompile(`^(.+?)\s+(-?\d+,\d{2})\s*€?$`)
+	carrefourDiscounts    = regexp.MustCompile(`^DESCUENTOS\b[^\d-]*(-?\d[\d.]*,\d{2})`)
+	carrefourEndOfItems   = regexp.MustCompile(`^(A PAGAR|TOTAL)\b`)
+)
+
+func (carrefourGrammar) Store() string   { return "CARREFOUR" }
+func (carrefourGrammar) Version() string { return "carrefour-grammar-v1" }
+
+func (carrefourGrammar) Matches(header []string) bool {
+	return containsAny(header, "CARREFOUR")
+}
+
+func (g carrefourGrammar) Parse(lines []string) (*models.Receipt, error) {
+	receipt := &models.Receipt{Items: []models.Item{}}
+
+	// Items start after the header, at the first product-looking line
+	start := 0
+	for start < len(lines) && !carrefourPriceLine.MatchString(lines[start]) {
+		start++
+	}
+	// The header's last lines may be a product without price ("NAME CODE"),
+	// but never the store banner itself
+	if start > 0 && start < len(lines) && carrefourQuantityLine.MatchString(lines[start]) &&
+		!containsAny(lines[start-1:start], "CARREFOUR") {
+		start--
+	}
+
+	productDiscounts := 0.0
+	var pending *models.Item
+	flush := func() {
+		if pending != nil {
+			receipt.Items = append(receipt.Items, *pending)
+			pending = nil
+		}
+	}
+
+	end := len(lines)
+	for i := start; i < len(lines); i++ {
+		line := lines[i]
+		upper := strings.ToUpper(line)
+
+		if carrefourEndOfItems.MatchString(upper) {
+			end = i
+			break
+		}
+
+		// Type B: quantity line for the previous product
+		if match := carrefourQuantityLine.FindStringSubmatch(line); match != nil {
+			if pending == nil {
+				continue
+			}
+			quantity, err := parseAmount(match[1])
+			if err != nil {
+				return nil, err
+			}
+			price, err := parseAmount(match[2])
+			if err != nil {
+				return nil, err
+			}
+			pending.Quantity = quantity
+			pending.Price = price
+			flush()
+			continue
+		}
+
+		// A new product line closes the previous product as a single unit
+		flush()
+
+		// Type C: product discount
+		if strings.HasPrefix(upper, "DESCUENTO") && !strings.HasPrefix(upper, "DESCUENTOS") {
+			if match := carrefourPriceLine.FindStringSubmatch(line); match != nil {
+				if amount, err := parseAmount(match[2]); err == nil {
+					productDiscounts += math.Abs(amount)
+				}
+			}
+			continue
+		}
+
+		// Type A: product with price on the same line
+		if match := carrefourPriceLine.FindStringSubmatch(line); match != nil {
+			price, err := parseAmount(match[2])
+			if err != nil {
+				return nil, err
+			}
+			pending = &models.Item{Name: strings.ToUpper(strings.TrimSpace(match[1])), Quantity: 1, Price: price}
+			continue
+		}
+
+		// Type A: product with code, quantity and price come in the next line
+		if i+1 < len(lines) && carrefourQuantityLine.MatchString(lines[i+1]) {
+			fields := strings.Fields(line)
+			name := line
+			if len(fields) > 1 {
+				name = strings.Join(fields[:len(fields)-1], " ")
+			}
+			pending = &models.Item{Name: strings.ToUpper(name), Quantity: 1}
+		}
+	}
+	flush()
+
+	// Receipt discounts: the DESCUENTOS line after A PAGAR, else the product discounts
+	receipt.Discounts = productDiscounts
+	for _, line := range lines[end:] {
+		if match := carrefourDiscounts.FindStringSubmatch(strings.ToUpper(line)); match != nil {
+			if amount, err := parseAmount(match[1]); err == nil {
+				receipt.Discounts = math.Abs(amount)
+			}
+			break
+		}
+	}
+
+	if containsAny(lines[:min(headerLines, len(lines))], "CARREFOUR EXPRESS") {
+		receipt.StoreName = "CARREFOUR EXPRESS"
+	}
+	receipt.BoughtDate = findDate(lines)
+	receipt.Total = findTotal(lines)
+
+	return receipt, nil
+}
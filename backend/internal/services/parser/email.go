@@ -0,0 +1,235 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"golang.org/x/net/html/charset"
+)
+
+// maxEmailDepth bounds the nesting of multiparts and forwarded messages
+const maxEmailDepth = 10
+
+// Attachment is a file attached to an email
+type Attachment struct {
+	Name string
+	Data []byte
+}
+
+// Email is the part of an email relevant to receipt ingestion
+type Email struct {
+	From       string // Lowercased address of the sender
+	FromName   string // Display name of the sender, if any
+	Subject    string
+	MessageID  string
+	References string
+	Date       time.Time // When the message was sent, zero if unknown
+	// AutoSubmitted is set for automatic messages, which are never replied to
+	AutoSubmitted bool
+
+	Attachments []Attachment
+	HTMLBodies  []string
+	TextBodies  []string
+}
+
+// Sender returns the best guess of the sender's organization: its display
+// name, or the second-level domain of its address
+func (e *Email) Sender() string {
+	if e.FromName != "" {
+		return e.FromName
+	}
+
+	_, domain, ok := strings.Cut(e.From, "@")
+	if !ok {
+		return ""
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return domain
+	}
+	return labels[len(labels)-2]
+}
+
+// mimeExtensions maps the receipt content types to a file extension, for
+// attachments sent without a file name
+var mimeExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/pdf": ".pdf",
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+// ParseEmail reads an RFC 5322 message and collects its attachments and bodies
+func ParseEmail(r io.Reader) (*Email, error) {
+	raw, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	from, err := mail.ParseAddress(raw.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid From header: %w", err)
+	}
+
+	subject, err := wordDecoder.DecodeHeader(raw.Header.Get("Subject"))
+	if err != nil {
+		subject = raw.Header.Get("Subject")
+	}
+
+	autoSubmitted := raw.Header.Get("Auto-Submitted")
+	precedence := strings.ToLower(raw.Header.Get("Precedence"))
+
+	date, _ := raw.Header.Date()
+
+	email := &Email{
+		From:          strings.ToLower(from.Address),
+		FromName:      from.Name,
+		Subject:       subject,
+		MessageID:     raw.Header.Get("Message-ID"),
+		References:    raw.Header.Get("References"),
+		Date:          date,
+		AutoSubmitted: (autoSubmitted != "" && autoSubmitted != "no") || precedence == "bulk" || precedence == "junk" || precedence == "list",
+	}
+
+	if err := email.walk(raw.Header, raw.Body, 0); err != nil {
+		return nil, err
+	}
+
+	return email, nil
+}
+
+// partHeader is the subset of part headers needed to decode a part
+type partHeader interface {
+	Get(key string) string
+}
+
+// walk collects the attachments and bodies of a part, recursing into
+// multiparts and forwarded messages
+func (e *Email) walk(header partHeader, body io.Reader, depth int) error {
+	if depth > maxEmailDepth {
+		return fmt.Errorf("message nested too deeply")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read multipart: %w", err)
+			}
+			if err := e.walk(part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	decoded := decodeTransfer(header.Get("Content-Transfer-Encoding"), body)
+
+	if mediaType == "message/rfc822" {
+		forwarded, err := mail.ReadMessage(decoded)
+		if err != nil {
+			log.Warn("Skipping unreadable forwarded message", "error", err)
+			return nil
+		}
+		return e.walk(forwarded.Header, forwarded.Body, depth+1)
+	}
+
+	data, err := io.ReadAll(decoded)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s part: %w", mediaType, err)
+	}
+
+	name := partFilename(header, params)
+	disposition, _, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+
+	switch {
+	case name != "" || disposition == "attachment" || mimeExtensions[mediaType] != "":
+		// Images referenced from the HTML body are logos, not receipts
+		if header.Get("Content-ID") != "" && disposition != "attachment" {
+			return nil
+		}
+		if name == "" || filepath.Ext(name) == "" {
+			name += mimeExtensions[mediaType]
+		}
+		e.Attachments = append(e.Attachments, Attachment{Name: name, Data: data})
+	case mediaType == "text/html":
+		e.HTMLBodies = append(e.HTMLBodies, decodeCharset(params["charset"], data))
+	case mediaType == "text/plain":
+		e.TextBodies = append(e.TextBodies, decodeCharset(params["charset"], data))
+	}
+
+	return nil
+}
+
+// partFilename returns the decoded base name of a part's file, if any
+func partFilename(header partHeader, contentTypeParams map[string]string) string {
+	name := ""
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = contentTypeParams["name"]
+	}
+	if decoded, err := wordDecoder.DecodeHeader(name); err == nil {
+		name = decoded
+	}
+
+	// Keep only the base name of a name chosen by the sender
+	name = strings.ReplaceAll(name, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// decodeTransfer undoes the Content-Transfer-Encoding of a part
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// decodeCharset converts a text part to UTF-8
+func decodeCharset(label string, data []byte) string {
+	if label == "" || strings.EqualFold(label, "utf-8") || strings.EqualFold(label, "us-ascii") {
+		return string(data)
+	}
+
+	reader, err := charset.NewReaderLabel(label, bytes.NewReader(data))
+	if err != nil {
+		log.Debug("Unknown charset, assuming UTF-8", "charset", label)
+		return string(data)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return string(data)
+	}
+	return string(decoded)
+}
@@ -0,0 +1,561 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// HTMLExtractor extracts receipts from a retailer's HTML e-receipts
+type HTMLExtractor interface {
+	// Store returns the normalized store name, empty for the generic extractor
+	Store() string
+	// Version identifies the extraction rules, recorded as extraction provenance
+	Version() string
+	// Matches reports whether the document comes from this retailer
+	Matches(doc *HTMLDocument) bool
+	// Extract returns the receipt of the document
+	Extract(doc *HTMLDocument) (*models.Receipt, error)
+}
+
+// htmlExtractors lists the retailer extractors, tried in order before the
+// generic one
+var htmlExtractors = []HTMLExtractor{
+	retailerExtractor{store: "MERCADONA", version: "mercadona-html-v1", senders: []string{"mercadona"}, markers: []string{"MERCADONA"}},
+	retailerExtractor{store: "CARREFOUR", version: "carrefour-html-v1", senders: []string{"carrefour"}, markers: []string{"CARREFOUR"}},
+	retailerExtractor{store: "DIA", version: "dia-html-v1", senders: []string{"dia"}, markers: []string{"SUPERMERCADOS DIA", "DIA.ES"}},
+	retailerExtractor{store: "ALCAMPO", version: "alcampo-html-v1", senders: []string{"alcampo"}, markers: []string{"ALCAMPO"}},
+	retailerExtractor{store: "EL CORTE INGLES", version: "elcorteingles-html-v1", senders: []string{"elcorteingles"}, markers: []string{"EL CORTE INGLÉS", "EL CORTE INGLES"}},
+}
+
+// ParseHTML extracts a receipt from an HTML e-receipt without any network
+// call, with the first matching retailer extractor or the generic table
+// heuristic. It returns ErrUnparseable if the result is incomplete.
+func ParseHTML(doc *HTMLDocument) (*models.Receipt, HTMLExtractor, error) {
+	extractor := HTMLExtractor(genericExtractor{})
+	for _, candidate := range htmlExtractors {
+		if candidate.Matches(doc) {
+			extractor = candidate
+			break
+		}
+	}
+
+	log.Debug("Parsing HTML receipt", "store", extractor.Store(), "extractor", extractor.Version())
+	receipt, err := extractor.Extract(doc)
+	if err != nil {
+		return nil, extractor, fmt.Errorf("%w: %s: %v", ErrUnparseable, extractor.Version(), err)
+	}
+
+	if len(receipt.Items) == 0 {
+		return nil, extractor, fmt.Errorf("%w: %s: no items found", ErrUnparseable, extractor.Version())
+	}
+	if receipt.BoughtDate == "" {
+		return nil, extractor, fmt.Errorf("%w: %s: no date found", ErrUnparseable, extractor.Version())
+	}
+
+	log.Info("Parsed HTML receipt",
+		"store", receipt.StoreName,
+		"bought_date", receipt.BoughtDate,
+		"items", len(receipt.Items),
+		"discounts", receipt.Discounts)
+	return receipt, extractor, nil
+}
+
+// retailerExtractor identifies a retailer by the email sender or by markers
+// in the title and header, and reads its item table with the table heuristic
+type retailerExtractor struct {
+	store   string
+	version string
+	senders []string // Sender organizations, compared case-insensitively
+	markers []string // Uppercase texts found in the title or the first lines
+}
+
+func (e retailerExtractor) Store() string   { return e.store }
+func (e retailerExtractor) Version() string { return e.version }
+
+func (e retailerExtractor) Matches(doc *HTMLDocument) bool {
+	for _, sender := range e.senders {
+		if strings.EqualFold(doc.Sender, sender) || strings.EqualFold(doc.SiteName, sender) {
+			return true
+		}
+	}
+
+	header := append([]string{doc.Title, doc.SiteName}, doc.Lines[:min(headerLines, len(doc.Lines))]...)
+	return containsAny(header, e.markers...)
+}
+
+func (e retailerExtractor) Extract(doc *HTMLDocument) (*models.Receipt, error) {
+	receipt, err := extractItemTable(doc)
+	if err != nil {
+		return nil, err
+	}
+	receipt.StoreName = e.store
+	return receipt, nil
+}
+
+// genericExtractor reads the item table of any retailer, naming the store
+// after the email sender or the site
+type genericExtractor struct{}
+
+func (genericExtractor) Store() string                  { return "" }
+func (genericExtractor) Version() string                { return "generic-html-v1" }
+func (genericExtractor) Matches(doc *HTMLDocument) bool { return true }
+
+func (genericExtractor) Extract(doc *HTMLDocument) (*models.Receipt, error) {
+	store := doc.SiteName
+	if store == "" {
+		store = doc.Sender
+	}
+	if store == "" {
+		return nil, errors.New("unknown store")
+	}
+
+	receipt, err := extractItemTable(doc)
+	if err != nil {
+		return nil, err
+	}
+	receipt.StoreName = strings.ToUpper(strings.TrimSpace(store))
+	return receipt, nil
+}
+
+var (
+	moneyCell    = regexp.MustCompile(`^(?:€|EUR)?\s*(-?\s*\d{1,3}(?:[.,\s]\d{3})*[.,]\d{2}|-?\s*\d+[.,]\d{2})\s*(?:€|EUR)?$`)
+	quantityCell = regexp.MustCompile(`(?i)^(?:x\s*)?(\d{1,3}(?:[.,]\d{1,3})?)\s*(x|uds?\.?|unidades|u\.?|kg|g|gr)?$`)
+
+	quantityPrefix = regexp.MustCompile(`^(\d+)\s*[xX]\s+(.+)$`)
+	quantitySuffix = regexp.MustCompile(`^(.+?)\s+[xX]\s*(\d+)$`)
+
+	summaryLabel  = regexp.MustCompile(`(?i)^(sub-?total|total|importe total|base imponible|iva|impuestos|i\.v\.a\.)\b`)
+	totalLabel    = regexp.MustCompile(`(?i)^(total(?: a pagar| pagado| del pedido| final)?|importe total|order total|grand total)\b`)
+	discountLabel = regexp.MustCompile(`(?i)(descuento|dto\.?|cup[oó]n|promoci[oó]n|ahorro|rebaja|discount|coupon)`)
+	discountLine  = regexp.MustCompile(`(?i)^(descuentos?|dto\.?|cup[oó]n|promoci[oó]n(es)?|ahorro|total ahorro|total descuentos|rebaja|discount|coupon)\b`)
+	amountInText  = regexp.MustCompile(`-?\s*\d{1,3}(?:[.,]\d{3})*[.,]\d{2}|-?\s*\d+[.,]\d{2}`)
+)
+
+// column is the role of a column of the item table
+type column int
+
+const (
+	columnUnknown column = iota
+	columnName
+	columnQuantity
+	columnUnitPrice
+	columnTotal
+)
+
+// headerColumns maps header cells to column roles, most specific first
+var headerColumns = []struct {
+	pattern *regexp.Regexp
+	role    column
+}{
+	{regexp.MustCompile(`(?i)(precio\s*(unitario|/\s*ud|por unidad|unidad)|p\.\s*unit|unit price|€\s*/\s*(ud|kg))`), columnUnitPrice},
+	{regexp.MustCompile(`(?i)(importe|total|subtotal|amount)`), columnTotal},
+	{regexp.MustCompile(`(?i)(cantidad|unidades|uds|cant\.|qty|quantity|peso)`), columnQuantity},
+	{regexp.MustCompile(`(?i)(producto|art[ií]culo|descripci[oó]n|concepto|product|item)`), columnName},
+	{regexp.MustCompile(`(?i)(precio|price|pvp)`), columnUnitPrice},
+}
+
+// extractItemTable finds the table listing the items and reads the items,
+// discounts and printed total from it, and the order date from the text
+func extractItemTable(doc *HTMLDocument) (*models.Receipt, error) {
+	table, header := findItemTable(doc.Tables)
+	if table == nil {
+		return nil, errors.New("no item table found")
+	}
+
+	columns := []column{}
+	start := 0
+	if header >= 0 {
+		columns = mapColumns(table.Rows[header])
+		start = header + 1
+	}
+
+	receipt := &models.Receipt{Items: []models.Item{}}
+	discountRows := false
+
+	for _, row := range table.Rows[start:] {
+		line := classifyRow(row, columns)
+		switch line.kind {
+		case rowItem:
+			receipt.Items = append(receipt.Items, line.item)
+		case rowDiscount:
+			receipt.Discounts += line.amount
+			discountRows = true
+		case rowTotal:
+			amount := line.amount
+			receipt.Total = &amount
+		}
+	}
+
+	// The summary is often a separate table below the items
+	if receipt.Total == nil {
+		receipt.Total = lastLabelledAmount(doc.Lines, totalLabel)
+	}
+	if !discountRows {
+		receipt.Discounts = sumLabelledAmounts(doc.Lines, discountLine)
+	}
+	receipt.Discounts = math.Round(receipt.Discounts*100) / 100
+
+	receipt.BoughtDate = findOrderDate(doc.Lines)
+	if receipt.BoughtDate == "" && !doc.Sent.IsZero() {
+		receipt.BoughtDate = doc.Sent.Format("2006-01-02")
+	}
+
+	return receipt, nil
+}
+
+// findItemTable returns the table with the most item-looking rows, preferring
+// the innermost of layout tables, and the index of its header row (-1 if none)
+func findItemTable(tables []Table) (*Table, int) {
+	var best *Table
+	bestScore, bestSize := 0, 0
+	for i := range tables {
+		score := 0
+		size := 0
+		for _, row := range tables[i].Rows {
+			if isItemRow(row) {
+				score++
+			}
+			for _, cell := range row {
+				size += len(cell)
+			}
+		}
+		if score > bestScore || (score == bestScore && score > 0 && size < bestSize) {
+			best, bestScore, bestSize = &tables[i], score, size
+		}
+	}
+	if best == nil {
+		return nil, -1
+	}
+
+	for i, row := range best.Rows {
+		if isItemRow(row) {
+			break
+		}
+		if isHeaderRow(row) {
+			return best, i
+		}
+	}
+	return best, -1
+}
+
+// isItemRow reports whether a row has a name and at least one amount
+func isItemRow(row []string) bool {
+	cells := nonEmpty(row)
+	if len(cells) < 2 || len(cells) > 8 {
+		return false
+	}
+
+	names, amounts := 0, 0
+	for _, cell := range cells {
+		switch {
+		case moneyCell.MatchString(cell):
+			amounts++
+		case quantityCell.MatchString(cell):
+		case hasLetters(cell):
+			names++
+		}
+	}
+	return names >= 1 && amounts >= 1
+}
+
+// isHeaderRow reports whether a row names at least two item columns
+func isHeaderRow(row []string) bool {
+	roles := 0
+	for _, role := range mapColumns(row) {
+		if role != columnUnknown {
+			roles++
+		}
+	}
+	return roles >= 2
+}
+
+// mapColumns returns the role of each cell of a header row
+func mapColumns(header []string) []column {
+	columns := make([]column, len(header))
+	for i, cell := range header {
+		for _, candidate := range headerColumns {
+			if candidate.pattern.MatchString(cell) {
+				columns[i] = candidate.role
+				break
+			}
+		}
+	}
+	return columns
+}
+
+type rowKind int
+
+const (
+	rowSkip rowKind = iota
+	rowItem
+	rowDiscount
+	rowTotal
+)
+
+// tableLine is a classified row of the item table
+type tableLine struct {
+	kind   rowKind
+	item   models.Item
+	amount float64
+}
+
+// classifyRow reads a row as an item, a discount or a summary line, using the
+// header's columns when known and the shape of the cells otherwise
+func classifyRow(row []string, columns []column) tableLine {
+	name := ""
+	quantity := 0.0
+	amounts := []float64{}
+	unitPrice, lineTotal := math.NaN(), math.NaN()
+
+	for i, cell := range row {
+		if cell == "" {
+			continue
+		}
+		role := columnUnknown
+		if i < len(columns) {
+			role = columns[i]
+		}
+
+		if moneyCell.MatchString(cell) {
+			amount, err := parseMoney(cell)
+			if err != nil {
+				continue
+			}
+			amounts = append(amounts, amount)
+			switch role {
+			case columnUnitPrice:
+				unitPrice = amount
+			case columnTotal:
+				lineTotal = amount
+			}
+			continue
+		}
+
+		if match := quantityCell.FindStringSubmatch(cell); match != nil && (role == columnQuantity || role == columnUnknown) {
+			quantity = parseQuantity(match[1], match[2])
+			continue
+		}
+
+		if name == "" && hasLetters(cell) && (role == columnName || role == columnUnknown) {
+			name = cell
+		}
+	}
+
+	if name == "" || len(amounts) == 0 {
+		return tableLine{kind: rowSkip}
+	}
+
+	last := amounts[len(amounts)-1]
+	if math.IsNaN(lineTotal) {
+		lineTotal = last
+	}
+
+	switch {
+	case discountLabel.MatchString(name) || lineTotal < 0:
+		return tableLine{kind: rowDiscount, amount: math.Abs(lineTotal)}
+	case totalLabel.MatchString(name):
+		return tableLine{kind: rowTotal, amount: lineTotal}
+	case summaryLabel.MatchString(name):
+		return tableLine{kind: rowSkip}
+	case lineTotal == 0:
+		// Free shipping, bags and the like
+		return tableLine{kind: rowSkip}
+	}
+
+	// A quantity written in the name: "2 x LECHE" or "LECHE x2"
+	if quantity == 0 {
+		if match := quantityPrefix.FindStringSubmatch(name); match != nil {
+			quantity, _ = strconv.ParseFloat(match[1], 64)
+			name = match[2]
+		} else if match := quantitySuffix.FindStringSubmatch(name); match != nil {
+			quantity, _ = strconv.ParseFloat(match[2], 64)
+			name = match[1]
+		}
+	}
+
+	// Without a unit price column, the unit price is whichever other amount
+	// the line total is a multiple of
+	if math.IsNaN(unitPrice) {
+		for _, amount := range amounts[:len(amounts)-1] {
+			if amount <= 0 {
+				continue
+			}
+			ratio := lineTotal / amount
+			if quantity > 0 && math.Abs(quantity*amount-lineTotal) <= 0.01 {
+				unitPrice = amount
+				break
+			}
+			if quantity == 0 && ratio >= 1 && math.Abs(ratio-math.Round(ratio)) < 0.01 {
+				unitPrice, quantity = amount, math.Round(ratio)
+				break
+			}
+		}
+	}
+
+	if quantity <= 0 {
+		quantity = 1
+		if !math.IsNaN(unitPrice) && unitPrice > 0 {
+			quantity = math.Round(lineTotal/unitPrice*1000) / 1000
+		}
+	}
+
+	price := lineTotal / quantity
+	if !math.IsNaN(unitPrice) && math.Abs(quantity*unitPrice-lineTotal) <= 0.01 {
+		price = unitPrice
+	}
+
+	return tableLine{kind: rowItem, item: models.Item{
+		Name:     strings.ToUpper(strings.TrimSpace(name)),
+		Quantity: quantity,
+		Price:    math.Round(price*100) / 100,
+	}}
+}
+
+// parseMoney parses an amount with either decimal separator ("1.234,56",
+// "1,234.56", "-2,50 €")
+func parseMoney(s string) (float64, error) {
+	s = strings.NewReplacer("€", "", "EUR", "", " ", "", " ", "").Replace(s)
+	if len(s) < 3 {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	// The decimal separator is the one before the last two digits
+	integer, decimals := s[:len(s)-3], s[len(s)-2:]
+	integer = strings.NewReplacer(".", "", ",", "").Replace(integer)
+	return strconv.ParseFloat(integer+"."+decimals, 64)
+}
+
+// parseQuantity parses a quantity cell, converting grams to kilograms
+func parseQuantity(value, unit string) float64 {
+	quantity, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", "."), 64)
+	if err != nil {
+		return 0
+	}
+	switch strings.ToLower(unit) {
+	case "g", "gr":
+		return quantity / 1000
+	default:
+		return quantity
+	}
+}
+
+// labelledAmounts returns the last amount of each line whose label matches,
+// skipping discount lines unless they are what is looked for
+func labelledAmounts(lines []string, label *regexp.Regexp) []float64 {
+	amounts := []float64{}
+	for _, line := range lines {
+		if !label.MatchString(line) || (label != discountLine && discountLine.MatchString(line)) {
+			continue
+		}
+		found := amountInText.FindAllString(line, -1)
+		if len(found) == 0 {
+			continue
+		}
+		if amount, err := parseMoney(found[len(found)-1]); err == nil {
+			amounts = append(amounts, amount)
+		}
+	}
+	return amounts
+}
+
+// lastLabelledAmount returns the amount of the last matching line, or nil.
+// Summaries end with the amount paid.
+func lastLabelledAmount(lines []string, label *regexp.Regexp) *float64 {
+	amounts := labelledAmounts(lines, label)
+	if len(amounts) == 0 {
+		return nil
+	}
+	return &amounts[len(amounts)-1]
+}
+
+// sumLabelledAmounts adds up the absolute amounts of the matching lines
+func sumLabelledAmounts(lines []string, label *regexp.Regexp) float64 {
+	sum := 0.0
+	for _, amount := range labelledAmounts(lines, label) {
+		sum += math.Abs(amount)
+	}
+	return sum
+}
+
+// orderDateKeywords mark the lines holding the order date, best first; the
+// delivery date comes last
+var orderDateKeywords = []string{
+	"FECHA DEL PEDIDO", "FECHA DE PEDIDO", "PEDIDO REALIZADO", "FECHA DE COMPRA", "ORDER DATE",
+	"FECHA", "PEDIDO", "DATE", "ENTREGA",
+}
+
+var (
+	isoDatePattern  = regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})(?:T|\b)`)
+	longDatePattern = regexp.MustCompile(`(?i)\b(\d{1,2})\s+de\s+(enero|febrero|marzo|abril|mayo|junio|julio|agosto|septiembre|setiembre|octubre|noviembre|diciembre)\s+(?:de\s+|del\s+)?(\d{4})\b`)
+)
+
+var spanishMonths = map[string]int{
+	"enero": 1, "febrero": 2, "marzo": 3, "abril": 4, "mayo": 5, "junio": 6, "julio": 7,
+	"agosto": 8, "septiembre": 9, "setiembre": 9, "octubre": 10, "noviembre": 11, "diciembre": 12,
+}
+
+// findOrderDate returns the order date as ISO 8601, looking first at the
+// lines labelled as such and then at any date in the text
+func findOrderDate(lines []string) string {
+	for _, keyword := range orderDateKeywords {
+		for _, line := range lines {
+			if strings.Contains(strings.ToUpper(line), keyword) {
+				if date := findAnyDate(line); date != "" {
+					return date
+				}
+			}
+		}
+	}
+
+	for _, line := range lines {
+		if date := findAnyDate(line); date != "" {
+			return date
+		}
+	}
+	return ""
+}
+
+// findAnyDate returns the first numeric, ISO or Spanish long date of a line
+func findAnyDate(line string) string {
+	if date := findDate([]string{line}); date != "" {
+		return date
+	}
+	if match := isoDatePattern.FindStringSubmatch(line); match != nil {
+		return match[1] + "-" + match[2] + "-" + match[3]
+	}
+	if match := longDatePattern.FindStringSubmatch(line); match != nil {
+		day, _ := strconv.Atoi(match[1])
+		return fmt.Sprintf("%s-%02d-%02d", match[3], spanishMonths[strings.ToLower(match[2])], day)
+	}
+	return ""
+}
+
+// nonEmpty returns the non-empty cells of a row
+func nonEmpty(row []string) []string {
+	cells := []string{}
+	for _, cell := range row {
+		if cell != "" {
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
+
+// hasLetters reports whether a cell has at least two letters
+func hasLetters(s string) bool {
+	letters := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	return letters >= 2
+}
@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+func TestParseFileEReceipt(t *testing.T) {
+	tests := []struct {
+		file      string
+		store     string
+		version   string
+		date      string
+		items     []models.Item
+		discounts float64
+		total     float64
+	}{
+		{
+			// A header row names the columns; the bag at 0,00 is not an item,
+			// and the order date wins over the delivery date and the email's
+			file:    "mercadona.eml",
+			store:   "MERCADONA",
+			version: "mercadona-html-v1",
+			date:    "2024-03-15",
+			items: []models.Item{
+				{Name: "LECHE SEMIDESNATADA", Quantity: 6, Price: 0.89},
+				{Name: "PLÁTANO DE CANARIAS", Quantity: 0.750, Price: 1.99},
+			},
+			discounts: 1.00,
+			total:     5.83,
+		},
+		{
+			// A header-less table: quantities in the names, struck-through
+			// prices before a promotion and the summary in a table of its own
+			file:    "carrefour.html",
+			store:   "CARREFOUR",
+			version: "carrefour-html-v1",
+			date:    "2024-03-03",
+			items: []models.Item{
+				{Name: "YOGUR NATURAL", Quantity: 2, Price: 1.20},
+				{Name: "PAN DE PUEBLO", Quantity: 3, Price: 0.65},
+				{Name: "ACEITE DE OLIVA VIRGEN EXTRA 1L", Quantity: 1, Price: 7.99},
+			},
+			discounts: 2.00,
+			total:     10.34,
+		},
+		{
+			// Any other store is named after the sender, in a Latin-1 body
+			// without a date, so the email's date is the order date
+			file:    "generic.eml",
+			store:   "FRUTERÍA LOLA",
+			version: "generic-html-v1",
+			date:    "2024-03-12",
+			items: []models.Item{
+				{Name: "MANZANA GOLDEN", Quantity: 1.5, Price: 2.20},
+				{Name: "NARANJAS", Quantity: 2, Price: 1.80},
+			},
+			discounts: 0.50,
+			total:     6.40,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			parsed, err := ParseFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatalf("ParseFile failed: %v", err)
+			}
+
+			receipt := parsed.Receipt
+			if parsed.Version != tt.version {
+				t.Errorf("version = %s, want %s", parsed.Version, tt.version)
+			}
+			if receipt.StoreName != tt.store || receipt.BoughtDate != tt.date {
+				t.Errorf("got %s on %q, want %s on %q", receipt.StoreName, receipt.BoughtDate, tt.store, tt.date)
+			}
+
+			if len(receipt.Items) != len(tt.items) {
+				t.Fatalf("items = %+v, want %+v", receipt.Items, tt.items)
+			}
+			for i, item := range receipt.Items {
+				want := tt.items[i]
+				if item.Name != want.Name || !approx(item.Quantity, want.Quantity) || !approx(item.Price, want.Price) {
+					t.Errorf("item %d = %s %v x %v, want %s %v x %v", i, item.Name, item.Quantity, item.Price, want.Name, want.Quantity, want.Price)
+				}
+			}
+
+			if !approx(receipt.Discounts, tt.discounts) {
+				t.Errorf("discounts = %.2f, want %.2f", receipt.Discounts, tt.discounts)
+			}
+			if receipt.Total == nil || !approx(*receipt.Total, tt.total) {
+				t.Errorf("total = %v, want %.2f", receipt.Total, tt.total)
+			}
+		})
+	}
+}
+
+func TestHTMLExtractorMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     HTMLDocument
+		version string
+	}{
+		{"sender", HTMLDocument{Sender: "Mercadona"}, "mercadona-html-v1"},
+		{"site name", HTMLDocument{SiteName: "carrefour"}, "carrefour-html-v1"},
+		{"marker in the title", HTMLDocument{Title: "Tu compra en Supermercados DIA"}, "dia-html-v1"},
+		{"marker in the first lines", HTMLDocument{Lines: []string{"Hola Ana", "Gracias por comprar en Alcampo"}}, "alcampo-html-v1"},
+		{"accented marker", HTMLDocument{Title: "El Corte Inglés - Confirmación de pedido"}, "elcorteingles-html-v1"},
+		{"marker far down the text", HTMLDocument{Lines: []string{"1", "2", "3", "4", "5", "6", "7", "8", "Envío de Carrefour"}}, "generic-html-v1"},
+		{"unknown retailer", HTMLDocument{Sender: "fruterialola"}, "generic-html-v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extractor := HTMLExtractor(genericExtractor{})
+			for _, candidate := range htmlExtractors {
+				if candidate.Matches(&tt.doc) {
+					extractor = candidate
+					break
+				}
+			}
+			if extractor.Version() != tt.version {
+				t.Errorf("extractor = %s, want %s", extractor.Version(), tt.version)
+			}
+		})
+	}
+}
+
+func TestParseHTMLStruckThrough(t *testing.T) {
+	doc, err := NewHTMLDocument(`<html><head><meta property="og:site_name" content="Tienda"></head><body>
+		<p>Fecha: 01/02/2024</p>
+		<table><tr><td>Cafe molido</td><td><strike>4,50 €</strike></td><td>3,60 €</td></tr></table>
+		<p>Antes <del>4,50 €</del>, ahora 3,60 €</p></body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Struck-through prices are neither in the cells nor in the text
+	receipt, _, err := ParseHTML(doc)
+	if err != nil {
+		t.Fatalf("ParseHTML failed: %v", err)
+	}
+	if len(receipt.Items) != 1 || receipt.Items[0].Quantity != 1 || receipt.Items[0].Price != 3.60 {
+		t.Errorf("items = %+v, want 1 x 3.60", receipt.Items)
+	}
+	for _, line := range doc.Lines {
+		if strings.Contains(line, "4,50") {
+			t.Errorf("line %q has the struck-through price", line)
+		}
+	}
+}
+
+func TestParseHTMLUnparseable(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  HTMLDocument
+	}{
+		{"no table", HTMLDocument{Sender: "Mercadona", Lines: []string{"Total 5,00 €"}}},
+		{"no items", HTMLDocument{Sender: "Mercadona", Tables: []Table{{Rows: [][]string{{"Producto", "Importe"}}}}}},
+		{"no date", HTMLDocument{Sender: "Mercadona", Tables: []Table{{Rows: [][]string{{"LECHE", "0,89 €"}}}}}},
+		{"unknown store", HTMLDocument{Lines: []string{"01/02/2024"}, Tables: []Table{{Rows: [][]string{{"LECHE", "0,89 €"}}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receipt, _, err := ParseHTML(&tt.doc)
+			if !errors.Is(err, ErrUnparseable) {
+				t.Errorf("ParseHTML = %+v, %v, want ErrUnparseable", receipt, err)
+			}
+		})
+	}
+
+	// The date of the email completes a body without one
+	doc := HTMLDocument{Sender: "Mercadona", Sent: time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC), Tables: []Table{{Rows: [][]string{{"LECHE", "0,89 €"}}}}}
+	if receipt, _, err := ParseHTML(&doc); err != nil || receipt.BoughtDate != "2024-02-01" {
+		t.Errorf("ParseHTML = %+v, %v, want a receipt of 2024-02-01", receipt, err)
+	}
+}
+
+func TestFindOrderDate(t *testing.T) {
+	tests := []struct {
+		lines []string
+		want  string
+	}{
+		{[]string{"Entrega: 20/03/2024", "Fecha del pedido: 15/03/2024"}, "2024-03-15"},
+		{[]string{"Pedido realizado el 3 de marzo de 2024"}, "2024-03-03"},
+		{[]string{"Compra del 1 de septiembre del 2023"}, "2023-09-01"},
+		{[]string{"Order date 2024-01-31T10:00:00Z"}, "2024-01-31"},
+		{[]string{"Sin fecha"}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := findOrderDate(tt.lines); got != tt.want {
+			t.Errorf("findOrderDate(%q) = %q, want %q", tt.lines, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// Facturae tax type codes of the value added taxes: IVA, IPSI (Ceuta and
+// Melilla) and IGIC (Canary Islands)
+var facturaeVATCodes = map[string]bool{"01": true, "02": true, "03": true}
+
+// facturae is a Facturae 3.2.x file, the Spanish e-invoicing format. Elements
+// are matched by local name, so the version's namespace and the XAdES
+// signature of signed files are ignored.
+type facturae struct {
+	SchemaVersion string `xml:"FileHeader>SchemaVersion"`
+	Seller        struct {
+		TaxID       string `xml:"TaxIdentification>TaxIdentificationNumber"`
+		LegalEntity *struct {
+			CorporateName string `xml:"CorporateName"`
+			TradeName     string `xml:"TradeName"`
+		} `xml:"LegalEntity"`
+		Individual *struct {
+			Name          string `xml:"Name"`
+			FirstSurname  string `xml:"FirstSurname"`
+			SecondSurname string `xml:"SecondSurname"`
+		} `xml:"Individual"`
+	} `xml:"Parties>SellerParty"`
+	Invoices []facturaeInvoice `xml:"Invoices>Invoice"`
+}
+
+type facturaeInvoice struct {
+	Number    string `xml:"InvoiceHeader>InvoiceNumber"`
+	Series    string `xml:"InvoiceHeader>InvoiceSeriesCode"`
+	Class     string `xml:"InvoiceHeader>InvoiceClass"`
+	IssueDate string `xml:"InvoiceIssueData>IssueDate"`
+	Currency  string `xml:"InvoiceIssueData>InvoiceCurrencyCode"`
+	Totals    struct {
+		GrossAmountBeforeTaxes amount `xml:"TotalGrossAmountBeforeTaxes"`
+		GeneralSurcharges      amount `xml:"TotalGeneralSurcharges"`
+		TaxOutputs             amount `xml:"TotalTaxOutputs"`
+		TaxesWithheld          amount `xml:"TotalTaxesWithheld"`
+		InvoiceTotal           amount `xml:"InvoiceTotal"`
+	} `xml:"InvoiceTotals"`
+	Lines []facturaeLine `xml:"Items>InvoiceLine"`
+}
+
+type facturaeLine struct {
+	Description string        `xml:"ItemDescription"`
+	Quantity    amount        `xml:"Quantity"`
+	GrossAmount amount        `xml:"GrossAmount"` // After line discounts and charges, before taxes
+	Taxes       []facturaeTax `xml:"TaxesOutputs>Tax"`
+}
+
+type facturaeTax struct {
+	TypeCode                   string `xml:"TaxTypeCode"`
+	Rate                       amount `xml:"TaxRate"`
+	Base                       amount `xml:"TaxableBase>TotalAmount"`
+	Amount                     amount `xml:"TaxAmount>TotalAmount"`
+	EquivalenceSurchargeAmount amount `xml:"EquivalenceSurchargeAmount>TotalAmount"`
+}
+
+// version identifies the Facturae schema version, e.g. "facturae-3.2.2"
+func (f *facturae) version() string {
+	version := strings.TrimSpace(f.SchemaVersion)
+	if version == "" {
+		version = "3.2"
+	}
+	return "facturae-" + version
+}
+
+// sellerName returns the trade name of the seller, or its legal name
+func (f *facturae) sellerName() string {
+	if entity := f.Seller.LegalEntity; entity != nil {
+		if name := strings.TrimSpace(entity.TradeName); name != "" {
+			return name
+		}
+		return strings.TrimSpace(entity.CorporateName)
+	}
+	if individual := f.Seller.Individual; individual != nil {
+		return strings.Join(strings.Fields(individual.Name+" "+individual.FirstSurname+" "+individual.SecondSurname), " ")
+	}
+	return ""
+}
+
+// receipts converts every invoice of the file
+func (f *facturae) receipts(text string) ([]*Parsed, error) {
+	if len(f.Invoices) == 0 {
+		return nil, fmt.Errorf("the Facturae file has no invoices")
+	}
+
+	store := f.sellerName()
+	if store == "" {
+		return nil, fmt.Errorf("the Facturae seller has no name")
+	}
+
+	parsed := make([]*Parsed, 0, len(f.Invoices))
+	for i := range f.Invoices {
+		receipt, err := f.Invoices[i].receipt(store, strings.TrimSpace(f.Seller.TaxID))
+		if err != nil {
+			return nil, fmt.Errorf("invoice %d: %w", i+1, err)
+		}
+		parsed = append(parsed, &Parsed{Receipt: receipt, Version: f.version(), Text: text})
+	}
+	return parsed, nil
+}
+
+// receipt converts an invoice of a Facturae file
+func (inv *facturaeInvoice) receipt(store, taxID string) (*models.Receipt, error) {
+	// Corrective invoices (and their copies) amend another invoice
+	if class := strings.TrimSpace(inv.Class); class == "OR" || class == "CR" {
+		return nil, fmt.Errorf("corrective invoices are not supported (class %s)", class)
+	}
+	if err := checkCurrency(inv.Currency); err != nil {
+		return nil, err
+	}
+
+	boughtDate, err := invoiceDate(inv.IssueDate)
+	if err != nil {
+		return nil, err
+	}
+
+	number := strings.TrimSpace(inv.Number)
+	if series := strings.TrimSpace(inv.Series); series != "" {
+		number = series + "-" + number
+	}
+
+	receipt := &models.Receipt{
+		StoreName:      store,
+		StoreTaxID:     taxID,
+		BoughtDate:     boughtDate,
+		DocumentNumber: number,
+		Items:          []models.Item{},
+	}
+
+	for _, line := range inv.Lines {
+		item, err := line.item()
+		if err != nil {
+			return nil, err
+		}
+		receipt.Items = append(receipt.Items, item)
+	}
+
+	totals, err := amounts(inv.Totals.GrossAmountBeforeTaxes, inv.Totals.GeneralSurcharges,
+		inv.Totals.TaxOutputs, inv.Totals.TaxesWithheld, inv.Totals.InvoiceTotal)
+	if err != nil {
+		return nil, err
+	}
+	beforeTaxes, surcharges, taxes, withheld, invoiceTotal := totals[0], totals[1], totals[2], totals[3], totals[4]
+
+	// General surcharges (e.g. shipping) are taxed at the invoice's rates,
+	// approximated by its average rate
+	if surcharges > 0 {
+		taxFactor := 1.0
+		if beforeTaxes > 0 {
+			taxFactor = (beforeTaxes + taxes) / beforeTaxes
+		}
+		receipt.Items = append(receipt.Items, models.Item{
+			Name:     "SURCHARGES",
+			Quantity: 1,
+			Price:    roundCents(surcharges * taxFactor),
+		})
+	}
+
+	// Withholdings (IRPF) are paid by the buyer to the tax agency instead of
+	// the seller, they are part of the price
+	settleTotal(receipt, invoiceTotal+withheld)
+	return receipt, nil
+}
+
+// item converts an invoice line, adding its taxes to its gross amount
+func (line *facturaeLine) item() (models.Item, error) {
+	values, err := amounts(line.Quantity, line.GrossAmount)
+	if err != nil {
+		return models.Item{}, err
+	}
+	quantity, grossAmount := values[0], values[1]
+	lineTotal := grossAmount
+
+	var taxRate *float64
+	for _, tax := range line.Taxes {
+		values, err := amounts(tax.Rate, tax.Base, tax.Amount, tax.EquivalenceSurchargeAmount)
+		if err != nil {
+			return models.Item{}, err
+		}
+		rate, base, taxAmount, surcharge := values[0], values[1], values[2], values[3]
+
+		if !tax.Amount.isSet() {
+			if !tax.Base.isSet() {
+				base = grossAmount
+			}
+			taxAmount = base * rate / 100
+		}
+		lineTotal += taxAmount + surcharge
+
+		// TaxTypeCode is mandatory, but be lenient with files that omit it
+		if code := strings.TrimSpace(tax.TypeCode); taxRate == nil && (code == "" || facturaeVATCodes[code]) {
+			taxRate = &rate
+		}
+	}
+
+	return invoiceItem(line.Description, quantity, lineTotal, taxRate)
+}
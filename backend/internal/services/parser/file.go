@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// Parsed is a receipt parsed from a file, with its provenance
+type Parsed struct {
+	Receipt *models.Receipt
+	// Version identifies the grammar or extractor used
+	Version string
+	// Text is what the rules were applied to
+	Text string
+}
+
+// ParseFile parses a text-layer receipt, an HTML e-receipt or an email
+// without any network call. It returns ErrUnparseable if no rules apply.
+func ParseFile(path string) (*Parsed, error) {
+	if IsMarkup(path) {
+		doc, text, err := readMarkup(path)
+		if err != nil {
+			return nil, err
+		}
+
+		// Plain text emails go through the receipt grammars
+		if doc == nil {
+			return parseText(text)
+		}
+
+		receipt, extractor, err := ParseHTML(doc)
+		if err != nil {
+			return nil, err
+		}
+		return &Parsed{Receipt: receipt, Version: extractor.Version(), Text: strings.Join(doc.Lines, "\n")}, nil
+	}
+
+	text, err := ExtractText(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseText(text)
+}
+
+// parseText parses receipt text with the store grammars
+func parseText(text string) (*Parsed, error) {
+	receipt, grammar, err := Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Parsed{Receipt: receipt, Version: grammar.Version(), Text: text}, nil
+}
+
+// readMarkup reads an HTML page or an email. It returns the HTML document of
+// the page or of the email's HTML body, or else the email's plain text body.
+func readMarkup(path string) (*HTMLDocument, string, error) {
+	if strings.ToLower(filepath.Ext(path)) != ".eml" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read HTML: %w", err)
+		}
+		doc, err := NewHTMLDocument(string(data))
+		return doc, "", err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open email: %w", err)
+	}
+	defer file.Close()
+
+	email, err := ParseEmail(file)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(email.HTMLBodies) == 0 {
+		if len(email.TextBodies) == 0 {
+			return nil, "", fmt.Errorf("%w: the email has no body", ErrUnparseable)
+		}
+		return nil, strings.Join(email.TextBodies, "\n"), nil
+	}
+
+	doc, err := NewHTMLDocument(email.HTMLBodies[0])
+	if err != nil {
+		return nil, "", err
+	}
+	doc.Sender = email.Sender()
+	doc.Sent = email.Date
+	return doc, "", nil
+}
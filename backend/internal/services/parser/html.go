@@ -0,0 +1,163 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// HTMLDocument is an HTML e-receipt (an order confirmation email or page)
+// reduced to what the extractors need
+type HTMLDocument struct {
+	// Sender is the organization that sent the email, empty for HTML files
+	Sender string
+	// Sent is when the email was sent, used when the body has no order date
+	Sent time.Time
+
+	Title    string
+	SiteName string   // og:site_name, if declared
+	Lines    []string // Rendered text: one block or table row per line
+	Tables   []Table
+}
+
+// Table is an HTML table, as the text of each cell of each row. Nested tables
+// are tables of their own, and are also part of the text of their cell.
+type Table struct {
+	Rows [][]string
+}
+
+// NewHTMLDocument parses an HTML body
+func NewHTMLDocument(body string) (*HTMLDocument, error) {
+	root, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	doc := &HTMLDocument{Lines: splitLines(renderText(root))}
+
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "title":
+				doc.Title = strings.TrimSpace(nodeText(n))
+			case "meta":
+				if attr(n, "property") == "og:site_name" {
+					doc.SiteName = strings.TrimSpace(attr(n, "content"))
+				}
+			case "table":
+				doc.Tables = append(doc.Tables, Table{Rows: tableRows(n)})
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			visit(child)
+		}
+	}
+	visit(root)
+
+	return doc, nil
+}
+
+// HTMLToText renders an HTML body as plain text, one table row or block per
+// line with table cells separated by spaces
+func HTMLToText(body string) string {
+	root, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	return strings.Join(splitLines(renderText(root)), "\n")
+}
+
+// blockElements start a new line when rendering HTML as text
+var blockElements = map[string]bool{
+	"address": true, "article": true, "br": true, "div": true, "dl": true, "dt": true, "dd": true,
+	"footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "ol": true, "p": true, "section": true,
+	"table": true, "tbody": true, "thead": true, "tfoot": true, "tr": true, "ul": true,
+}
+
+// skippedElements are never rendered. Struck-through prices are the price
+// before a promotion, not the one paid.
+var skippedElements = map[string]bool{
+	"script": true, "style": true, "head": true, "title": true,
+	"s": true, "del": true, "strike": true,
+}
+
+// renderText renders a node as text, separating blocks with newlines
+func renderText(n *html.Node) string {
+	var b strings.Builder
+	var render func(*html.Node)
+	render = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			if text := strings.Join(strings.Fields(n.Data), " "); text != "" {
+				b.WriteString(text)
+				b.WriteByte(' ')
+			}
+			return
+		case html.ElementNode:
+			if skippedElements[n.Data] {
+				return
+			}
+		}
+
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			render(child)
+		}
+
+		if n.Type == html.ElementNode && blockElements[n.Data] {
+			b.WriteByte('\n')
+		}
+	}
+	render(n)
+	return b.String()
+}
+
+// nodeText returns the text of a node on a single line
+func nodeText(n *html.Node) string {
+	return strings.Join(strings.Fields(renderText(n)), " ")
+}
+
+// tableRows returns the cell texts of the rows of a table, without the rows
+// of its nested tables
+func tableRows(table *html.Node) [][]string {
+	rows := [][]string{}
+
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.Data {
+			case "table":
+				// Nested tables are collected on their own
+			case "tr":
+				cells := []string{}
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+						cells = append(cells, nodeText(cell))
+					}
+				}
+				rows = append(rows, cells)
+			default:
+				visit(child)
+			}
+		}
+	}
+	visit(table)
+
+	return rows
+}
+
+// attr returns the value of an attribute of an element
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,191 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vieitesss/ticketer/internal/models"
+	"golang.org/x/net/html/charset"
+)
+
+// ErrInvalidInvoice is returned when a file is not a Facturae or UBL invoice,
+// or one that cannot be imported
+var ErrInvalidInvoice = errors.New("invalid e-invoice")
+
+// invoiceExtensions are the file extensions of e-invoices. Signed Facturae
+// files are usually named .xsig.
+var invoiceExtensions = map[string]bool{
+	".xml":  true,
+	".xsig": true,
+}
+
+// IsInvoiceFile reports whether a file name looks like an e-invoice
+func IsInvoiceFile(name string) bool {
+	return invoiceExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// ParseInvoice parses a Facturae 3.2.x (signed or not) or UBL 2.x invoice. A
+// Facturae file may hold several invoices from the same seller, so it returns
+// one receipt per invoice. Amounts are converted to what was paid: item prices
+// include VAT, and the total is the invoice total before withholdings.
+func ParseInvoice(data []byte) ([]*Parsed, error) {
+	parsed, err := parseInvoice(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInvoice, err)
+	}
+	return parsed, nil
+}
+
+// parseInvoice finds the invoice element and decodes it by format
+func parseInvoice(data []byte) ([]*Parsed, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	// The invoice is the root element, or is enveloped in a signature
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("not a Facturae or UBL invoice")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read invoice XML: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "Facturae":
+			var document facturae
+			if err := decoder.DecodeElement(&document, &start); err != nil {
+				return nil, fmt.Errorf("failed to decode Facturae: %w", err)
+			}
+			return document.receipts(string(data))
+		case "Invoice":
+			var document ublInvoice
+			if err := decoder.DecodeElement(&document, &start); err != nil {
+				return nil, fmt.Errorf("failed to decode UBL invoice: %w", err)
+			}
+			receipt, err := document.receipt(string(data))
+			if err != nil {
+				return nil, err
+			}
+			return []*Parsed{receipt}, nil
+		case "CreditNote":
+			return nil, fmt.Errorf("credit notes are not supported, only invoices")
+		}
+	}
+}
+
+// amount is a decimal amount of an e-invoice, which may be empty
+type amount string
+
+// value returns the amount as a number, 0 when empty
+func (a amount) value() (float64, error) {
+	s := strings.TrimSpace(string(a))
+	if s == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	return value, nil
+}
+
+// isSet reports whether the amount is present
+func (a amount) isSet() bool {
+	return strings.TrimSpace(string(a)) != ""
+}
+
+// amounts converts several amounts, stopping at the first invalid one
+func amounts(values ...amount) ([]float64, error) {
+	result := make([]float64, len(values))
+	for i, value := range values {
+		v, err := value.value()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+// roundCents rounds an amount to cents
+func roundCents(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// invoiceItem builds the item of an invoice line from its VAT-inclusive
+// amount. The price is per unit, rounded to cents like any receipt price;
+// when that would be more than a cent off the line amount (e.g. 200 kWh at
+// 0.3025), the line is kept as a single unit at its exact amount instead.
+func invoiceItem(name string, quantity, lineTotal float64, taxRate *float64) (models.Item, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return models.Item{}, fmt.Errorf("an invoice line has no description")
+	}
+	if quantity <= 0 {
+		return models.Item{}, fmt.Errorf("invoice line %q has a non-positive quantity", name)
+	}
+
+	price := roundCents(lineTotal / quantity)
+	if math.Abs(price*quantity-lineTotal) > 0.01 {
+		quantity, price = 1, roundCents(lineTotal)
+	}
+
+	return models.Item{
+		Name:     name,
+		Quantity: quantity,
+		Price:    price,
+		TaxRate:  taxRate,
+	}, nil
+}
+
+// checkCurrency rejects invoices in a currency other than euros, since
+// receipts have no currency of their own
+func checkCurrency(code string) error {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code != "" && code != "EUR" {
+		return fmt.Errorf("invoices in %s are not supported, only EUR", code)
+	}
+	return nil
+}
+
+// invoiceDate validates an ISO 8601 issue date
+func invoiceDate(date string) (string, error) {
+	date = strings.TrimSpace(date)
+
+	// Dates may carry a time zone, e.g. 2024-01-15+01:00
+	if len(date) > 10 {
+		date = date[:10]
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return "", fmt.Errorf("invalid issue date %q", date)
+	}
+	return date, nil
+}
+
+// settleTotal sets the total of an invoice receipt, and the discounts as what
+// the items add up to over it. Rounding the unit prices can leave the items a
+// few cents under the total, which is not a discount.
+func settleTotal(receipt *models.Receipt, total float64) {
+	subtotal := 0.0
+	for _, item := range receipt.Items {
+		subtotal += item.Quantity * item.Price
+	}
+
+	total = roundCents(total)
+	receipt.Total = &total
+	receipt.Discounts = math.Max(0, roundCents(subtotal-total))
+}
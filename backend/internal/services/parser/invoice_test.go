@@ -0,0 +1,205 @@
+package parser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// wantItem is what a line of an invoice is expected to become
+type wantItem struct {
+	name     string
+	quantity float64
+	price    float64
+	taxRate  float64
+}
+
+// checkInvoice compares a parsed invoice with the receipt it should become
+func checkInvoice(t *testing.T, parsed *Parsed, version string, want models.Receipt, items []wantItem, total float64) {
+	t.Helper()
+
+	receipt := parsed.Receipt
+	if parsed.Version != version {
+		t.Errorf("version = %s, want %s", parsed.Version, version)
+	}
+	if receipt.StoreName != want.StoreName || receipt.StoreTaxID != want.StoreTaxID {
+		t.Errorf("store = %s (%s), want %s (%s)", receipt.StoreName, receipt.StoreTaxID, want.StoreName, want.StoreTaxID)
+	}
+	if receipt.BoughtDate != want.BoughtDate || receipt.DocumentNumber != want.DocumentNumber {
+		t.Errorf("invoice %q on %q, want %q on %q", receipt.DocumentNumber, receipt.BoughtDate, want.DocumentNumber, want.BoughtDate)
+	}
+
+	if len(receipt.Items) != len(items) {
+		t.Fatalf("items = %+v, want %+v", receipt.Items, items)
+	}
+	for i, item := range receipt.Items {
+		want := items[i]
+		if item.Name != want.name || !approx(item.Quantity, want.quantity) || !approx(item.Price, want.price) {
+			t.Errorf("item %d = %s %v x %v, want %s %v x %v", i, item.Name, item.Quantity, item.Price, want.name, want.quantity, want.price)
+		}
+		if want.taxRate == 0 && item.TaxRate != nil || want.taxRate != 0 && (item.TaxRate == nil || !approx(*item.TaxRate, want.taxRate)) {
+			t.Errorf("item %d tax rate = %v, want %v", i, item.TaxRate, want.taxRate)
+		}
+	}
+
+	if !approx(receipt.Discounts, want.Discounts) {
+		t.Errorf("discounts = %.2f, want %.2f", receipt.Discounts, want.Discounts)
+	}
+	if receipt.Total == nil || !approx(*receipt.Total, total) {
+		t.Errorf("total = %v, want %.2f", receipt.Total, total)
+	}
+}
+
+func readInvoice(t *testing.T, file string) []*Parsed {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseInvoice(data)
+	if err != nil {
+		t.Fatalf("ParseInvoice failed: %v", err)
+	}
+	return parsed
+}
+
+func TestParseFacturae(t *testing.T) {
+	parsed := readInvoice(t, "facturae.xml")
+	if len(parsed) != 2 {
+		t.Fatalf("got %d invoices, want 2", len(parsed))
+	}
+
+	// The trade name is preferred, and the series prefixes the number
+	checkInvoice(t, parsed[0], "facturae-3.2.2",
+		models.Receipt{StoreName: "FERRETERIA PEREZ", StoreTaxID: "B12345678", BoughtDate: "2024-03-10", DocumentNumber: "A-0001"},
+		[]wantItem{
+			{"TORNILLOS 4X40 (CAJA)", 2, 6.05, 21},
+			{"MARTILLO", 1, 18.15, 21},
+		}, 30.25)
+
+	// The surcharge is an item taxed at the invoice's rate, and the IRPF
+	// withheld is part of what was paid
+	checkInvoice(t, parsed[1], "facturae-3.2.2",
+		models.Receipt{StoreName: "FERRETERIA PEREZ", StoreTaxID: "B12345678", BoughtDate: "2024-03-12", DocumentNumber: "A-0002"},
+		[]wantItem{
+			{"REPARACION CALDERA", 1, 121.00, 21},
+			{"SURCHARGES", 1, 12.10, 0},
+		}, 133.10)
+}
+
+func TestParseSignedFacturae(t *testing.T) {
+	parsed := readInvoice(t, "facturae_signed.xsig")
+	if len(parsed) != 1 {
+		t.Fatalf("got %d invoices, want 1", len(parsed))
+	}
+
+	// An individual seller is named by their full name, and a tax without an
+	// amount is worked out from its base
+	checkInvoice(t, parsed[0], "facturae-3.2.1",
+		models.Receipt{StoreName: "Ana García López", StoreTaxID: "00000000T", BoughtDate: "2024-05-02", DocumentNumber: "17"},
+		[]wantItem{
+			{"CLASE DE GUITARRA", 4, 24.20, 21},
+		}, 96.80)
+}
+
+func TestParseUBL(t *testing.T) {
+	parsed := readInvoice(t, "ubl.xml")
+	if len(parsed) != 1 {
+		t.Fatalf("got %d invoices, want 1", len(parsed))
+	}
+
+	// The shipping charge is an item, and the coupon ends up in the discounts
+	checkInvoice(t, parsed[0], "ubl-2.1",
+		models.Receipt{StoreName: "Tienda Online", StoreTaxID: "ESB87654321", BoughtDate: "2024-06-01", DocumentNumber: "INV-2024-001", Discounts: 12.10},
+		[]wantItem{
+			{"CAMISETA", 3, 12.10, 21},
+			{"LIBRO", 1, 10.40, 4},
+			{"ENVIO", 1, 6.05, 21},
+		}, 40.65)
+}
+
+func TestParseInvoiceRejected(t *testing.T) {
+	facturae := func(class, currency string) string {
+		return `<Facturae><FileHeader><SchemaVersion>3.2.2</SchemaVersion></FileHeader>
+			<Parties><SellerParty><LegalEntity><CorporateName>ACME</CorporateName></LegalEntity></SellerParty></Parties>
+			<Invoices><Invoice>
+				<InvoiceHeader><InvoiceNumber>1</InvoiceNumber><InvoiceClass>` + class + `</InvoiceClass></InvoiceHeader>
+				<InvoiceIssueData><IssueDate>2024-01-15</IssueDate><InvoiceCurrencyCode>` + currency + `</InvoiceCurrencyCode></InvoiceIssueData>
+				<InvoiceTotals><InvoiceTotal>1.21</InvoiceTotal></InvoiceTotals>
+				<Items><InvoiceLine><ItemDescription>X</ItemDescription><Quantity>1</Quantity><GrossAmount>1.00</GrossAmount></InvoiceLine></Items>
+			</Invoice></Invoices></Facturae>`
+	}
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not XML", "%PDF-1.4"},
+		{"another XML document", `<Order><ID>1</ID></Order>`},
+		{"Facturae in dollars", facturae("OO", "USD")},
+		{"corrective Facturae", facturae("OR", "EUR")},
+		{"Facturae without invoices", `<Facturae><Parties><SellerParty><LegalEntity><CorporateName>ACME</CorporateName></LegalEntity></SellerParty></Parties></Facturae>`},
+		{"UBL in pounds", `<Invoice><ID>1</ID><IssueDate>2024-01-15</IssueDate><DocumentCurrencyCode>GBP</DocumentCurrencyCode>
+			<AccountingSupplierParty><Party><PartyName><Name>ACME</Name></PartyName></Party></AccountingSupplierParty></Invoice>`},
+		{"UBL credit note", `<CreditNote><ID>1</ID><IssueDate>2024-01-15</IssueDate></CreditNote>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseInvoice([]byte(tt.data))
+			if !errors.Is(err, ErrInvalidInvoice) {
+				t.Errorf("ParseInvoice = %v, %v, want ErrInvalidInvoice", parsed, err)
+			}
+		})
+	}
+
+	// The same invoice in euros, and of an ordinary class, is imported
+	if _, err := ParseInvoice([]byte(facturae("OO", "EUR"))); err != nil {
+		t.Errorf("Facturae in euros: %v", err)
+	}
+}
+
+func TestInvoiceItem(t *testing.T) {
+	rate := 21.0
+
+	tests := []struct {
+		name      string
+		quantity  float64
+		lineTotal float64
+		wantQty   float64
+		wantPrice float64
+		wantErr   bool
+	}{
+		{"exact unit price", 3, 36.30, 3, 12.10, false},
+		{"weighed, within a cent", 0.508, 1.26, 0.508, 2.48, false},
+		// 200 kWh at 0.3025 cannot be a price in cents: one unit of the line
+		{"unit price off by more than a cent", 200, 60.50, 1, 60.50, false},
+		{"no quantity", 0, 1, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item, err := invoiceItem(" LINE ", tt.quantity, tt.lineTotal, &rate)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("invoiceItem = %+v, want an error", item)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("invoiceItem failed: %v", err)
+			}
+			if item.Name != "LINE" || !approx(item.Quantity, tt.wantQty) || !approx(item.Price, tt.wantPrice) || item.TaxRate != &rate {
+				t.Errorf("invoiceItem = %s %v x %v, want LINE %v x %v", item.Name, item.Quantity, item.Price, tt.wantQty, tt.wantPrice)
+			}
+		})
+	}
+
+	if _, err := invoiceItem("  ", 1, 1, nil); err == nil {
+		t.Error("invoiceItem without a description succeeded")
+	}
+}
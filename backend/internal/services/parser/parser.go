@@ -0,0 +1,171 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// ErrUnparseable is returned when no grammar can parse a receipt text, in which
+// case callers should fall back to the LLM extractor
+var ErrUnparseable = errors.New("receipt text could not be parsed")
+
+// Grammar parses the text layout of a single store's receipts
+type Grammar interface {
+	// Store returns the normalized store name the grammar handles
+	Store() string
+	// Version identifies the grammar rules, recorded as extraction provenance
+	Version() string
+	// Matches reports whether the header lines belong to this store
+	Matches(header []string) bool
+	// Parse extracts the receipt from the full list of lines
+	Parse(lines []string) (*models.Receipt, error)
+}
+
+// grammars lists every supported store layout
+var grammars = []Grammar{
+	aldiGrammar{},
+	carrefourGrammar{},
+}
+
+// headerLines is how many lines from the top are used to identify the store
+const headerLines = 8
+
+// Parse extracts a receipt from machine-readable receipt text without any
+// network call. It returns the grammar used, or ErrUnparseable if no grammar
+// matches or the result is incomplete.
+func Parse(text string) (*models.Receipt, Grammar, error) {
+	lines := splitLines(text)
+	if len(lines) == 0 {
+		return nil, nil, fmt.Errorf("%w: empty text", ErrUnparseable)
+	}
+
+	header := lines[:min(headerLines, len(lines))]
+	for _, grammar := range grammars {
+		if !grammar.Matches(header) {
+			continue
+		}
+
+		log.Debug("Parsing receipt text", "store", grammar.Store(), "grammar", grammar.Version())
+		receipt, err := grammar.Parse(lines)
+		if err != nil {
+			return nil, grammar, fmt.Errorf("%w: %s: %v", ErrUnparseable, grammar.Store(), err)
+		}
+
+		if len(receipt.Items) == 0 {
+			return nil, grammar, fmt.Errorf("%w: %s: no items found", ErrUnparseable, grammar.Store())
+		}
+		if receipt.BoughtDate == "" {
+			return nil, grammar, fmt.Errorf("%w: %s: no date found", ErrUnparseable, grammar.Store())
+		}
+
+		if receipt.StoreName == "" {
+			receipt.StoreName = grammar.Store()
+		}
+		log.Info("Parsed receipt text",
+			"store", receipt.StoreName,
+			"bought_date", receipt.BoughtDate,
+			"items", len(receipt.Items),
+			"discounts", receipt.Discounts)
+		return receipt, grammar, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: unknown store layout", ErrUnparseable)
+}
+
+// splitLines returns the non-empty, trimmed lines of a text with inner
+// whitespace collapsed, so column alignment does not matter
+func splitLines(text string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// containsAny reports whether any line contains any of the words
+func containsAny(lines []string, words ...string) bool {
+	for _, line := range lines {
+		upper := strings.ToUpper(line)
+		for _, word := range words {
+			if strings.Contains(upper, word) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseAmount parses a Spanish-formatted number ("1.234,56", "0,508", "-0,50")
+func parseAmount(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(s, "€")
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, ",") {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.ReplaceAll(s, ",", ".")
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+var datePattern = regexp.MustCompile(`\b(\d{1,2})[/.-](\d{1,2})[/.-](\d{4}|\d{2})\b`)
+
+// findDate returns the first DD/MM/YYYY (or DD-MM-YY, DD.MM.YYYY) date as ISO 8601
+func findDate(lines []string) string {
+	for _, line := range lines {
+		match := datePattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		year := match[3]
+		if len(year) == 2 {
+			year = "20" + year
+		}
+		candidate := fmt.Sprintf("%s-%02s-%02s", year, match[2], match[1])
+		if _, err := time.Parse("2006-01-02", candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+var totalPattern = regexp.MustCompile(`^(?:TOTAL(?: A PAGAR)?|A PAGAR|IMPORTE TOTAL)\b[^\d-]*(-?\d[\d.]*,\d{2})`)
+
+// isTotalLine reports whether a line holds a printed total
+func isTotalLine(line string) bool {
+	return totalPattern.MatchString(strings.ToUpper(line))
+}
+
+// findTotal returns the printed total, or nil if none is found. The amount
+// to pay ("A PAGAR") wins over a plain TOTAL, which may precede discounts.
+func findTotal(lines []string) *float64 {
+	var total *float64
+	for _, line := range lines {
+		upper := strings.ToUpper(line)
+		match := totalPattern.FindStringSubmatch(upper)
+		if match == nil {
+			continue
+		}
+		amount, err := parseAmount(match[1])
+		if err != nil {
+			continue
+		}
+		if strings.Contains(upper, "A PAGAR") {
+			return &amount
+		}
+		if total == nil {
+			total = &amount
+		}
+	}
+	return total
+}
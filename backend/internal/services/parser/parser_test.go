@@ -0,0 +1,181 @@
+package parser
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file      string
+		store     string
+		grammar   string
+		date      string
+		items     []models.Item
+		discounts float64
+		total     float64
+	}{
+		{
+			file:    "aldi.txt",
+			store:   "ALDI",
+			grammar: "aldi-grammar-v1",
+			date:    "2024-03-05",
+			items: []models.Item{
+				{Name: "LECHE ENTERA", Quantity: 2, Price: 0.92},
+				{Name: "PLATANO DE CANARIAS", Quantity: 0.508, Price: 2.49},
+				{Name: "PAN DE MOLDE", Quantity: 1, Price: 1.35},
+				{Name: "ACEITE DE OLIVA VIRGEN", Quantity: 1, Price: 5.49},
+			},
+			discounts: 0.50,
+			total:     9.44,
+		},
+		{
+			// The DESCUENTOS line below A PAGAR wins over the product discounts
+			file:    "carrefour_express.txt",
+			store:   "CARREFOUR EXPRESS",
+			grammar: "carrefour-grammar-v1",
+			date:    "2024-03-15",
+			items: []models.Item{
+				{Name: "LECHE SEMIDESNATADA", Quantity: 3, Price: 0.89},
+				{Name: "PAN BARRA", Quantity: 1, Price: 0.65},
+				{Name: "PLATANO", Quantity: 0.750, Price: 1.99},
+				{Name: "YOGUR NATURAL", Quantity: 1, Price: 1.20},
+			},
+			discounts: 0.45,
+			total:     5.56,
+		},
+		{
+			file:    "carrefour.txt",
+			store:   "CARREFOUR",
+			grammar: "carrefour-grammar-v1",
+			date:    "2024-02-01",
+			items: []models.Item{
+				{Name: "AGUA MINERAL 1,5L", Quantity: 6, Price: 0.35},
+				{Name: "ACEITE OLIVA VIRGEN 1L", Quantity: 1, Price: 8.95},
+			},
+			discounts: 0.30,
+			total:     10.75,
+		},
+		{
+			// A quantity line right below the banner belongs to no product
+			file:    "carrefour_banner.txt",
+			store:   "CARREFOUR",
+			grammar: "carrefour-grammar-v1",
+			date:    "2024-01-01",
+			items: []models.Item{
+				{Name: "AGUA MINERAL 1,5L", Quantity: 1, Price: 0.35},
+			},
+			total: 2.35,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			text, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			receipt, grammar, err := Parse(string(text))
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if grammar.Version() != tt.grammar {
+				t.Errorf("grammar = %s, want %s", grammar.Version(), tt.grammar)
+			}
+			if receipt.StoreName != tt.store || receipt.BoughtDate != tt.date {
+				t.Errorf("got %s on %q, want %s on %q", receipt.StoreName, receipt.BoughtDate, tt.store, tt.date)
+			}
+
+			if len(receipt.Items) != len(tt.items) {
+				t.Fatalf("items = %+v, want %+v", receipt.Items, tt.items)
+			}
+			for i, item := range receipt.Items {
+				want := tt.items[i]
+				if item.Name != want.Name || !approx(item.Quantity, want.Quantity) || !approx(item.Price, want.Price) {
+					t.Errorf("item %d = %s %v x %v, want %s %v x %v", i, item.Name, item.Quantity, item.Price, want.Name, want.Quantity, want.Price)
+				}
+			}
+
+			if !approx(receipt.Discounts, tt.discounts) {
+				t.Errorf("discounts = %.2f, want %.2f", receipt.Discounts, tt.discounts)
+			}
+			if receipt.Total == nil || !approx(*receipt.Total, tt.total) {
+				t.Errorf("total = %v, want %.2f", receipt.Total, tt.total)
+			}
+		})
+	}
+}
+
+func TestParseUnparseable(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"empty", "\n  \n"},
+		{"unknown store", "MERCADONA\nLECHE 0,92\n01/01/2024"},
+		{"carrefour banner over a quantity line", "CARREFOUR\n2 x ( 1,00 ) 2,00\n01/01/2024"},
+		{"no date", "ALDI\nLECHE ENTERA 0,92 € A"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receipt, _, err := Parse(tt.text)
+			if !errors.Is(err, ErrUnparseable) {
+				t.Errorf("Parse = %+v, %v, want ErrUnparseable", receipt, err)
+			}
+		})
+	}
+}
+
+func TestFindDate(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"15/03/2024 18:42", "2024-03-15"},
+		{"5/3/2024", "2024-03-05"},
+		{"FECHA: 1-2-24", "2024-02-01"},
+		{"15.03.24 10:21", "2024-03-15"},
+		{"31/02/2024", ""},
+		{"NIF B-12345678", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if got := findDate([]string{tt.line}); got != tt.want {
+				t.Errorf("findDate(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+	}{
+		{"0,92", 0.92},
+		{"1.234,56", 1234.56},
+		{"-0,50", -0.50},
+		{"0,508", 0.508},
+		{"3,29 €", 3.29},
+		{"12.5", 12.5},
+	}
+
+	for _, tt := range tests {
+		got, err := parseAmount(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("parseAmount(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 0.001
+}
@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// SupportsFile reports whether a file may carry a machine-readable text layer
+func SupportsFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".txt", ".pdf", ".html", ".htm", ".eml":
+		return true
+	default:
+		return false
+	}
+}
+
+// IsMarkup reports whether a file is an HTML page or an email
+func IsMarkup(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm", ".eml":
+		return true
+	default:
+		return false
+	}
+}
+
+// ExtractText returns the text layer of a plain text or PDF receipt, one
+// receipt line per text line. Scanned PDFs have no text layer and return an
+// empty string. HTML pages and emails are rendered as text.
+func ExtractText(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".txt":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read text: %w", err)
+		}
+		return string(data), nil
+	case ".pdf":
+		return extractPDFText(path)
+	case ".html", ".htm", ".eml":
+		doc, text, err := readMarkup(path)
+		if err != nil {
+			return "", err
+		}
+		if doc != nil {
+			return strings.Join(doc.Lines, "\n"), nil
+		}
+		return text, nil
+	default:
+		return "", fmt.Errorf("unsupported file type for text extraction: %s", filepath.Ext(path))
+	}
+}
+
+// extractPDFText reads a PDF's text row by row, top to bottom
+func extractPDFText(path string) (string, error) {
+	file, reader, err := pdf.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open PDF: %w", err)
+	}
+	defer file.Close()
+
+	var sb strings.Builder
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+
+		rows, err := page.GetTextByRow()
+		if err != nil {
+			return "", fmt.Errorf("failed to read PDF page %d: %w", i, err)
+		}
+
+		for _, row := range rows {
+			// Runs may be single glyphs: only separate runs with a visible gap
+			end := 0.0
+			for j, text := range row.Content {
+				if j > 0 && text.X-end > text.FontSize*0.2 {
+					sb.WriteString(" ")
+				}
+				sb.WriteString(text.S)
+				end = text.X + text.W
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,177 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// ublInvoice is a UBL 2.x invoice, as used by Peppol and EN 16931. Elements are
+// matched by local name, so the cac/cbc namespaces are ignored.
+type ublInvoice struct {
+	Version   string `xml:"UBLVersionID"`
+	ID        string `xml:"ID"`
+	IssueDate string `xml:"IssueDate"`
+	Currency  string `xml:"DocumentCurrencyCode"`
+	Supplier  struct {
+		Names            []string `xml:"PartyName>Name"`
+		RegistrationName string   `xml:"PartyLegalEntity>RegistrationName"`
+		TaxSchemes       []struct {
+			CompanyID string `xml:"CompanyID"`
+			Scheme    string `xml:"TaxScheme>ID"`
+		} `xml:"PartyTaxScheme"`
+		LegalCompanyID string `xml:"PartyLegalEntity>CompanyID"`
+	} `xml:"AccountingSupplierParty>Party"`
+	AllowanceCharges []ublAllowanceCharge `xml:"AllowanceCharge"`
+	Totals           struct {
+		TaxInclusiveAmount amount `xml:"TaxInclusiveAmount"`
+	} `xml:"LegalMonetaryTotal"`
+	Lines []ublLine `xml:"InvoiceLine"`
+}
+
+type ublAllowanceCharge struct {
+	ChargeIndicator string `xml:"ChargeIndicator"`
+	Reason          string `xml:"AllowanceChargeReason"`
+	Amount          amount `xml:"Amount"`
+	TaxPercent      amount `xml:"TaxCategory>Percent"`
+}
+
+type ublLine struct {
+	Quantity            amount `xml:"InvoicedQuantity"`
+	LineExtensionAmount amount `xml:"LineExtensionAmount"` // After line allowances and charges, before taxes
+	Name                string `xml:"Item>Name"`
+	Description         string `xml:"Item>Description"`
+	TaxPercent          amount `xml:"Item>ClassifiedTaxCategory>Percent"`
+}
+
+// version identifies the UBL version, e.g. "ubl-2.1"
+func (inv *ublInvoice) version() string {
+	version := strings.TrimSpace(inv.Version)
+	if version == "" {
+		version = "2.1"
+	}
+	return "ubl-" + version
+}
+
+// supplierName returns the trading name of the supplier, or its legal name
+func (inv *ublInvoice) supplierName() string {
+	for _, name := range inv.Supplier.Names {
+		if name = strings.TrimSpace(name); name != "" {
+			return name
+		}
+	}
+	return strings.TrimSpace(inv.Supplier.RegistrationName)
+}
+
+// supplierTaxID returns the VAT identifier of the supplier, or its legal
+// registration identifier
+func (inv *ublInvoice) supplierTaxID() string {
+	for _, scheme := range inv.Supplier.TaxSchemes {
+		if strings.EqualFold(strings.TrimSpace(scheme.Scheme), "VAT") && strings.TrimSpace(scheme.CompanyID) != "" {
+			return strings.TrimSpace(scheme.CompanyID)
+		}
+	}
+	for _, scheme := range inv.Supplier.TaxSchemes {
+		if id := strings.TrimSpace(scheme.CompanyID); id != "" {
+			return id
+		}
+	}
+	return strings.TrimSpace(inv.Supplier.LegalCompanyID)
+}
+
+// receipt converts the invoice
+func (inv *ublInvoice) receipt(text string) (*Parsed, error) {
+	if err := checkCurrency(inv.Currency); err != nil {
+		return nil, err
+	}
+
+	store := inv.supplierName()
+	if store == "" {
+		return nil, fmt.Errorf("the UBL invoice supplier has no name")
+	}
+
+	boughtDate, err := invoiceDate(inv.IssueDate)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := &models.Receipt{
+		StoreName:      store,
+		StoreTaxID:     inv.supplierTaxID(),
+		BoughtDate:     boughtDate,
+		DocumentNumber: strings.TrimSpace(inv.ID),
+		Items:          []models.Item{},
+	}
+
+	for _, line := range inv.Lines {
+		item, err := line.item()
+		if err != nil {
+			return nil, err
+		}
+		receipt.Items = append(receipt.Items, item)
+	}
+
+	// Document level charges (e.g. shipping) are items of their own, and
+	// allowances end up in the discounts
+	for _, charge := range inv.AllowanceCharges {
+		if !strings.EqualFold(strings.TrimSpace(charge.ChargeIndicator), "true") {
+			continue
+		}
+		item, err := charge.item()
+		if err != nil {
+			return nil, err
+		}
+		receipt.Items = append(receipt.Items, item)
+	}
+
+	total, err := inv.Totals.TaxInclusiveAmount.value()
+	if err != nil {
+		return nil, err
+	}
+	settleTotal(receipt, total)
+
+	return &Parsed{Receipt: receipt, Version: inv.version(), Text: text}, nil
+}
+
+// item converts an invoice line, adding its VAT to its net amount
+func (line *ublLine) item() (models.Item, error) {
+	values, err := amounts(line.Quantity, line.LineExtensionAmount, line.TaxPercent)
+	if err != nil {
+		return models.Item{}, err
+	}
+	quantity, net, rate := values[0], values[1], values[2]
+
+	name := line.Name
+	if strings.TrimSpace(name) == "" {
+		name = line.Description
+	}
+
+	var taxRate *float64
+	if line.TaxPercent.isSet() {
+		taxRate = &rate
+	}
+
+	return invoiceItem(name, quantity, net*(1+rate/100), taxRate)
+}
+
+// item converts a document level charge
+func (charge *ublAllowanceCharge) item() (models.Item, error) {
+	values, err := amounts(charge.Amount, charge.TaxPercent)
+	if err != nil {
+		return models.Item{}, err
+	}
+	net, rate := values[0], values[1]
+
+	name := charge.Reason
+	if strings.TrimSpace(name) == "" {
+		name = "CHARGES"
+	}
+
+	var taxRate *float64
+	if charge.TaxPercent.isSet() {
+		taxRate = &rate
+	}
+
+	return invoiceItem(name, 1, net*(1+rate/100), taxRate)
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vieitesss/ticketer/internal/config"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/ai"
+	"github.com/vieitesss/ticketer/internal/services/webhooks"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// cassetteDir holds the golden cassettes and the receipts they were recorded
+// for. The receipts are placeholder images, which replay only matches by
+// digest, and the recorded answers were scripted to describe them. To record
+// real answers, replace an image with a photo of a receipt and upload it to a
+// server run with AI_CASSETTE_MODE=record and AI_CASSETTE_PATH set.
+var cassetteDir = filepath.Join("ai", "testdata")
+
+// replayConfig is the configuration the golden cassettes were recorded with.
+// The models and generation settings are part of each request's match key.
+func replayConfig() *config.Config {
+	return &config.Config{
+		AIStoreStage:       config.AIStageConfig{Model: "gemini-2.5-flash-lite"},
+		AIItemsStage:       config.AIStageConfig{Model: "gemini-2.5-flash"},
+		AIEscalationBudget: 3,
+		AIEscalationModel:  "gemini-2.5-pro",
+	}
+}
+
+// newReplayService creates a receipt service that extracts with the answers
+// of a golden cassette and saves to memory
+func newReplayService(t *testing.T, cassette string) (*ReceiptService, *memoryRepository) {
+	t.Helper()
+
+	replayer, err := ai.NewReplayer(filepath.Join(cassetteDir, cassette+".cassette.json"))
+	if err != nil {
+		t.Fatalf("failed to load cassette: %v", err)
+	}
+
+	cfg := replayConfig()
+	repository := newMemoryRepository()
+	dispatcher := webhooks.NewDispatcher(repository, cfg)
+	budgets := NewBudgetService(repository, repository, dispatcher, cfg)
+	return NewReceiptService(ai.NewGeminiServiceWithClient(replayer, cfg), repository, dispatcher, budgets, cfg), repository
+}
+
+func TestProcessReceiptReplay(t *testing.T) {
+	service, repository := newReplayService(t, "aldi")
+
+	receipt, err := service.ProcessReceipt(context.Background(), filepath.Join(cassetteDir, "aldi.png"))
+	if err != nil {
+		t.Fatalf("ProcessReceipt failed: %v", err)
+	}
+
+	if receipt.ID == "" {
+		t.Fatal("receipt was not saved")
+	}
+	if receipt.Store.Name != "ALDI" || receipt.BoughtDate != "2024-03-15" {
+		t.Errorf("got store %q on %q, want ALDI on 2024-03-15", receipt.Store.Name, receipt.BoughtDate)
+	}
+
+	want := []struct {
+		name     string
+		quantity float64
+		price    float64
+	}{
+		{"LECHE ENTERA", 2, 0.92},
+		{"PLATANO DE CANARIAS", 0.508, 2.49},
+		{"PAN DE MOLDE", 1, 1.35},
+	}
+	if len(receipt.Items) != len(want) {
+		t.Fatalf("got %d items, want %d", len(receipt.Items), len(want))
+	}
+	for i, item := range receipt.Items {
+		if item.ProductName != want[i].name || item.Quantity != want[i].quantity || item.PricePaid != want[i].price {
+			t.Errorf("item %d = %s %v x %v, want %s %v x %v", i, item.ProductName, item.Quantity, item.PricePaid,
+				want[i].name, want[i].quantity, want[i].price)
+		}
+	}
+	if math.Abs(receipt.TotalAmount-4.45) > 0.01 {
+		t.Errorf("total = %.2f, want 4.45", receipt.TotalAmount)
+	}
+
+	// A consistent extraction is kept as it is, without escalating, and its
+	// provenance is saved with the receipt
+	if len(repository.extractions) != 1 {
+		t.Fatalf("got %d extractions, want 1", len(repository.extractions))
+	}
+	extraction := repository.extractions[0]
+	if !extraction.Selected || extraction.Attempt != 1 || extraction.Strategy != "initial" || len(extraction.Issues) != 0 {
+		t.Errorf("extraction = attempt %d %q (selected %v, issues %v), want selected initial attempt 1 without issues",
+			extraction.Attempt, extraction.Strategy, extraction.Selected, extraction.Issues)
+	}
+	if extraction.ReceiptID != receipt.ID || extraction.StoreAnswer != "ALDI" {
+		t.Errorf("extraction for receipt %q with store %q, want %q with ALDI", extraction.ReceiptID, extraction.StoreAnswer, receipt.ID)
+	}
+	if extraction.StoreStage.Model != "gemini-2.5-flash-lite" || extraction.ItemsStage.Model != "gemini-2.5-flash" {
+		t.Errorf("stage models = %q, %q", extraction.StoreStage.Model, extraction.ItemsStage.Model)
+	}
+	if extraction.TotalTokens() == 0 || extraction.RawResponse == "" {
+		t.Errorf("extraction has %d tokens and raw response %q, want the recorded usage and answer", extraction.TotalTokens(), extraction.RawResponse)
+	}
+
+	// Processing the same receipt again is deterministic: it is a duplicate
+	result, err := service.ProcessReceiptFile(context.Background(), filepath.Join(cassetteDir, "aldi.png"))
+	if err != nil {
+		t.Fatalf("processing again failed: %v", err)
+	}
+	if result.DuplicateOf() != receipt.ID {
+		t.Errorf("second processing duplicate of %q, want %q", result.DuplicateOf(), receipt.ID)
+	}
+}
+
+func TestExtractionProvenance(t *testing.T) {
+	service, _ := newReplayService(t, "carrefour_fix")
+
+	receipt, err := service.ProcessReceipt(context.Background(), filepath.Join(cassetteDir, "carrefour_fix.png"))
+	if err != nil {
+		t.Fatalf("ProcessReceipt failed: %v", err)
+	}
+
+	// The provenance saved while processing is what the receipt shows
+	extraction, err := service.GetExtraction(context.Background(), receipt.ID)
+	if err != nil {
+		t.Fatalf("GetExtraction failed: %v", err)
+	}
+	if extraction.ReceiptID != receipt.ID || !extraction.Selected || extraction.Attempt != 2 || extraction.Strategy != "fix" {
+		t.Errorf("extraction = attempt %d %q of %q (selected %v), want the selected fix of %q",
+			extraction.Attempt, extraction.Strategy, extraction.ReceiptID, extraction.Selected, receipt.ID)
+	}
+	// The fix reuses the store answer of the initial attempt
+	if extraction.StoreAnswer != "CARREFOUR" || extraction.StoreStage.Model != "" || extraction.ItemsStage.PromptVersion != "carrefour-v2+fix-v1" {
+		t.Errorf("store %q (store stage %+v) with prompt %q, want CARREFOUR without a store stage and the fix prompt",
+			extraction.StoreAnswer, extraction.StoreStage, extraction.ItemsStage.PromptVersion)
+	}
+	stages := []dto.ExtractionStageResponse{extraction.StoreStage, extraction.ItemsStage}
+	total := int32(0)
+	for _, stage := range stages {
+		total += stage.PromptTokens + stage.OutputTokens
+	}
+	if total == 0 || extraction.TotalTokens != total {
+		t.Errorf("total tokens = %d, want %d from the stages", extraction.TotalTokens, total)
+	}
+	if !json.Valid([]byte(extraction.RawResponse)) {
+		t.Errorf("raw response %q is not the JSON answer", extraction.RawResponse)
+	}
+	if _, err := time.Parse(time.RFC3339, extraction.CreatedAt); err != nil {
+		t.Errorf("created at %q: %v", extraction.CreatedAt, err)
+	}
+
+	// Every attempt is listed, the discarded one with its issues
+	extractions, err := service.ListExtractions(context.Background(), receipt.ID)
+	if err != nil {
+		t.Fatalf("ListExtractions failed: %v", err)
+	}
+	if len(extractions) != 2 || extractions[0].Selected || len(extractions[0].Issues) == 0 || extractions[1].ID != extraction.ID {
+		t.Fatalf("extractions = %+v, want the initial attempt with issues and then the kept one", extractions)
+	}
+	if initial := extractions[0]; initial.StoreStage.PromptVersion != "store-v1" || initial.ItemsStage.PromptVersion != "carrefour-v2" {
+		t.Errorf("initial attempt prompts %q and %q, want store-v1 and carrefour-v2", initial.StoreStage.PromptVersion, initial.ItemsStage.PromptVersion)
+	}
+
+	if _, err := service.GetExtraction(context.Background(), "receipt-unknown"); err == nil {
+		t.Error("GetExtraction of an unknown receipt succeeded")
+	}
+}
+
+// stageNames returns the stage of each progress report, with the attempt of
+// the extraction stages
+func stageNames(progress []dto.ProcessingProgress) []string {
+	names := make([]string, len(progress))
+	for i, p := range progress {
+		names[i] = p.Stage
+		if p.Attempt > 0 {
+			names[i] += fmt.Sprintf("#%d", p.Attempt)
+		}
+	}
+	return names
+}
+
+func TestProcessProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		cassette string // Empty to process without AI
+		file     string
+		want     []string
+	}{
+		{
+			"extracted",
+			"aldi",
+			filepath.Join(cassetteDir, "aldi.png"),
+			[]string{"uploaded", "store_identified", "extraction_started#1", "items_parsed#1", "validated#1", "saved"},
+		},
+		{
+			"escalated",
+			"carrefour_fix",
+			filepath.Join(cassetteDir, "carrefour_fix.png"),
+			[]string{"uploaded", "store_identified", "extraction_started#1", "items_parsed#1", "validated#1",
+				"extraction_started#2", "items_parsed#2", "validated#2", "saved"},
+		},
+		{
+			"parsed",
+			"",
+			filepath.Join("parser", "testdata", "mercadona.eml"),
+			[]string{"uploaded", "store_identified", "items_parsed#1", "validated#1", "saved"},
+		},
+		{
+			// Images cannot be read without AI
+			"failed",
+			"",
+			filepath.Join(cassetteDir, "aldi.png"),
+			[]string{"uploaded", "failed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var service *ReceiptService
+			var repository *memoryRepository
+			if tt.cassette != "" {
+				service, repository = newReplayService(t, tt.cassette)
+			} else {
+				service, repository = newMemoryService()
+			}
+
+			var progress []dto.ProcessingProgress
+			result, _ := service.ProcessReceiptFileWith(context.Background(), tt.file, ProcessOptions{
+				Owner:    "ana",
+				Progress: func(p dto.ProcessingProgress) { progress = append(progress, p) },
+			})
+
+			if got := stageNames(progress); !slices.Equal(got, tt.want) {
+				t.Fatalf("stages = %q, want %q", got, tt.want)
+			}
+			for _, p := range progress {
+				if p.File != filepath.Base(tt.file) {
+					t.Errorf("%s reported for %q", p.Stage, p.File)
+				}
+			}
+
+			// Only the final stage carries the receipt
+			last := progress[len(progress)-1]
+			for _, p := range progress[:len(progress)-1] {
+				if p.Receipt != nil {
+					t.Errorf("%s carries the receipt", p.Stage)
+				}
+			}
+			if last.Stage == dto.StageSaved && (last.Receipt == nil || last.ReceiptID != result.Receipt.ID || last.Receipt.ID != last.ReceiptID) {
+				t.Errorf("saved stage = %+v, want the saved receipt", last)
+			}
+			if last.Stage == dto.StageFailed && last.Error == "" {
+				t.Error("failed stage without its error")
+			}
+
+			// The same stages are published to the owner, without the receipt
+			var published []dto.ProcessingProgress
+			for _, event := range repository.events {
+				if event.Type != models.EventProcessingProgress {
+					continue
+				}
+				var p dto.ProcessingProgress
+				if err := json.Unmarshal(event.Data, &p); err != nil {
+					t.Fatal(err)
+				}
+				if event.Owner != "ana" || p.Receipt != nil {
+					t.Errorf("published %s for %q with a receipt %v", p.Stage, event.Owner, p.Receipt != nil)
+				}
+				published = append(published, p)
+			}
+			if got := stageNames(published); !slices.Equal(got, tt.want) {
+				t.Errorf("published stages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessProgressDuplicate(t *testing.T) {
+	service, _ := newReplayService(t, "aldi")
+	if _, err := service.ProcessReceipt(context.Background(), filepath.Join(cassetteDir, "aldi.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	var last dto.ProcessingProgress
+	result, err := service.ProcessReceiptFileWith(context.Background(), filepath.Join(cassetteDir, "aldi.png"), ProcessOptions{
+		Progress: func(p dto.ProcessingProgress) { last = p },
+	})
+	if err != nil {
+		t.Fatalf("processing again failed: %v", err)
+	}
+	if last.Stage != dto.StageDuplicate || last.DuplicateOf == "" || last.DuplicateOf != result.DuplicateOf() {
+		t.Errorf("final stage = %s of %q, want duplicate of %q", last.Stage, last.DuplicateOf, result.DuplicateOf())
+	}
+}
@@ -2,39 +2,221 @@ package services
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/config"
 	"github.com/vieitesss/ticketer/internal/database"
 	"github.com/vieitesss/ticketer/internal/models"
 	"github.com/vieitesss/ticketer/internal/services/ai"
+	"github.com/vieitesss/ticketer/internal/services/events"
+	"github.com/vieitesss/ticketer/internal/services/export"
+	"github.com/vieitesss/ticketer/internal/services/webhooks"
 	"github.com/vieitesss/ticketer/internal/transport/dto"
 )
 
 type ReceiptService struct {
 	aiService *ai.GeminiService
 	db        database.ReceiptRepository
+
+	// escalationBudget is how many escalated attempts an extraction failing validation gets
+	escalationBudget int
+
+	// batchConcurrency bounds how many files of a batch are processed at once
+	batchConcurrency int
+
+	// uploadsDir is where uploaded files are saved, and kept for saved receipts
+	uploadsDir string
+
+	// accounts maps receipts to the accounts of accounting exports
+	accounts export.Accounts
+
+	// budgets alerts when new receipts push budgets past their thresholds
+	budgets *BudgetService
+
+	// webhooks queues events for, and delivers them to, webhooks (nil without a database)
+	webhooks *webhooks.Dispatcher
+
+	// events streams changes and processing progress to clients (nil without a database)
+	events *events.Broker
 }
 
-func NewReceiptService(ctx context.Context, db database.ReceiptRepository) (*ReceiptService, error) {
-	// Initialize AI service
-	geminiService, err := ai.NewGeminiService(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Gemini service: %w", err)
+// NewReceiptService creates the receipt service, queueing its events for
+// webhooks with dispatcher (nil without a database) and checking new receipts
+// against budgets
+func NewReceiptService(aiService *ai.GeminiService, db database.ReceiptRepository, dispatcher *webhooks.Dispatcher, budgets *BudgetService, cfg *config.Config) *ReceiptService {
+	var broker *events.Broker
+	if db != nil {
+		broker = events.NewBroker(db)
 	}
 
 	return &ReceiptService{
-		aiService: geminiService,
-		db:        db,
-	}, nil
+		aiService:        aiService,
+		db:               db,
+		escalationBudget: cfg.AIEscalationBudget,
+		batchConcurrency: cfg.BatchConcurrency,
+		uploadsDir:       cfg.UploadsDir,
+		accounts: export.Accounts{
+			Payment:    cfg.AccountingPaymentAccount,
+			Default:    cfg.AccountingDefaultAccount,
+			Discount:   cfg.AccountingDiscountAccount,
+			Commodity:  cfg.AccountingCommodity,
+			Stores:     cfg.AccountingStoreAccounts,
+			Categories: cfg.AccountingCategoryAccounts,
+		},
+		budgets:  budgets,
+		webhooks: dispatcher,
+		events:   broker,
+	}
 }
 
 func (s *ReceiptService) ProcessReceipt(ctx context.Context, imagePath string) (*dto.ReceiptResponse, error) {
-	// Process receipt using AI service
-	receipt, err := s.aiService.ProcessReceipt(ctx, imagePath)
+	result, err := s.ProcessReceiptFile(ctx, imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Receipt, nil
+}
+
+// ProcessResult is the outcome of processing a receipt file
+type ProcessResult struct {
+	Receipt *dto.ReceiptResponse
+	// SaveErr is why the receipt was not saved, nil when it was (or there is no database)
+	SaveErr error
+}
+
+// DuplicateOf returns the ID of the existing receipt when the file was a duplicate
+func (r *ProcessResult) DuplicateOf() string {
+	var duplicateErr *database.DuplicateReceiptError
+	if errors.As(r.SaveErr, &duplicateErr) {
+		return duplicateErr.ExistingID
+	}
+	return ""
+}
+
+// ProcessReceiptFile extracts a receipt from a file and saves it. A failure to
+// save does not fail processing, it is reported in the result instead.
+func (s *ReceiptService) ProcessReceiptFile(ctx context.Context, imagePath string) (*ProcessResult, error) {
+	return s.ProcessReceiptFileAs(ctx, imagePath, "")
+}
+
+// ProcessReceiptFileAs processes a receipt file submitted by a known user
+func (s *ReceiptService) ProcessReceiptFileAs(ctx context.Context, imagePath, owner string) (*ProcessResult, error) {
+	return s.ProcessReceiptFileWith(ctx, imagePath, ProcessOptions{Owner: owner})
+}
+
+// ProcessOptions are the optional settings of processing a receipt file
+type ProcessOptions struct {
+	// Owner is the user who submitted the file
+	Owner string
+	// HouseholdID is the household the receipt is saved in, by default the
+	// only one the owner edits in (see receiptHousehold)
+	HouseholdID string
+	// Progress is called with each stage of the processing as it happens, the
+	// final one with the receipt. Every stage is also published as a
+	// processing.progress event.
+	Progress func(dto.ProcessingProgress)
+}
+
+// receiptHousehold returns the household a receipt of an owner is saved in:
+// the one asked for, where the owner has to be an editor, or else the only
+// household the owner edits in, if any. Receipts in no household are only
+// seen by their owner once households exist.
+func (s *ReceiptService) receiptHousehold(ctx context.Context, owner, requested string) (string, error) {
+	requested = strings.TrimSpace(requested)
+	if s.db == nil {
+		return "", nil
+	}
+	if owner == "" {
+		if requested != "" {
+			return "", &ValidationError{Issues: []string{"receipts without an owner cannot be saved in a household"}}
+		}
+		return "", nil
+	}
+
+	households, err := s.db.ListMemberHouseholds(ctx, owner, models.RoleEditor)
+	if err != nil {
+		return "", err
+	}
+	if requested != "" {
+		if !slices.Contains(households, requested) {
+			return "", ErrHouseholdRole
+		}
+		return requested, nil
+	}
+	if len(households) == 1 {
+		return households[0], nil
+	}
+	return "", nil
+}
+
+// householdLister lists the households where a member has at least a role
+type householdLister interface {
+	ListMemberHouseholds(ctx context.Context, member, role string) ([]string, error)
+}
+
+// sharedHousehold checks the household a rule, budget, participant or
+// settlement of an owner is shared in, where the owner has to be an editor.
+// Without one, it is kept by its owner alone.
+func sharedHousehold(ctx context.Context, db householdLister, owner, requested string) (string, error) {
+	requested = strings.TrimSpace(requested)
+	if requested == "" {
+		return "", nil
+	}
+	if owner == "" {
+		return "", &ValidationError{Issues: []string{"only users can share in a household"}}
+	}
+
+	households, err := db.ListMemberHouseholds(ctx, owner, models.RoleEditor)
 	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(households, requested) {
+		return "", ErrHouseholdRole
+	}
+	return requested, nil
+}
+
+// ProcessReceiptFileWith processes a receipt file, reporting its progress.
+// Cancelling ctx before the receipt is saved fails the processing.
+func (s *ReceiptService) ProcessReceiptFileWith(ctx context.Context, imagePath string, opts ProcessOptions) (*ProcessResult, error) {
+	report := s.progressReporter(ctx, imagePath, opts)
+	report(dto.ProcessingProgress{Stage: dto.StageUploaded})
+
+	// Check the household before paying for the extraction
+	householdID, err := s.receiptHousehold(ctx, opts.Owner, opts.HouseholdID)
+	if err != nil {
+		report(dto.ProcessingProgress{Stage: dto.StageFailed, Error: err.Error()})
+		return nil, err
+	}
+
+	// Parse or extract the receipt, escalating if the result is inconsistent
+	receipt, extractions, err := s.extractReceipt(ctx, imagePath, report)
+	if err != nil {
+		s.saveExtractions(ctx, extractions, "")
+		report(dto.ProcessingProgress{Stage: dto.StageFailed, Error: err.Error()})
+		s.emit(ctx, models.EventExtractionFailed, dto.ExtractionFailedEvent{
+			File:  filepath.Base(imagePath),
+			Owner: opts.Owner,
+			Error: err.Error(),
+		})
 		return nil, err
 	}
+	if err := ctx.Err(); err != nil {
+		report(dto.ProcessingProgress{Stage: dto.StageFailed, Error: err.Error()})
+		return nil, err
+	}
+	receipt.ImagePath = imagePath
+	receipt.Owner = opts.Owner
+	receipt.HouseholdID = householdID
+
+	result := &ProcessResult{}
 
 	// Save to database if available
 	if s.db != nil {
@@ -42,13 +224,58 @@ func (s *ReceiptService) ProcessReceipt(ctx context.Context, imagePath string) (
 		if err != nil {
 			log.Warn("Failed to save receipt to database", "error", err)
 			// Don't fail the request if database save fails
+			result.SaveErr = err
 		} else {
 			log.Info("Receipt saved to database", "id", receiptID)
+			receipt.ID = receiptID
 		}
 	}
 
+	s.saveExtractions(ctx, extractions, receipt.ID)
+	s.receiptSaved(ctx, receipt.ID)
+
 	// Convert to DTO with calculated fields
-	return s.modelToDTO(receipt), nil
+	result.Receipt = s.modelToDTO(receipt)
+
+	final := dto.ProcessingProgress{Stage: dto.StageSaved, ReceiptID: receipt.ID, Receipt: result.Receipt}
+	switch {
+	case result.DuplicateOf() != "":
+		final.Stage, final.DuplicateOf = dto.StageDuplicate, result.DuplicateOf()
+	case result.SaveErr != nil:
+		final.Stage, final.Error = dto.StageFailed, result.SaveErr.Error()
+	case receipt.ID == "":
+		final.Stage = dto.StageProcessed
+	}
+	report(final)
+
+	return result, nil
+}
+
+// saveExtractions stores the provenance of every extraction attempt, linked to
+// receiptID when not empty, and returns the ID of the selected attempt (empty
+// when it was not saved)
+func (s *ReceiptService) saveExtractions(ctx context.Context, extractions []*models.Extraction, receiptID string) string {
+	if s.db == nil {
+		return ""
+	}
+
+	selectedID := ""
+	for _, extraction := range extractions {
+		extraction.ReceiptID = receiptID
+
+		extractionID, err := s.db.CreateExtraction(ctx, extraction)
+		if err != nil {
+			log.Warn("Failed to save extraction to database", "error", err)
+			continue
+		}
+		log.Debug("Extraction saved to database", "id", extractionID, "receipt_id", receiptID, "attempt", extraction.Attempt)
+
+		if extraction.Selected {
+			selectedID = extractionID
+		}
+	}
+
+	return selectedID
 }
 
 func (s *ReceiptService) GetReceipt(ctx context.Context, id string) (*dto.ReceiptResponse, error) {
@@ -60,8 +287,8 @@ func (s *ReceiptService) GetReceipt(ctx context.Context, id string) (*dto.Receip
 	return s.modelToDTO(receipt), nil
 }
 
-func (s *ReceiptService) ListReceipts(ctx context.Context, limit, offset int) ([]dto.ReceiptListItem, error) {
-	receipts, err := s.db.ListReceipts(ctx, limit, offset)
+func (s *ReceiptService) ListReceipts(ctx context.Context, filter database.ReceiptFilter, limit, offset int) ([]dto.ReceiptListItem, error) {
+	receipts, err := s.db.ListReceipts(ctx, filter, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -74,20 +301,88 @@ func (s *ReceiptService) ListReceipts(ctx context.Context, limit, offset int) ([
 			ItemCount:   receipt.ItemCount,
 			BoughtDate:  receipt.BoughtDate,
 			TotalAmount: receipt.TotalAmount,
+			Categories:  receipt.Categories,
+			Tags:        nonNilTags(receipt.Tags),
 		}
 	}
 
 	return listItems, nil
 }
 
-// DeleteReceipt deletes a receipt by ID
+// GetExtraction retrieves the provenance of a receipt's kept extraction
+func (s *ReceiptService) GetExtraction(ctx context.Context, receiptID string) (*dto.ExtractionResponse, error) {
+	extraction, err := s.db.GetExtractionByReceipt(ctx, receiptID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := extractionToDTO(extraction)
+	return &response, nil
+}
+
+// ListExtractions retrieves the provenance of every extraction attempt of a receipt
+func (s *ReceiptService) ListExtractions(ctx context.Context, receiptID string) ([]dto.ExtractionResponse, error) {
+	extractions, err := s.db.ListExtractionsByReceipt(ctx, receiptID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]dto.ExtractionResponse, len(extractions))
+	for i := range extractions {
+		responses[i] = extractionToDTO(&extractions[i])
+	}
+
+	return responses, nil
+}
+
+// DeleteReceipt deletes a receipt by ID, along with its original image
 func (s *ReceiptService) DeleteReceipt(ctx context.Context, id string) error {
-	return s.db.DeleteReceipt(ctx, id)
+	receipt, err := s.db.GetReceipt(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.DeleteReceipt(ctx, id); err != nil {
+		return err
+	}
+	s.emit(ctx, models.EventReceiptDeleted, s.modelToDTO(receipt))
+
+	if receipt.ImagePath != "" {
+		if err := os.Remove(receipt.ImagePath); err != nil && !os.IsNotExist(err) {
+			log.Warn("Failed to remove receipt image", "path", receipt.ImagePath, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// receiptSaved runs what follows saving a new receipt: the automation rules,
+// the receipt.created event, then the budget checks, as rules may change the
+// tags and categories that budgets look at. It does nothing for a receipt
+// that was not saved.
+func (s *ReceiptService) receiptSaved(ctx context.Context, receiptID string) {
+	s.runRules(ctx, receiptID)
+	s.emitReceipt(ctx, models.EventReceiptCreated, receiptID)
+	s.budgets.checkBudgets(ctx, receiptID)
+}
+
+// receiptChanged runs what follows changing the contents of a receipt: the
+// automation rules, then the receipt.updated event
+func (s *ReceiptService) receiptChanged(ctx context.Context, receiptID string) {
+	s.runRules(ctx, receiptID)
+	s.emitReceipt(ctx, models.EventReceiptUpdated, receiptID)
 }
 
-// UpdateItem updates an item's quantity and price
+// UpdateItem updates an item's quantity and price, then runs the automation
+// rules on its receipt again
 func (s *ReceiptService) UpdateItem(ctx context.Context, itemID string, quantity, pricePaid float64) error {
-	return s.db.UpdateItem(ctx, itemID, quantity, pricePaid)
+	receiptID, err := s.db.UpdateItem(ctx, itemID, quantity, pricePaid)
+	if err != nil {
+		return err
+	}
+
+	s.receiptChanged(ctx, receiptID)
+	return nil
 }
 
 // modelToDTO converts a receipt model to a DTO with calculated fields
@@ -100,11 +395,18 @@ func (s *ReceiptService) modelToDTO(receipt *models.Receipt) *dto.ReceiptRespons
 		subtotal += itemSubtotal
 		items[i] = dto.ItemResponse{
 			ID:          item.ID,
-			ProductID:   item.ID, // TODO: Need to get actual product_id from database
+			ProductID:   item.ProductID,
 			ProductName: item.Name,
 			Quantity:    item.Quantity,
 			PricePaid:   item.Price,
 			Subtotal:    itemSubtotal,
+			TaxRate:     item.TaxRate,
+
+			CategoryID:     item.CategoryID,
+			Category:       item.Category,
+			CategorySource: item.CategorySource,
+			Tags:           nonNilTags(item.Tags),
+			Notes:          item.Notes,
 		}
 	}
 
@@ -114,17 +416,62 @@ func (s *ReceiptService) modelToDTO(receipt *models.Receipt) *dto.ReceiptRespons
 	// Extract store info (for now, we only have store name)
 	// TODO: Need to get actual store ID from database
 	storeResponse := dto.StoreResponse{
-		ID:   "",
-		Name: receipt.StoreName,
+		ID:    "",
+		Name:  receipt.StoreName,
+		TaxID: receipt.StoreTaxID,
 	}
 
 	return &dto.ReceiptResponse{
-		ID:          receipt.ID,
-		Store:       storeResponse,
-		BoughtDate:  receipt.BoughtDate,
-		Items:       items,
-		Subtotal:    subtotal,
-		Discounts:   receipt.Discounts,
-		TotalAmount: totalAmount,
+		ID:             receipt.ID,
+		Store:          storeResponse,
+		BoughtDate:     receipt.BoughtDate,
+		Items:          items,
+		Subtotal:       subtotal,
+		Discounts:      receipt.Discounts,
+		TotalAmount:    totalAmount,
+		Owner:          receipt.Owner,
+		DocumentNumber: receipt.DocumentNumber,
+		Tags:           nonNilTags(receipt.Tags),
+		Notes:          receipt.Notes,
+		Business:       receipt.Business,
+		HouseholdID:    receipt.HouseholdID,
+	}
+}
+
+// nonNilTags returns tags, or an empty list instead of nil
+func nonNilTags(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// extractionToDTO converts an extraction model to a DTO
+func extractionToDTO(extraction *models.Extraction) dto.ExtractionResponse {
+	return dto.ExtractionResponse{
+		ID:          extraction.ID,
+		ReceiptID:   extraction.ReceiptID,
+		StoreAnswer: extraction.StoreAnswer,
+		StoreStage:  stageToDTO(extraction.StoreStage),
+		ItemsStage:  stageToDTO(extraction.ItemsStage),
+		TotalTokens: extraction.TotalTokens(),
+		Attempt:     extraction.Attempt,
+		Strategy:    extraction.Strategy,
+		Issues:      extraction.Issues,
+		Selected:    extraction.Selected,
+		RawResponse: extraction.RawResponse,
+		Error:       extraction.Error,
+		CreatedAt:   extraction.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// stageToDTO converts an extraction stage model to a DTO
+func stageToDTO(stage models.ExtractionStage) dto.ExtractionStageResponse {
+	return dto.ExtractionStageResponse{
+		Model:         stage.Model,
+		PromptVersion: stage.PromptVersion,
+		LatencyMs:     stage.LatencyMs,
+		PromptTokens:  stage.PromptTokens,
+		OutputTokens:  stage.OutputTokens,
 	}
 }
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// ReprocessReceipt re-runs the extraction of a stored receipt with the current
+// prompts, grammars and models, and stores the result as a proposal to accept or reject
+func (s *ReceiptService) ReprocessReceipt(ctx context.Context, id string) (*dto.ReprocessingResponse, error) {
+	stored, err := s.db.GetReceipt(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.ImagePath == "" {
+		return nil, fmt.Errorf("original image not available for receipt %s", id)
+	}
+
+	log.Info("Reprocessing receipt", "id", id)
+
+	proposed, extractions, err := s.extractReceipt(ctx, stored.ImagePath, func(dto.ProcessingProgress) {})
+	if err != nil {
+		s.saveExtractions(ctx, extractions, "")
+		return nil, err
+	}
+
+	// Attempts stay unlinked until the proposal is accepted
+	reprocessing := &models.Reprocessing{
+		ReceiptID:    id,
+		ExtractionID: s.saveExtractions(ctx, extractions, ""),
+		Status:       models.ReprocessingPending,
+		Proposed:     *proposed,
+		Diff:         diffReceipts(stored, proposed),
+		CreatedAt:    time.Now(),
+	}
+
+	reprocessing.ID, err = s.db.CreateReprocessing(ctx, reprocessing)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Reprocessing ready for review",
+		"id", reprocessing.ID,
+		"receipt_id", id,
+		"added", len(reprocessing.Diff.Added),
+		"removed", len(reprocessing.Diff.Removed),
+		"changed", len(reprocessing.Diff.Changed))
+
+	return s.reprocessingToDTO(reprocessing), nil
+}
+
+// ReprocessReceipts reprocesses every receipt matching a filter. Failures are
+// reported per receipt and do not stop the rest of the batch.
+func (s *ReceiptService) ReprocessReceipts(ctx context.Context, filter database.ReceiptFilter) ([]dto.ReprocessResult, error) {
+	ids, err := s.db.ListReceiptIDs(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]dto.ReprocessResult, len(ids))
+	for i, id := range ids {
+		results[i].ReceiptID = id
+
+		reprocessing, err := s.ReprocessReceipt(ctx, id)
+		if err != nil {
+			log.Warn("Failed to reprocess receipt", "id", id, "error", err)
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Reprocessing = reprocessing
+	}
+
+	return results, nil
+}
+
+// AcceptReprocessing replaces the receipt's contents with the proposed extraction
+func (s *ReceiptService) AcceptReprocessing(ctx context.Context, id string) (*dto.ReceiptResponse, error) {
+	reprocessing, err := s.db.AcceptReprocessing(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if reprocessing.ExtractionID != "" {
+		if err := s.db.LinkExtraction(ctx, reprocessing.ExtractionID, reprocessing.ReceiptID); err != nil {
+			log.Warn("Failed to link extraction to receipt", "extraction_id", reprocessing.ExtractionID, "error", err)
+		}
+	}
+
+	log.Info("Reprocessing accepted", "id", id, "receipt_id", reprocessing.ReceiptID)
+
+	s.receiptChanged(ctx, reprocessing.ReceiptID)
+
+	return s.GetReceipt(ctx, reprocessing.ReceiptID)
+}
+
+// RejectReprocessing discards a proposed extraction, leaving the receipt untouched
+func (s *ReceiptService) RejectReprocessing(ctx context.Context, id string) error {
+	return s.db.RejectReprocessing(ctx, id)
+}
+
+// reprocessingToDTO converts a reprocessing model to a DTO
+func (s *ReceiptService) reprocessingToDTO(reprocessing *models.Reprocessing) *dto.ReprocessingResponse {
+	proposed := reprocessing.Proposed
+	proposed.ID = reprocessing.ReceiptID
+
+	return &dto.ReprocessingResponse{
+		ID:        reprocessing.ID,
+		ReceiptID: reprocessing.ReceiptID,
+		Status:    reprocessing.Status,
+		Diff:      reprocessing.Diff,
+		Proposed:  *s.modelToDTO(&proposed),
+		CreatedAt: reprocessing.CreatedAt.Format(time.RFC3339),
+	}
+}
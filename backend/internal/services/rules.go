@@ -0,0 +1,489 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/rules"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// Limits of automation rules and their previews
+const (
+	maxRuleActions      = 20
+	maxNoteLength       = 500
+	rulePreviewLimit    = 500 // Receipts a preview looks at by default
+	maxRulePreviewLimit = 5000
+	maxPreviewErrors    = 20
+)
+
+// compiledRule is an automation rule with its compiled condition
+type compiledRule struct {
+	rule    models.Rule
+	program *rules.Program
+}
+
+// ListRules retrieves the automation rules of a scope, highest priority first
+func (s *ReceiptService) ListRules(ctx context.Context, scope database.Scope) ([]dto.RuleResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	ruleList, err := s.db.ListRules(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.RuleResponse, len(ruleList))
+	for i := range ruleList {
+		response[i] = ruleToDTO(&ruleList[i])
+	}
+	return response, nil
+}
+
+// CreateRule adds an automation rule of the member of a scope, shared in the
+// household of the request, if any. It applies to receipts saved or edited
+// from then on; use a preview to see what it would do to past ones.
+func (s *ReceiptService) CreateRule(ctx context.Context, scope database.Scope, req dto.RuleRequest) (*dto.RuleResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rule, _, err := s.ruleFromRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	rule.Owner = scope.Member
+	if rule.HouseholdID, err = sharedHousehold(ctx, s.db, scope.Member, req.HouseholdID); err != nil {
+		return nil, err
+	}
+
+	id, err := s.db.CreateRule(ctx, rule)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Rule created", "id", id, "name", rule.Name, "scope", rule.Scope, "owner", rule.Owner, "household", rule.HouseholdID)
+
+	return s.getRule(ctx, id, scope)
+}
+
+// UpdateRule replaces an automation rule of a scope, keeping its owner and
+// household
+func (s *ReceiptService) UpdateRule(ctx context.Context, scope database.Scope, id string, req dto.RuleRequest) (*dto.RuleResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rule, _, err := s.ruleFromRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	rule.ID = id
+
+	if err := s.db.UpdateRule(ctx, rule, scope); err != nil {
+		return nil, err
+	}
+
+	return s.getRule(ctx, id, scope)
+}
+
+// DeleteRule deletes an automation rule of a scope. What it already changed stays.
+func (s *ReceiptService) DeleteRule(ctx context.Context, scope database.Scope, id string) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+
+	return s.db.DeleteRule(ctx, id, scope)
+}
+
+// getRule retrieves an automation rule of a scope as a response
+func (s *ReceiptService) getRule(ctx context.Context, id string, scope database.Scope) (*dto.RuleResponse, error) {
+	rule, err := s.db.GetRule(ctx, id, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	response := ruleToDTO(rule)
+	return &response, nil
+}
+
+// ruleFromRequest validates a rule request, compiling its condition and
+// resolving the categories of its actions to IDs
+func (s *ReceiptService) ruleFromRequest(ctx context.Context, req dto.RuleRequest) (*models.Rule, *rules.Program, error) {
+	rule := &models.Rule{
+		Name:      strings.Join(strings.Fields(req.Name), " "),
+		Scope:     strings.ToLower(strings.TrimSpace(req.Scope)),
+		Condition: strings.TrimSpace(req.Condition),
+		Enabled:   req.Enabled == nil || *req.Enabled,
+		Priority:  req.Priority,
+	}
+	if rule.Scope == "" {
+		rule.Scope = models.RuleScopeReceipt
+	}
+
+	issues := []string{}
+	switch {
+	case rule.Name == "":
+		issues = append(issues, "the rule name is missing")
+	case len(rule.Name) > 100:
+		issues = append(issues, "the rule name is longer than 100 characters")
+	}
+
+	var program *rules.Program
+	if rule.Scope != models.RuleScopeReceipt && rule.Scope != models.RuleScopeItem {
+		issues = append(issues, fmt.Sprintf("unknown scope %q (expected receipt or item)", req.Scope))
+	} else {
+		var err error
+		if program, err = rules.Compile(rules.Scope(rule.Scope), rule.Condition); err != nil {
+			issues = append(issues, fmt.Sprintf("invalid condition: %v", err))
+		}
+	}
+
+	switch {
+	case len(req.Actions) == 0:
+		issues = append(issues, "the rule has no actions")
+	case len(req.Actions) > maxRuleActions:
+		issues = append(issues, fmt.Sprintf("the rule has more than %d actions", maxRuleActions))
+	}
+
+	var categories []models.Category
+	for i, action := range req.Actions {
+		actionType := strings.ToLower(strings.TrimSpace(action.Type))
+		value, issue := "", ""
+
+		switch actionType {
+		case models.RuleActionTag:
+			if value = NormalizeTag(action.Value); value == "" {
+				issue = "the tag is empty"
+			} else if len(value) > maxTagLength {
+				issue = fmt.Sprintf("the tag is longer than %d characters", maxTagLength)
+			}
+
+		case models.RuleActionNote:
+			if value = strings.Join(strings.Fields(action.Value), " "); value == "" {
+				issue = "the note is empty"
+			} else if len(value) > maxNoteLength {
+				issue = fmt.Sprintf("the note is longer than %d characters", maxNoteLength)
+			}
+
+		case models.RuleActionBusiness:
+			business := true
+			if strings.TrimSpace(action.Value) != "" {
+				var err error
+				if business, err = strconv.ParseBool(strings.TrimSpace(action.Value)); err != nil {
+					issue = fmt.Sprintf("invalid value %q (expected true or false)", action.Value)
+				}
+			}
+			value = strconv.FormatBool(business)
+
+		case models.RuleActionCategory:
+			if categories == nil {
+				var err error
+				if categories, err = s.db.ListCategories(ctx); err != nil {
+					return nil, nil, err
+				}
+			}
+			if value = resolveCategory(categories, action.Value); value == "" {
+				issue = fmt.Sprintf("unknown category %q", action.Value)
+			}
+
+		default:
+			issue = fmt.Sprintf("unknown type %q (expected tag, category, note or business)", action.Type)
+		}
+
+		if issue != "" {
+			issues = append(issues, fmt.Sprintf("action %d: %s", i+1, issue))
+			continue
+		}
+		rule.Actions = append(rule.Actions, models.RuleAction{Type: actionType, Value: value})
+	}
+
+	if len(issues) > 0 {
+		return nil, nil, &ValidationError{Issues: issues}
+	}
+
+	return rule, program, nil
+}
+
+// resolveCategory finds a category by ID or by path, regardless of case.
+// Returns its ID, or an empty string if there is none.
+func resolveCategory(categories []models.Category, value string) string {
+	value = strings.Trim(strings.TrimSpace(value), "/")
+	for _, category := range categories {
+		if category.ID == value || strings.EqualFold(category.Path, value) {
+			return category.ID
+		}
+	}
+	return ""
+}
+
+// runRules evaluates the enabled automation rules that run on a saved receipt
+// and applies their actions. Rules never block a save: failures are logged.
+func (s *ReceiptService) runRules(ctx context.Context, receiptID string) {
+	if s.db == nil || receiptID == "" {
+		return
+	}
+
+	ruleList, err := s.db.ListReceiptRules(ctx, receiptID)
+	if err != nil {
+		log.Warn("Failed to load rules", "error", err)
+		return
+	}
+	if len(ruleList) == 0 {
+		return
+	}
+
+	compiled := make([]compiledRule, 0, len(ruleList))
+	for _, rule := range ruleList {
+		program, err := rules.Compile(rules.Scope(rule.Scope), rule.Condition)
+		if err != nil {
+			log.Warn("Skipping invalid rule", "id", rule.ID, "name", rule.Name, "error", err)
+			continue
+		}
+		compiled = append(compiled, compiledRule{rule: rule, program: program})
+	}
+
+	receipt, err := s.db.GetReceipt(ctx, receiptID)
+	if err != nil {
+		log.Warn("Failed to load receipt for rules", "id", receiptID, "error", err)
+		return
+	}
+
+	effects := &models.RuleEffects{}
+	matched := 0
+	for _, c := range compiled {
+		ok, items, err := c.program.Match(receipt)
+		if err != nil {
+			log.Warn("Rule evaluation failed", "rule", c.rule.Name, "receipt", receiptID, "error", err)
+		}
+		if ok {
+			matched++
+			addRuleEffects(effects, &c.rule, receipt, items)
+		}
+	}
+
+	if effects.Empty() {
+		return
+	}
+
+	if err := s.db.ApplyRuleEffects(ctx, receiptID, effects); err != nil {
+		log.Warn("Failed to apply rules", "receipt", receiptID, "error", err)
+		return
+	}
+	log.Info("Rules applied", "receipt", receiptID, "matched", matched)
+}
+
+// addRuleEffects adds what a matching rule changes on a receipt to effects,
+// leaving out what the receipt already has. items are the indexes of the
+// matching items of item rules; receipt rules act on the receipt, and their
+// categories on all its items. When rules disagree, the first (the highest
+// priority) wins.
+func addRuleEffects(effects *models.RuleEffects, rule *models.Rule, receipt *models.Receipt, items []int) {
+	if rule.Scope == models.RuleScopeReceipt {
+		items = make([]int, len(receipt.Items))
+		for i := range items {
+			items[i] = i
+		}
+	}
+
+	for _, action := range rule.Actions {
+		switch action.Type {
+		case models.RuleActionTag:
+			if rule.Scope == models.RuleScopeReceipt {
+				if !slices.Contains(receipt.Tags, action.Value) && !slices.Contains(effects.ReceiptTags, action.Value) {
+					effects.ReceiptTags = append(effects.ReceiptTags, action.Value)
+				}
+				continue
+			}
+			for _, i := range items {
+				item := &receipt.Items[i]
+				if !slices.Contains(item.Tags, action.Value) && !slices.Contains(effects.ItemTags[item.ID], action.Value) {
+					if effects.ItemTags == nil {
+						effects.ItemTags = map[string][]string{}
+					}
+					effects.ItemTags[item.ID] = append(effects.ItemTags[item.ID], action.Value)
+				}
+			}
+
+		case models.RuleActionNote:
+			if rule.Scope == models.RuleScopeReceipt {
+				if !hasNote(receipt.Notes, action.Value) && !slices.Contains(effects.ReceiptNotes, action.Value) {
+					effects.ReceiptNotes = append(effects.ReceiptNotes, action.Value)
+				}
+				continue
+			}
+			for _, i := range items {
+				item := &receipt.Items[i]
+				if !hasNote(item.Notes, action.Value) && !slices.Contains(effects.ItemNotes[item.ID], action.Value) {
+					if effects.ItemNotes == nil {
+						effects.ItemNotes = map[string][]string{}
+					}
+					effects.ItemNotes[item.ID] = append(effects.ItemNotes[item.ID], action.Value)
+				}
+			}
+
+		case models.RuleActionBusiness:
+			business := action.Value == "true"
+			if effects.Business == nil && business != receipt.Business {
+				effects.Business = &business
+			}
+
+		case models.RuleActionCategory:
+			for _, i := range items {
+				item := &receipt.Items[i]
+				if item.ProductID == "" || item.CategorySource == models.CategorySourceManual || item.CategoryID == action.Value {
+					continue
+				}
+				if _, decided := effects.ProductCategories[item.ProductID]; decided {
+					continue
+				}
+				if effects.ProductCategories == nil {
+					effects.ProductCategories = map[string]string{}
+				}
+				effects.ProductCategories[item.ProductID] = action.Value
+			}
+		}
+	}
+}
+
+// hasNote reports whether notes, one per line, include note
+func hasNote(notes, note string) bool {
+	return slices.Contains(strings.Split(notes, "\n"), note)
+}
+
+// PreviewRule evaluates a rule, without saving it, against the most recent
+// receipts matching a filter, and reports what it would change on each
+func (s *ReceiptService) PreviewRule(ctx context.Context, req dto.RuleRequest, filter database.ReceiptFilter, limit int) (*dto.RulePreviewResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+	if limit <= 0 {
+		limit = rulePreviewLimit
+	}
+	limit = min(limit, maxRulePreviewLimit)
+
+	rule, program, err := s.ruleFromRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := s.db.ListCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]string, len(categories))
+	for _, category := range categories {
+		paths[category.ID] = category.Path
+	}
+
+	ids, err := s.db.ListReceiptIDs(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &dto.RulePreviewResponse{Receipts: []dto.RulePreviewMatch{}}
+	// Newest first: the IDs come oldest first
+	for i := len(ids) - 1; i >= 0 && response.Scanned < limit; i-- {
+		receipt, err := s.db.GetReceipt(ctx, ids[i])
+		if err != nil {
+			return nil, err
+		}
+		response.Scanned++
+
+		matched, items, err := program.Match(receipt)
+		if err != nil && len(response.Errors) < maxPreviewErrors {
+			response.Errors = append(response.Errors, fmt.Sprintf("receipt %s: %v", receipt.ID, err))
+		}
+		if !matched {
+			continue
+		}
+		response.Matched++
+
+		effects := &models.RuleEffects{}
+		addRuleEffects(effects, rule, receipt, items)
+
+		match := dto.RulePreviewMatch{
+			ReceiptID:   receipt.ID,
+			StoreName:   receipt.StoreName,
+			BoughtDate:  receipt.BoughtDate,
+			TotalAmount: receiptSubtotal(receipt) - receipt.Discounts,
+			Changes:     describeRuleEffects(effects, receipt, paths),
+		}
+		for _, i := range items {
+			match.MatchedItems = append(match.MatchedItems, receipt.Items[i].Name)
+		}
+		response.Receipts = append(response.Receipts, match)
+	}
+
+	return response, nil
+}
+
+// describeRuleEffects lists the changes of effects on a receipt in words,
+// e.g. `tag "party" on CERVEZA`. paths are category paths by ID.
+func describeRuleEffects(effects *models.RuleEffects, receipt *models.Receipt, paths map[string]string) []string {
+	changes := []string{}
+	for _, tag := range effects.ReceiptTags {
+		changes = append(changes, fmt.Sprintf("tag %q", tag))
+	}
+	for _, note := range effects.ReceiptNotes {
+		changes = append(changes, fmt.Sprintf("note %q", note))
+	}
+	if effects.Business != nil {
+		if *effects.Business {
+			changes = append(changes, "flag as a business expense")
+		} else {
+			changes = append(changes, "unflag as a business expense")
+		}
+	}
+
+	for _, item := range receipt.Items {
+		for _, tag := range effects.ItemTags[item.ID] {
+			changes = append(changes, fmt.Sprintf("tag %q on %s", tag, item.Name))
+		}
+		for _, note := range effects.ItemNotes[item.ID] {
+			changes = append(changes, fmt.Sprintf("note %q on %s", note, item.Name))
+		}
+	}
+
+	// A product appears once even if the receipt has several items of it
+	described := map[string]bool{}
+	for _, item := range receipt.Items {
+		categoryID, ok := effects.ProductCategories[item.ProductID]
+		if !ok || described[item.ProductID] {
+			continue
+		}
+		described[item.ProductID] = true
+		changes = append(changes, fmt.Sprintf("category %q for %s", paths[categoryID], item.Name))
+	}
+
+	return changes
+}
+
+// ruleToDTO converts an automation rule to its response
+func ruleToDTO(rule *models.Rule) dto.RuleResponse {
+	actions := make([]dto.RuleActionDTO, len(rule.Actions))
+	for i, action := range rule.Actions {
+		actions[i] = dto.RuleActionDTO{Type: action.Type, Value: action.Value}
+	}
+
+	return dto.RuleResponse{
+		ID:          rule.ID,
+		Name:        rule.Name,
+		Scope:       rule.Scope,
+		Condition:   rule.Condition,
+		Actions:     actions,
+		Enabled:     rule.Enabled,
+		Priority:    rule.Priority,
+		Owner:       rule.Owner,
+		HouseholdID: rule.HouseholdID,
+		CreatedAt:   rule.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:   rule.UpdatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,542 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// Scope is what a condition is evaluated against: a whole receipt, or each
+// of its items
+type Scope string
+
+const (
+	ScopeReceipt Scope = models.RuleScopeReceipt
+	ScopeItem    Scope = models.RuleScopeItem
+)
+
+// epsilon is how far apart two numbers can be and still be equal, to absorb
+// floating point error in computed totals
+const epsilon = 1e-6
+
+// TypeError is an evaluation error caused by the condition itself, such as
+// comparing a number with a string, rather than by the data it runs on
+type TypeError struct {
+	Message string
+}
+
+func (e *TypeError) Error() string {
+	return e.Message
+}
+
+func typeErrorf(format string, args ...any) error {
+	return &TypeError{Message: fmt.Sprintf(format, args...)}
+}
+
+// env is what a condition is evaluated against. item is nil for receipt rules.
+type env struct {
+	receipt *models.Receipt
+	item    *models.Item
+}
+
+// node is a node of a compiled condition. Values are float64, string, bool,
+// []any or *regexp.Regexp.
+type node interface {
+	eval(e *env) (any, error)
+}
+
+// variables are the fields of each scope
+var variables = map[Scope]map[string]func(e *env) any{
+	ScopeReceipt: {
+		"store":           func(e *env) any { return e.receipt.StoreName },
+		"date":            func(e *env) any { return e.receipt.BoughtDate },
+		"weekday":         func(e *env) any { return weekday(e.receipt.BoughtDate) },
+		"total":           func(e *env) any { return subtotal(e.receipt) - e.receipt.Discounts },
+		"subtotal":        func(e *env) any { return subtotal(e.receipt) },
+		"discounts":       func(e *env) any { return e.receipt.Discounts },
+		"item_count":      func(e *env) any { return float64(len(e.receipt.Items)) },
+		"owner":           func(e *env) any { return e.receipt.Owner },
+		"document_number": func(e *env) any { return e.receipt.DocumentNumber },
+		"tags":            func(e *env) any { return list(e.receipt.Tags) },
+		"business":        func(e *env) any { return e.receipt.Business },
+	},
+	ScopeItem: {
+		"name":          func(e *env) any { return e.item.Name },
+		"quantity":      func(e *env) any { return e.item.Quantity },
+		"price":         func(e *env) any { return e.item.Price },
+		"line_total":    func(e *env) any { return e.item.Quantity * e.item.Price },
+		"category":      func(e *env) any { return e.item.Category },
+		"tags":          func(e *env) any { return list(e.item.Tags) },
+		"store":         func(e *env) any { return e.receipt.StoreName },
+		"date":          func(e *env) any { return e.receipt.BoughtDate },
+		"weekday":       func(e *env) any { return weekday(e.receipt.BoughtDate) },
+		"owner":         func(e *env) any { return e.receipt.Owner },
+		"receipt_total": func(e *env) any { return subtotal(e.receipt) - e.receipt.Discounts },
+		"receipt_tags":  func(e *env) any { return list(e.receipt.Tags) },
+	},
+}
+
+// fieldNames lists the fields of a scope, sorted
+func fieldNames(scope Scope) []string {
+	names := make([]string, 0, len(variables[scope]))
+	for name := range variables[scope] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// function is a built-in function. Item functions evaluate their argument,
+// a condition in the item scope, on each item of the receipt.
+type function struct {
+	arity int
+	items bool
+	call  func(e *env, args []node) (any, error)
+}
+
+var functions = map[string]function{
+	"lower":       {arity: 1, call: stringFunction(strings.ToLower)},
+	"upper":       {arity: 1, call: stringFunction(strings.ToUpper)},
+	"contains":    {arity: 2, call: containsFunction},
+	"starts_with": {arity: 2, call: affixFunction(strings.HasPrefix)},
+	"ends_with":   {arity: 2, call: affixFunction(strings.HasSuffix)},
+	"any_item":    {arity: 1, items: true, call: itemsFunction(func(matched, total int, _ float64) any { return matched > 0 })},
+	"all_items":   {arity: 1, items: true, call: itemsFunction(func(matched, total int, _ float64) any { return total > 0 && matched == total })},
+	"count_items": {arity: 1, items: true, call: itemsFunction(func(matched, _ int, _ float64) any { return float64(matched) })},
+	"sum_items":   {arity: 1, items: true, call: itemsFunction(func(_, _ int, sum float64) any { return sum })},
+}
+
+func stringFunction(fn func(string) string) func(e *env, args []node) (any, error) {
+	return func(e *env, args []node) (any, error) {
+		value, err := evalString(e, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return fn(value), nil
+	}
+}
+
+func affixFunction(fn func(s, affix string) bool) func(e *env, args []node) (any, error) {
+	return func(e *env, args []node) (any, error) {
+		s, err := evalString(e, args[0])
+		if err != nil {
+			return nil, err
+		}
+		affix, err := evalString(e, args[1])
+		if err != nil {
+			return nil, err
+		}
+		return fn(strings.ToLower(s), strings.ToLower(affix)), nil
+	}
+}
+
+func containsFunction(e *env, args []node) (any, error) {
+	return (&inNode{needle: args[1], haystack: args[0]}).eval(e)
+}
+
+// itemsFunction evaluates a condition on each item, and summarises how many
+// items matched, out of how many, and the sum of the matching line totals
+func itemsFunction(summarise func(matched, total int, sum float64) any) func(e *env, args []node) (any, error) {
+	return func(e *env, args []node) (any, error) {
+		matched, sum := 0, 0.0
+		for i := range e.receipt.Items {
+			item := &e.receipt.Items[i]
+			ok, err := evalBool(&env{receipt: e.receipt, item: item}, args[0])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matched++
+				sum += item.Quantity * item.Price
+			}
+		}
+		return summarise(matched, len(e.receipt.Items), sum), nil
+	}
+}
+
+type literalNode struct {
+	value any
+}
+
+func (n *literalNode) eval(*env) (any, error) {
+	return n.value, nil
+}
+
+type variableNode struct {
+	name string
+}
+
+func (n *variableNode) eval(e *env) (any, error) {
+	return variables[scopeOf(e)][n.name](e), nil
+}
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(e *env) (any, error) {
+	values := make([]any, len(n.items))
+	for i, item := range n.items {
+		value, err := item.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+type callNode struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (n *callNode) eval(e *env) (any, error) {
+	return n.fn.call(e, n.args)
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) eval(e *env) (any, error) {
+	value, err := evalBool(e, n.operand)
+	if err != nil {
+		return nil, err
+	}
+	return !value, nil
+}
+
+// logicalNode is "and" or "or", which short-circuit
+type logicalNode struct {
+	and         bool
+	left, right node
+}
+
+func (n *logicalNode) eval(e *env) (any, error) {
+	left, err := evalBool(e, n.left)
+	if err != nil {
+		return nil, err
+	}
+	if left != n.and {
+		return left, nil
+	}
+	return evalBool(e, n.right)
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n *compareNode) eval(e *env) (any, error) {
+	left, err := n.left.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==", "!=":
+		equal, err := equalValues(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return equal == (n.op == "=="), nil
+	}
+
+	var order int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, typeErrorf("cannot compare %s with %s", typeName(left), typeName(right))
+		}
+		switch {
+		case math.Abs(l-r) < epsilon:
+			order = 0
+		case l < r:
+			order = -1
+		default:
+			order = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, typeErrorf("cannot compare %s with %s", typeName(left), typeName(right))
+		}
+		order = strings.Compare(strings.ToLower(l), strings.ToLower(r))
+	default:
+		return nil, typeErrorf("cannot order %s values", typeName(left))
+	}
+
+	switch n.op {
+	case "<":
+		return order < 0, nil
+	case "<=":
+		return order <= 0, nil
+	case ">":
+		return order > 0, nil
+	default:
+		return order >= 0, nil
+	}
+}
+
+type arithmeticNode struct {
+	op          string
+	left, right node
+}
+
+func (n *arithmeticNode) eval(e *env) (any, error) {
+	left, err := evalNumber(e, n.left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := evalNumber(e, n.right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	default:
+		if right == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return left / right, nil
+	}
+}
+
+// matchNode is "~" or "!~". A string on the left matches if the pattern is
+// found in it; a list of tags matches if any of them does.
+type matchNode struct {
+	subject node
+	pattern node
+	re      *regexp.Regexp // Compiled in advance for literal patterns
+	negate  bool
+}
+
+func newMatchNode(subject, pattern node, negate bool) (node, error) {
+	n := &matchNode{subject: subject, pattern: pattern, negate: negate}
+	if literal, ok := pattern.(*literalNode); ok {
+		switch value := literal.value.(type) {
+		case *regexp.Regexp:
+			n.re = value
+		case string:
+			re, err := compileRegex(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q: %w", value, err)
+			}
+			n.re = re
+		default:
+			return nil, fmt.Errorf("the right side of \"~\" must be a regular expression or a string")
+		}
+	}
+	return n, nil
+}
+
+func (n *matchNode) eval(e *env) (any, error) {
+	re := n.re
+	if re == nil {
+		pattern, err := n.pattern.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		switch value := pattern.(type) {
+		case *regexp.Regexp:
+			re = value
+		case string:
+			if re, err = compileRegex(value); err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q: %w", value, err)
+			}
+		default:
+			return nil, typeErrorf("cannot match against %s", typeName(pattern))
+		}
+	}
+
+	subject, err := n.subject.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := false
+	switch value := subject.(type) {
+	case string:
+		matched = re.MatchString(value)
+	case []any:
+		for _, element := range value {
+			if s, ok := element.(string); ok && re.MatchString(s) {
+				matched = true
+				break
+			}
+		}
+	default:
+		return nil, typeErrorf("cannot match %s against a regular expression", typeName(subject))
+	}
+	return matched != n.negate, nil
+}
+
+// inNode is "in" or "not in": membership in a list, or a substring test
+type inNode struct {
+	needle, haystack node
+	negate           bool
+}
+
+func (n *inNode) eval(e *env) (any, error) {
+	needle, err := n.needle.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	haystack, err := n.haystack.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	switch value := haystack.(type) {
+	case []any:
+		for _, element := range value {
+			equal, err := equalValues(needle, element)
+			if err != nil {
+				if _, ok := err.(*TypeError); ok {
+					continue
+				}
+				return nil, err
+			}
+			if equal {
+				found = true
+				break
+			}
+		}
+	case string:
+		s, ok := needle.(string)
+		if !ok {
+			return nil, typeErrorf("cannot look for %s in a string", typeName(needle))
+		}
+		found = strings.Contains(strings.ToLower(value), strings.ToLower(s))
+	default:
+		return nil, typeErrorf("cannot look for values in %s", typeName(haystack))
+	}
+	return found != n.negate, nil
+}
+
+// equalValues compares two values of the same type. Strings are equal
+// regardless of case.
+func equalValues(left, right any) (bool, error) {
+	switch l := left.(type) {
+	case float64:
+		if r, ok := right.(float64); ok {
+			return math.Abs(l-r) < epsilon, nil
+		}
+	case string:
+		if r, ok := right.(string); ok {
+			return strings.EqualFold(l, r), nil
+		}
+	case bool:
+		if r, ok := right.(bool); ok {
+			return l == r, nil
+		}
+	}
+	return false, typeErrorf("cannot compare %s with %s", typeName(left), typeName(right))
+}
+
+func evalBool(e *env, n node) (bool, error) {
+	value, err := n.eval(e)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, typeErrorf("expected true or false, got %s", typeName(value))
+	}
+	return b, nil
+}
+
+func evalNumber(e *env, n node) (float64, error) {
+	value, err := n.eval(e)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := value.(float64)
+	if !ok {
+		return 0, typeErrorf("expected a number, got %s", typeName(value))
+	}
+	return f, nil
+}
+
+func evalString(e *env, n node) (string, error) {
+	value, err := n.eval(e)
+	if err != nil {
+		return "", err
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", typeErrorf("expected a string, got %s", typeName(value))
+	}
+	return s, nil
+}
+
+func typeName(value any) string {
+	switch value.(type) {
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case bool:
+		return "true or false"
+	case []any:
+		return "a list"
+	case *regexp.Regexp:
+		return "a regular expression"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func scopeOf(e *env) Scope {
+	if e.item != nil {
+		return ScopeItem
+	}
+	return ScopeReceipt
+}
+
+// subtotal sums the line totals of a receipt, before discounts
+func subtotal(receipt *models.Receipt) float64 {
+	sum := 0.0
+	for _, item := range receipt.Items {
+		sum += item.Quantity * item.Price
+	}
+	return sum
+}
+
+// weekday is the lowercase English name of a date's day, e.g. "saturday"
+func weekday(date string) string {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(t.Weekday().String())
+}
+
+func list(values []string) []any {
+	result := make([]any, len(values))
+	for i, value := range values {
+		result[i] = value
+	}
+	return result
+}
@@ -0,0 +1,168 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenKind classifies the tokens of a condition
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenRegex
+	tokenIdent
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+	tokenComma
+)
+
+// token is a lexeme of a condition, with its byte offset for error messages
+type token struct {
+	kind tokenKind
+	text string // Operator or identifier as written, or the value of a literal
+	pos  int
+}
+
+// operators are matched longest first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "!~", "<", ">", "!", "~", "+", "-", "*", "/"}
+
+// lex splits a condition into tokens. A "/" starts a regular expression
+// literal wherever a value is expected, and is a division anywhere else.
+func lex(src string) ([]token, error) {
+	tokens := []token{}
+	for pos := 0; pos < len(src); {
+		r, size := utf8.DecodeRuneInString(src[pos:])
+		if unicode.IsSpace(r) {
+			pos += size
+			continue
+		}
+
+		start := pos
+		switch {
+		case r == '"' || r == '\'':
+			value, end, err := lexString(src, pos, byte(r))
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value, pos: start})
+			pos = end
+
+		case r == '/' && expectsValue(tokens):
+			value, end, err := lexRegex(src, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenRegex, text: value, pos: start})
+			pos = end
+
+		case r >= '0' && r <= '9' || r == '.' && pos+1 < len(src) && src[pos+1] >= '0' && src[pos+1] <= '9':
+			for pos < len(src) && (src[pos] >= '0' && src[pos] <= '9' || src[pos] == '.') {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: src[start:pos], pos: start})
+
+		case r == '_' || unicode.IsLetter(r):
+			for pos < len(src) {
+				r, size := utf8.DecodeRuneInString(src[pos:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				pos += size
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: src[start:pos], pos: start})
+
+		case r == '(' || r == ')' || r == '[' || r == ']' || r == ',':
+			kinds := map[rune]tokenKind{'(': tokenLParen, ')': tokenRParen, '[': tokenLBracket, ']': tokenRBracket, ',': tokenComma}
+			tokens = append(tokens, token{kind: kinds[r], text: string(r), pos: start})
+			pos++
+
+		default:
+			operator := ""
+			for _, op := range operators {
+				if strings.HasPrefix(src[pos:], op) {
+					operator = op
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", r, pos)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: operator, pos: start})
+			pos += len(operator)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(src)}), nil
+}
+
+// expectsValue reports whether the next token starts an operand
+func expectsValue(tokens []token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	switch last := tokens[len(tokens)-1]; last.kind {
+	case tokenNumber, tokenString, tokenRegex, tokenRParen, tokenRBracket:
+		return false
+	case tokenIdent:
+		return isKeyword(last.text)
+	default:
+		return true
+	}
+}
+
+// lexString reads a quoted string starting at pos, with backslash escapes,
+// and returns its value and the offset after its closing quote
+func lexString(src string, pos int, quote byte) (string, int, error) {
+	var sb strings.Builder
+	for i := pos + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			if i+1 == len(src) {
+				return "", 0, fmt.Errorf("unterminated string at %d", pos)
+			}
+			i++
+			switch src[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			default:
+				sb.WriteByte(src[i])
+			}
+		case quote:
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteByte(src[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string at %d", pos)
+}
+
+// lexRegex reads a /pattern/ literal starting at pos. A "\/" inside the
+// pattern is a literal slash; every other escape is kept for the regexp.
+func lexRegex(src string, pos int) (string, int, error) {
+	var sb strings.Builder
+	for i := pos + 1; i < len(src); i++ {
+		switch {
+		case src[i] == '\\' && i+1 < len(src) && src[i+1] == '/':
+			sb.WriteByte('/')
+			i++
+		case src[i] == '\\' && i+1 < len(src):
+			sb.WriteString(src[i : i+2])
+			i++
+		case src[i] == '/':
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteByte(src[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated regular expression at %d", pos)
+}
@@ -0,0 +1,380 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Limits that keep conditions cheap to evaluate. Regular expressions use
+// RE2, whose matching time is linear in the input.
+const (
+	maxConditionLength = 2000
+	maxDepth           = 50
+)
+
+// isKeyword reports whether an identifier is an operator word
+func isKeyword(ident string) bool {
+	switch strings.ToLower(ident) {
+	case "and", "or", "not", "in":
+		return true
+	}
+	return false
+}
+
+// parser is a recursive descent parser with one token of lookahead. Each
+// precedence level is a method, from the loosest to the tightest binding:
+//
+//	or      = and { ("||" | "or") and }
+//	and     = not { ("&&" | "and") not }
+//	not     = ("!" | "not") not | compare
+//	compare = sum [ ("==" | "!=" | "<" | "<=" | ">" | ">=" | "~" | "!~" | "in" | "not in") sum ]
+//	sum     = product { ("+" | "-") product }
+//	product = unary { ("*" | "/") unary }
+//	unary   = "-" unary | primary
+//	primary = number | string | regex | "true" | "false" | name | name "(" args ")" | "(" or ")" | "[" args "]"
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+	scope  Scope
+}
+
+// parse compiles a condition for a scope
+func parse(src string, scope Scope) (node, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, fmt.Errorf("the condition is empty")
+	}
+	if len(src) > maxConditionLength {
+		return nil, fmt.Errorf("the condition is longer than %d characters", maxConditionLength)
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, scope: scope}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", next.text, next.pos)
+	}
+	return root, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of the given operators or
+// keywords, returning the canonical operator
+func (p *parser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator && t.kind != tokenIdent {
+		return "", false
+	}
+	text := t.text
+	if t.kind == tokenIdent {
+		text = strings.ToLower(text)
+	}
+	for _, op := range ops {
+		if text == op {
+			p.next()
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// enter guards against deeply nested conditions
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return fmt.Errorf("the condition is nested more than %d levels deep", maxDepth)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||", "or"); !ok {
+			return left, nil
+		}
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{and: false, left: left, right: right}
+	}
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&", "and"); !ok {
+			return left, nil
+		}
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{and: true, left: left, right: right}
+	}
+}
+
+func (p *parser) not() (node, error) {
+	// "not in" is a comparison, handled by compare
+	if t := p.peek(); t.kind == tokenIdent && strings.EqualFold(t.text, "not") && p.tokens[p.pos+1].kind == tokenIdent &&
+		strings.EqualFold(p.tokens[p.pos+1].text, "in") {
+		return nil, fmt.Errorf("unexpected \"not in\" at %d", t.pos)
+	}
+	if _, ok := p.accept("!", "not"); ok {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.compare()
+}
+
+func (p *parser) compare() (node, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	op, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "~", "!~", "in", "not")
+	if !ok {
+		return left, nil
+	}
+	if op == "not" {
+		if _, ok := p.accept("in"); !ok {
+			return nil, fmt.Errorf("expected \"in\" after \"not\" at %d", t.pos)
+		}
+		op = "not in"
+	}
+
+	right, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "~", "!~":
+		return newMatchNode(left, right, op == "!~")
+	case "in", "not in":
+		return &inNode{needle: left, haystack: right, negate: op == "not in"}, nil
+	default:
+		return &compareNode{op: op, left: left, right: right}, nil
+	}
+}
+
+func (p *parser) sum() (node, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithmeticNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) product() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithmeticNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	if _, ok := p.accept("-"); ok {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &arithmeticNode{op: "-", left: &literalNode{value: 0.0}, right: operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return &literalNode{value: value}, nil
+
+	case tokenString:
+		return &literalNode{value: t.text}, nil
+
+	case tokenRegex:
+		re, err := compileRegex(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression at %d: %w", t.pos, err)
+		}
+		return &literalNode{value: re}, nil
+
+	case tokenLParen:
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("expected \")\" at %d", closing.pos)
+		}
+		return inner, nil
+
+	case tokenLBracket:
+		items, err := p.args(tokenRBracket, "]")
+		if err != nil {
+			return nil, err
+		}
+		return &listNode{items: items}, nil
+
+	case tokenIdent:
+		name := strings.ToLower(t.text)
+		switch name {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		}
+		if isKeyword(name) {
+			return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+		}
+		if p.peek().kind == tokenLParen {
+			p.next()
+			return p.call(name, t.pos)
+		}
+		if _, ok := variables[p.scope][name]; !ok {
+			return nil, fmt.Errorf("unknown field %q at %d (%s rules can use %s)", t.text, t.pos, p.scope, strings.Join(fieldNames(p.scope), ", "))
+		}
+		return &variableNode{name: name}, nil
+
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of condition")
+
+	default:
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+}
+
+// args parses comma-separated expressions up to the closing token
+func (p *parser) args(closing tokenKind, closingText string) ([]node, error) {
+	items := []node{}
+	if p.peek().kind == closing {
+		p.next()
+		return items, nil
+	}
+	for {
+		item, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		t := p.next()
+		switch t.kind {
+		case tokenComma:
+			continue
+		case closing:
+			return items, nil
+		default:
+			return nil, fmt.Errorf("expected \",\" or %q at %d", closingText, t.pos)
+		}
+	}
+}
+
+// call parses the arguments of a function call. The item functions of
+// receipt rules take a condition on each item, compiled in the item scope.
+func (p *parser) call(name string, pos int) (node, error) {
+	fn, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at %d", name, pos)
+	}
+
+	if fn.items {
+		if p.scope != ScopeReceipt {
+			return nil, fmt.Errorf("%s() at %d is only available in receipt rules", name, pos)
+		}
+		p.scope = ScopeItem
+		defer func() { p.scope = ScopeReceipt }()
+	}
+
+	args, err := p.args(tokenRParen, ")")
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != fn.arity {
+		return nil, fmt.Errorf("%s() at %d takes %d argument(s), got %d", name, pos, fn.arity, len(args))
+	}
+
+	return &callNode{name: name, fn: fn, args: args}, nil
+}
+
+// compileRegex compiles a pattern, case-insensitively
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + pattern)
+}
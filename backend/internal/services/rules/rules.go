@@ -0,0 +1,84 @@
+// Package rules implements the condition language of automation rules: a
+// small, side-effect free expression language evaluated against a receipt,
+// or against each of its items.
+//
+// A condition compares fields with literals, e.g.
+//
+//	store == "LIDL" && total > 80
+//	name ~ /CERVEZA/ and quantity >= 6
+//	any_item(category == "Alcohol") or "party" in tags
+//
+// Strings compare regardless of case, and regular expressions (RE2, also
+// case-insensitive) are written between slashes.
+package rules
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// Program is a compiled condition
+type Program struct {
+	scope Scope
+	root  node
+}
+
+// Compile parses a condition for a scope, checking its fields, functions and
+// regular expressions, and that it evaluates to true or false
+func Compile(scope Scope, condition string) (*Program, error) {
+	if scope != ScopeReceipt && scope != ScopeItem {
+		return nil, fmt.Errorf("unknown scope %q", scope)
+	}
+
+	root, err := parse(condition, scope)
+	if err != nil {
+		return nil, err
+	}
+	program := &Program{scope: scope, root: root}
+
+	// Evaluate against an empty receipt to catch type errors early. Errors
+	// that depend on the data, like a division by zero, are left for later.
+	sample := &models.Receipt{Items: []models.Item{{}}}
+	if _, _, err := program.Match(sample); err != nil {
+		var typeErr *TypeError
+		if errors.As(err, &typeErr) {
+			return nil, err
+		}
+	}
+
+	return program, nil
+}
+
+// Scope returns the scope the program was compiled for
+func (p *Program) Scope() Scope {
+	return p.scope
+}
+
+// Match evaluates the program against a receipt. Receipt programs report
+// whether the receipt matches; item programs also return the indexes of the
+// matching items, and match when any does. An item whose evaluation fails
+// does not match, and the first error is returned with the result.
+func (p *Program) Match(receipt *models.Receipt) (bool, []int, error) {
+	if p.scope == ScopeReceipt {
+		matched, err := evalBool(&env{receipt: receipt}, p.root)
+		return matched, nil, err
+	}
+
+	var items []int
+	var firstErr error
+	for i := range receipt.Items {
+		matched, err := evalBool(&env{receipt: receipt, item: &receipt.Items[i]}, p.root)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("item %q: %w", receipt.Items[i].Name, err)
+			}
+			continue
+		}
+		if matched {
+			items = append(items, i)
+		}
+	}
+	return len(items) > 0, items, firstErr
+}
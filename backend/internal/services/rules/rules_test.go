@@ -0,0 +1,152 @@
+package rules
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+func TestCompile(t *testing.T) {
+	// A condition of exactly the maximum length
+	padding := strings.Repeat("A", maxConditionLength-len(`store == ""`))
+	longest := `store == "` + padding + `"`
+
+	tests := []struct {
+		name      string
+		scope     Scope
+		condition string
+		wantErr   string // Substring of the expected error, empty to compile
+		typeErr   bool   // Whether the error is a TypeError
+	}{
+		{"comparison", ScopeReceipt, `store == "LIDL" && total > 80`, "", false},
+		{"keywords", ScopeReceipt, `not business and store in ["ALDI", "LIDL"] or "party" in tags`, "", false},
+		{"item regex", ScopeItem, `name ~ /CERVEZA/ and quantity >= 6`, "", false},
+		{"item function", ScopeReceipt, `any_item(category == "Alcohol") or count_items(price > 5) >= 2`, "", false},
+		{"longest condition", ScopeReceipt, longest, "", false},
+		{"division by zero is left for later", ScopeReceipt, `total / discounts > 1`, "", false},
+
+		{"unknown scope", Scope("store"), `total > 1`, `unknown scope "store"`, false},
+		{"empty", ScopeReceipt, "   ", "the condition is empty", false},
+		{"too long", ScopeReceipt, longest + " ", "longer than 2000 characters", false},
+		{"too deep", ScopeReceipt, strings.Repeat("(", maxDepth) + "true" + strings.Repeat(")", maxDepth), "nested more than 50 levels", false},
+		{"syntax error", ScopeReceipt, `store ==`, "unexpected end of condition", false},
+		{"trailing tokens", ScopeReceipt, `total > 1 2`, `unexpected "2" at 10`, false},
+		{"unterminated string", ScopeReceipt, `store == "LIDL`, "unterminated string", false},
+		{"misplaced not in", ScopeReceipt, `not in tags`, `unexpected "not in"`, false},
+
+		{"item field in receipt rule", ScopeReceipt, `name == "PAN"`, `unknown field "name"`, false},
+		{"receipt field in item rule", ScopeItem, `total > 10`, `unknown field "total"`, false},
+		{"receipt field of item rule", ScopeItem, `receipt_total > 10 and store == "LIDL"`, "", false},
+		{"item field inside any_item", ScopeReceipt, `any_item(total > 10)`, `unknown field "total"`, false},
+		{"any_item in item rule", ScopeItem, `any_item(price > 1)`, "only available in receipt rules", false},
+		{"any_item arity", ScopeReceipt, `any_item(price > 1, price < 5)`, "takes 1 argument(s), got 2", false},
+		{"unknown function", ScopeReceipt, `length(store) > 3`, `unknown function "length"`, false},
+
+		{"invalid regex literal", ScopeReceipt, `store ~ /(/`, "invalid regular expression", false},
+		{"invalid regex string", ScopeItem, `name ~ "["`, `invalid regular expression "["`, false},
+		{"match against a number", ScopeReceipt, `store ~ 5`, "must be a regular expression or a string", false},
+
+		{"string ordered with number", ScopeReceipt, `store > 5`, "cannot compare a string with a number", true},
+		{"arithmetic on a string", ScopeReceipt, `store + 1 > 2`, "expected a number, got a string", true},
+		{"not a condition", ScopeReceipt, `total + 1`, "expected true or false, got a number", true},
+		{"not of a string", ScopeItem, `!name`, "expected true or false, got a string", true},
+		{"equality across types", ScopeReceipt, `business == "yes"`, "cannot compare true or false with a string", true},
+		{"match a number", ScopeItem, `price ~ /1/`, "cannot match a number", true},
+		{"look in a number", ScopeReceipt, `"x" in total`, "cannot look for values in a number", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Compile(tt.scope, tt.condition)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Compile failed: %v", err)
+				}
+				if program.Scope() != tt.scope {
+					t.Errorf("scope = %s, want %s", program.Scope(), tt.scope)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Compile error = %v, want it to mention %q", err, tt.wantErr)
+			}
+			var typeErr *TypeError
+			if errors.As(err, &typeErr) != tt.typeErr {
+				t.Errorf("TypeError = %v, want %v", !tt.typeErr, tt.typeErr)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	// A saturday: 6 x 0.80 + 1.20 - 0.50 = 5.50
+	receipt := &models.Receipt{
+		StoreName:  "LIDL",
+		BoughtDate: "2024-03-16",
+		Owner:      "ana",
+		Tags:       []string{"weekend"},
+		Discounts:  0.5,
+		Items: []models.Item{
+			{Name: "CERVEZA LAGER", Quantity: 6, Price: 0.8, Category: "Alcohol", Tags: []string{"party"}},
+			{Name: "PAN DE MOLDE", Quantity: 1, Price: 1.2, Category: "Bakery"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		scope     Scope
+		condition string
+		want      bool
+		wantItems []int
+		wantErr   string
+	}{
+		{"strings ignore case", ScopeReceipt, `store == "lidl"`, true, nil, ""},
+		{"total after discounts", ScopeReceipt, `total == 5.5 and subtotal == 6`, true, nil, ""},
+		{"arithmetic", ScopeReceipt, `subtotal - discounts * 2 == 5`, true, nil, ""},
+		{"weekday", ScopeReceipt, `weekday == "saturday"`, true, nil, ""},
+		{"ordering strings", ScopeReceipt, `store > "aldi"`, true, nil, ""},
+		{"list membership", ScopeReceipt, `store in ["ALDI", "LIDL"] and owner not in ["bob"]`, true, nil, ""},
+		{"tags", ScopeReceipt, `"weekend" in tags and tags ~ /^week/`, true, nil, ""},
+		{"string functions", ScopeReceipt, `contains(store, "id") and starts_with(lower(store), "LI") and ends_with(store, "dl")`, true, nil, ""},
+		{"negation", ScopeReceipt, `not (item_count > 2) && !business`, true, nil, ""},
+		{"any_item", ScopeReceipt, `any_item(category == "Alcohol")`, true, nil, ""},
+		{"any_item without a match", ScopeReceipt, `any_item(name ~ /VINO/)`, false, nil, ""},
+		{"all_items", ScopeReceipt, `all_items(price < 1)`, false, nil, ""},
+		{"count_items", ScopeReceipt, `count_items(quantity >= 6) == 1`, true, nil, ""},
+		{"sum_items", ScopeReceipt, `sum_items(name ~ /cerveza/) == 4.8`, true, nil, ""},
+		{"division", ScopeReceipt, `total / discounts == 11`, true, nil, ""},
+		{"division by zero", ScopeReceipt, `total / (discounts - 0.5) > 1`, false, nil, "division by zero"},
+		{"short circuit skips the error", ScopeReceipt, `false and total / 0 > 1`, false, nil, ""},
+
+		{"item regex", ScopeItem, `name ~ /cerveza/`, true, []int{0}, ""},
+		{"every item", ScopeItem, `line_total > 1`, true, []int{0, 1}, ""},
+		{"no item", ScopeItem, `quantity > 100`, false, nil, ""},
+		{"receipt fields", ScopeItem, `category == "Bakery" and receipt_total > 5 and store == "LIDL"`, true, []int{1}, ""},
+		{"item and receipt tags", ScopeItem, `"party" in tags and "weekend" in receipt_tags`, true, []int{0}, ""},
+		{"failing item does not match", ScopeItem, `price / (quantity - 1) > 0`, true, []int{0}, `item "PAN DE MOLDE": division by zero`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Compile(tt.scope, tt.condition)
+			if err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			matched, items, err := program.Match(receipt)
+			if matched != tt.want || !slices.Equal(items, tt.wantItems) {
+				t.Errorf("Match = %v %v, want %v %v", matched, items, tt.want, tt.wantItems)
+			}
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Match failed: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Match error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
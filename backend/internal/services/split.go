@@ -0,0 +1,617 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// shareTolerance is how far below 1 the fractions of an item may add up to
+// and still count as the whole item, so thirds can be given as 0.333333
+const shareTolerance = 0.001
+
+// SplitService splits receipts between participants and settles what they
+// owe each other
+type SplitService struct {
+	db database.SplitRepository
+}
+
+func NewSplitService(db database.SplitRepository) *SplitService {
+	return &SplitService{
+		db: db,
+	}
+}
+
+// ListParticipants retrieves the participants of a scope
+func (s *SplitService) ListParticipants(ctx context.Context, scope database.Scope) ([]dto.ParticipantResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	participants, err := s.db.ListParticipants(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.ParticipantResponse, len(participants))
+	for i := range participants {
+		response[i] = participantToDTO(&participants[i])
+	}
+	return response, nil
+}
+
+// CreateParticipant adds a person receipts can be split with, for the member
+// of a scope or shared in the household of the request
+func (s *SplitService) CreateParticipant(ctx context.Context, scope database.Scope, req dto.ParticipantRequest) (*dto.ParticipantResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	participant := &models.Participant{Name: strings.Join(strings.Fields(req.Name), " "), Owner: scope.Member}
+	switch {
+	case participant.Name == "":
+		return nil, &ValidationError{Issues: []string{"the participant name is missing"}}
+	case len(participant.Name) > 100:
+		return nil, &ValidationError{Issues: []string{"the participant name is longer than 100 characters"}}
+	}
+
+	var err error
+	if participant.HouseholdID, err = sharedHousehold(ctx, s.db, scope.Member, req.HouseholdID); err != nil {
+		return nil, err
+	}
+
+	if participant.ID, err = s.db.CreateParticipant(ctx, participant); err != nil {
+		return nil, err
+	}
+	participant.CreatedAt = time.Now()
+	log.Info("Participant created", "id", participant.ID, "name", participant.Name, "owner", participant.Owner,
+		"household", participant.HouseholdID)
+
+	response := participantToDTO(participant)
+	return &response, nil
+}
+
+// DeleteParticipant deletes a participant of a scope who is on no split
+// receipt or settlement
+func (s *SplitService) DeleteParticipant(ctx context.Context, scope database.Scope, id string) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+
+	return s.db.DeleteParticipant(ctx, id, scope)
+}
+
+// GetReceiptSplit retrieves how a receipt is split and what each participant owes
+func (s *SplitService) GetReceiptSplit(ctx context.Context, receiptID string) (*dto.ReceiptSplitResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	split, err := s.db.GetReceiptSplit(ctx, receiptID)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitToDTO(split), nil
+}
+
+// UpdateReceiptSplit replaces the participants, payer and item shares of a
+// receipt, between participants of a scope
+func (s *SplitService) UpdateReceiptSplit(ctx context.Context, scope database.Scope, receiptID string, req dto.ReceiptSplitRequest) (*dto.ReceiptSplitResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	participants, err := s.db.ListParticipants(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	split := &models.ReceiptSplit{ReceiptID: receiptID}
+	issues := []string{}
+	add := func(ref string) string {
+		participant := resolveParticipant(participants, ref)
+		if participant == nil {
+			issues = append(issues, fmt.Sprintf("unknown participant %q", ref))
+			return ""
+		}
+		for _, existing := range split.Participants {
+			if existing.ID == participant.ID {
+				return participant.ID
+			}
+		}
+		split.Participants = append(split.Participants, *participant)
+		return participant.ID
+	}
+
+	for _, ref := range req.Participants {
+		add(ref)
+	}
+	switch {
+	case strings.TrimSpace(req.PaidBy) != "":
+		split.PayerID = add(req.PaidBy)
+	case len(req.Participants) > 0:
+		issues = append(issues, "paid_by is missing: a split receipt is paid by one of its participants")
+	}
+
+	for i, item := range req.Items {
+		if strings.TrimSpace(item.ItemID) == "" {
+			issues = append(issues, fmt.Sprintf("item %d: the item_id is missing", i+1))
+			continue
+		}
+		shares, shareIssues := sharesFromRequest(split.Participants, item.Shares)
+		for _, issue := range shareIssues {
+			issues = append(issues, fmt.Sprintf("item %s: %s", item.ItemID, issue))
+		}
+		split.Items = append(split.Items, models.SplitItem{ItemID: item.ItemID, Shares: shares})
+	}
+
+	if len(issues) > 0 {
+		return nil, &ValidationError{Issues: issues}
+	}
+
+	if err := s.db.SetReceiptSplit(ctx, split); err != nil {
+		return nil, err
+	}
+	log.Info("Receipt split updated", "id", receiptID, "participants", len(split.Participants), "items", len(split.Items))
+
+	return s.GetReceiptSplit(ctx, receiptID)
+}
+
+// DeleteReceiptSplit stops splitting a receipt
+func (s *SplitService) DeleteReceiptSplit(ctx context.Context, receiptID string) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+
+	return s.db.SetReceiptSplit(ctx, &models.ReceiptSplit{ReceiptID: receiptID})
+}
+
+// UpdateItemSplit replaces the fractions of an item assigned to participants
+// of its receipt, of a scope, returning the split of the receipt
+func (s *SplitService) UpdateItemSplit(ctx context.Context, scope database.Scope, itemID string, req dto.ItemSplitRequest) (*dto.ReceiptSplitResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	participants, err := s.db.ListParticipants(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	shares, issues := sharesFromRequest(participants, req.Shares)
+	if len(issues) > 0 {
+		return nil, &ValidationError{Issues: issues}
+	}
+
+	receiptID, err := s.db.SetItemShares(ctx, itemID, shares)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetReceiptSplit(ctx, receiptID)
+}
+
+// SplitBalances computes what every participant is owed or owes across the
+// split receipts matching a filter and the settlements of its member, and
+// payments that would settle it
+func (s *SplitService) SplitBalances(ctx context.Context, filter database.ReceiptFilter) (*dto.SplitBalances, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	scope := database.Scope{Member: filter.Member, Role: filter.Role, Admin: filter.Admin}
+	participants, err := s.db.ListParticipants(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	splits, err := s.db.ListReceiptSplits(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	settlements, err := s.db.ListSettlements(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	participants = balanceParticipants(participants, splits, settlements)
+
+	// In cents, so balances add up to zero exactly
+	paid, owed := map[string]int64{}, map[string]int64{}
+	for i := range splits {
+		split := &splits[i]
+		_, amounts := splitAmounts(split)
+		for participantID, amount := range amounts {
+			if participantID == split.PayerID {
+				continue
+			}
+			owed[participantID] += amount
+			paid[split.PayerID] += amount
+		}
+	}
+	for _, settlement := range settlements {
+		amount := int64(math.Round(settlement.Amount * 100))
+		paid[settlement.FromID] += amount
+		owed[settlement.ToID] += amount
+	}
+
+	response := &dto.SplitBalances{Balances: make([]dto.ParticipantBalance, len(participants)), Debts: []dto.Debt{}}
+	balances := make([]int64, len(participants))
+	for i, participant := range participants {
+		balances[i] = paid[participant.ID] - owed[participant.ID]
+		response.Balances[i] = dto.ParticipantBalance{
+			ID:      participant.ID,
+			Name:    participant.Name,
+			Paid:    float64(paid[participant.ID]) / 100,
+			Owed:    float64(owed[participant.ID]) / 100,
+			Balance: float64(balances[i]) / 100,
+		}
+	}
+
+	for _, debt := range settleBalances(balances) {
+		from, to := participants[debt.from], participants[debt.to]
+		response.Debts = append(response.Debts, dto.Debt{
+			From:   from.Name,
+			FromID: from.ID,
+			To:     to.Name,
+			ToID:   to.ID,
+			Amount: float64(debt.amount) / 100,
+		})
+	}
+	return response, nil
+}
+
+// balanceParticipants adds to participants those of splits and settlements
+// that are missing, such as the participants of another member on a receipt
+// of a household, so that balances add up to zero
+func balanceParticipants(participants []models.Participant, splits []models.ReceiptSplit, settlements []models.Settlement) []models.Participant {
+	seen := map[string]bool{}
+	for _, participant := range participants {
+		seen[participant.ID] = true
+	}
+	add := func(participant models.Participant) {
+		if !seen[participant.ID] {
+			seen[participant.ID] = true
+			participants = append(participants, participant)
+		}
+	}
+
+	for _, split := range splits {
+		for _, participant := range split.Participants {
+			add(participant)
+		}
+	}
+	for _, settlement := range settlements {
+		add(models.Participant{ID: settlement.FromID, Name: settlement.FromName})
+		add(models.Participant{ID: settlement.ToID, Name: settlement.ToName})
+	}
+	return participants
+}
+
+// ListSettlements retrieves the settlements of a scope
+func (s *SplitService) ListSettlements(ctx context.Context, scope database.Scope) ([]dto.SettlementResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	settlements, err := s.db.ListSettlements(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.SettlementResponse, len(settlements))
+	for i := range settlements {
+		response[i] = settlementToDTO(&settlements[i])
+	}
+	return response, nil
+}
+
+// CreateSettlement records a payment from a participant of a scope to
+// another, for its member or shared in the household of the request
+func (s *SplitService) CreateSettlement(ctx context.Context, scope database.Scope, req dto.SettlementRequest) (*dto.SettlementResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	participants, err := s.db.ListParticipants(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	settlement := &models.Settlement{
+		Amount: math.Round(req.Amount*100) / 100,
+		Date:   strings.TrimSpace(req.Date),
+		Note:   strings.TrimSpace(req.Note),
+		Owner:  scope.Member,
+	}
+	if settlement.HouseholdID, err = sharedHousehold(ctx, s.db, scope.Member, req.HouseholdID); err != nil {
+		return nil, err
+	}
+	if settlement.Date == "" {
+		settlement.Date = time.Now().Format("2006-01-02")
+	}
+
+	issues := []string{}
+	from, to := resolveParticipant(participants, req.From), resolveParticipant(participants, req.To)
+	if from == nil {
+		issues = append(issues, fmt.Sprintf("unknown participant %q", req.From))
+	}
+	if to == nil {
+		issues = append(issues, fmt.Sprintf("unknown participant %q", req.To))
+	}
+	if from != nil && to != nil {
+		if from.ID == to.ID {
+			issues = append(issues, "a participant cannot settle with themselves")
+		}
+		settlement.FromID, settlement.FromName = from.ID, from.Name
+		settlement.ToID, settlement.ToName = to.ID, to.Name
+	}
+	if settlement.Amount <= 0 {
+		issues = append(issues, "the amount must be greater than 0")
+	}
+	if _, err := time.Parse("2006-01-02", settlement.Date); err != nil {
+		issues = append(issues, fmt.Sprintf("invalid date %q (expected YYYY-MM-DD)", req.Date))
+	}
+	if len(issues) > 0 {
+		return nil, &ValidationError{Issues: issues}
+	}
+
+	settlement.ID, err = s.db.CreateSettlement(ctx, settlement)
+	if err != nil {
+		return nil, err
+	}
+	settlement.CreatedAt = time.Now()
+	log.Info("Settlement created", "id", settlement.ID, "from", settlement.FromName, "to", settlement.ToName, "amount", settlement.Amount,
+		"household", settlement.HouseholdID)
+
+	response := settlementToDTO(settlement)
+	return &response, nil
+}
+
+// DeleteSettlement deletes a settlement of a scope
+func (s *SplitService) DeleteSettlement(ctx context.Context, scope database.Scope, id string) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+
+	return s.db.DeleteSettlement(ctx, id, scope)
+}
+
+// resolveParticipant finds a participant by ID or name, ignoring case
+func resolveParticipant(participants []models.Participant, ref string) *models.Participant {
+	ref = strings.Join(strings.Fields(ref), " ")
+	for i := range participants {
+		if participants[i].ID == ref || strings.EqualFold(participants[i].Name, ref) {
+			return &participants[i]
+		}
+	}
+	return nil
+}
+
+// sharesFromRequest validates the fractions of an item assigned to
+// participants, keyed by participant ID
+func sharesFromRequest(participants []models.Participant, req map[string]float64) (map[string]float64, []string) {
+	shares := map[string]float64{}
+	issues := []string{}
+
+	total := 0.0
+	for ref, share := range req {
+		participant := resolveParticipant(participants, ref)
+		switch {
+		case participant == nil:
+			issues = append(issues, fmt.Sprintf("unknown participant %q", ref))
+		case share <= 0 || share > 1:
+			issues = append(issues, fmt.Sprintf("the share of %s must be greater than 0 and at most 1", participant.Name))
+		default:
+			shares[participant.ID] += share
+			total += share
+		}
+	}
+	if total > 1+shareTolerance {
+		issues = append(issues, fmt.Sprintf("the shares add up to %.4g, more than the whole item", total))
+	}
+
+	sort.Strings(issues)
+	return shares, issues
+}
+
+// splitAmounts computes what each participant of a split receipt owes, before
+// the discounts and in cents after them. The discounts are shared in
+// proportion to the items of each participant, and cents are rounded so the
+// amounts add up to the receipt total.
+func splitAmounts(split *models.ReceiptSplit) (map[string]float64, map[string]int64) {
+	items := map[string]float64{}
+	if len(split.Participants) == 0 {
+		return items, map[string]int64{}
+	}
+
+	subtotal := 0.0
+	for _, item := range split.Items {
+		subtotal += item.Total
+
+		assigned := 0.0
+		for _, share := range item.Shares {
+			assigned += share
+		}
+		scale, unassigned := 1.0, 1-assigned
+		if unassigned < shareTolerance {
+			scale, unassigned = 1/assigned, 0
+		}
+
+		for participantID, share := range item.Shares {
+			items[participantID] += item.Total * share * scale
+		}
+		for _, participant := range split.Participants {
+			items[participant.ID] += item.Total * unassigned / float64(len(split.Participants))
+		}
+	}
+
+	order := make([]string, len(split.Participants))
+	amounts := make([]float64, len(split.Participants))
+	for i, participant := range split.Participants {
+		order[i] = participant.ID
+		amounts[i] = items[participant.ID]
+		if subtotal != 0 {
+			amounts[i] -= split.Discounts * items[participant.ID] / subtotal
+		}
+	}
+
+	cents := allocateCents(amounts, int64(math.Round((subtotal-split.Discounts)*100)))
+	owed := make(map[string]int64, len(order))
+	for i, participantID := range order {
+		owed[participantID] = cents[i]
+	}
+	return items, owed
+}
+
+// allocateCents rounds amounts to cents that add up to total, giving the
+// cents left over by rounding down to the largest remainders
+func allocateCents(amounts []float64, total int64) []int64 {
+	cents := make([]int64, len(amounts))
+	remainders := make([]int, len(amounts))
+	left := total
+	for i, amount := range amounts {
+		cents[i] = int64(math.Floor(amount * 100))
+		left -= cents[i]
+		remainders[i] = i
+	}
+	if len(amounts) == 0 {
+		return cents
+	}
+
+	sort.SliceStable(remainders, func(a, b int) bool {
+		i, j := remainders[a], remainders[b]
+		return amounts[i]*100-float64(cents[i]) > amounts[j]*100-float64(cents[j])
+	})
+	for i := 0; left != 0; i = (i + 1) % len(amounts) {
+		if left > 0 {
+			cents[remainders[i]]++
+			left--
+		} else {
+			cents[remainders[i]]--
+			left++
+		}
+	}
+	return cents
+}
+
+// debt is a payment between participants, by index, in cents
+type debt struct {
+	from, to int
+	amount   int64
+}
+
+// settleBalances returns payments that settle balances in cents adding up to
+// zero, paying the largest creditor from the largest debtor first
+func settleBalances(balances []int64) []debt {
+	remaining := append([]int64(nil), balances...)
+	debts := []debt{}
+	for {
+		debtor, creditor := -1, -1
+		for i, balance := range remaining {
+			if balance < 0 && (debtor < 0 || balance < remaining[debtor]) {
+				debtor = i
+			}
+			if balance > 0 && (creditor < 0 || balance > remaining[creditor]) {
+				creditor = i
+			}
+		}
+		if debtor < 0 || creditor < 0 {
+			return debts
+		}
+
+		amount := min(-remaining[debtor], remaining[creditor])
+		debts = append(debts, debt{from: debtor, to: creditor, amount: amount})
+		remaining[debtor] += amount
+		remaining[creditor] -= amount
+	}
+}
+
+// splitToDTO converts a receipt split to its response, with what each
+// participant owes
+func splitToDTO(split *models.ReceiptSplit) *dto.ReceiptSplitResponse {
+	items, owed := splitAmounts(split)
+	names := map[string]string{}
+	for _, participant := range split.Participants {
+		names[participant.ID] = participant.Name
+	}
+
+	response := &dto.ReceiptSplitResponse{
+		ReceiptID:    split.ReceiptID,
+		Store:        split.StoreName,
+		BoughtDate:   split.BoughtDate,
+		Discounts:    split.Discounts,
+		PaidBy:       names[split.PayerID],
+		Participants: make([]dto.ParticipantShare, len(split.Participants)),
+		Items:        make([]dto.SplitItemResponse, len(split.Items)),
+	}
+
+	subtotal := 0.0
+	for i, item := range split.Items {
+		subtotal += item.Total
+		unassigned := 1.0
+		shares := map[string]float64{}
+		for participantID, share := range item.Shares {
+			shares[names[participantID]] = share
+			unassigned -= share
+		}
+		if unassigned < shareTolerance {
+			unassigned = 0
+		}
+		response.Items[i] = dto.SplitItemResponse{
+			ItemID:     item.ItemID,
+			Name:       item.Name,
+			Total:      math.Round(item.Total*100) / 100,
+			Shares:     shares,
+			Unassigned: math.Round(unassigned*1e6) / 1e6,
+		}
+	}
+	response.Total = math.Round((subtotal-split.Discounts)*100) / 100
+
+	for i, participant := range split.Participants {
+		itemsAmount := math.Round(items[participant.ID]*100) / 100
+		amount := float64(owed[participant.ID]) / 100
+		response.Participants[i] = dto.ParticipantShare{
+			ID:       participant.ID,
+			Name:     participant.Name,
+			Items:    itemsAmount,
+			Discount: math.Round((itemsAmount-amount)*100) / 100,
+			Amount:   amount,
+		}
+	}
+	return response
+}
+
+// participantToDTO converts a participant to its response
+func participantToDTO(participant *models.Participant) dto.ParticipantResponse {
+	return dto.ParticipantResponse{
+		ID:          participant.ID,
+		Name:        participant.Name,
+		Owner:       participant.Owner,
+		HouseholdID: participant.HouseholdID,
+		CreatedAt:   participant.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// settlementToDTO converts a settlement to its response
+func settlementToDTO(settlement *models.Settlement) dto.SettlementResponse {
+	return dto.SettlementResponse{
+		ID:          settlement.ID,
+		From:        settlement.FromName,
+		FromID:      settlement.FromID,
+		To:          settlement.ToName,
+		ToID:        settlement.ToID,
+		Amount:      settlement.Amount,
+		Date:        settlement.Date,
+		Note:        settlement.Note,
+		Owner:       settlement.Owner,
+		HouseholdID: settlement.HouseholdID,
+		CreatedAt:   settlement.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+func TestSplitAmounts(t *testing.T) {
+	ana, bea, carlos := models.Participant{ID: "ana"}, models.Participant{ID: "bea"}, models.Participant{ID: "carlos"}
+
+	tests := []struct {
+		name      string
+		split     models.ReceiptSplit
+		wantItems map[string]float64 // Before discounts
+		wantOwed  map[string]int64   // In cents
+	}{
+		{
+			name:      "not split",
+			split:     models.ReceiptSplit{Items: []models.SplitItem{{Total: 10}}},
+			wantItems: map[string]float64{},
+			wantOwed:  map[string]int64{},
+		},
+		{
+			name: "shared equally",
+			split: models.ReceiptSplit{
+				Participants: []models.Participant{ana, bea, carlos},
+				Items:        []models.SplitItem{{Total: 10}},
+			},
+			wantItems: map[string]float64{"ana": 10.0 / 3, "bea": 10.0 / 3, "carlos": 10.0 / 3},
+			wantOwed:  map[string]int64{"ana": 334, "bea": 333, "carlos": 333},
+		},
+		{
+			name: "whole items",
+			split: models.ReceiptSplit{
+				Participants: []models.Participant{ana, bea},
+				Items: []models.SplitItem{
+					{Total: 4.8, Shares: map[string]float64{"ana": 1}},
+					{Total: 1.2, Shares: map[string]float64{"bea": 1}},
+				},
+			},
+			wantItems: map[string]float64{"ana": 4.8, "bea": 1.2},
+			wantOwed:  map[string]int64{"ana": 480, "bea": 120},
+		},
+		{
+			// The unassigned half is shared by both
+			name: "partial share",
+			split: models.ReceiptSplit{
+				Participants: []models.Participant{ana, bea},
+				Items:        []models.SplitItem{{Total: 6, Shares: map[string]float64{"ana": 0.5}}},
+			},
+			wantItems: map[string]float64{"ana": 4.5, "bea": 1.5},
+			wantOwed:  map[string]int64{"ana": 450, "bea": 150},
+		},
+		{
+			name: "thirds within tolerance",
+			split: models.ReceiptSplit{
+				Participants: []models.Participant{ana, bea, carlos},
+				Items:        []models.SplitItem{{Total: 1, Shares: map[string]float64{"ana": 0.333333, "bea": 0.333333, "carlos": 0.333333}}},
+			},
+			wantItems: map[string]float64{"ana": 1.0 / 3, "bea": 1.0 / 3, "carlos": 1.0 / 3},
+			wantOwed:  map[string]int64{"ana": 34, "bea": 33, "carlos": 33},
+		},
+		{
+			// Receipt discounts are shared in proportion to what each bought
+			name: "discounts",
+			split: models.ReceiptSplit{
+				Participants: []models.Participant{ana, bea},
+				Discounts:    1,
+				Items: []models.SplitItem{
+					{Total: 8, Shares: map[string]float64{"ana": 1}},
+					{Total: 2, Shares: map[string]float64{"bea": 1}},
+				},
+			},
+			wantItems: map[string]float64{"ana": 8, "bea": 2},
+			wantOwed:  map[string]int64{"ana": 720, "bea": 180},
+		},
+		{
+			name: "mixed",
+			split: models.ReceiptSplit{
+				Participants: []models.Participant{ana, bea, carlos},
+				Discounts:    0.37,
+				Items: []models.SplitItem{
+					{Total: 2.99},
+					{Total: 5.49, Shares: map[string]float64{"bea": 0.25, "carlos": 0.25}},
+					{Total: 0.01, Shares: map[string]float64{"ana": 1}},
+				},
+			},
+			wantOwed: map[string]int64{"ana": 184, "bea": 314, "carlos": 314},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, owed := splitAmounts(&tt.split)
+
+			if tt.wantItems != nil {
+				if !maps.EqualFunc(items, tt.wantItems, func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }) {
+					t.Errorf("items = %v, want %v", items, tt.wantItems)
+				}
+			}
+			if !maps.Equal(owed, tt.wantOwed) {
+				t.Errorf("owed = %v, want %v", owed, tt.wantOwed)
+			}
+
+			// What participants owe adds up to the receipt total
+			if len(tt.split.Participants) == 0 {
+				return
+			}
+			total := -tt.split.Discounts
+			for _, item := range tt.split.Items {
+				total += item.Total
+			}
+			sum := int64(0)
+			for _, amount := range owed {
+				sum += amount
+			}
+			if want := int64(math.Round(total * 100)); sum != want {
+				t.Errorf("owed adds up to %d cents, want the receipt's %d", sum, want)
+			}
+		})
+	}
+}
+
+func TestAllocateCents(t *testing.T) {
+	tests := []struct {
+		name    string
+		amounts []float64
+		total   int64
+		want    []int64
+	}{
+		{"nothing", []float64{}, 0, []int64{}},
+		{"exact", []float64{1.5, 2.25}, 375, []int64{150, 225}},
+		{"thirds", []float64{10.0 / 3, 10.0 / 3, 10.0 / 3}, 1000, []int64{334, 333, 333}},
+		{"largest remainder first", []float64{0.101, 0.109, 0.105}, 32, []int64{10, 11, 11}},
+		{"rounding up overshoots", []float64{2, 2}, 399, []int64{199, 200}},
+		{"negative amounts", []float64{-0.5, 1.5}, 100, []int64{-50, 150}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := allocateCents(tt.amounts, tt.total)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("allocateCents(%v, %d) = %v, want %v", tt.amounts, tt.total, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSettleBalances(t *testing.T) {
+	tests := []struct {
+		name     string
+		balances []int64
+		want     []debt
+	}{
+		{"settled", []int64{0, 0}, []debt{}},
+		{"one creditor", []int64{500, -300, -200}, []debt{{from: 1, to: 0, amount: 300}, {from: 2, to: 0, amount: 200}}},
+		{"one debtor", []int64{-450, 150, 300}, []debt{{from: 0, to: 2, amount: 300}, {from: 0, to: 1, amount: 150}}},
+		{"several", []int64{1234, -1, -1233, 700, -700}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			balances := slices.Clone(tt.balances)
+			debts := settleBalances(balances)
+			if !slices.Equal(balances, tt.balances) {
+				t.Fatalf("balances changed to %v", balances)
+			}
+			if tt.want != nil && !slices.Equal(debts, tt.want) {
+				t.Errorf("settleBalances(%v) = %v, want %v", tt.balances, debts, tt.want)
+			}
+
+			// Paying the debts brings every balance to zero, without paying
+			// more than needed
+			if len(debts) >= max(len(tt.balances), 1) {
+				t.Errorf("%d payments settle %d balances", len(debts), len(tt.balances))
+			}
+			remaining := slices.Clone(tt.balances)
+			for _, debt := range debts {
+				if debt.amount <= 0 {
+					t.Errorf("payment of %d cents", debt.amount)
+				}
+				remaining[debt.from] += debt.amount
+				remaining[debt.to] -= debt.amount
+			}
+			for i, balance := range remaining {
+				if balance != 0 {
+					t.Errorf("participant %d left with %d cents", i, balance)
+				}
+			}
+		})
+	}
+}
+
+func TestBalanceParticipants(t *testing.T) {
+	ana, bea, carlos := models.Participant{ID: "1", Name: "Ana"}, models.Participant{ID: "2", Name: "Bea"}, models.Participant{ID: "3", Name: "Carlos"}
+	splits := []models.ReceiptSplit{
+		{ReceiptID: "flat's", PayerID: "1", Participants: []models.Participant{ana, bea}},
+	}
+	settlements := []models.Settlement{
+		{FromID: "3", FromName: "Carlos", ToID: "1", ToName: "Ana"},
+	}
+
+	got := balanceParticipants([]models.Participant{ana}, splits, settlements)
+	want := []models.Participant{ana, bea, carlos}
+	if !slices.Equal(got, want) {
+		t.Errorf("balanceParticipants = %v, want %v", got, want)
+	}
+}
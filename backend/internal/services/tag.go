@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// Limits of tags and notes
+const (
+	maxTagLength   = 64
+	maxTags        = 50 // Per receipt or item
+	maxNotesLength = 5000
+)
+
+// NormalizeTag lowercases a tag and joins its words with dashes. A leading
+// "#" or "tag:" is dropped, e.g. "tag:Monthly Shop" becomes "monthly-shop".
+func NormalizeTag(tag string) string {
+	tag = strings.TrimSpace(tag)
+	tag = strings.TrimPrefix(tag, "#")
+	if len(tag) > 4 && strings.EqualFold(tag[:4], "tag:") {
+		tag = tag[4:]
+	}
+	return strings.ToLower(strings.Join(strings.Fields(tag), "-"))
+}
+
+// normalizeTags normalises tags, dropping empty and repeated ones, and sorts them
+func normalizeTags(tags []string) []string {
+	if tags == nil {
+		return nil
+	}
+
+	normalized := []string{}
+	for _, tag := range tags {
+		if tag = NormalizeTag(tag); tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	slices.Sort(normalized)
+	return normalized
+}
+
+// tagIssues checks normalised tags, prefixing each issue
+func tagIssues(tags []string, prefix string) []string {
+	issues := []string{}
+	if len(tags) > maxTags {
+		issues = append(issues, fmt.Sprintf("%smore than %d tags", prefix, maxTags))
+	}
+	for _, tag := range tags {
+		if len(tag) > maxTagLength {
+			issues = append(issues, fmt.Sprintf("%sthe tag %q is longer than %d characters", prefix, tag, maxTagLength))
+		}
+	}
+	return issues
+}
+
+// mergeTags returns the tags of both lists, sorted and without repetitions
+func mergeTags(a, b []string) []string {
+	merged := append(slices.Clone(a), b...)
+	slices.Sort(merged)
+	return slices.Compact(merged)
+}
+
+// annotationsFromRequest normalises and validates edited tags and notes
+func annotationsFromRequest(tags *[]string, notes *string) (*[]string, *string, error) {
+	issues := []string{}
+	if tags != nil {
+		normalized := normalizeTags(*tags)
+		if normalized == nil {
+			normalized = []string{}
+		}
+		issues = append(issues, tagIssues(normalized, "")...)
+		tags = &normalized
+	}
+	if notes != nil {
+		trimmed := strings.TrimSpace(*notes)
+		if len(trimmed) > maxNotesLength {
+			issues = append(issues, fmt.Sprintf("the notes are longer than %d characters", maxNotesLength))
+		}
+		notes = &trimmed
+	}
+	if len(issues) > 0 {
+		return nil, nil, &ValidationError{Issues: issues}
+	}
+	return tags, notes, nil
+}
+
+// UpdateReceipt edits the tags, notes and business flag of a receipt.
+// Editing them does not run the automation rules again.
+func (s *ReceiptService) UpdateReceipt(ctx context.Context, id string, req dto.UpdateReceiptRequest) (*dto.ReceiptResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	tags, notes, err := annotationsFromRequest(req.Tags, req.Notes)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := database.ReceiptAnnotations{Tags: tags, Notes: notes, Business: req.Business}
+	if err := s.db.UpdateReceiptAnnotations(ctx, id, annotations); err != nil {
+		return nil, err
+	}
+
+	receipt, err := s.GetReceipt(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.emit(ctx, models.EventReceiptUpdated, receipt)
+	return receipt, nil
+}
+
+// UpdateItemAnnotations edits the tags and notes of an item, returns its receipt
+func (s *ReceiptService) UpdateItemAnnotations(ctx context.Context, itemID string, req dto.UpdateItemAnnotationsRequest) (*dto.ReceiptResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	tags, notes, err := annotationsFromRequest(req.Tags, req.Notes)
+	if err != nil {
+		return nil, err
+	}
+
+	receiptID, err := s.db.UpdateItemAnnotations(ctx, itemID, database.ItemAnnotations{Tags: tags, Notes: notes})
+	if err != nil {
+		return nil, err
+	}
+
+	receipt, err := s.GetReceipt(ctx, receiptID)
+	if err != nil {
+		return nil, err
+	}
+	s.emit(ctx, models.EventReceiptUpdated, receipt)
+	return receipt, nil
+}
+
+// ListTags retrieves every tag in use on the receipts matching a filter
+func (s *ReceiptService) ListTags(ctx context.Context, filter database.ReceiptFilter) ([]dto.TagResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	tags, err := s.db.ListTags(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.TagResponse, len(tags))
+	for i, tag := range tags {
+		response[i] = dto.TagResponse{Tag: tag.Tag, Receipts: tag.Receipts, Items: tag.Items}
+	}
+	return response, nil
+}
+
+// RenameTag renames a tag on the receipts matching a filter and their items,
+// and in the rules of its member that add it. Renaming into a tag in use
+// merges both.
+func (s *ReceiptService) RenameTag(ctx context.Context, filter database.ReceiptFilter, tag string, req dto.RenameTagRequest) (*dto.TagChangesResponse, error) {
+	return s.MergeTags(ctx, filter, dto.MergeTagsRequest{Tags: []string{tag}, Into: req.Name})
+}
+
+// MergeTags replaces several tags with one on the receipts matching a filter
+// and their items, and in the rules of its member that add them
+func (s *ReceiptService) MergeTags(ctx context.Context, filter database.ReceiptFilter, req dto.MergeTagsRequest) (*dto.TagChangesResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	from := normalizeTags(req.Tags)
+	into := NormalizeTag(req.Into)
+
+	issues := []string{}
+	if len(from) == 0 {
+		issues = append(issues, "no tags to rename given")
+	}
+	switch {
+	case into == "":
+		issues = append(issues, "the new tag is empty")
+	case len(into) > maxTagLength:
+		issues = append(issues, fmt.Sprintf("the new tag is longer than %d characters", maxTagLength))
+	}
+	if len(issues) > 0 {
+		return nil, &ValidationError{Issues: issues}
+	}
+
+	changes, err := s.db.RenameTags(ctx, filter, from, into)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Tags renamed", "from", from, "to", into, "member", filter.Member,
+		"receipts", changes.Receipts, "items", changes.Items, "rules", changes.Rules)
+
+	return tagChangesToDTO(changes), nil
+}
+
+// DeleteTag removes a tag from the receipts matching a filter and their items
+func (s *ReceiptService) DeleteTag(ctx context.Context, filter database.ReceiptFilter, tag string) (*dto.TagChangesResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	tag = NormalizeTag(tag)
+	if tag == "" {
+		return nil, &ValidationError{Issues: []string{"the tag is empty"}}
+	}
+
+	changes, err := s.db.DeleteTag(ctx, filter, tag)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Tag deleted", "tag", tag, "member", filter.Member, "receipts", changes.Receipts, "items", changes.Items)
+
+	return tagChangesToDTO(changes), nil
+}
+
+// TagSpending sums what was spent per tag on the receipts matching a filter
+func (s *ReceiptService) TagSpending(ctx context.Context, filter database.ReceiptFilter) ([]dto.TagSpendingResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	spending, err := s.db.TagSpending(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.TagSpendingResponse, len(spending))
+	for i, tag := range spending {
+		response[i] = dto.TagSpendingResponse{
+			Tag:      tag.Tag,
+			Receipts: tag.Receipts,
+			Items:    tag.Items,
+			Total:    tag.Total,
+		}
+	}
+	return response, nil
+}
+
+// tagChangesToDTO converts tag changes to their response
+func tagChangesToDTO(changes *database.TagChanges) *dto.TagChangesResponse {
+	return &dto.TagChangesResponse{
+		Receipts: changes.Receipts,
+		Items:    changes.Items,
+		Rules:    changes.Rules,
+	}
+}
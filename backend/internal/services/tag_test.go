@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"trip", "trip"},
+		{"  Monthly   Shop ", "monthly-shop"},
+		{"#Dinner", "dinner"},
+		{"tag:Monthly Shop", "monthly-shop"},
+		{"TAG:reimbursable", "reimbursable"},
+		{"tag:", "tag:"},
+		{"#", ""},
+		{"  ", ""},
+		{"Año Nuevo", "año-nuevo"},
+		{"already-normal", "already-normal"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeTag(tt.tag); got != tt.want {
+			t.Errorf("NormalizeTag(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+	}{
+		{"not given", nil, nil},
+		{"sorted", []string{"trip", "dinner"}, []string{"dinner", "trip"}},
+		{"repeated once normalised", []string{"#Trip", "trip", "TRIP "}, []string{"trip"}},
+		{"empty dropped", []string{"", " ", "#", "work"}, []string{"work"}},
+		{"all empty", []string{" "}, []string{}},
+	}
+
+	for _, tt := range tests {
+		got := normalizeTags(tt.tags)
+		if !slices.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+			t.Errorf("%s: normalizeTags(%q) = %#v, want %#v", tt.name, tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestMergeTagLists(t *testing.T) {
+	got := mergeTags([]string{"trip", "dinner"}, []string{"work", "dinner"})
+	if want := []string{"dinner", "trip", "work"}; !slices.Equal(got, want) {
+		t.Errorf("mergeTags = %q, want %q", got, want)
+	}
+}
+
+func TestAnnotationsFromRequest(t *testing.T) {
+	tooMany := make([]string, maxTags+1)
+	for i := range tooMany {
+		tooMany[i] = strings.Repeat("t", i+1)
+	}
+
+	// Blank tags clear the tags, rather than leaving them unchanged
+	tags, notes, err := annotationsFromRequest(&[]string{" "}, new(string))
+	if err != nil || tags == nil || len(*tags) != 0 || notes == nil || *notes != "" {
+		t.Errorf("blank annotations = %v, %v, %v, want empty tags and notes", tags, notes, err)
+	}
+
+	// Nothing given leaves both unchanged
+	if tags, notes, err := annotationsFromRequest(nil, nil); tags != nil || notes != nil || err != nil {
+		t.Errorf("no annotations = %v, %v, %v, want nil", tags, notes, err)
+	}
+
+	tests := []struct {
+		name  string
+		tags  []string
+		notes string
+		want  string
+	}{
+		{"too many tags", tooMany, "", "more than 50 tags"},
+		{"tag too long", []string{strings.Repeat("x", maxTagLength+1)}, "", "longer than 64 characters"},
+		{"notes too long", nil, strings.Repeat("n", maxNotesLength+1), "notes are longer"},
+	}
+	for _, tt := range tests {
+		_, _, err := annotationsFromRequest(&tt.tags, &tt.notes)
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: annotationsFromRequest = %v, want an issue about %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// tagRepository records the tags it is asked to rename
+type tagRepository struct {
+	database.ReceiptRepository
+
+	from []string
+	to   string
+}
+
+func (r *tagRepository) RenameTags(ctx context.Context, filter database.ReceiptFilter, from []string, to string) (*database.TagChanges, error) {
+	r.from, r.to = from, to
+	return &database.TagChanges{Receipts: 2, Items: 1, Rules: 1}, nil
+}
+
+func TestMergeTags(t *testing.T) {
+	repository := &tagRepository{}
+	service := &ReceiptService{db: repository}
+
+	// Tags are renamed by their normalised names
+	changes, err := service.MergeTags(context.Background(), database.ReceiptFilter{}, dto.MergeTagsRequest{
+		Tags: []string{"Trip Rome", "#trip-rome", "tag:Rome 2024"},
+		Into: " Rome Trip ",
+	})
+	if err != nil {
+		t.Fatalf("MergeTags failed: %v", err)
+	}
+	if !slices.Equal(repository.from, []string{"rome-2024", "trip-rome"}) || repository.to != "rome-trip" {
+		t.Errorf("renamed %q into %q, want rome-2024 and trip-rome into rome-trip", repository.from, repository.to)
+	}
+	if *changes != (dto.TagChangesResponse{Receipts: 2, Items: 1, Rules: 1}) {
+		t.Errorf("changes = %+v", changes)
+	}
+
+	// Renaming is merging a single tag
+	if _, err := service.RenameTag(context.Background(), database.ReceiptFilter{}, "Dinners", dto.RenameTagRequest{Name: "#dinner"}); err != nil {
+		t.Fatalf("RenameTag failed: %v", err)
+	}
+	if !slices.Equal(repository.from, []string{"dinners"}) || repository.to != "dinner" {
+		t.Errorf("renamed %q into %q, want dinners into dinner", repository.from, repository.to)
+	}
+
+	tests := []struct {
+		name string
+		req  dto.MergeTagsRequest
+	}{
+		{"no tags", dto.MergeTagsRequest{Tags: []string{" ", "#"}, Into: "trip"}},
+		{"empty target", dto.MergeTagsRequest{Tags: []string{"trip"}, Into: "#"}},
+		{"target too long", dto.MergeTagsRequest{Tags: []string{"trip"}, Into: strings.Repeat("x", maxTagLength+1)}},
+	}
+	for _, tt := range tests {
+		repository.from = nil
+		_, err := service.MergeTags(context.Background(), database.ReceiptFilter{}, tt.req)
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || repository.from != nil {
+			t.Errorf("%s: MergeTags = %v, want a validation error before renaming", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/config"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// API tokens are tokenPrefix followed by the hex of tokenBytes random bytes.
+// The first tokenDisplayLength characters are kept to tell them apart.
+const (
+	tokenPrefix         = "tkt_"
+	tokenBytes          = 32
+	tokenDisplayLength  = 12
+	maxTokenNameLength  = 100
+	maxTokenOwnerLength = 255
+)
+
+// ErrTokenRequired is returned when a request without a token needs one
+var ErrTokenRequired = errors.New("an API token is required")
+
+// ErrInvalidToken is returned for tokens that are unknown, revoked or expired
+var ErrInvalidToken = errors.New("invalid or expired API token")
+
+// ErrInsufficientScope is returned when a token lacks the scope of a request
+var ErrInsufficientScope = errors.New("the API token does not have the required scope")
+
+// TokenService manages the API tokens and authenticates the requests made
+// with them
+type TokenService struct {
+	db database.TokenRepository
+
+	// authRequired makes every request need an API token, even before the
+	// first one is created
+	authRequired bool
+}
+
+func NewTokenService(db database.TokenRepository, cfg *config.Config) *TokenService {
+	return &TokenService{
+		db:           db,
+		authRequired: cfg.APIAuthRequired,
+	}
+}
+
+// Caller is who makes a request: the holder of an API token, or anyone when
+// no token is sent
+type Caller struct {
+	// Token is the API token of the request, nil when none was sent
+	Token *models.APIToken
+
+	// open lets requests without a token through, except for admin scope
+	open bool
+}
+
+// Authorize checks that a caller may use a scope. Admin scope always needs a
+// token.
+func (c *Caller) Authorize(scope string) error {
+	if c.Token == nil {
+		if !c.open || scope == models.ScopeAdmin {
+			return ErrTokenRequired
+		}
+		return nil
+	}
+	if !c.Token.Allows(scope) {
+		return ErrInsufficientScope
+	}
+	return nil
+}
+
+// User returns who the caller is, the owner of its API token, or empty
+// without one
+func (c *Caller) User() string {
+	if c.Token == nil {
+		return ""
+	}
+	return c.Token.Owner
+}
+
+// OwnerFor returns who owns the receipts a caller submits: the caller, unless
+// an admin submits them for someone else
+func (c *Caller) OwnerFor(requested string) (string, error) {
+	requested = strings.TrimSpace(requested)
+	if requested == "" || requested == c.User() {
+		return c.User(), nil
+	}
+	if err := c.Authorize(models.ScopeAdmin); err != nil {
+		return "", err
+	}
+	log.Info("Receipts submitted for another user", "caller", c.User(), "owner", requested)
+	return requested, nil
+}
+
+// Authenticate identifies the caller of a request by its token (empty when
+// none was sent), recording that the token was used. Requests without a
+// token are only let through on a fresh install: until the first token is
+// created, and when tokens are not required.
+func (s *TokenService) Authenticate(ctx context.Context, token string) (*Caller, error) {
+	if token == "" {
+		if s.authRequired || s.db == nil {
+			return &Caller{open: !s.authRequired}, nil
+		}
+		exists, err := s.db.HasAPITokens(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &Caller{open: !exists}, nil
+	}
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	apiToken, err := s.db.GetAPITokenByHash(ctx, hashToken(token))
+	if errors.Is(err, database.ErrTokenNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if apiToken.Expired(time.Now()) {
+		return nil, ErrInvalidToken
+	}
+
+	// Failing to record the use never fails the request
+	if err := s.db.TouchAPIToken(ctx, apiToken.ID); err != nil {
+		log.Warn("Failed to record API token use", "id", apiToken.ID, "error", err)
+	}
+
+	return &Caller{Token: apiToken}, nil
+}
+
+// tokenOwner returns whose API tokens a caller manages: their own, or every
+// user's for admins (empty)
+func tokenOwner(caller *Caller) (string, error) {
+	if caller.Token == nil {
+		return "", ErrTokenRequired
+	}
+	if caller.Token.Allows(models.ScopeAdmin) {
+		return "", nil
+	}
+	return caller.Token.Owner, nil
+}
+
+// ListAPITokens retrieves the API tokens a caller manages, without the tokens
+// themselves: their own, or every one for admins
+func (s *TokenService) ListAPITokens(ctx context.Context, caller *Caller) ([]dto.APITokenResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	owner, err := tokenOwner(caller)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.db.ListAPITokens(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.APITokenResponse, len(tokens))
+	for i := range tokens {
+		response[i] = apiTokenToDTO(&tokens[i])
+	}
+	return response, nil
+}
+
+// CreateAPIToken creates an API token from the command line. The token is
+// only returned now: just its hash is stored.
+func (s *TokenService) CreateAPIToken(ctx context.Context, req dto.APITokenRequest) (*dto.APITokenResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	apiToken, err := apiTokenFromRequest(req, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return s.createAPIToken(ctx, apiToken)
+}
+
+// CreateAPITokenAs creates an API token for a caller. Callers create tokens
+// for themselves (the owner defaults to them), with no more scopes than their
+// own and expiring no later; admins create any token, for anyone, and the
+// creator of every token is recorded.
+func (s *TokenService) CreateAPITokenAs(ctx context.Context, caller *Caller, req dto.APITokenRequest) (*dto.APITokenResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+	if caller.Token == nil {
+		return nil, ErrTokenRequired
+	}
+
+	if strings.TrimSpace(req.Owner) == "" {
+		req.Owner = caller.Token.Owner
+	}
+	apiToken, err := apiTokenFromRequest(req, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeNewToken(caller.Token, apiToken); err != nil {
+		return nil, err
+	}
+	apiToken.CreatedBy = caller.Token.Owner
+
+	return s.createAPIToken(ctx, apiToken)
+}
+
+// authorizeNewToken checks that the holder of a token may create another:
+// admins any, other users only their own, within the scopes and expiry of
+// theirs
+func authorizeNewToken(creator, apiToken *models.APIToken) error {
+	if creator.Allows(models.ScopeAdmin) {
+		if apiToken.Owner != creator.Owner {
+			log.Warn("API token created for another user", "creator", creator.Owner, "owner", apiToken.Owner, "scopes", apiToken.Scopes)
+		}
+		return nil
+	}
+
+	if apiToken.Owner != creator.Owner {
+		return ErrInsufficientScope
+	}
+	for _, scope := range apiToken.Scopes {
+		if !creator.Allows(scope) {
+			return ErrInsufficientScope
+		}
+	}
+	if creator.ExpiresAt != nil && (apiToken.ExpiresAt == nil || apiToken.ExpiresAt.After(*creator.ExpiresAt)) {
+		return &ValidationError{Issues: []string{
+			fmt.Sprintf("the token must expire by %s, when the token creating it does", creator.ExpiresAt.Format(time.RFC3339)),
+		}}
+	}
+	return nil
+}
+
+// createAPIToken generates and saves a validated API token
+func (s *TokenService) createAPIToken(ctx context.Context, apiToken *models.APIToken) (*dto.APITokenResponse, error) {
+	secret := make([]byte, tokenBytes)
+	rand.Read(secret)
+	token := tokenPrefix + hex.EncodeToString(secret)
+	apiToken.Prefix = token[:tokenDisplayLength]
+	apiToken.Hash = hashToken(token)
+
+	id, err := s.db.CreateAPIToken(ctx, apiToken)
+	if err != nil {
+		return nil, err
+	}
+	apiToken.ID = id
+	apiToken.CreatedAt = time.Now()
+	log.Info("API token created", "id", id, "name", apiToken.Name, "owner", apiToken.Owner, "scopes", apiToken.Scopes,
+		"created_by", apiToken.CreatedBy)
+
+	response := apiTokenToDTO(apiToken)
+	response.Token = token
+	return &response, nil
+}
+
+// DeleteAPIToken revokes an API token a caller manages: one of their own, or
+// any for admins
+func (s *TokenService) DeleteAPIToken(ctx context.Context, caller *Caller, id string) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+
+	owner, err := tokenOwner(caller)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.DeleteAPIToken(ctx, id, owner); err != nil {
+		return err
+	}
+	log.Info("API token revoked", "id", id, "by", caller.Token.Owner)
+
+	return nil
+}
+
+// hashToken hashes a token the way it is stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiTokenFromRequest validates an API token request
+func apiTokenFromRequest(req dto.APITokenRequest, now time.Time) (*models.APIToken, error) {
+	apiToken := &models.APIToken{
+		Name:   strings.TrimSpace(req.Name),
+		Owner:  strings.TrimSpace(req.Owner),
+		Scopes: []string{},
+	}
+
+	issues := []string{}
+	if apiToken.Name == "" {
+		issues = append(issues, "the name is required")
+	} else if len(apiToken.Name) > maxTokenNameLength {
+		issues = append(issues, fmt.Sprintf("the name is longer than %d characters", maxTokenNameLength))
+	}
+	if apiToken.Owner == "" {
+		issues = append(issues, "the owner is required")
+	} else if len(apiToken.Owner) > maxTokenOwnerLength {
+		issues = append(issues, fmt.Sprintf("the owner is longer than %d characters", maxTokenOwnerLength))
+	}
+
+	for _, scope := range req.Scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !slices.Contains(models.Scopes, scope) {
+			issues = append(issues, fmt.Sprintf("unknown scope %q (expected %s)", scope, strings.Join(models.Scopes, ", ")))
+			continue
+		}
+		if !slices.Contains(apiToken.Scopes, scope) {
+			apiToken.Scopes = append(apiToken.Scopes, scope)
+		}
+	}
+	if len(req.Scopes) == 0 {
+		issues = append(issues, "at least one scope is required")
+	}
+
+	if expiresAt := strings.TrimSpace(req.ExpiresAt); expiresAt != "" {
+		expiry, err := parseExpiry(expiresAt)
+		switch {
+		case err != nil:
+			issues = append(issues, fmt.Sprintf("invalid expiry %q (expected YYYY-MM-DD or RFC 3339)", req.ExpiresAt))
+		case !expiry.After(now):
+			issues = append(issues, "the expiry is not in the future")
+		default:
+			apiToken.ExpiresAt = &expiry
+		}
+	}
+
+	if len(issues) > 0 {
+		return nil, &ValidationError{Issues: issues}
+	}
+	return apiToken, nil
+}
+
+// parseExpiry parses an expiry as a time, or as a date the token expires at
+// the start of (UTC)
+func parseExpiry(value string) (time.Time, error) {
+	if expiry, err := time.Parse(time.DateOnly, value); err == nil {
+		return expiry, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// apiTokenToDTO converts an API token, without the token itself
+func apiTokenToDTO(apiToken *models.APIToken) dto.APITokenResponse {
+	response := dto.APITokenResponse{
+		ID:        apiToken.ID,
+		Name:      apiToken.Name,
+		Owner:     apiToken.Owner,
+		Prefix:    apiToken.Prefix,
+		Scopes:    apiToken.Scopes,
+		CreatedAt: apiToken.CreatedAt.Format(time.RFC3339),
+		CreatedBy: apiToken.CreatedBy,
+	}
+	if apiToken.ExpiresAt != nil {
+		response.ExpiresAt = apiToken.ExpiresAt.Format(time.RFC3339)
+	}
+	if apiToken.LastUsedAt != nil {
+		response.LastUsedAt = apiToken.LastUsedAt.Format(time.RFC3339)
+	}
+	return response
+}
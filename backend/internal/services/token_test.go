@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vieitesss/ticketer/internal/config"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// tokenRepository keeps API tokens in memory, by hash
+type tokenRepository struct {
+	database.TokenRepository
+
+	tokens map[string]*models.APIToken
+}
+
+func (r *tokenRepository) HasAPITokens(ctx context.Context) (bool, error) {
+	return len(r.tokens) > 0, nil
+}
+
+func (r *tokenRepository) GetAPITokenByHash(ctx context.Context, hash string) (*models.APIToken, error) {
+	token, ok := r.tokens[hash]
+	if !ok {
+		return nil, database.ErrTokenNotFound
+	}
+	return token, nil
+}
+
+func (r *tokenRepository) TouchAPIToken(ctx context.Context, id string) error {
+	return nil
+}
+
+func (r *tokenRepository) ListAPITokens(ctx context.Context, owner string) ([]models.APIToken, error) {
+	tokens := []models.APIToken{}
+	for _, token := range r.tokens {
+		if owner == "" || token.Owner == owner {
+			tokens = append(tokens, *token)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b models.APIToken) int { return strings.Compare(a.ID, b.ID) })
+	return tokens, nil
+}
+
+func (r *tokenRepository) CreateAPIToken(ctx context.Context, token *models.APIToken) (string, error) {
+	token.ID = token.Name
+	r.tokens[token.Hash] = token
+	return token.ID, nil
+}
+
+func (r *tokenRepository) DeleteAPIToken(ctx context.Context, id, owner string) error {
+	for hash, token := range r.tokens {
+		if token.ID == id && (owner == "" || token.Owner == owner) {
+			delete(r.tokens, hash)
+			return nil
+		}
+	}
+	return database.ErrTokenNotFound
+}
+
+func TestAuthenticate(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+	tokens := map[string]*models.APIToken{
+		hashToken("tkt_reader"):  {ID: "reader", Owner: "ana", Scopes: []string{models.ScopeRead}},
+		hashToken("tkt_admin"):   {ID: "admin", Owner: "ana", Scopes: []string{models.ScopeAdmin}},
+		hashToken("tkt_expired"): {ID: "expired", Owner: "bea", Scopes: []string{models.ScopeAdmin}, ExpiresAt: &expired},
+	}
+
+	tests := []struct {
+		name         string
+		tokens       map[string]*models.APIToken
+		authRequired bool
+		token        string
+		scope        string
+		wantErr      error
+	}{
+		{"fresh install", nil, false, "", models.ScopeWrite, nil},
+		{"fresh install admin", nil, false, "", models.ScopeAdmin, ErrTokenRequired},
+		{"fresh install with tokens required", nil, true, "", models.ScopeRead, ErrTokenRequired},
+		{"anonymous once tokens exist", tokens, false, "", models.ScopeRead, ErrTokenRequired},
+		{"anonymous admin once tokens exist", tokens, false, "", models.ScopeAdmin, ErrTokenRequired},
+		{"scope of the token", tokens, false, "tkt_reader", models.ScopeRead, nil},
+		{"scope the token lacks", tokens, false, "tkt_reader", models.ScopeWrite, ErrInsufficientScope},
+		{"admin grants every scope", tokens, true, "tkt_admin", models.ScopeUpload, nil},
+		{"unknown token", tokens, false, "tkt_unknown", models.ScopeRead, ErrInvalidToken},
+		{"unknown token on a fresh install", nil, false, "tkt_unknown", models.ScopeRead, ErrInvalidToken},
+		{"expired token", tokens, false, "tkt_expired", models.ScopeRead, ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewTokenService(&tokenRepository{tokens: tt.tokens}, &config.Config{APIAuthRequired: tt.authRequired})
+
+			caller, err := service.Authenticate(context.Background(), tt.token)
+			if err == nil {
+				err = caller.Authorize(tt.scope)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOwnerFor(t *testing.T) {
+	user := &Caller{Token: &models.APIToken{Owner: "ana", Scopes: []string{models.ScopeUpload}}}
+	admin := &Caller{Token: &models.APIToken{Owner: "root", Scopes: []string{models.ScopeAdmin}}}
+
+	tests := []struct {
+		name      string
+		caller    *Caller
+		requested string
+		want      string
+		wantErr   error
+	}{
+		{"the caller", user, "", "ana", nil},
+		{"the caller by name", user, " ana ", "ana", nil},
+		{"someone else", user, "bea", "", ErrInsufficientScope},
+		{"admin", admin, "", "root", nil},
+		{"admin for someone else", admin, "bea", "bea", nil},
+		{"without a token", &Caller{open: true}, "", "", nil},
+		{"without a token for someone", &Caller{open: true}, "bea", "", ErrTokenRequired},
+	}
+
+	for _, tt := range tests {
+		owner, err := tt.caller.OwnerFor(tt.requested)
+		if owner != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: OwnerFor(%q) = %q, %v, want %q, %v", tt.name, tt.requested, owner, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCreateAPITokenAs(t *testing.T) {
+	soon := time.Now().Add(24 * time.Hour)
+	user := &Caller{Token: &models.APIToken{Owner: "ana", Scopes: []string{models.ScopeRead, models.ScopeUpload}}}
+	expiring := &Caller{Token: &models.APIToken{Owner: "ana", Scopes: []string{models.ScopeRead}, ExpiresAt: &soon}}
+	admin := &Caller{Token: &models.APIToken{Owner: "root", Scopes: []string{models.ScopeAdmin}}}
+
+	tests := []struct {
+		name      string
+		caller    *Caller
+		req       dto.APITokenRequest
+		wantOwner string
+		wantErr   error
+	}{
+		{"own token", user, dto.APITokenRequest{Name: "phone", Scopes: []string{"upload"}}, "ana", nil},
+		{"own token by name", user, dto.APITokenRequest{Name: "phone", Owner: "ana", Scopes: []string{"read"}}, "ana", nil},
+		{"for someone else", user, dto.APITokenRequest{Name: "phone", Owner: "bea", Scopes: []string{"read"}}, "", ErrInsufficientScope},
+		{"more scopes", user, dto.APITokenRequest{Name: "phone", Scopes: []string{"write"}}, "", ErrInsufficientScope},
+		{"admin scope", user, dto.APITokenRequest{Name: "phone", Scopes: []string{"admin"}}, "", ErrInsufficientScope},
+		{"outliving the creator", expiring, dto.APITokenRequest{Name: "phone", Scopes: []string{"read"}}, "", &ValidationError{}},
+		{"within the creator's expiry", expiring, dto.APITokenRequest{Name: "phone", Scopes: []string{"read"}, ExpiresAt: time.Now().Add(time.Hour).Format(time.RFC3339)}, "ana", nil},
+		{"admin for someone else", admin, dto.APITokenRequest{Name: "scanner", Owner: "bea", Scopes: []string{"admin"}}, "bea", nil},
+		{"without a token", &Caller{open: true}, dto.APITokenRequest{Name: "phone", Owner: "ana", Scopes: []string{"read"}}, "", ErrTokenRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &tokenRepository{tokens: map[string]*models.APIToken{}}
+			service := NewTokenService(repository, &config.Config{})
+
+			token, err := service.CreateAPITokenAs(context.Background(), tt.caller, tt.req)
+			if validationErr, ok := tt.wantErr.(*ValidationError); ok {
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("error = %v, want a ValidationError", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(repository.tokens) > 0 {
+					t.Errorf("created %v", repository.tokens)
+				}
+				return
+			}
+			if token.Owner != tt.wantOwner || token.CreatedBy != tt.caller.Token.Owner || token.Token == "" {
+				t.Errorf("token of %q created by %q, want one of %q created by %q", token.Owner, token.CreatedBy, tt.wantOwner, tt.caller.Token.Owner)
+			}
+		})
+	}
+}
+
+func TestManageOwnAPITokens(t *testing.T) {
+	repository := &tokenRepository{tokens: map[string]*models.APIToken{
+		"a": {ID: "ana-phone", Owner: "ana"},
+		"b": {ID: "ana-laptop", Owner: "ana"},
+		"c": {ID: "bea-phone", Owner: "bea"},
+	}}
+	service := NewTokenService(repository, &config.Config{})
+	ana := &Caller{Token: &models.APIToken{Owner: "ana", Scopes: []string{models.ScopeRead}}}
+	admin := &Caller{Token: &models.APIToken{Owner: "root", Scopes: []string{models.ScopeAdmin}}}
+
+	ids := func(caller *Caller) []string {
+		t.Helper()
+		tokens, err := service.ListAPITokens(context.Background(), caller)
+		if err != nil {
+			t.Fatalf("ListAPITokens failed: %v", err)
+		}
+		ids := []string{}
+		for _, token := range tokens {
+			ids = append(ids, token.ID)
+		}
+		return ids
+	}
+
+	if got, want := ids(ana), []string{"ana-laptop", "ana-phone"}; !slices.Equal(got, want) {
+		t.Errorf("user lists %v, want %v", got, want)
+	}
+	if got, want := ids(admin), []string{"ana-laptop", "ana-phone", "bea-phone"}; !slices.Equal(got, want) {
+		t.Errorf("admin lists %v, want %v", got, want)
+	}
+	if _, err := service.ListAPITokens(context.Background(), &Caller{open: true}); !errors.Is(err, ErrTokenRequired) {
+		t.Errorf("listing without a token: %v, want ErrTokenRequired", err)
+	}
+
+	if err := service.DeleteAPIToken(context.Background(), ana, "bea-phone"); !errors.Is(err, database.ErrTokenNotFound) {
+		t.Errorf("revoking someone else's token: %v, want ErrTokenNotFound", err)
+	}
+	if err := service.DeleteAPIToken(context.Background(), ana, "ana-phone"); err != nil {
+		t.Errorf("revoking an own token: %v", err)
+	}
+	if err := service.DeleteAPIToken(context.Background(), admin, "bea-phone"); err != nil {
+		t.Errorf("admin revoking someone else's token: %v", err)
+	}
+	if got, want := ids(admin), []string{"ana-laptop"}; !slices.Equal(got, want) {
+		t.Errorf("left %v, want %v", got, want)
+	}
+}
+
+func TestAPITokenFromRequest(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	apiToken, err := apiTokenFromRequest(dto.APITokenRequest{
+		Name:      " CLI ",
+		Owner:     " ana ",
+		Scopes:    []string{"Read", "write", "read"},
+		ExpiresAt: "2024-04-01",
+	}, now)
+	if err != nil {
+		t.Fatalf("apiTokenFromRequest failed: %v", err)
+	}
+	if apiToken.Name != "CLI" || apiToken.Owner != "ana" || !slices.Equal(apiToken.Scopes, []string{"read", "write"}) {
+		t.Errorf("token = %s of %s with %v", apiToken.Name, apiToken.Owner, apiToken.Scopes)
+	}
+	if want := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC); !apiToken.ExpiresAt.Equal(want) {
+		t.Errorf("expires at %v, want %v", apiToken.ExpiresAt, want)
+	}
+
+	_, err = apiTokenFromRequest(dto.APITokenRequest{Scopes: []string{"root"}, ExpiresAt: "2024-03-01"}, now)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("error = %v, want a ValidationError", err)
+	}
+	want := []string{
+		"the name is required",
+		"the owner is required",
+		`unknown scope "root" (expected read, upload, write, admin)`,
+		"the expiry is not in the future",
+	}
+	if !slices.Equal(validationErr.Issues, want) {
+		t.Errorf("issues = %q, want %q", validationErr.Issues, want)
+	}
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/google/uuid"
+)
+
+// SupportedExtensions lists the receipt file formats that can be processed
+var SupportedExtensions = []string{".jpg", ".jpeg", ".png", ".pdf", ".txt", ".html", ".htm", ".eml"}
+
+// IsSupportedFile reports whether a file has a supported receipt format
+func IsSupportedFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, supported := range SupportedExtensions {
+		if ext == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveUpload saves a receipt file under a unique name in the uploads directory.
+// The file is kept as the receipt's original image for reprocessing.
+func (s *ReceiptService) SaveUpload(src io.Reader, ext string) (string, error) {
+	// Create uploads directory if it doesn't exist
+	if err := os.MkdirAll(s.uploadsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create uploads directory: %w", err)
+	}
+
+	path := filepath.Join(s.uploadsDir, uuid.New().String()+strings.ToLower(ext))
+	dst, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return path, nil
+}
+
+// DiscardUpload removes a saved upload whose receipt was not saved
+func (s *ReceiptService) DiscardUpload(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Warn("Failed to remove upload", "path", path, "error", err)
+	}
+}
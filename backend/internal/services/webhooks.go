@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services/webhooks"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// Webhook limits
+const (
+	minSecretLength       = 16
+	maxSecretLength       = 128
+	webhookDeliveryLimit  = 50
+	maxWebhookDeliveryLog = 500
+)
+
+// WebhookService manages the webhooks and delivers the events queued for them
+type WebhookService struct {
+	db database.WebhookRepository
+
+	// webhooks delivers the queued events (nil without a database)
+	webhooks *webhooks.Dispatcher
+}
+
+func NewWebhookService(db database.WebhookRepository, dispatcher *webhooks.Dispatcher) *WebhookService {
+	return &WebhookService{
+		db:       db,
+		webhooks: dispatcher,
+	}
+}
+
+// DeliverWebhooks delivers the queued webhook events until ctx is cancelled
+func (s *WebhookService) DeliverWebhooks(ctx context.Context) error {
+	if s.webhooks == nil {
+		return ErrNoDatabase
+	}
+
+	return s.webhooks.Run(ctx)
+}
+
+// emitEvent queues an event for the webhooks subscribed to it. Events never
+// fail what emitted them: failures are logged.
+func emitEvent(ctx context.Context, dispatcher *webhooks.Dispatcher, event string, data any) {
+	if dispatcher == nil {
+		return
+	}
+
+	if err := dispatcher.Enqueue(ctx, event, data); err != nil {
+		log.Warn("Failed to queue webhook event", "event", event, "error", err)
+	}
+}
+
+// emit queues an event for the webhooks subscribed to it
+func (s *ReceiptService) emit(ctx context.Context, event string, data any) {
+	emitEvent(ctx, s.webhooks, event, data)
+}
+
+// emitReceipt queues a receipt event with the current state of the receipt
+func (s *ReceiptService) emitReceipt(ctx context.Context, event, receiptID string) {
+	if s.webhooks == nil || receiptID == "" {
+		return
+	}
+
+	receipt, err := s.GetReceipt(ctx, receiptID)
+	if err != nil {
+		log.Warn("Failed to load receipt for webhook event", "event", event, "id", receiptID, "error", err)
+		return
+	}
+	s.emit(ctx, event, receipt)
+}
+
+// ListWebhooks retrieves every webhook
+func (s *WebhookService) ListWebhooks(ctx context.Context) ([]dto.WebhookResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	webhooks, err := s.db.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.WebhookResponse, len(webhooks))
+	for i := range webhooks {
+		response[i] = webhookToDTO(&webhooks[i])
+	}
+	return response, nil
+}
+
+// CreateWebhook subscribes a URL to events, returning the webhook with its secret
+func (s *WebhookService) CreateWebhook(ctx context.Context, req dto.WebhookRequest) (*dto.WebhookResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	webhook, err := webhookFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+
+	id, err := s.db.CreateWebhook(ctx, webhook)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Webhook created", "id", id, "url", webhook.URL, "events", webhook.Events)
+
+	created, err := s.db.GetWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	response := webhookToDTO(created)
+	response.Secret = created.Secret
+	return &response, nil
+}
+
+// UpdateWebhook replaces a webhook, keeping its secret unless a new one is given
+func (s *WebhookService) UpdateWebhook(ctx context.Context, id string, req dto.WebhookRequest) (*dto.WebhookResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	webhook, err := webhookFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	webhook.ID = id
+
+	if err := s.db.UpdateWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+
+	updated, err := s.db.GetWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	response := webhookToDTO(updated)
+	return &response, nil
+}
+
+// DeleteWebhook deletes a webhook with its delivery log
+func (s *WebhookService) DeleteWebhook(ctx context.Context, id string) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+
+	return s.db.DeleteWebhook(ctx, id)
+}
+
+// ListWebhookDeliveries retrieves the latest deliveries of a webhook, up to
+// limit of them, optionally only those with a status
+func (s *WebhookService) ListWebhookDeliveries(ctx context.Context, webhookID, status string, limit int) ([]dto.WebhookDeliveryResponse, error) {
+	if s.db == nil {
+		return nil, ErrNoDatabase
+	}
+
+	switch status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryFailed:
+	default:
+		return nil, &ValidationError{Issues: []string{fmt.Sprintf("unknown status %q (expected pending, delivered or failed)", status)}}
+	}
+	if limit <= 0 {
+		limit = webhookDeliveryLimit
+	}
+	limit = min(limit, maxWebhookDeliveryLog)
+
+	deliveries, err := s.db.ListWebhookDeliveries(ctx, webhookID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]dto.WebhookDeliveryResponse, len(deliveries))
+	for i, delivery := range deliveries {
+		response[i] = dto.WebhookDeliveryResponse{
+			ID:             delivery.ID,
+			EventID:        delivery.EventID,
+			Event:          delivery.Event,
+			Payload:        delivery.Payload,
+			Status:         delivery.Status,
+			Attempts:       delivery.Attempts,
+			LastStatusCode: delivery.LastStatusCode,
+			LastError:      delivery.LastError,
+			CreatedAt:      delivery.CreatedAt.Format(time.RFC3339),
+		}
+		if delivery.Status == models.DeliveryPending {
+			response[i].NextAttemptAt = delivery.NextAttemptAt.Format(time.RFC3339)
+		}
+		if delivery.DeliveredAt != nil {
+			response[i].DeliveredAt = delivery.DeliveredAt.Format(time.RFC3339)
+		}
+	}
+	return response, nil
+}
+
+// RetryWebhookDelivery queues a delivery of a webhook again, now
+func (s *WebhookService) RetryWebhookDelivery(ctx context.Context, webhookID, deliveryID string) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+
+	if err := s.db.RetryWebhookDelivery(ctx, webhookID, deliveryID); err != nil {
+		return err
+	}
+	log.Info("Webhook delivery queued again", "webhook", webhookID, "delivery", deliveryID)
+
+	return nil
+}
+
+// webhookFromRequest validates a webhook request
+func webhookFromRequest(req dto.WebhookRequest) (*models.Webhook, error) {
+	webhook := &models.Webhook{
+		URL:         strings.TrimSpace(req.URL),
+		Secret:      req.Secret,
+		Description: strings.TrimSpace(req.Description),
+		Enabled:     req.Enabled == nil || *req.Enabled,
+		Events:      []string{},
+	}
+
+	issues := []string{}
+	if parsed, err := url.Parse(webhook.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		issues = append(issues, fmt.Sprintf("invalid URL %q (expected an http or https URL)", req.URL))
+	}
+	if webhook.Secret != "" && (len(webhook.Secret) < minSecretLength || len(webhook.Secret) > maxSecretLength) {
+		issues = append(issues, fmt.Sprintf("the secret must be %d to %d characters long", minSecretLength, maxSecretLength))
+	}
+	if len(webhook.Description) > 255 {
+		issues = append(issues, "the description is longer than 255 characters")
+	}
+
+	for _, event := range req.Events {
+		event = strings.ToLower(strings.TrimSpace(event))
+		switch {
+		case event != models.WebhookAllEvents && !slices.Contains(models.Events, event):
+			issues = append(issues, fmt.Sprintf("unknown event %q (expected one of %s, or *)", event, strings.Join(models.Events, ", ")))
+		case !slices.Contains(webhook.Events, event):
+			webhook.Events = append(webhook.Events, event)
+		}
+	}
+	if len(req.Events) == 0 {
+		issues = append(issues, "no events to subscribe to")
+	}
+
+	if len(issues) > 0 {
+		return nil, &ValidationError{Issues: issues}
+	}
+	return webhook, nil
+}
+
+// webhookToDTO converts a webhook to its response, without its secret
+func webhookToDTO(webhook *models.Webhook) dto.WebhookResponse {
+	return dto.WebhookResponse{
+		ID:          webhook.ID,
+		URL:         webhook.URL,
+		Events:      webhook.Events,
+		Description: webhook.Description,
+		Enabled:     webhook.Enabled,
+		CreatedAt:   webhook.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:   webhook.UpdatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,246 @@
+// Package webhooks delivers events to webhook subscriptions. Events are
+// queued in the database, one delivery per subscribed webhook, and posted as
+// HMAC-signed JSON by a dispatcher that retries failures with exponential
+// backoff. Several instances can dispatch from the same queue.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/google/uuid"
+	"github.com/vieitesss/ticketer/internal/config"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+// Headers of a delivery
+const (
+	HeaderEvent     = "X-Ticketer-Event"
+	HeaderEventID   = "X-Ticketer-Event-Id" // Same on every retry: use it to skip repeated deliveries
+	HeaderDelivery  = "X-Ticketer-Delivery"
+	HeaderTimestamp = "X-Ticketer-Timestamp" // Unix seconds
+	HeaderSignature = "X-Ticketer-Signature" // sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+)
+
+const (
+	// batchSize is how many deliveries are claimed, and posted concurrently, at once
+	batchSize = 20
+
+	// baseBackoff and maxBackoff bound the wait before retrying a failed delivery
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+
+	// pruneInterval is how often finished deliveries past the retention are deleted
+	pruneInterval = time.Hour
+
+	// maxErrorBody is how much of an error response is kept in the delivery log
+	maxErrorBody = 512
+)
+
+// Envelope is the JSON body of a delivery
+type Envelope struct {
+	ID        string    `json:"id"` // Event ID
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Dispatcher queues events and delivers them to webhooks
+type Dispatcher struct {
+	db           database.WebhookRepository
+	client       *http.Client
+	pollInterval time.Duration
+	timeout      time.Duration
+	maxAttempts  int
+	retention    time.Duration
+
+	// wake starts a delivery round as soon as an event is queued
+	wake chan struct{}
+}
+
+func NewDispatcher(db database.WebhookRepository, cfg *config.Config) *Dispatcher {
+	return &Dispatcher{
+		db:           db,
+		client:       &http.Client{Timeout: cfg.WebhookTimeout},
+		pollInterval: cfg.WebhookPollInterval,
+		timeout:      cfg.WebhookTimeout,
+		maxAttempts:  max(cfg.WebhookMaxAttempts, 1),
+		retention:    cfg.WebhookRetention,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Enqueue queues an event for every webhook subscribed to it
+func (d *Dispatcher) Enqueue(ctx context.Context, event string, data any) error {
+	envelope := Envelope{
+		ID:        uuid.New().String(),
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	queued, err := d.db.EnqueueWebhookEvent(ctx, envelope.ID, event, payload)
+	if err != nil {
+		return err
+	}
+
+	if queued > 0 {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run delivers the queued events until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) error {
+	log.Info("Delivering webhooks", "poll_interval", d.pollInterval, "max_attempts", d.maxAttempts)
+
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	lastPrune := time.Time{}
+	for {
+		d.deliverDue(ctx)
+
+		if d.retention > 0 && time.Since(lastPrune) >= pruneInterval {
+			lastPrune = time.Now()
+			if pruned, err := d.db.PruneWebhookDeliveries(ctx, time.Now().Add(-d.retention)); err != nil {
+				log.Warn("Failed to prune webhook deliveries", "error", err)
+			} else if pruned > 0 {
+				log.Info("Pruned webhook deliveries", "deleted", pruned)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue delivers the deliveries that are due, a batch at a time
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := d.db.ClaimWebhookDeliveries(ctx, batchSize, d.timeout+30*time.Second)
+		if err != nil {
+			log.Warn("Failed to claim webhook deliveries", "error", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Go(func() {
+				attempt := d.deliver(ctx, &delivery)
+				if err := d.db.RecordWebhookAttempt(context.WithoutCancel(ctx), attempt); err != nil {
+					log.Warn("Failed to record webhook attempt", "delivery", delivery.ID, "error", err)
+				}
+			})
+		}
+		wg.Wait()
+
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+// deliver posts a delivery to its webhook and returns the outcome
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) database.WebhookAttempt {
+	attempt := database.WebhookAttempt{DeliveryID: delivery.ID}
+
+	statusCode, err := d.post(ctx, delivery)
+	attempt.StatusCode = statusCode
+	switch {
+	case err == nil:
+		attempt.Status = models.DeliveryDelivered
+		attempt.NextAttemptAt = time.Now()
+		log.Debug("Webhook delivered", "delivery", delivery.ID, "event", delivery.Event, "url", delivery.URL)
+	case delivery.Attempts+1 >= d.maxAttempts:
+		attempt.Status = models.DeliveryFailed
+		attempt.Error = err.Error()
+		attempt.NextAttemptAt = time.Now()
+		log.Warn("Webhook delivery failed for good", "delivery", delivery.ID, "event", delivery.Event, "url", delivery.URL,
+			"attempts", delivery.Attempts+1, "error", err)
+	default:
+		attempt.Status = models.DeliveryPending
+		attempt.Error = err.Error()
+		attempt.NextAttemptAt = time.Now().Add(backoff(delivery.Attempts + 1))
+		log.Warn("Webhook delivery failed, will retry", "delivery", delivery.ID, "event", delivery.Event, "url", delivery.URL,
+			"attempts", delivery.Attempts+1, "next_attempt", attempt.NextAttemptAt, "error", err)
+	}
+	return attempt
+}
+
+// post signs and posts the payload of a delivery, failing on any status
+// other than 2xx. It returns the status code, 0 without a response.
+func (d *Dispatcher) post(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("invalid webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ticketer-webhooks/1")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderEventID, delivery.EventID)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, "sha256="+Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return resp.StatusCode, fmt.Errorf("webhook answered %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" keyed by the
+// webhook secret. Receivers recompute it to check a payload came from ticketer
+// and, with the timestamp, that it is not an old one replayed.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the wait before the next attempt after a number of failed
+// ones: 30s, 1m, 2m, 4m... up to 6h, give or take 10%
+func backoff(attempts int) time.Duration {
+	wait := maxBackoff
+	if attempts < 20 {
+		wait = min(baseBackoff<<(attempts-1), maxBackoff)
+	}
+	jitter := time.Duration((rand.Float64()*0.2 - 0.1) * float64(wait))
+	return wait + jitter
+}
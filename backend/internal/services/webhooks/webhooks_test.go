@@ -0,0 +1,117 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/vieitesss/ticketer/internal/models"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		secret string
+		body   string
+		want   string
+	}{
+		{"whsec_test", `{"id":"e1"}`, "6a85cb117c993612a34c72b4cfb5a16baed25a80d26e298ce0e6671320fd07c8"},
+		{"", "", "c1da1b6c6b8e9da7f4bbb90f7cab0820f271ad19ccbf80c88479c4e14f37d1c6"},
+	}
+
+	for _, tt := range tests {
+		if got := Sign(tt.secret, 1700000000, []byte(tt.body)); got != tt.want {
+			t.Errorf("Sign(%q, %q) = %s, want %s", tt.secret, tt.body, got, tt.want)
+		}
+	}
+
+	// The timestamp is signed too, so an old payload cannot be replayed as new
+	if Sign("whsec_test", 1700000000, []byte("{}")) == Sign("whsec_test", 1700000001, []byte("{}")) {
+		t.Error("signature does not depend on the timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, maxBackoff},
+		{19, maxBackoff},
+		{100, maxBackoff},
+	}
+
+	for _, tt := range tests {
+		// Jitter keeps it within 10% of the nominal wait
+		low, high := tt.want*9/10, tt.want*11/10
+		for range 50 {
+			if got := backoff(tt.attempts); got < low || got > high {
+				t.Fatalf("backoff(%d) = %v, want %v ± 10%%", tt.attempts, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	status := http.StatusNoContent
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+		io.WriteString(w, "  try later \n")
+	}))
+	defer server.Close()
+
+	d := &Dispatcher{client: server.Client(), maxAttempts: 3}
+	delivery := &models.WebhookDelivery{
+		ID:      "d1",
+		EventID: "e1",
+		Event:   models.EventReceiptCreated,
+		URL:     server.URL,
+		Secret:  "whsec_test",
+		Payload: []byte(`{"id":"e1"}`),
+	}
+
+	attempt := d.deliver(context.Background(), delivery)
+	if attempt.Status != models.DeliveryDelivered || attempt.StatusCode != http.StatusNoContent || attempt.Error != "" {
+		t.Fatalf("attempt = %+v, want delivered", attempt)
+	}
+
+	// The receiver can check the signature with the headers and the body
+	if got.Header.Get(HeaderEvent) != delivery.Event || got.Header.Get(HeaderEventID) != "e1" || got.Header.Get(HeaderDelivery) != "d1" {
+		t.Errorf("headers = %v", got.Header)
+	}
+	timestamp, err := strconv.ParseInt(got.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid timestamp: %v", err)
+	}
+	if want := "sha256=" + Sign("whsec_test", timestamp, body); got.Header.Get(HeaderSignature) != want {
+		t.Errorf("signature = %s, want %s", got.Header.Get(HeaderSignature), want)
+	}
+
+	// A failure is retried later, until the last attempt
+	status = http.StatusServiceUnavailable
+	before := time.Now()
+	attempt = d.deliver(context.Background(), delivery)
+	if attempt.Status != models.DeliveryPending || attempt.StatusCode != http.StatusServiceUnavailable || attempt.Error != "webhook answered 503 Service Unavailable: try later" {
+		t.Errorf("attempt = %+v, want pending", attempt)
+	}
+	if wait := attempt.NextAttemptAt.Sub(before); wait < 27*time.Second || wait > 34*time.Second {
+		t.Errorf("next attempt in %v, want about 30s", wait)
+	}
+
+	delivery.Attempts = 2
+	if attempt = d.deliver(context.Background(), delivery); attempt.Status != models.DeliveryFailed {
+		t.Errorf("last attempt = %+v, want failed", attempt)
+	}
+}
@@ -0,0 +1,42 @@
+package dto
+
+// BudgetRequest represents a new or edited budget. Exactly one of Category,
+// Store and Tag is set.
+type BudgetRequest struct {
+	Name        string  `json:"name"`
+	Period      string  `json:"period"` // monthly (the default) or weekly
+	Amount      float64 `json:"amount"`
+	Category    string  `json:"category,omitempty"` // Category path or ID, subcategories included
+	Store       string  `json:"store,omitempty"`
+	Tag         string  `json:"tag,omitempty"`
+	HouseholdID string  `json:"household_id,omitempty"` // Adds up its receipts instead of the creator's; ignored on updates
+}
+
+// BudgetResponse represents a budget with its progress in a period
+type BudgetResponse struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	Period       string  `json:"period"`
+	Amount       float64 `json:"amount"`
+	CategoryID   string  `json:"category_id,omitempty"`
+	CategoryPath string  `json:"category_path,omitempty"`
+	Store        string  `json:"store,omitempty"`
+	Tag          string  `json:"tag,omitempty"`
+	Owner        string  `json:"owner,omitempty"`
+	HouseholdID  string  `json:"household_id,omitempty"`
+	PeriodStart  string  `json:"period_start"` // YYYY-MM-DD
+	PeriodEnd    string  `json:"period_end"`   // YYYY-MM-DD, last day of the period
+	Spent        float64 `json:"spent"`
+	Remaining    float64 `json:"remaining"`  // Negative once exceeded
+	Percent      float64 `json:"percent"`    // Spent, in percent of the amount
+	Projected    float64 `json:"projected"`  // Spent by the end of the period at the current pace
+	CreatedAt    string  `json:"created_at"` // RFC 3339
+	UpdatedAt    string  `json:"updated_at"` // RFC 3339
+}
+
+// BudgetAlert is the data of a budget.warning or budget.exceeded notification
+type BudgetAlert struct {
+	Budget    BudgetResponse `json:"budget"`
+	Threshold int            `json:"threshold"`  // Percent crossed: 80 or 100
+	ReceiptID string         `json:"receipt_id"` // Receipt that crossed it
+}
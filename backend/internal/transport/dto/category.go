@@ -0,0 +1,73 @@
+package dto
+
+// CategoryResponse represents a category of the taxonomy
+type CategoryResponse struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	ParentID string `json:"parent_id,omitempty"` // Empty for top-level categories
+	Path     string `json:"path"`                // e.g. "Groceries/Dairy"
+}
+
+// CategoryRequest represents a new or edited category
+type CategoryRequest struct {
+	Name     string `json:"name"`
+	ParentID string `json:"parent_id"` // Empty for a top-level category
+}
+
+// CategoryRuleRequest represents a new category rule: either a pattern,
+// optionally limited to a store, or a store product
+type CategoryRuleRequest struct {
+	CategoryID string `json:"category_id"`
+	Pattern    string `json:"pattern"`    // Case-insensitive regular expression matched against product names
+	StoreName  string `json:"store_name"` // Limits the pattern to a store
+	ProductID  string `json:"product_id"` // Store product the rule is for, instead of a pattern
+	Priority   int    `json:"priority"`   // Higher wins when several rules match
+}
+
+// CategoryRuleResponse represents a category rule
+type CategoryRuleResponse struct {
+	ID         string `json:"id"`
+	CategoryID string `json:"category_id"`
+	Pattern    string `json:"pattern,omitempty"`
+	StoreName  string `json:"store_name,omitempty"`
+	ProductID  string `json:"product_id,omitempty"`
+	Priority   int    `json:"priority"`
+	CreatedAt  string `json:"created_at"` // RFC 3339
+}
+
+// RecategorizeRequest represents a bulk recategorisation. Empty fields are ignored.
+type RecategorizeRequest struct {
+	StoreName         string `json:"store_name"`
+	OnlyUncategorized bool   `json:"only_uncategorized"` // Leave products that have a category alone
+	AI                bool   `json:"ai"`                 // Ask the AI for the products no rule matches
+}
+
+// RecategorizeResult represents the outcome of a bulk recategorisation
+type RecategorizeResult struct {
+	ByRules      int64 `json:"by_rules"`     // Products categorised by a rule
+	ByAI         int64 `json:"by_ai"`        // Products categorised by the AI
+	Unclassified int   `json:"unclassified"` // Products the AI could not place
+}
+
+// ProductCategoryRequest represents a manual category assignment of products.
+// An empty category ID removes the manual category, and the rules apply again.
+type ProductCategoryRequest struct {
+	ProductIDs []string `json:"product_ids"`
+	CategoryID string   `json:"category_id"`
+}
+
+// ProductCategoryResult represents the outcome of a manual category assignment
+type ProductCategoryResult struct {
+	Updated int64 `json:"updated"`
+}
+
+// CategorySpendingResponse represents what was spent on a category
+type CategorySpendingResponse struct {
+	CategoryID string  `json:"category_id"` // Empty for uncategorised items
+	Name       string  `json:"name"`
+	ParentID   string  `json:"parent_id,omitempty"`
+	Path       string  `json:"path"`
+	Own        float64 `json:"own"`        // Spent on items of the category itself
+	Total      float64 `json:"total"`      // Including its subcategories
+	ItemCount  int     `json:"item_count"` // Including its subcategories
+}
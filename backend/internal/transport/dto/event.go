@@ -0,0 +1,34 @@
+package dto
+
+// Stages of processing an upload, in order, reported by processing.progress
+// events and by streamed uploads. Escalated extractions repeat the
+// extraction_started, items_parsed and validated stages, and parsed text
+// receipts skip extraction_started.
+const (
+	StageUploaded          = "uploaded"
+	StageStoreIdentified   = "store_identified"
+	StageExtractionStarted = "extraction_started"
+	StageItemsParsed       = "items_parsed"
+	StageValidated         = "validated"
+
+	// Final stages
+	StageSaved     = "saved"
+	StageProcessed = "processed" // Extracted but not saved, without a database
+	StageDuplicate = "duplicate"
+	StageFailed    = "failed"
+)
+
+// ProcessingProgress is a stage of processing an upload
+type ProcessingProgress struct {
+	File        string           `json:"file"` // Name of the uploaded file
+	Stage       string           `json:"stage"`
+	Store       string           `json:"store,omitempty"`
+	Attempt     int              `json:"attempt,omitempty"` // Extraction attempt, from 1
+	Items       int              `json:"items,omitempty"`
+	Valid       *bool            `json:"valid,omitempty"` // Validation result
+	Issues      []string         `json:"issues,omitempty"`
+	ReceiptID   string           `json:"receipt_id,omitempty"`   // Once saved
+	DuplicateOf string           `json:"duplicate_of,omitempty"` // ID of the existing receipt, for duplicates
+	Error       string           `json:"error,omitempty"`
+	Receipt     *ReceiptResponse `json:"receipt,omitempty"` // Final stage of a streamed upload
+}
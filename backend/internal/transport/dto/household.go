@@ -0,0 +1,51 @@
+package dto
+
+// HouseholdRequest represents a new or renamed household
+type HouseholdRequest struct {
+	Name string `json:"name"`
+}
+
+// HouseholdResponse represents a household, with the role of the user and,
+// when retrieved on its own, its members and pending invitations
+type HouseholdResponse struct {
+	ID          string                        `json:"id"`
+	Name        string                        `json:"name"`
+	Role        string                        `json:"role"`
+	Members     []HouseholdMemberResponse     `json:"members,omitempty"`
+	Invitations []HouseholdInvitationResponse `json:"invitations,omitempty"`
+	CreatedAt   string                        `json:"created_at"` // RFC 3339
+}
+
+// HouseholdMemberRequest represents the new role of a member
+type HouseholdMemberRequest struct {
+	Role string `json:"role"` // owner, editor or viewer
+}
+
+// HouseholdMemberResponse represents a member of a household
+type HouseholdMemberResponse struct {
+	Member   string `json:"member"`
+	Role     string `json:"role"`
+	JoinedAt string `json:"joined_at"` // RFC 3339
+}
+
+// HouseholdInvitationRequest represents an invitation of a user to a household
+type HouseholdInvitationRequest struct {
+	Member string `json:"member"` // Owner of the user's API tokens
+	Role   string `json:"role"`   // owner, editor or viewer (the default)
+}
+
+// HouseholdInvitationResponse represents a pending invitation
+type HouseholdInvitationResponse struct {
+	ID            string `json:"id"`
+	HouseholdID   string `json:"household_id"`
+	HouseholdName string `json:"household_name"`
+	Member        string `json:"member"`
+	Role          string `json:"role"`
+	InvitedBy     string `json:"invited_by"`
+	CreatedAt     string `json:"created_at"` // RFC 3339
+}
+
+// MoveReceiptRequest represents the household a receipt moves to
+type MoveReceiptRequest struct {
+	HouseholdID string `json:"household_id"` // Empty to take it out of any household
+}
@@ -1,39 +1,106 @@
 package dto
 
+import "github.com/vieitesss/ticketer/internal/models"
+
 // StoreResponse represents a store in the API response
 type StoreResponse struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	TaxID string `json:"tax_id,omitempty"`
 }
 
 // ItemResponse represents an item in the API response
 type ItemResponse struct {
-	ID          string  `json:"id"`
-	ProductID   string  `json:"product_id"`
-	ProductName string  `json:"product_name"`
-	Quantity    float64 `json:"quantity"`
-	PricePaid   float64 `json:"price_paid"`
-	Subtotal    float64 `json:"subtotal"` // quantity * price_paid
+	ID          string   `json:"id"`
+	ProductID   string   `json:"product_id"`
+	ProductName string   `json:"product_name"`
+	Quantity    float64  `json:"quantity"`
+	PricePaid   float64  `json:"price_paid"`
+	Subtotal    float64  `json:"subtotal"`           // quantity * price_paid
+	TaxRate     *float64 `json:"tax_rate,omitempty"` // VAT rate in percent, if known
+
+	CategoryID     string   `json:"category_id,omitempty"`
+	Category       string   `json:"category,omitempty"`        // Category path, e.g. "Groceries/Dairy"
+	CategorySource string   `json:"category_source,omitempty"` // rule, ai, automation or manual
+	Tags           []string `json:"tags"`
+	Notes          string   `json:"notes,omitempty"`
 }
 
 // ReceiptResponse represents a receipt in the API response with calculated fields (for detail view)
 type ReceiptResponse struct {
-	ID          string         `json:"id"`
-	Store       StoreResponse  `json:"store"`
-	BoughtDate  string         `json:"bought_date"` // ISO 8601: YYYY-MM-DD
-	Items       []ItemResponse `json:"items"`
-	Subtotal    float64        `json:"subtotal"`
-	Discounts   float64        `json:"discounts"`
-	TotalAmount float64        `json:"total_amount"`
+	ID             string         `json:"id"`
+	Store          StoreResponse  `json:"store"`
+	BoughtDate     string         `json:"bought_date"` // ISO 8601: YYYY-MM-DD
+	Items          []ItemResponse `json:"items"`
+	Subtotal       float64        `json:"subtotal"`
+	Discounts      float64        `json:"discounts"`
+	TotalAmount    float64        `json:"total_amount"`
+	Owner          string         `json:"owner,omitempty"`
+	DocumentNumber string         `json:"document_number,omitempty"` // Invoice number, for receipts imported from e-invoices
+	Tags           []string       `json:"tags"`
+	Notes          string         `json:"notes,omitempty"`
+	Business       bool           `json:"business"`               // Flagged as a business expense
+	HouseholdID    string         `json:"household_id,omitempty"` // Household it is shared in
 }
 
 // ReceiptListItem represents a receipt in list views (for left sidebar)
 type ReceiptListItem struct {
-	ID          string  `json:"id"`
-	StoreName   string  `json:"store_name"`
-	ItemCount   int     `json:"item_count"`
-	BoughtDate  string  `json:"bought_date"` // ISO 8601: YYYY-MM-DD
-	TotalAmount float64 `json:"total_amount"`
+	ID          string   `json:"id"`
+	StoreName   string   `json:"store_name"`
+	ItemCount   int      `json:"item_count"`
+	BoughtDate  string   `json:"bought_date"` // ISO 8601: YYYY-MM-DD
+	TotalAmount float64  `json:"total_amount"`
+	Categories  []string `json:"categories"` // Category paths of its items
+	Tags        []string `json:"tags"`
+}
+
+// CreateItemRequest represents an item of a manually entered receipt
+type CreateItemRequest struct {
+	ProductName string   `json:"product_name"`
+	Quantity    float64  `json:"quantity"`
+	PricePaid   float64  `json:"price_paid"`
+	Tags        []string `json:"tags,omitempty"`
+	Notes       string   `json:"notes,omitempty"`
+}
+
+// CreateReceiptRequest represents a manually entered receipt
+type CreateReceiptRequest struct {
+	StoreName   string              `json:"store_name"`
+	BoughtDate  string              `json:"bought_date"` // ISO 8601: YYYY-MM-DD
+	Items       []CreateItemRequest `json:"items"`
+	Discounts   float64             `json:"discounts"`
+	Total       *float64            `json:"total,omitempty"` // Optional, checked against the items
+	Tags        []string            `json:"tags,omitempty"`
+	Notes       string              `json:"notes,omitempty"`
+	Business    bool                `json:"business,omitempty"`
+	Owner       string              `json:"owner,omitempty"`        // Only admins may create receipts for someone else
+	HouseholdID string              `json:"household_id,omitempty"` // By default the only household the owner edits in
+}
+
+// ToModel converts the request to a receipt model
+func (r *CreateReceiptRequest) ToModel() *models.Receipt {
+	items := make([]models.Item, len(r.Items))
+	for i, item := range r.Items {
+		items[i] = models.Item{
+			Name:     item.ProductName,
+			Quantity: item.Quantity,
+			Price:    item.PricePaid,
+			Tags:     item.Tags,
+			Notes:    item.Notes,
+		}
+	}
+
+	return &models.Receipt{
+		StoreName:   r.StoreName,
+		BoughtDate:  r.BoughtDate,
+		Items:       items,
+		Discounts:   r.Discounts,
+		Total:       r.Total,
+		Tags:        r.Tags,
+		Notes:       r.Notes,
+		Business:    r.Business,
+		HouseholdID: r.HouseholdID,
+	}
 }
 
 // UpdateItemRequest represents the request to update an item
@@ -41,3 +108,121 @@ type UpdateItemRequest struct {
 	Quantity  float64 `json:"quantity"`
 	PricePaid float64 `json:"price_paid"`
 }
+
+// ExtractionStageResponse represents a single model call of an extraction
+type ExtractionStageResponse struct {
+	Model         string `json:"model"`
+	PromptVersion string `json:"prompt_version"`
+	LatencyMs     int64  `json:"latency_ms"`
+	PromptTokens  int32  `json:"prompt_tokens"`
+	OutputTokens  int32  `json:"output_tokens"`
+}
+
+// ExtractionResponse represents the provenance of a receipt's extraction
+type ExtractionResponse struct {
+	ID          string                  `json:"id"`
+	ReceiptID   string                  `json:"receipt_id"`
+	StoreAnswer string                  `json:"store_answer"`
+	StoreStage  ExtractionStageResponse `json:"store_stage"`
+	ItemsStage  ExtractionStageResponse `json:"items_stage"`
+	TotalTokens int32                   `json:"total_tokens"`
+	Attempt     int                     `json:"attempt"`
+	Strategy    string                  `json:"strategy"`
+	Issues      []string                `json:"issues"`
+	Selected    bool                    `json:"selected"`
+	RawResponse string                  `json:"raw_response"`
+	Error       string                  `json:"error,omitempty"`
+	CreatedAt   string                  `json:"created_at"` // RFC 3339
+}
+
+// ReprocessingResponse represents a proposed re-extraction of a receipt awaiting review
+type ReprocessingResponse struct {
+	ID        string             `json:"id"`
+	ReceiptID string             `json:"receipt_id"`
+	Status    string             `json:"status"` // pending, accepted or rejected
+	Diff      models.ReceiptDiff `json:"diff"`
+	Proposed  ReceiptResponse    `json:"proposed"`
+	CreatedAt string             `json:"created_at"` // RFC 3339
+}
+
+// ReprocessRequest represents the filters of a bulk reprocess. Empty fields are ignored.
+type ReprocessRequest struct {
+	StoreName string `json:"store_name"`
+	StartDate string `json:"start_date"` // ISO 8601: YYYY-MM-DD
+	EndDate   string `json:"end_date"`   // ISO 8601: YYYY-MM-DD
+}
+
+// ReprocessResult represents the outcome of reprocessing one receipt in a bulk reprocess
+type ReprocessResult struct {
+	ReceiptID    string                `json:"receipt_id"`
+	Reprocessing *ReprocessingResponse `json:"reprocessing,omitempty"`
+	Error        string                `json:"error,omitempty"`
+}
+
+// BatchFileResult represents the outcome of one file of a batch upload, streamed as a JSON line
+type BatchFileResult struct {
+	File        string `json:"file"`
+	Status      string `json:"status"` // saved, duplicate, processed (no database) or error
+	ReceiptID   string `json:"receipt_id,omitempty"`
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// BatchSummary is the last JSON line of a batch upload
+type BatchSummary struct {
+	Done       bool `json:"done"`
+	Total      int  `json:"total"`
+	Saved      int  `json:"saved"`
+	Duplicates int  `json:"duplicates"`
+	Failed     int  `json:"failed"`
+}
+
+// InvoiceImportResult represents the outcome of importing one invoice of an e-invoice file
+type InvoiceImportResult struct {
+	DocumentNumber string           `json:"document_number"`
+	Status         string           `json:"status"` // saved, duplicate or error
+	Receipt        *ReceiptResponse `json:"receipt,omitempty"`
+	DuplicateOf    string           `json:"duplicate_of,omitempty"`
+	Error          string           `json:"error,omitempty"`
+}
+
+// ImportReport represents the outcome of importing one CSV or backup file
+type ImportReport struct {
+	File       string            `json:"file"`
+	Format     string            `json:"format"` // csv or json
+	DryRun     bool              `json:"dry_run"`
+	Committed  bool              `json:"committed"` // Whether the receipts were saved; false on dry runs and errors
+	Total      int               `json:"total"`
+	Imported   int               `json:"imported"`
+	Duplicates int               `json:"duplicates"`
+	Invalid    int               `json:"invalid"`
+	Error      string            `json:"error,omitempty"`
+	Receipts   []ImportedReceipt `json:"receipts"`
+}
+
+// ImportedReceipt represents the outcome of one receipt of an imported file
+type ImportedReceipt struct {
+	Index       int      `json:"index"` // Position in the file, from 1
+	Store       string   `json:"store"`
+	BoughtDate  string   `json:"bought_date"`
+	Items       int      `json:"items"`
+	Status      string   `json:"status"` // imported, duplicate or invalid
+	ReceiptID   string   `json:"receipt_id,omitempty"`
+	DuplicateOf string   `json:"duplicate_of,omitempty"`
+	Issues      []string `json:"issues,omitempty"`
+}
+
+// UpdateReceiptRequest represents an edit of the tags, notes or business flag
+// of a receipt. Omitted fields are left as they are; tags replace the whole set.
+type UpdateReceiptRequest struct {
+	Tags     *[]string `json:"tags,omitempty"`
+	Notes    *string   `json:"notes,omitempty"`
+	Business *bool     `json:"business,omitempty"`
+}
+
+// UpdateItemAnnotationsRequest represents an edit of the tags or notes of an
+// item. Omitted fields are left as they are; tags replace the whole set.
+type UpdateItemAnnotationsRequest struct {
+	Tags  *[]string `json:"tags,omitempty"`
+	Notes *string   `json:"notes,omitempty"`
+}
@@ -0,0 +1,51 @@
+package dto
+
+// RuleActionDTO represents an action of an automation rule
+type RuleActionDTO struct {
+	Type  string `json:"type"`  // tag, category, note or business
+	Value string `json:"value"` // Tag, category path or ID, note text, or "true"/"false" for business
+}
+
+// RuleRequest represents a new or edited automation rule
+type RuleRequest struct {
+	Name        string          `json:"name"`
+	Scope       string          `json:"scope"`     // receipt (the default) or item
+	Condition   string          `json:"condition"` // e.g. store == "LIDL" && total > 80
+	Actions     []RuleActionDTO `json:"actions"`
+	Enabled     *bool           `json:"enabled,omitempty"`      // Defaults to true
+	Priority    int             `json:"priority"`               // Higher runs first
+	HouseholdID string          `json:"household_id,omitempty"` // Runs on its receipts instead of the creator's; ignored on updates
+}
+
+// RuleResponse represents an automation rule. Category actions hold the category ID.
+type RuleResponse struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Scope       string          `json:"scope"`
+	Condition   string          `json:"condition"`
+	Actions     []RuleActionDTO `json:"actions"`
+	Enabled     bool            `json:"enabled"`
+	Priority    int             `json:"priority"`
+	Owner       string          `json:"owner,omitempty"`
+	HouseholdID string          `json:"household_id,omitempty"`
+	CreatedAt   string          `json:"created_at"` // RFC 3339
+	UpdatedAt   string          `json:"updated_at"` // RFC 3339
+}
+
+// RulePreviewResponse is what a rule would do to past receipts
+type RulePreviewResponse struct {
+	Scanned  int                `json:"scanned"` // Receipts the rule was evaluated against
+	Matched  int                `json:"matched"`
+	Receipts []RulePreviewMatch `json:"receipts"`
+	Errors   []string           `json:"errors,omitempty"` // Evaluation errors, by receipt
+}
+
+// RulePreviewMatch is a receipt a rule matches
+type RulePreviewMatch struct {
+	ReceiptID    string   `json:"receipt_id"`
+	StoreName    string   `json:"store_name"`
+	BoughtDate   string   `json:"bought_date"`
+	TotalAmount  float64  `json:"total_amount"`
+	MatchedItems []string `json:"matched_items,omitempty"` // Names of the matching items, for item rules
+	Changes      []string `json:"changes"`                 // What the rule would change, e.g. `tag "monthly-shop"`
+}
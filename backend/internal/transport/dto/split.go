@@ -0,0 +1,112 @@
+package dto
+
+// ParticipantRequest represents a new participant
+type ParticipantRequest struct {
+	Name        string `json:"name"`
+	HouseholdID string `json:"household_id,omitempty"` // Shared with its members instead of kept by the creator
+}
+
+// ParticipantResponse represents a participant
+type ParticipantResponse struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Owner       string `json:"owner,omitempty"`
+	HouseholdID string `json:"household_id,omitempty"`
+	CreatedAt   string `json:"created_at"` // RFC 3339
+}
+
+// ReceiptSplitRequest replaces how a receipt is split. Participants are
+// referred to by name or ID. No participants stops splitting the receipt.
+type ReceiptSplitRequest struct {
+	Participants []string           `json:"participants"`
+	PaidBy       string             `json:"paid_by"` // Added to the participants when missing
+	Items        []ItemSplitRequest `json:"items,omitempty"`
+}
+
+// ItemSplitRequest assigns fractions of an item to participants of its
+// receipt. What is not assigned is shared equally by every participant.
+type ItemSplitRequest struct {
+	ItemID string             `json:"item_id,omitempty"` // Only within a receipt split
+	Shares map[string]float64 `json:"shares"`            // Fraction, from 0 to 1, per participant
+}
+
+// ReceiptSplitResponse represents how a receipt is split
+type ReceiptSplitResponse struct {
+	ReceiptID    string              `json:"receipt_id"`
+	Store        string              `json:"store"`
+	BoughtDate   string              `json:"bought_date"`
+	Total        float64             `json:"total"` // Items minus discounts
+	Discounts    float64             `json:"discounts"`
+	PaidBy       string              `json:"paid_by,omitempty"`
+	Participants []ParticipantShare  `json:"participants"`
+	Items        []SplitItemResponse `json:"items"`
+}
+
+// ParticipantShare is what a participant owes of a receipt
+type ParticipantShare struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Items    float64 `json:"items"`    // Share of the items
+	Discount float64 `json:"discount"` // Share of the receipt discounts
+	Amount   float64 `json:"amount"`   // Items minus discount
+}
+
+// SplitItemResponse represents an item with the fractions assigned to each participant
+type SplitItemResponse struct {
+	ItemID     string             `json:"item_id"`
+	Name       string             `json:"name"`
+	Total      float64            `json:"total"`
+	Shares     map[string]float64 `json:"shares"`     // Fraction per participant name
+	Unassigned float64            `json:"unassigned"` // Fraction shared equally
+}
+
+// SplitBalances are the running balances of every participant and the
+// payments that would settle them
+type SplitBalances struct {
+	Balances []ParticipantBalance `json:"balances"`
+	Debts    []Debt               `json:"debts"`
+}
+
+// ParticipantBalance is what a participant is owed (positive) or owes (negative)
+type ParticipantBalance struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	Paid    float64 `json:"paid"`    // Paid for others, plus settlements paid
+	Owed    float64 `json:"owed"`    // Owed to others, plus settlements received
+	Balance float64 `json:"balance"` // Paid minus owed
+}
+
+// Debt is a payment that settles part of the balances
+type Debt struct {
+	From   string  `json:"from"`
+	FromID string  `json:"from_id"`
+	To     string  `json:"to"`
+	ToID   string  `json:"to_id"`
+	Amount float64 `json:"amount"`
+}
+
+// SettlementRequest records a payment between participants, referred to by
+// name or ID
+type SettlementRequest struct {
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	Amount      float64 `json:"amount"`
+	Date        string  `json:"date,omitempty"` // YYYY-MM-DD, today by default
+	Note        string  `json:"note,omitempty"`
+	HouseholdID string  `json:"household_id,omitempty"` // Shared with its members instead of kept by the creator
+}
+
+// SettlementResponse represents a payment between participants
+type SettlementResponse struct {
+	ID          string  `json:"id"`
+	From        string  `json:"from"`
+	FromID      string  `json:"from_id"`
+	To          string  `json:"to"`
+	ToID        string  `json:"to_id"`
+	Amount      float64 `json:"amount"`
+	Date        string  `json:"date"`
+	Note        string  `json:"note,omitempty"`
+	Owner       string  `json:"owner,omitempty"`
+	HouseholdID string  `json:"household_id,omitempty"`
+	CreatedAt   string  `json:"created_at"` // RFC 3339
+}
@@ -0,0 +1,35 @@
+package dto
+
+// TagResponse represents a tag in use
+type TagResponse struct {
+	Tag      string `json:"tag"`
+	Receipts int    `json:"receipts"` // Receipts with the tag
+	Items    int    `json:"items"`    // Items with the tag
+}
+
+// RenameTagRequest represents the new name of a tag. Renaming into a tag in
+// use merges both.
+type RenameTagRequest struct {
+	Name string `json:"name"`
+}
+
+// MergeTagsRequest represents merging tags into one
+type MergeTagsRequest struct {
+	Tags []string `json:"tags"`
+	Into string   `json:"into"`
+}
+
+// TagChangesResponse counts what a tag rename, merge or deletion changed
+type TagChangesResponse struct {
+	Receipts int64 `json:"receipts"`
+	Items    int64 `json:"items"`
+	Rules    int64 `json:"rules"` // Rules whose tag actions were updated
+}
+
+// TagSpendingResponse represents what was spent on the items of a tag
+type TagSpendingResponse struct {
+	Tag      string  `json:"tag"`
+	Receipts int     `json:"receipts"`
+	Items    int     `json:"items"`
+	Total    float64 `json:"total"` // Line totals, before receipt discounts
+}
@@ -0,0 +1,24 @@
+package dto
+
+// APITokenRequest represents a new API token
+type APITokenRequest struct {
+	Name      string   `json:"name"`
+	Owner     string   `json:"owner"`                // User the token is for
+	Scopes    []string `json:"scopes"`               // read, upload, write and/or admin
+	ExpiresAt string   `json:"expires_at,omitempty"` // YYYY-MM-DD or RFC 3339, never expires when empty
+}
+
+// APITokenResponse represents an API token. The token itself is only
+// returned when it is created.
+type APITokenResponse struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Owner      string   `json:"owner"`
+	Prefix     string   `json:"prefix"`
+	Token      string   `json:"token,omitempty"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`   // RFC 3339
+	LastUsedAt string   `json:"last_used_at,omitempty"` // RFC 3339
+	CreatedAt  string   `json:"created_at"`             // RFC 3339
+	CreatedBy  string   `json:"created_by,omitempty"`   // User whose token created it
+}
@@ -0,0 +1,47 @@
+package dto
+
+import "encoding/json"
+
+// WebhookRequest represents a new or edited webhook
+type WebhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`           // Event names, or "*" for all
+	Secret      string   `json:"secret,omitempty"` // Generated when creating without one; kept when editing without one
+	Description string   `json:"description,omitempty"`
+	Enabled     *bool    `json:"enabled,omitempty"` // Defaults to true
+}
+
+// WebhookResponse represents a webhook. The secret is only returned when the
+// webhook is created.
+type WebhookResponse struct {
+	ID          string   `json:"id"`
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Secret      string   `json:"secret,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Enabled     bool     `json:"enabled"`
+	CreatedAt   string   `json:"created_at"` // RFC 3339
+	UpdatedAt   string   `json:"updated_at"` // RFC 3339
+}
+
+// WebhookDeliveryResponse represents an entry of the delivery log of a webhook
+type WebhookDeliveryResponse struct {
+	ID             string          `json:"id"`
+	EventID        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"` // pending, delivered or failed
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  string          `json:"next_attempt_at,omitempty"` // RFC 3339, while pending
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      string          `json:"created_at"`             // RFC 3339
+	DeliveredAt    string          `json:"delivered_at,omitempty"` // RFC 3339
+}
+
+// ExtractionFailedEvent is the data of an extraction.failed event
+type ExtractionFailedEvent struct {
+	File  string `json:"file"` // Name of the uploaded file
+	Owner string `json:"owner,omitempty"`
+	Error string `json:"error"`
+}
@@ -0,0 +1,228 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/config"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/services/parser"
+)
+
+// Ingester imports the receipts attached to, or contained in, the messages of
+// a Maildir. Each sender address maps to the user the receipts belong to, and
+// messages from unknown senders are ignored. When a reply relay is
+// configured, the sender gets the outcome of every document back.
+type Ingester struct {
+	receiptService *services.ReceiptService
+	maildir        maildir
+	pollInterval   time.Duration
+	senders        map[string]string
+	replier        *replier
+	smtp           *smtpServer
+
+	// delivered wakes the poller up when the SMTP listener delivers a message
+	delivered chan struct{}
+}
+
+func NewIngester(receiptService *services.ReceiptService, cfg *config.Config) *Ingester {
+	i := &Ingester{
+		receiptService: receiptService,
+		maildir:        maildir{dir: cfg.EmailMaildir},
+		pollInterval:   cfg.EmailPollInterval,
+		senders:        cfg.EmailSenders,
+		replier:        newReplier(cfg),
+		delivered:      make(chan struct{}, 1),
+	}
+
+	if cfg.EmailSMTPAddr != "" {
+		i.smtp = &smtpServer{
+			addr:     cfg.EmailSMTPAddr,
+			hostname: defaultHostname(),
+			maxSize:  int64(cfg.EmailMaxSizeMB) << 20,
+			senders:  cfg.EmailSenders,
+			deliver:  i.deliver,
+		}
+	}
+
+	return i
+}
+
+// Run polls the Maildir, and serves SMTP if enabled, until ctx is cancelled
+func (i *Ingester) Run(ctx context.Context) error {
+	if err := i.maildir.init(); err != nil {
+		return err
+	}
+
+	if i.replier == nil {
+		log.Warn("No reply relay configured, senders will not be told the outcome")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	smtpErr := make(chan error, 1)
+	if i.smtp != nil {
+		go func() { smtpErr <- i.smtp.serve(ctx) }()
+	}
+
+	log.Info("Reading emails", "maildir", i.maildir.dir, "poll_interval", i.pollInterval, "senders", len(i.senders))
+
+	ticker := time.NewTicker(i.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		i.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Info("Email ingester stopped")
+			return nil
+		case err := <-smtpErr:
+			return err
+		case <-i.delivered:
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver stores a message received over SMTP and wakes the poller up
+func (i *Ingester) deliver(data []byte) error {
+	if err := i.maildir.deliver(data); err != nil {
+		return err
+	}
+
+	select {
+	case i.delivered <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// poll processes the unprocessed messages of the Maildir
+func (i *Ingester) poll(ctx context.Context) {
+	names, err := i.maildir.pending()
+	if err != nil {
+		log.Error("Failed to list emails", "error", err)
+		return
+	}
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return
+		}
+		i.handle(ctx, name)
+	}
+}
+
+// handle processes one message and marks it as seen, unless processing was
+// interrupted, in which case it is picked up again on the next start
+func (i *Ingester) handle(ctx context.Context, name string) {
+	raw, err := i.maildir.read(name)
+	if err != nil {
+		log.Error("Failed to read email", "name", name, "error", err)
+		return
+	}
+
+	msg, err := parser.ParseEmail(bytes.NewReader(raw))
+	if err != nil {
+		log.Warn("Ignoring unreadable email", "name", name, "error", err)
+		i.markSeen(name)
+		return
+	}
+
+	user, ok := i.senders[msg.From]
+	if !ok {
+		log.Warn("Ignoring email from unknown sender", "from", msg.From, "subject", msg.Subject)
+		i.markSeen(name)
+		return
+	}
+
+	log.Info("Processing email", "from", msg.From, "user", user, "subject", msg.Subject)
+
+	docs := documents(msg, raw)
+	outcomes := []outcome{}
+	for _, doc := range docs {
+		o := i.importDocument(ctx, doc, user)
+		if ctx.Err() != nil {
+			return
+		}
+		outcomes = append(outcomes, o)
+	}
+	if len(docs) == 0 {
+		outcomes = append(outcomes, outcome{
+			Name: "message",
+			Err:  errors.New("no receipt found: attach an image or PDF, or forward the e-receipt"),
+		})
+	}
+
+	i.reply(msg, outcomes)
+	i.markSeen(name)
+}
+
+// importDocument saves a document to the uploads directory and processes it
+func (i *Ingester) importDocument(ctx context.Context, doc document, user string) outcome {
+	o := outcome{Name: doc.Name}
+
+	if !i.receiptService.CanProcess(doc.Name) {
+		o.Err = services.ErrNoExtractor
+		return o
+	}
+
+	uploadPath, err := i.receiptService.SaveUpload(bytes.NewReader(doc.Data), filepath.Ext(doc.Name))
+	if err != nil {
+		o.Err = err
+		return o
+	}
+
+	result, err := i.receiptService.ProcessReceiptFileAs(ctx, uploadPath, user)
+	if err != nil {
+		i.receiptService.DiscardUpload(uploadPath)
+		log.Error("Failed to process email document", "name", doc.Name, "error", err)
+		o.Err = err
+		return o
+	}
+
+	// Only keep the original image of saved receipts
+	if result.Receipt.ID == "" {
+		i.receiptService.DiscardUpload(uploadPath)
+	}
+
+	switch {
+	case result.DuplicateOf() != "":
+		o.DuplicateOf = result.DuplicateOf()
+	case result.SaveErr != nil:
+		o.Err = fmt.Errorf("failed to save receipt: %w", result.SaveErr)
+	default:
+		o.Receipt = result.Receipt
+		log.Info("Receipt imported from email", "name", doc.Name, "receipt_id", result.Receipt.ID, "user", user)
+	}
+
+	return o
+}
+
+// reply sends the outcome to the sender, if a relay is configured
+func (i *Ingester) reply(msg *parser.Email, outcomes []outcome) {
+	for _, o := range outcomes {
+		log.Info("Email document processed", "from", msg.From, "outcome", o.String())
+	}
+
+	if i.replier == nil || msg.AutoSubmitted {
+		return
+	}
+	if err := i.replier.send(msg, outcomes); err != nil {
+		log.Error("Failed to reply to email", "to", msg.From, "error", err)
+	}
+}
+
+// markSeen moves a handled message out of new/
+func (i *Ingester) markSeen(name string) {
+	if err := i.maildir.markSeen(name); err != nil {
+		log.Error("Failed to mark email as seen", "name", name, "error", err)
+	}
+}
@@ -0,0 +1,122 @@
+package email
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vieitesss/ticketer/internal/config"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/services/parser"
+)
+
+// newTestIngester creates an ingester of a new Maildir that imports through a
+// receipt service without AI or database and replies through relay, if any
+func newTestIngester(t *testing.T, relay string) *Ingester {
+	t.Helper()
+
+	cfg := &config.Config{
+		UploadsDir:     t.TempDir(),
+		EmailMaildir:   t.TempDir(),
+		EmailSenders:   map[string]string{"ticket@mercadona.es": "ana", "ana@example.com": "ana"},
+		EmailReplySMTP: relay,
+		EmailReplyFrom: "receipts@ticketer.test",
+	}
+	service := services.NewReceiptService(nil, nil, nil, services.NewBudgetService(nil, nil, nil, cfg), cfg)
+	i := NewIngester(service, cfg)
+	if err := i.maildir.init(); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestIngesterHandle(t *testing.T) {
+	relay, replies := startSMTP(t, map[string]string{"receipts@ticketer.test": ""})
+	i := newTestIngester(t, relay)
+
+	receipt, err := os.ReadFile(filepath.Join("..", "..", "services", "parser", "testdata", "mercadona.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := [][]byte{
+		receipt,
+		[]byte("From: spam@example.com\nSubject: Offer\n\nBuy now\n"),
+		[]byte("From: ana@example.com\nSubject: Nothing\nMessage-ID: <empty@example.com>\n\n \n"),
+	}
+	for _, message := range messages {
+		if err := i.maildir.deliver(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	i.poll(context.Background())
+
+	// Every message is handled, whether it had a receipt or not
+	if pending, _ := i.maildir.pending(); len(pending) != 0 {
+		t.Errorf("pending = %q, want every message seen", pending)
+	}
+	if seen, _ := os.ReadDir(filepath.Join(i.maildir.dir, "cur")); len(seen) != 3 {
+		t.Errorf("%d messages in cur/, want 3", len(seen))
+	}
+
+	// The known senders get the outcome of their messages, in order
+	want := []struct {
+		to      string
+		subject string
+		outcome string
+	}{
+		{"ticket@mercadona.es", "Re: Tu ticket de compra en Mercadona", "- message.eml: saved as receipt  (MERCADONA, 2024-03-15, 2 items, 5.83)"},
+		{"ana@example.com", "Re: Nothing", "- message: failed: no receipt found"},
+	}
+	for _, w := range want {
+		reply := strings.ReplaceAll(string(<-replies), "\n", "\r\n")
+		if !strings.Contains(reply, "\r\nTo: "+w.to+"\r\n") || !strings.Contains(reply, "\r\nSubject: "+w.subject+"\r\n") {
+			t.Errorf("reply = %q, want one to %s about %q", reply, w.to, w.subject)
+		}
+		if !strings.Contains(reply, "\r\nAuto-Submitted: auto-replied\r\n") || !strings.Contains(reply, w.outcome) {
+			t.Errorf("reply = %q, want an automatic reply with %q", reply, w.outcome)
+		}
+	}
+	if len(replies) != 0 {
+		t.Error("an unknown sender got a reply")
+	}
+}
+
+func TestDocuments(t *testing.T) {
+	raw := []byte("raw message")
+
+	tests := []struct {
+		name string
+		msg  parser.Email
+		want []string
+	}{
+		{
+			"supported attachments, not the body",
+			parser.Email{
+				Attachments: []parser.Attachment{{Name: "ticket.pdf"}, {Name: "logo.gif"}, {Name: "scan.JPG"}},
+				HTMLBodies:  []string{"<p>Your ticket</p>"},
+			},
+			[]string{"ticket.pdf", "scan.JPG"},
+		},
+		{
+			"the message without supported attachments",
+			parser.Email{Attachments: []parser.Attachment{{Name: "logo.gif"}}, TextBodies: []string{"Total 5,00"}},
+			[]string{"message.eml"},
+		},
+		{"nothing", parser.Email{TextBodies: []string{" \n"}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, doc := range documents(&tt.msg, raw) {
+				got = append(got, doc.Name)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("documents = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package email
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maildir is a Maildir spool: messages are written to tmp/ and moved to new/
+// once complete, and moved to cur/ once processed. The rename to cur/ is what
+// makes a restart pick up exactly the messages that were not processed yet.
+type maildir struct {
+	dir string
+}
+
+// init creates the Maildir subdirectories
+func (m maildir) init() error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.dir, sub), 0700); err != nil {
+			return fmt.Errorf("failed to create maildir: %w", err)
+		}
+	}
+	return nil
+}
+
+// deliver writes a message to new/
+func (m maildir) deliver(data []byte) error {
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%s.%s", time.Now().UnixNano(), uuid.New().String(), strings.ReplaceAll(hostname, "/", "_"))
+	tmpPath := filepath.Join(m.dir, "tmp", name)
+
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create message: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync message: %w", err)
+	}
+	file.Close()
+
+	if err := os.Rename(tmpPath, filepath.Join(m.dir, "new", name)); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to deliver message: %w", err)
+	}
+	return nil
+}
+
+// pending returns the names of the unprocessed messages, oldest first
+func (m maildir) pending() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(m.dir, "new"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read maildir: %w", err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// read returns the content of an unprocessed message
+func (m maildir) read(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(m.dir, "new", name))
+}
+
+// markSeen moves a processed message to cur/ with the Seen flag
+func (m maildir) markSeen(name string) error {
+	return os.Rename(filepath.Join(m.dir, "new", name), filepath.Join(m.dir, "cur", name+":2,S"))
+}
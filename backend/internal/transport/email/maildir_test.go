@@ -0,0 +1,48 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaildir(t *testing.T) {
+	m := maildir{dir: filepath.Join(t.TempDir(), "Maildir")}
+	if err := m.init(); err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+
+	for _, message := range []string{"first", "second"} {
+		if err := m.deliver([]byte(message)); err != nil {
+			t.Fatalf("deliver failed: %v", err)
+		}
+	}
+	// Files being written by other delivery agents are hidden
+	os.WriteFile(filepath.Join(m.dir, "new", ".partial"), nil, 0600)
+
+	pending, err := m.pending()
+	if err != nil {
+		t.Fatalf("pending failed: %v", err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("pending = %q, want the 2 messages", pending)
+	}
+	if tmp, _ := os.ReadDir(filepath.Join(m.dir, "tmp")); len(tmp) != 0 {
+		t.Errorf("left %d files in tmp/", len(tmp))
+	}
+
+	// Names sort by delivery time, so messages are read in order
+	if data, err := m.read(pending[0]); err != nil || string(data) != "first" {
+		t.Errorf("read = %q, %v, want the first message", data, err)
+	}
+
+	if err := m.markSeen(pending[0]); err != nil {
+		t.Fatalf("markSeen failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(m.dir, "cur", pending[0]+":2,S")); err != nil {
+		t.Errorf("seen message not in cur/: %v", err)
+	}
+	if left, _ := m.pending(); len(left) != 1 || left[0] != pending[1] {
+		t.Errorf("pending = %q, want the second message only", left)
+	}
+}
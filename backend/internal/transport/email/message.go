@@ -0,0 +1,39 @@
+package email
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/services/parser"
+)
+
+// document is a file of a message to process as a receipt
+type document struct {
+	Name string
+	Data []byte
+}
+
+// documents returns the files to process: the supported attachments or, when
+// there are none, the message itself, whose HTML (or plain text) body goes
+// through the e-receipt extractors with the sender as a hint of the retailer
+func documents(msg *parser.Email, raw []byte) []document {
+	documents := []document{}
+	for _, attachment := range msg.Attachments {
+		if services.IsSupportedFile(attachment.Name) {
+			documents = append(documents, document{Name: attachment.Name, Data: attachment.Data})
+		} else {
+			log.Debug("Skipping unsupported attachment", "name", attachment.Name)
+		}
+	}
+	if len(documents) > 0 {
+		return documents
+	}
+
+	for _, body := range append(msg.HTMLBodies, msg.TextBodies...) {
+		if strings.TrimSpace(body) != "" {
+			return []document{{Name: "message.eml", Data: raw}}
+		}
+	}
+	return documents
+}
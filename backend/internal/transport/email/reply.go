@@ -0,0 +1,112 @@
+package email
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vieitesss/ticketer/internal/config"
+	"github.com/vieitesss/ticketer/internal/services/parser"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// outcome is the processing result of one document of a message
+type outcome struct {
+	Name        string
+	Receipt     *dto.ReceiptResponse
+	DuplicateOf string
+	Err         error
+}
+
+// String describes the outcome in one line of the reply
+func (o outcome) String() string {
+	switch {
+	case o.Err != nil:
+		return fmt.Sprintf("%s: failed: %v", o.Name, o.Err)
+	case o.DuplicateOf != "":
+		return fmt.Sprintf("%s: already imported as receipt %s", o.Name, o.DuplicateOf)
+	default:
+		return fmt.Sprintf("%s: saved as receipt %s (%s, %s, %d items, %.2f)",
+			o.Name, o.Receipt.ID, o.Receipt.Store.Name, o.Receipt.BoughtDate, len(o.Receipt.Items), o.Receipt.TotalAmount)
+	}
+}
+
+// replier sends the processing outcome back to the sender
+type replier struct {
+	relay    string
+	from     string
+	username string
+	password string
+}
+
+func newReplier(cfg *config.Config) *replier {
+	if cfg.EmailReplySMTP == "" || cfg.EmailReplyFrom == "" {
+		return nil
+	}
+	return &replier{
+		relay:    cfg.EmailReplySMTP,
+		from:     cfg.EmailReplyFrom,
+		username: cfg.EmailReplyUsername,
+		password: cfg.EmailReplyPassword,
+	}
+}
+
+// send replies to a message with the outcome of each of its documents
+func (r *replier) send(msg *parser.Email, outcomes []outcome) error {
+	var body strings.Builder
+	body.WriteString("Your receipts were processed:\r\n\r\n")
+	for _, o := range outcomes {
+		body.WriteString("- " + o.String() + "\r\n")
+	}
+
+	subject := msg.Subject
+	if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+		subject = "Re: " + subject
+	}
+	references := strings.TrimSpace(msg.References + " " + msg.MessageID)
+
+	var data strings.Builder
+	fmt.Fprintf(&data, "From: %s\r\n", r.from)
+	fmt.Fprintf(&data, "To: %s\r\n", msg.From)
+	fmt.Fprintf(&data, "Subject: %s\r\n", mimeHeader(subject))
+	fmt.Fprintf(&data, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&data, "Message-ID: <%s@ticketer>\r\n", uuid.New().String())
+	if msg.MessageID != "" {
+		fmt.Fprintf(&data, "In-Reply-To: %s\r\n", msg.MessageID)
+		fmt.Fprintf(&data, "References: %s\r\n", references)
+	}
+	// Keep auto-responders from replying back (RFC 3834)
+	data.WriteString("Auto-Submitted: auto-replied\r\n")
+	data.WriteString("MIME-Version: 1.0\r\n")
+	data.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	data.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	data.WriteString(body.String())
+
+	var auth smtp.Auth
+	if r.username != "" {
+		host, _, err := net.SplitHostPort(r.relay)
+		if err != nil {
+			return fmt.Errorf("invalid reply relay %q: %w", r.relay, err)
+		}
+		auth = smtp.PlainAuth("", r.username, r.password, host)
+	}
+
+	if err := smtp.SendMail(r.relay, auth, r.from, []string{msg.From}, []byte(data.String())); err != nil {
+		return fmt.Errorf("failed to send reply: %w", err)
+	}
+	return nil
+}
+
+// mimeHeader encodes a header value if it is not plain ASCII
+func mimeHeader(value string) string {
+	for _, r := range value {
+		if r > 127 {
+			return mime.QEncoding.Encode("utf-8", value)
+		}
+	}
+	return value
+}
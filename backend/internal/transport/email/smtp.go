@@ -0,0 +1,193 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// smtpTimeout bounds how long a client may stay idle between commands
+const smtpTimeout = 5 * time.Minute
+
+// smtpServer is a minimal SMTP listener that accepts messages from known
+// senders and delivers them to the Maildir. It has no TLS nor authentication
+// and is meant to listen on a local or private network only.
+type smtpServer struct {
+	addr     string
+	hostname string
+	maxSize  int64
+	senders  map[string]string
+	deliver  func(data []byte) error
+}
+
+// serve accepts connections until ctx is cancelled
+func (s *smtpServer) serve(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+	log.Info("SMTP listener started", "addr", listener.Addr())
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		go s.handle(conn)
+	}
+}
+
+// smtpSession is the envelope of the message being received
+type smtpSession struct {
+	from       string
+	recipients int
+}
+
+// handle runs the SMTP dialogue of one connection
+func (s *smtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	remote := conn.RemoteAddr().String()
+	text := textproto.NewConn(conn)
+	session := smtpSession{}
+
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	text.PrintfLine("220 %s ticketer ESMTP ready", s.hostname)
+
+	for {
+		conn.SetDeadline(time.Now().Add(smtpTimeout))
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			text.PrintfLine("250 %s", s.hostname)
+		case "EHLO":
+			text.PrintfLine("250-%s", s.hostname)
+			text.PrintfLine("250-SIZE %d", s.maxSize)
+			text.PrintfLine("250 8BITMIME")
+		case "MAIL":
+			address, ok := pathArgument(arg, "FROM:")
+			if !ok {
+				text.PrintfLine("501 5.5.4 Syntax: MAIL FROM:<address>")
+				continue
+			}
+			if _, known := s.senders[address]; !known {
+				log.Warn("Rejecting email from unknown sender", "from", address, "remote", remote)
+				text.PrintfLine("550 5.7.1 Sender not allowed")
+				continue
+			}
+			session = smtpSession{from: address}
+			text.PrintfLine("250 2.1.0 OK")
+		case "RCPT":
+			if session.from == "" {
+				text.PrintfLine("503 5.5.1 MAIL first")
+				continue
+			}
+			if _, ok := pathArgument(arg, "TO:"); !ok {
+				text.PrintfLine("501 5.5.4 Syntax: RCPT TO:<address>")
+				continue
+			}
+			session.recipients++
+			text.PrintfLine("250 2.1.5 OK")
+		case "DATA":
+			if session.from == "" || session.recipients == 0 {
+				text.PrintfLine("503 5.5.1 MAIL and RCPT first")
+				continue
+			}
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			text.PrintfLine("%s", s.receive(text, session, remote))
+			session = smtpSession{}
+		case "RSET":
+			session = smtpSession{}
+			text.PrintfLine("250 2.0.0 OK")
+		case "NOOP":
+			text.PrintfLine("250 2.0.0 OK")
+		case "VRFY":
+			text.PrintfLine("252 2.5.0 Cannot verify")
+		case "QUIT":
+			text.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			text.PrintfLine("502 5.5.2 Command not implemented")
+		}
+	}
+}
+
+// receive reads the message data and delivers it, returning the reply line
+func (s *smtpServer) receive(text *textproto.Conn, session smtpSession, remote string) string {
+	reader := text.DotReader()
+	data, err := io.ReadAll(io.LimitReader(reader, s.maxSize+1))
+	if err != nil {
+		return "451 4.3.0 Failed to read message"
+	}
+	if int64(len(data)) > s.maxSize {
+		// Consume the rest of the message to stay in sync with the client
+		io.Copy(io.Discard, reader)
+		return "552 5.3.4 Message too big"
+	}
+
+	// The dot reader turns the CRLF line endings into LF, as Maildir stores them
+	trace := fmt.Sprintf("Return-Path: <%s>\nReceived: from %s by %s (ticketer); %s\n",
+		session.from, remote, s.hostname, time.Now().Format(time.RFC1123Z))
+	if err := s.deliver(append([]byte(trace), data...)); err != nil {
+		log.Error("Failed to deliver email", "from", session.from, "error", err)
+		return "451 4.3.0 Failed to store message"
+	}
+
+	log.Info("Email received", "from", session.from, "size", len(data))
+	return "250 2.0.0 Queued"
+}
+
+// pathArgument parses the address of "FROM:<address> [params]" style arguments
+func pathArgument(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	path := strings.TrimSpace(arg[len(prefix):])
+	if end := strings.Index(path, ">"); strings.HasPrefix(path, "<") && end > 0 {
+		path = path[1:end]
+	} else if space := strings.IndexByte(path, ' '); space >= 0 {
+		path = path[:space]
+	}
+
+	// The null sender of bounces is never a known sender
+	if path == "" {
+		return "", true
+	}
+
+	address, err := mail.ParseAddress("<" + path + ">")
+	if err != nil {
+		return "", false
+	}
+	return strings.ToLower(address.Address), true
+}
+
+// defaultHostname is the name the listener greets with
+func defaultHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "localhost"
+	}
+	return hostname
+}
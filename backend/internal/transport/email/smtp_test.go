@@ -0,0 +1,158 @@
+package email
+
+import (
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// startSMTP serves SMTP on a local port for the known senders, sending every
+// delivered message to the returned channel
+func startSMTP(t *testing.T, senders map[string]string) (string, <-chan []byte) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	delivered := make(chan []byte, 10)
+	server := &smtpServer{
+		hostname: "ticketer.test",
+		maxSize:  1024,
+		senders:  senders,
+		deliver: func(data []byte) error {
+			delivered <- data
+			return nil
+		},
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handle(conn)
+		}
+	}()
+
+	return listener.Addr().String(), delivered
+}
+
+func TestSMTPDelivery(t *testing.T) {
+	addr, delivered := startSMTP(t, map[string]string{"ana@example.com": "ana"})
+
+	message := "From: ana@example.com\r\nTo: receipts@ticketer.test\r\nSubject: Ticket\r\n\r\nTicket attached.\r\n"
+	if err := smtp.SendMail(addr, nil, "Ana@Example.com", []string{"receipts@ticketer.test"}, []byte(message)); err != nil {
+		t.Fatalf("SendMail failed: %v", err)
+	}
+
+	// The message is delivered with a trace of its envelope on top, with the
+	// line endings of a Maildir
+	data := string(<-delivered)
+	if !strings.HasPrefix(data, "Return-Path: <ana@example.com>\nReceived: from 127.0.0.1:") {
+		t.Errorf("message starts with %q, want the trace headers", data[:min(len(data), 80)])
+	}
+	if !strings.HasSuffix(data, strings.ReplaceAll(message, "\r\n", "\n")) || strings.Contains(data, "\r") {
+		t.Errorf("message = %q, want it to end with what was sent", data)
+	}
+
+	// Unknown senders are refused before the message is sent
+	err := smtp.SendMail(addr, nil, "spam@example.com", []string{"receipts@ticketer.test"}, []byte(message))
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("SendMail from an unknown sender = %v, want 550", err)
+	}
+	if len(delivered) != 0 {
+		t.Error("message from an unknown sender was delivered")
+	}
+}
+
+func TestSMTPDialogue(t *testing.T) {
+	addr, delivered := startSMTP(t, map[string]string{"ana@example.com": "ana"})
+
+	conn, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.ReadResponse(220); err != nil {
+		t.Fatalf("greeting: %v", err)
+	}
+
+	steps := []struct {
+		command string
+		data    string // Message sent after a 354 reply
+		want    int
+	}{
+		{"EHLO client", "", 250},
+		{"RCPT TO:<receipts@ticketer.test>", "", 503},
+		{"DATA", "", 503},
+		{"MAIL TO:<ana@example.com>", "", 501},
+		{"MAIL FROM:<ana@example.com> SIZE=100", "", 250},
+		{"DATA", "", 503},
+		{"RCPT TO:receipts", "", 501},
+		{"RCPT TO:<receipts@ticketer.test>", "", 250},
+		{"DATA", strings.Repeat("too big ", 200), 552},
+		// The session is reset after a message, accepted or not
+		{"RCPT TO:<receipts@ticketer.test>", "", 503},
+		{"MAIL FROM:<ana@example.com>", "", 250},
+		{"RSET", "", 250},
+		{"RCPT TO:<receipts@ticketer.test>", "", 503},
+		{"MAIL FROM:<>", "", 550},
+		{"STARTTLS", "", 502},
+		{"NOOP", "", 250},
+		{"QUIT", "", 221},
+	}
+
+	for _, step := range steps {
+		id, err := conn.Cmd("%s", step.command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.StartResponse(id)
+		if step.data != "" {
+			if _, _, err := conn.ReadResponse(354); err != nil {
+				t.Fatalf("%s: %v", step.command, err)
+			}
+			w := conn.DotWriter()
+			w.Write([]byte(step.data))
+			w.Close()
+		}
+		code, message, _ := conn.ReadResponse(0)
+		conn.EndResponse(id)
+		if code != step.want {
+			t.Errorf("%s = %d %s, want %d", step.command, code, message, step.want)
+		}
+	}
+
+	if len(delivered) != 0 {
+		t.Error("a message too big was delivered")
+	}
+}
+
+func TestPathArgument(t *testing.T) {
+	tests := []struct {
+		arg    string
+		prefix string
+		want   string
+		ok     bool
+	}{
+		{"FROM:<Ana@Example.com>", "FROM:", "ana@example.com", true},
+		{"from: <ana@example.com> BODY=8BITMIME", "FROM:", "ana@example.com", true},
+		{"FROM:ana@example.com SIZE=100", "FROM:", "ana@example.com", true},
+		{"FROM:<>", "FROM:", "", true},
+		{"TO:<receipts@ticketer.test>", "TO:", "receipts@ticketer.test", true},
+		{"TO:<receipts>", "TO:", "", false},
+		{"<ana@example.com>", "FROM:", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := pathArgument(tt.arg, tt.prefix)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("pathArgument(%q) = %q, %v, want %q, %v", tt.arg, got, ok, tt.want, tt.ok)
+		}
+	}
+}
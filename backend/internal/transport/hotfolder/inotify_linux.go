@@ -0,0 +1,52 @@
+//go:build linux
+
+package hotfolder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// watchEvents signals on the returned channel whenever a file is written or
+// moved into dir. Events are coalesced: a signal means "scan the directory".
+// Network shares do not deliver events for remote writes, which is why the
+// watcher keeps polling as well.
+func watchEvents(ctx context.Context, dir string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+
+	if _, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+
+	// A non-blocking descriptor goes through the runtime poller, so closing
+	// the file unblocks the pending read
+	file := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-ctx.Done()
+		file.Close()
+	}()
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			if _, err := file.Read(buf); err != nil {
+				return
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return events, nil
+}
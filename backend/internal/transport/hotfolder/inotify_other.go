@@ -0,0 +1,13 @@
+//go:build !linux
+
+package hotfolder
+
+import (
+	"context"
+	"errors"
+)
+
+// watchEvents is only implemented on Linux, elsewhere the watcher polls
+func watchEvents(ctx context.Context, dir string) (<-chan struct{}, error) {
+	return nil, errors.New("inotify is not supported on this platform")
+}
@@ -0,0 +1,87 @@
+package hotfolder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// ledgerEntry records a file that was imported, keyed by its content hash
+type ledgerEntry struct {
+	SHA256     string    `json:"sha256"`
+	File       string    `json:"file"`
+	ReceiptID  string    `json:"receipt_id"`
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// ledger is an append-only JSON lines file of imported files. It survives
+// restarts so that a file dropped again, or left behind by a crash after its
+// receipt was saved, is not imported twice.
+type ledger struct {
+	path     string
+	imported map[string]ledgerEntry
+}
+
+// loadLedger reads the ledger at path, which may not exist yet
+func loadLedger(path string) (*ledger, error) {
+	l := &ledger{path: path, imported: make(map[string]ledgerEntry)}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry ledgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A crash can leave a partial last line behind
+			log.Warn("Skipping invalid ledger line", "path", path, "error", err)
+			continue
+		}
+		l.imported[entry.SHA256] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ledger: %w", err)
+	}
+
+	return l, nil
+}
+
+// lookup returns the entry of a previously imported file
+func (l *ledger) lookup(sha256 string) (ledgerEntry, bool) {
+	entry, ok := l.imported[sha256]
+	return entry, ok
+}
+
+// record appends an entry and syncs it to disk before returning
+func (l *ledger) record(entry ledgerEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ledger entry: %w", err)
+	}
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open ledger: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync ledger: %w", err)
+	}
+
+	l.imported[entry.SHA256] = entry
+	return nil
+}
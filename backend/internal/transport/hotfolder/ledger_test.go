@@ -0,0 +1,44 @@
+package hotfolder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+
+	l, err := loadLedger(path)
+	if err != nil {
+		t.Fatalf("loadLedger of a new ledger failed: %v", err)
+	}
+	for _, entry := range []ledgerEntry{
+		{SHA256: "aaa", File: "a.pdf", ReceiptID: "r1", ImportedAt: time.Now()},
+		{SHA256: "bbb", File: "b.pdf", ReceiptID: "r2", ImportedAt: time.Now()},
+	} {
+		if err := l.record(entry); err != nil {
+			t.Fatalf("record failed: %v", err)
+		}
+	}
+
+	// A crash while appending leaves a partial line, which is skipped
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"sha256":"ccc","fi`)
+	file.Close()
+
+	reloaded, err := loadLedger(path)
+	if err != nil {
+		t.Fatalf("loadLedger failed: %v", err)
+	}
+	if entry, ok := reloaded.lookup("bbb"); !ok || entry.File != "b.pdf" || entry.ReceiptID != "r2" {
+		t.Errorf("lookup(bbb) = %+v, %v, want b.pdf as r2", entry, ok)
+	}
+	if _, ok := reloaded.lookup("ccc"); ok || len(reloaded.imported) != 2 {
+		t.Errorf("loaded %d entries, want the 2 complete ones", len(reloaded.imported))
+	}
+}
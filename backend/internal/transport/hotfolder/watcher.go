@@ -0,0 +1,375 @@
+package hotfolder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/vieitesss/ticketer/internal/config"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/services/ai"
+)
+
+const (
+	doneDir       = "done"
+	failedDir     = "failed"
+	stateDir      = ".ticketer"
+	processingDir = ".ticketer/processing"
+	ledgerFile    = ".ticketer/ledger.jsonl"
+
+	errorSuffix = ".error.txt"
+
+	retryBaseDelay = time.Minute
+	retryMaxDelay  = time.Hour
+)
+
+// errNotSaved wraps the reason a processed receipt could not be saved
+var errNotSaved = errors.New("failed to save receipt")
+
+// Watcher imports receipt files dropped into a directory. Files are claimed by
+// moving them to a processing directory, imported through the receipt service
+// and then moved to done/, or to failed/ next to a sidecar file with the
+// error. Files that failed for a reason that may go away (the AI provider or
+// the database being down) stay claimed and are retried with backoff. Files
+// claimed when the daemon stopped are resumed on the next start.
+type Watcher struct {
+	receiptService *services.ReceiptService
+	dir            string
+	pollInterval   time.Duration
+	settleTime     time.Duration
+	owner          string // User the imported receipts belong to, if any
+	ledger         *ledger
+	retries        map[string]*retry // Claimed files waiting for a retry
+}
+
+// retry schedules the next import of a claimed file
+type retry struct {
+	attempts int
+	at       time.Time
+}
+
+func NewWatcher(receiptService *services.ReceiptService, cfg *config.Config) *Watcher {
+	return &Watcher{
+		receiptService: receiptService,
+		dir:            cfg.WatchDir,
+		pollInterval:   cfg.WatchPollInterval,
+		settleTime:     cfg.WatchSettleTime,
+		owner:          cfg.WatchOwner,
+		retries:        make(map[string]*retry),
+	}
+}
+
+// Run watches the directory until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context) error {
+	for _, dir := range []string{w.dir, w.path(doneDir), w.path(failedDir), w.path(processingDir)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+
+	var err error
+	w.ledger, err = loadLedger(w.path(ledgerFile))
+	if err != nil {
+		return err
+	}
+
+	log.Info("Watching hot folder", "dir", w.dir, "poll_interval", w.pollInterval, "imported", len(w.ledger.imported))
+
+	// Finish the files that were being processed when the daemon stopped
+	w.resume(ctx)
+
+	events, err := watchEvents(ctx, w.dir)
+	if err != nil {
+		log.Warn("File events unavailable, polling only", "error", err)
+	}
+
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	var settle <-chan time.Time
+	for {
+		w.retryDue(ctx)
+		if w.scan(ctx) {
+			// Some files are still being written, look again once they settle
+			settle = time.After(w.settleTime)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("Hot folder watcher stopped")
+			return nil
+		case _, ok := <-events:
+			if !ok {
+				log.Warn("File events stopped, polling only")
+				events = nil
+			}
+		case <-ticker.C:
+		case <-settle:
+			settle = nil
+		}
+	}
+}
+
+// path returns a path relative to the watched directory
+func (w *Watcher) path(name string) string {
+	return filepath.Join(w.dir, name)
+}
+
+// resume processes the files left in the processing directory
+func (w *Watcher) resume(ctx context.Context) {
+	entries, err := os.ReadDir(w.path(processingDir))
+	if err != nil {
+		log.Error("Failed to read processing directory", "error", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if entry.IsDir() {
+			continue
+		}
+		log.Info("Resuming interrupted file", "file", entry.Name())
+		w.process(ctx, filepath.Join(w.path(processingDir), entry.Name()))
+	}
+}
+
+// retryDue imports again the claimed files whose retry is due
+func (w *Watcher) retryDue(ctx context.Context) {
+	for claimed, r := range w.retries {
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Now().Before(r.at) {
+			continue
+		}
+		log.Info("Retrying file", "file", filepath.Base(claimed), "attempt", r.attempts+1)
+		w.process(ctx, claimed)
+	}
+}
+
+// scan claims and processes the files of the watched directory. It reports
+// whether some files were skipped because they are still being written.
+func (w *Watcher) scan(ctx context.Context) bool {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		log.Error("Failed to read hot folder", "dir", w.dir, "error", err)
+		return false
+	}
+
+	unsettled := false
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return false
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) < w.settleTime {
+			unsettled = true
+			continue
+		}
+
+		// Claiming by rename also keeps a second daemon off the same file
+		claimed, err := moveUnique(w.path(entry.Name()), w.path(processingDir))
+		if err != nil {
+			log.Warn("Failed to claim file", "file", entry.Name(), "error", err)
+			continue
+		}
+		w.process(ctx, claimed)
+	}
+
+	return unsettled
+}
+
+// process imports a claimed file and moves it to done/ or failed/, or
+// schedules a retry when it failed for a transient reason
+func (w *Watcher) process(ctx context.Context, claimed string) {
+	name := filepath.Base(claimed)
+
+	if !services.IsSupportedFile(name) {
+		w.fail(claimed, errors.New("unsupported file format"))
+		return
+	}
+
+	sum, err := fileSHA256(claimed)
+	if err != nil {
+		w.fail(claimed, err)
+		return
+	}
+
+	if entry, ok := w.ledger.lookup(sum); ok {
+		log.Info("File already imported, skipping", "file", name, "receipt_id", entry.ReceiptID)
+		w.done(claimed)
+		return
+	}
+
+	receiptID, err := w.importFile(ctx, claimed)
+	if err != nil {
+		// Leave the file claimed so that it is resumed on the next start
+		if ctx.Err() != nil {
+			return
+		}
+		if transient(err) {
+			w.backOff(claimed, err)
+			return
+		}
+		log.Error("Failed to import file", "file", name, "error", err)
+		w.fail(claimed, err)
+		return
+	}
+
+	// The receipt is saved: record it before moving the file, so that a crash
+	// in between does not import it again
+	err = w.ledger.record(ledgerEntry{
+		SHA256:     sum,
+		File:       name,
+		ReceiptID:  receiptID,
+		ImportedAt: time.Now(),
+	})
+	if err != nil {
+		log.Error("Failed to record imported file", "file", name, "error", err)
+	}
+
+	w.done(claimed)
+}
+
+// importFile copies a file to the uploads directory and processes it, returning
+// the ID of the saved receipt, or of the existing one for duplicates
+func (w *Watcher) importFile(ctx context.Context, claimed string) (string, error) {
+	file, err := os.Open(claimed)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	uploadPath, err := w.receiptService.SaveUpload(file, filepath.Ext(claimed))
+	if err != nil {
+		return "", err
+	}
+
+	result, err := w.receiptService.ProcessReceiptFileAs(ctx, uploadPath, w.owner)
+	if err != nil {
+		w.receiptService.DiscardUpload(uploadPath)
+		return "", err
+	}
+
+	// Only keep the original image of saved receipts
+	if result.Receipt.ID == "" {
+		w.receiptService.DiscardUpload(uploadPath)
+	}
+
+	if existingID := result.DuplicateOf(); existingID != "" {
+		log.Info("Receipt already exists", "file", filepath.Base(claimed), "receipt_id", existingID)
+		return existingID, nil
+	}
+	if result.SaveErr != nil {
+		return "", fmt.Errorf("%w: %w", errNotSaved, result.SaveErr)
+	}
+
+	log.Info("Receipt imported", "file", filepath.Base(claimed), "receipt_id", result.Receipt.ID)
+	return result.Receipt.ID, nil
+}
+
+// transient reports whether an import failed for a reason that may go away on
+// its own: the AI provider was unavailable, or the database failed to save
+// the receipt. Unsupported, unreadable, unparseable and invalid receipts fail
+// the same way on every attempt.
+func transient(err error) bool {
+	var validationErr *services.ValidationError
+	if errors.As(err, &validationErr) {
+		return false
+	}
+	return ai.IsTransient(err) || errors.Is(err, errNotSaved)
+}
+
+// backOff leaves a claimed file in the processing directory and schedules its
+// next import, doubling the delay on every failed attempt
+func (w *Watcher) backOff(claimed string, cause error) {
+	r, ok := w.retries[claimed]
+	if !ok {
+		r = &retry{}
+		w.retries[claimed] = r
+	}
+
+	delay := retryBaseDelay << r.attempts
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	r.attempts++
+	r.at = time.Now().Add(delay)
+
+	log.Warn("Failed to import file, retrying later", "file", filepath.Base(claimed), "attempt", r.attempts, "retry_in", delay, "error", cause)
+}
+
+// done moves a processed file to done/
+func (w *Watcher) done(claimed string) {
+	delete(w.retries, claimed)
+	if _, err := moveUnique(claimed, w.path(doneDir)); err != nil {
+		log.Error("Failed to move file to done", "file", filepath.Base(claimed), "error", err)
+	}
+}
+
+// fail moves a file to failed/ and writes the error to a sidecar file
+func (w *Watcher) fail(claimed string, cause error) {
+	delete(w.retries, claimed)
+	failed, err := moveUnique(claimed, w.path(failedDir))
+	if err != nil {
+		log.Error("Failed to move file to failed", "file", filepath.Base(claimed), "error", err)
+		return
+	}
+
+	sidecar := fmt.Sprintf("%s\n%s\n", time.Now().Format(time.RFC3339), cause)
+	if err := os.WriteFile(failed+errorSuffix, []byte(sidecar), 0644); err != nil {
+		log.Error("Failed to write error file", "file", filepath.Base(failed), "error", err)
+	}
+}
+
+// moveUnique moves a file into dir, adding a numeric suffix if its name is taken
+func moveUnique(src, dir string) (string, error) {
+	name := filepath.Base(src)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	dst := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(dst); os.IsNotExist(err) {
+			break
+		}
+		dst = filepath.Join(dir, fmt.Sprintf("%s-%d%s", stem, i, ext))
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// fileSHA256 returns the hex SHA-256 digest of a file's content
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
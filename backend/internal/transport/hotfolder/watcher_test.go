@@ -0,0 +1,304 @@
+package hotfolder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vieitesss/ticketer/internal/config"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/services/ai"
+	"google.golang.org/genai"
+)
+
+// receiptImage is a receipt the golden cassette of the receipt service answers
+var receiptImage = filepath.Join("..", "..", "services", "ai", "testdata", "aldi.png")
+
+// eReceiptTestdata holds e-receipts the parser reads without a model call
+var eReceiptTestdata = filepath.Join("..", "..", "services", "parser", "testdata")
+
+// failingClient fails every model call with an API error of a status code,
+// until it is told to answer from the golden cassette
+type failingClient struct {
+	code     int
+	replayer *ai.Replayer
+}
+
+func (c *failingClient) GenerateContent(ctx context.Context, model string, contents []*genai.Content, cfg *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	if c.code != 0 {
+		return nil, genai.APIError{Code: c.code, Message: "provider failure"}
+	}
+	return c.replayer.GenerateContent(ctx, model, contents, cfg)
+}
+
+// newTestWatcher creates a watcher on a temporary directory that extracts with
+// client, without a database
+func newTestWatcher(t *testing.T, client ai.ModelClient) *Watcher {
+	t.Helper()
+
+	cfg := &config.Config{
+		AIStoreStage: config.AIStageConfig{Model: "gemini-2.5-flash-lite"},
+		AIItemsStage: config.AIStageConfig{Model: "gemini-2.5-flash"},
+		UploadsDir:   t.TempDir(),
+		WatchDir:     t.TempDir(),
+	}
+	budgetService := services.NewBudgetService(nil, nil, nil, cfg)
+	receiptService := services.NewReceiptService(ai.NewGeminiServiceWithClient(client, cfg), nil, nil, budgetService, cfg)
+
+	w := NewWatcher(receiptService, cfg)
+	for _, dir := range []string{w.path(doneDir), w.path(failedDir), w.path(processingDir)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var err error
+	if w.ledger, err = loadLedger(w.path(ledgerFile)); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// claim puts a copy of the receipt image in the processing directory
+func claim(t *testing.T, w *Watcher, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(receiptImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed := filepath.Join(w.path(processingDir), name)
+	if err := os.WriteFile(claimed, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return claimed
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestProcessRetriesTransientFailures(t *testing.T) {
+	replayer, err := ai.NewReplayer(filepath.Join("..", "..", "services", "ai", "testdata", "aldi.cassette.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &failingClient{code: 503, replayer: replayer}
+	w := newTestWatcher(t, client)
+	claimed := claim(t, w, "scan.png")
+
+	// The provider is down: the file stays claimed, waiting for a retry
+	w.process(context.Background(), claimed)
+	if !exists(claimed) || exists(filepath.Join(w.path(failedDir), "scan.png")) {
+		t.Fatal("a file that failed for a transient reason left the processing directory")
+	}
+	first, ok := w.retries[claimed]
+	if !ok || first.attempts != 1 || !first.at.After(time.Now()) {
+		t.Fatalf("retry = %+v, want the first attempt scheduled later", first)
+	}
+
+	// Failing again doubles the delay
+	firstAt := first.at
+	w.process(context.Background(), claimed)
+	if r := w.retries[claimed]; !exists(claimed) || r.attempts != 2 || !r.at.After(firstAt) {
+		t.Fatalf("retry = %+v, want a second attempt scheduled after the first", r)
+	}
+
+	// Retries wait until they are due
+	client.code = 0
+	w.retryDue(context.Background())
+	if !exists(claimed) {
+		t.Fatal("the file was retried before its retry was due")
+	}
+
+	// Once the provider is back, the file is imported
+	w.retries[claimed].at = time.Now()
+	w.retryDue(context.Background())
+	if exists(claimed) || !exists(filepath.Join(w.path(doneDir), "scan.png")) {
+		t.Fatal("the retried file was not imported")
+	}
+	if len(w.retries) != 0 {
+		t.Errorf("retries = %v, want none left", w.retries)
+	}
+	if len(w.ledger.imported) != 1 {
+		t.Errorf("ledger has %d entries, want the imported file", len(w.ledger.imported))
+	}
+}
+
+func TestProcessFailsPermanentFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		code int
+	}{
+		{"rejected by the provider", "scan.png", 400},
+		{"unsupported format", "scan.docx", 503},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, &failingClient{code: tt.code})
+			claimed := claim(t, w, tt.file)
+
+			w.process(context.Background(), claimed)
+			failed := filepath.Join(w.path(failedDir), tt.file)
+			if exists(claimed) || !exists(failed) || !exists(failed+errorSuffix) {
+				t.Fatal("the file was not moved to failed/ with its error")
+			}
+			if len(w.retries) != 0 {
+				t.Errorf("retries = %v, want none", w.retries)
+			}
+		})
+	}
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"provider unavailable", genai.APIError{Code: 503}, true},
+		{"rate limited", genai.APIError{Code: 429}, true},
+		{"circuit open", ai.ErrCircuitOpen, true},
+		{"not saved", errNotSaved, true},
+		{"rejected request", genai.APIError{Code: 400}, false},
+		{"invalid receipt", &services.ValidationError{Issues: []string{"the date is missing"}}, false},
+		{"no extractor", services.ErrNoExtractor, false},
+	}
+
+	for _, tt := range tests {
+		if got := transient(tt.err); got != tt.want {
+			t.Errorf("%s: transient = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// dropFile writes a file into a directory of the watcher
+func dropFile(t *testing.T, w *Watcher, dir, name string, content []byte) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(w.path(dir), name), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listDir returns the names of the files in a directory of the watcher
+func listDir(t *testing.T, w *Watcher, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(w.path(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func TestMoveUnique(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	for _, name := range []string{"scan.pdf", "scan-1.pdf"} {
+		os.WriteFile(filepath.Join(dst, name), nil, 0644)
+	}
+	os.WriteFile(filepath.Join(src, "scan.pdf"), []byte("new"), 0644)
+
+	moved, err := moveUnique(filepath.Join(src, "scan.pdf"), dst)
+	if err != nil {
+		t.Fatalf("moveUnique failed: %v", err)
+	}
+	if moved != filepath.Join(dst, "scan-2.pdf") {
+		t.Errorf("moved to %s, want scan-2.pdf", moved)
+	}
+	if content, _ := os.ReadFile(moved); string(content) != "new" {
+		t.Errorf("moved file has %q", content)
+	}
+}
+
+func TestWatcherScan(t *testing.T) {
+	w := newTestWatcher(t, &failingClient{code: 400})
+	receipt, err := os.ReadFile(filepath.Join(eReceiptTestdata, "mercadona.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dropFile(t, w, ".", "mercadona.eml", receipt)
+	dropFile(t, w, ".", "notes.docx", []byte("notes"))
+	dropFile(t, w, ".", "blank.txt", []byte("nothing to read"))
+	dropFile(t, w, ".", ".partial.eml", receipt)
+	w.scan(context.Background())
+
+	// Hidden files are left alone, the rest is claimed and moved on
+	if got := strings.Join(listDir(t, w, "."), " "); got != ".partial.eml" {
+		t.Errorf("left %q in the hot folder, want the hidden file only", got)
+	}
+	if got := strings.Join(listDir(t, w, doneDir), " "); got != "mercadona.eml" {
+		t.Errorf("done = %q, want mercadona.eml", got)
+	}
+	if got := strings.Join(listDir(t, w, failedDir), " "); got != "blank.txt blank.txt.error.txt notes.docx notes.docx.error.txt" {
+		t.Errorf("failed = %q, want both failed files with their errors", got)
+	}
+	sidecar, _ := os.ReadFile(w.path(filepath.Join(failedDir, "notes.docx"+errorSuffix)))
+	if !strings.Contains(string(sidecar), "unsupported file format") {
+		t.Errorf("error file = %q", sidecar)
+	}
+
+	// The same receipt dropped again under another name is not imported again
+	dropFile(t, w, ".", "mercadona again.eml", receipt)
+	w.scan(context.Background())
+	if got := strings.Join(listDir(t, w, doneDir), " "); got != "mercadona again.eml mercadona.eml" {
+		t.Errorf("done = %q, want both copies", got)
+	}
+	if len(w.ledger.imported) != 1 {
+		t.Errorf("ledger has %d entries, want 1", len(w.ledger.imported))
+	}
+}
+
+func TestWatcherResume(t *testing.T) {
+	w := newTestWatcher(t, &failingClient{code: 400})
+
+	// A daemon stopped after saving the receipt of a file, and recording it in
+	// the ledger, but before moving it to done/
+	content := []byte("nothing to read")
+	sum := filepath.Join(t.TempDir(), "sum")
+	os.WriteFile(sum, content, 0644)
+	hash, err := fileSHA256(sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ledger.record(ledgerEntry{SHA256: hash, File: "saved.txt", ReceiptID: "r1"}); err != nil {
+		t.Fatal(err)
+	}
+	dropFile(t, w, processingDir, "saved.txt", content)
+
+	// and another one while it was still being imported
+	receipt, err := os.ReadFile(filepath.Join(eReceiptTestdata, "carrefour.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropFile(t, w, processingDir, "carrefour.html", receipt)
+
+	restarted := newTestWatcher(t, &failingClient{code: 400})
+	restarted.dir = w.dir
+	if restarted.ledger, err = loadLedger(w.path(ledgerFile)); err != nil {
+		t.Fatal(err)
+	}
+	restarted.resume(context.Background())
+
+	// Importing the first file again would have failed it, as the provider rejects it
+	if got := strings.Join(listDir(t, w, doneDir), " "); got != "carrefour.html saved.txt" {
+		t.Errorf("done = %q, want both resumed files", got)
+	}
+	if got := listDir(t, w, processingDir); len(got) != 0 {
+		t.Errorf("still processing %q", got)
+	}
+	if got := listDir(t, w, failedDir); len(got) != 0 {
+		t.Errorf("failed %q", got)
+	}
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+const (
+	// maxBatchFiles bounds how many receipts a single batch may contain
+	maxBatchFiles = 1000
+	// maxZipEntrySize bounds the uncompressed size of a single ZIP entry
+	maxZipEntrySize = 20 << 20
+)
+
+// UploadBatch accepts many receipt files, or ZIP archives of them, and streams
+// one JSON line per file as soon as it is processed, followed by a summary line
+func (h *ReceiptHandler) UploadBatch(c fiber.Ctx) error {
+	form, err := c.MultipartForm()
+	if err != nil {
+		log.Error("Failed to parse multipart form", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Failed to parse form")
+	}
+
+	headers := append(form.File["receipts"], form.File["receipt"]...)
+	if len(headers) == 0 {
+		return c.Status(http.StatusBadRequest).SendString("No file uploaded")
+	}
+
+	owner, err := receiptOwner(c, c.FormValue("owner"))
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	files, rejected, err := h.saveBatch(headers)
+	if err != nil {
+		h.removeBatch(files)
+		log.Error("Failed to save batch", "error", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	// Processing outlives the handler, so it gets its own context, cancelled
+	// when the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	results := h.receiptService.ProcessBatch(ctx, files, services.ProcessOptions{Owner: owner, HouseholdID: c.FormValue("household")})
+
+	c.Set("Content-Type", "application/x-ndjson")
+	return c.SendStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		summary := dto.BatchSummary{Done: true, Total: len(files) + len(rejected)}
+		encoder := json.NewEncoder(w)
+		write := func(v any) {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := encoder.Encode(v); err == nil {
+				err = w.Flush()
+			}
+			if err != nil {
+				log.Warn("Batch client disconnected, cancelling", "error", err)
+				cancel()
+			}
+		}
+
+		for _, result := range rejected {
+			summary.Failed++
+			write(result)
+		}
+
+		// Drain every result, even after a disconnect, so no worker is left blocked
+		for result := range results {
+			line := batchResultToDTO(result)
+			switch line.Status {
+			case "saved", "processed":
+				summary.Saved++
+			case "duplicate":
+				summary.Duplicates++
+			default:
+				summary.Failed++
+			}
+
+			// Only keep the original image of saved receipts
+			if line.Status != "saved" {
+				h.receiptService.DiscardUpload(result.File.Path)
+			}
+
+			write(line)
+		}
+
+		write(summary)
+	})
+}
+
+// batchResultToDTO converts the outcome of a batch file to its JSON line
+func batchResultToDTO(result services.BatchResult) dto.BatchFileResult {
+	line := dto.BatchFileResult{File: result.File.Name}
+
+	switch {
+	case result.Err != nil:
+		line.Status = "error"
+		line.Error = result.Err.Error()
+	case result.Result.DuplicateOf() != "":
+		line.Status = "duplicate"
+		line.DuplicateOf = result.Result.DuplicateOf()
+	case result.Result.SaveErr != nil:
+		line.Status = "error"
+		line.Error = result.Result.SaveErr.Error()
+	case result.Result.Receipt.ID == "":
+		line.Status = "processed"
+	default:
+		line.Status = "saved"
+		line.ReceiptID = result.Result.Receipt.ID
+	}
+
+	return line
+}
+
+// saveBatch saves uploaded files to disk, expanding ZIP archives. Files with an
+// unsupported format are reported as rejected rather than failing the batch.
+func (h *ReceiptHandler) saveBatch(headers []*multipart.FileHeader) ([]services.BatchFile, []dto.BatchFileResult, error) {
+	files := []services.BatchFile{}
+	rejected := []dto.BatchFileResult{}
+
+	for _, header := range headers {
+		ext := strings.ToLower(filepath.Ext(header.Filename))
+
+		if ext == ".zip" {
+			entries, entriesRejected, err := h.saveZip(header)
+			files = append(files, entries...)
+			rejected = append(rejected, entriesRejected...)
+			if err != nil {
+				return files, nil, err
+			}
+		} else if !services.IsSupportedFile(header.Filename) {
+			rejected = append(rejected, dto.BatchFileResult{File: header.Filename, Status: "error", Error: "unsupported file format"})
+		} else {
+			savedPath, err := h.saveMultipartFile(header, ext)
+			if err != nil {
+				return files, nil, fmt.Errorf("failed to save %s: %w", header.Filename, err)
+			}
+			files = append(files, services.BatchFile{Name: header.Filename, Path: savedPath})
+		}
+
+		if len(files) > maxBatchFiles {
+			return files, nil, fmt.Errorf("too many files in batch (max %d)", maxBatchFiles)
+		}
+	}
+
+	return files, rejected, nil
+}
+
+// saveMultipartFile saves a single uploaded file
+func (h *ReceiptHandler) saveMultipartFile(header *multipart.FileHeader, ext string) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return h.receiptService.SaveUpload(file, ext)
+}
+
+// saveZip saves every supported entry of an uploaded ZIP archive
+func (h *ReceiptHandler) saveZip(header *multipart.FileHeader) ([]services.BatchFile, []dto.BatchFileResult, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", header.Filename, err)
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ZIP archive %s: %w", header.Filename, err)
+	}
+
+	files := []services.BatchFile{}
+	rejected := []dto.BatchFileResult{}
+	for _, entry := range archive.File {
+		name := header.Filename + "/" + entry.Name
+		base := path.Base(entry.Name)
+
+		// Skip directories and OS metadata (__MACOSX, .DS_Store, ...)
+		if entry.FileInfo().IsDir() || strings.HasPrefix(base, ".") || strings.HasPrefix(entry.Name, "__MACOSX/") {
+			continue
+		}
+
+		ext := path.Ext(base)
+		if !services.IsSupportedFile(base) {
+			rejected = append(rejected, dto.BatchFileResult{File: name, Status: "error", Error: "unsupported file format"})
+			continue
+		}
+		if entry.UncompressedSize64 > maxZipEntrySize {
+			rejected = append(rejected, dto.BatchFileResult{File: name, Status: "error", Error: "file too large"})
+			continue
+		}
+
+		savedPath, err := h.saveZipEntry(entry, ext)
+		if err != nil {
+			return files, rejected, fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+		files = append(files, services.BatchFile{Name: name, Path: savedPath})
+
+		if len(files) > maxBatchFiles {
+			return files, rejected, fmt.Errorf("too many files in batch (max %d)", maxBatchFiles)
+		}
+	}
+
+	return files, rejected, nil
+}
+
+// saveZipEntry extracts a single ZIP entry, never reading past the size limit
+func (h *ReceiptHandler) saveZipEntry(entry *zip.File, ext string) (string, error) {
+	src, err := entry.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	return h.receiptService.SaveUpload(io.LimitReader(src, maxZipEntrySize), ext)
+}
+
+// removeBatch deletes the saved files of a batch that will not be processed
+func (h *ReceiptHandler) removeBatch(files []services.BatchFile) {
+	for _, file := range files {
+		h.receiptService.DiscardUpload(file.Path)
+	}
+}
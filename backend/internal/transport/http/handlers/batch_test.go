@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/vieitesss/ticketer/internal/config"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// receiptTestdata holds e-receipts the parser reads without AI
+var receiptTestdata = filepath.Join("..", "..", "..", "services", "parser", "testdata")
+
+// batchUpload posts files to a batch upload handler without a database, which
+// saves its uploads to uploadsDir, and returns the response
+func batchUpload(t *testing.T, uploadsDir string, files map[string][]byte) *http.Response {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := form.CreateFormFile("receipts", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(content)
+	}
+	form.Close()
+
+	cfg := &config.Config{UploadsDir: uploadsDir, BatchConcurrency: 2}
+	service := services.NewReceiptService(nil, nil, nil, services.NewBudgetService(nil, nil, nil, cfg), cfg)
+	handler := NewReceiptHandler(service)
+	app := fiber.New()
+	app.Post("/receipts/upload/batch", handler.UploadBatch)
+
+	req := httptest.NewRequest(http.MethodPost, "/receipts/upload/batch", &body)
+	req.Header.Set(fiber.HeaderContentType, form.FormDataContentType())
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// readTestdata reads an e-receipt of the parser's testdata
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(receiptTestdata, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestUploadBatch(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	entries := map[string][]byte{
+		"tickets/":                  nil,
+		"tickets/mercadona.eml":     readTestdata(t, "mercadona.eml"),
+		"tickets/carrefour.html":    readTestdata(t, "carrefour.html"),
+		"tickets/.DS_Store":         []byte("metadata"),
+		"__MACOSX/tickets/._a.html": []byte("metadata"),
+		"tickets/notes.docx":        []byte("notes"),
+	}
+	for name, content := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(content)
+	}
+	zw.Close()
+
+	uploadsDir := t.TempDir()
+	resp := batchUpload(t, uploadsDir, map[string][]byte{
+		"shoebox.zip": archive.Bytes(),
+		"generic.eml": readTestdata(t, "generic.eml"),
+		"scan.tiff":   []byte("image"),
+	})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(fiber.HeaderContentType) != "application/x-ndjson" {
+		t.Fatalf("status %d with %q, want 200 with JSON lines", resp.StatusCode, resp.Header.Get(fiber.HeaderContentType))
+	}
+
+	// One line per file, in no particular order, then the summary
+	var lines []json.RawMessage
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines = append(lines, json.RawMessage(bytes.Clone(scanner.Bytes())))
+	}
+	if len(lines) != 6 {
+		t.Fatalf("got %d lines, want 5 files and the summary", len(lines))
+	}
+
+	statuses := map[string]string{}
+	for _, line := range lines[:len(lines)-1] {
+		var result dto.BatchFileResult
+		if err := json.Unmarshal(line, &result); err != nil {
+			t.Fatalf("invalid line %s: %v", line, err)
+		}
+		statuses[result.File] = result.Status
+	}
+	want := map[string]string{
+		"shoebox.zip/tickets/mercadona.eml":  "processed",
+		"shoebox.zip/tickets/carrefour.html": "processed",
+		"shoebox.zip/tickets/notes.docx":     "error",
+		"generic.eml":                        "processed",
+		"scan.tiff":                          "error",
+	}
+	for file, status := range want {
+		if statuses[file] != status {
+			t.Errorf("%s = %q, want %q", file, statuses[file], status)
+		}
+	}
+
+	var summary dto.BatchSummary
+	if err := json.Unmarshal(lines[len(lines)-1], &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary != (dto.BatchSummary{Done: true, Total: 5, Saved: 3, Failed: 2}) {
+		t.Errorf("summary = %+v, want 5 files, 3 processed and 2 failed", summary)
+	}
+
+	// Without a database nothing is saved, so no upload is kept
+	if kept, _ := os.ReadDir(uploadsDir); len(kept) != 0 {
+		t.Errorf("kept %d uploads, want none", len(kept))
+	}
+}
+
+func TestUploadBatchInvalidZip(t *testing.T) {
+	uploadsDir := t.TempDir()
+	resp := batchUpload(t, uploadsDir, map[string][]byte{
+		"generic.eml": readTestdata(t, "generic.eml"),
+		"broken.zip":  []byte("not a zip"),
+	})
+
+	// The whole batch is refused, and the files saved before it removed
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.StatusCode)
+	}
+	if kept, _ := os.ReadDir(uploadsDir); len(kept) != 0 {
+		t.Errorf("kept %d uploads, want none", len(kept))
+	}
+}
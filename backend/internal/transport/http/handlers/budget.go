@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+type BudgetHandler struct {
+	budgetService *services.BudgetService
+}
+
+func NewBudgetHandler(budgetService *services.BudgetService) BudgetHandler {
+	return BudgetHandler{
+		budgetService: budgetService,
+	}
+}
+
+// ListBudgets retrieves the budgets the caller sees with their progress in the current
+// period, or in the period containing the date query parameter
+func (h *BudgetHandler) ListBudgets(c fiber.Ctx) error {
+	var date time.Time
+	if dateQuery := c.Query("date"); dateQuery != "" {
+		var err error
+		if date, err = time.Parse("2006-01-02", dateQuery); err != nil {
+			return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("invalid date %q (expected YYYY-MM-DD)", dateQuery))
+		}
+	}
+
+	budgets, err := h.budgetService.ListBudgets(c.Context(), callerScope(c, models.RoleViewer), date)
+	if err != nil {
+		log.Error("Failed to list budgets", "error", err)
+		return c.Status(statusForError(err)).SendString("Failed to list budgets")
+	}
+
+	return c.JSON(budgets)
+}
+
+// CreateBudget adds a budget of the caller
+func (h *BudgetHandler) CreateBudget(c fiber.Ctx) error {
+	var req dto.BudgetRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	budget, err := h.budgetService.CreateBudget(c.Context(), callerScope(c, models.RoleEditor), req)
+	if err != nil {
+		log.Error("Failed to create budget", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusCreated).JSON(budget)
+}
+
+// UpdateBudget replaces a budget
+func (h *BudgetHandler) UpdateBudget(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Budget ID is required")
+	}
+
+	var req dto.BudgetRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	budget, err := h.budgetService.UpdateBudget(c.Context(), callerScope(c, models.RoleEditor), id, req)
+	if err != nil {
+		log.Error("Failed to update budget", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(budget)
+}
+
+// DeleteBudget deletes a budget
+func (h *BudgetHandler) DeleteBudget(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Budget ID is required")
+	}
+
+	if err := h.budgetService.DeleteBudget(c.Context(), callerScope(c, models.RoleEditor), id); err != nil {
+		log.Error("Failed to delete budget", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
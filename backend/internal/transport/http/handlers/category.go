@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// ListCategories retrieves the category taxonomy
+func (h *ReceiptHandler) ListCategories(c fiber.Ctx) error {
+	categories, err := h.receiptService.ListCategories(c.Context())
+	if err != nil {
+		log.Error("Failed to list categories", "error", err)
+		return c.Status(statusForError(err)).SendString("Failed to list categories")
+	}
+
+	return c.JSON(categories)
+}
+
+// CreateCategory adds a category, at the top level or under a parent
+func (h *ReceiptHandler) CreateCategory(c fiber.Ctx) error {
+	var req dto.CategoryRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	category, err := h.receiptService.CreateCategory(c.Context(), req)
+	if err != nil {
+		log.Error("Failed to create category", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusCreated).JSON(category)
+}
+
+// UpdateCategory renames a category or moves it under another parent
+func (h *ReceiptHandler) UpdateCategory(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Category ID is required")
+	}
+
+	var req dto.CategoryRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	category, err := h.receiptService.UpdateCategory(c.Context(), id, req)
+	if err != nil {
+		log.Error("Failed to update category", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(category)
+}
+
+// DeleteCategory deletes a category with its subcategories and rules
+func (h *ReceiptHandler) DeleteCategory(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Category ID is required")
+	}
+
+	if err := h.receiptService.DeleteCategory(c.Context(), id); err != nil {
+		log.Error("Failed to delete category", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// ListCategoryRules retrieves every category rule
+func (h *ReceiptHandler) ListCategoryRules(c fiber.Ctx) error {
+	rules, err := h.receiptService.ListCategoryRules(c.Context())
+	if err != nil {
+		log.Error("Failed to list category rules", "error", err)
+		return c.Status(statusForError(err)).SendString("Failed to list category rules")
+	}
+
+	return c.JSON(rules)
+}
+
+// CreateCategoryRule adds a rule that assigns a category by product name
+// pattern or to a store product
+func (h *ReceiptHandler) CreateCategoryRule(c fiber.Ctx) error {
+	var req dto.CategoryRuleRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	rule, err := h.receiptService.CreateCategoryRule(c.Context(), req)
+	if err != nil {
+		log.Error("Failed to create category rule", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusCreated).JSON(rule)
+}
+
+// DeleteCategoryRule deletes a category rule
+func (h *ReceiptHandler) DeleteCategoryRule(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Rule ID is required")
+	}
+
+	if err := h.receiptService.DeleteCategoryRule(c.Context(), id); err != nil {
+		log.Error("Failed to delete category rule", "id", id, "error", err)
+		return c.Status(http.StatusNotFound).SendString("Category rule not found")
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// Recategorize applies the category rules again and, with "ai", classifies
+// the remaining uncategorised products with the AI
+func (h *ReceiptHandler) Recategorize(c fiber.Ctx) error {
+	var req dto.RecategorizeRequest
+	if len(c.Body()) > 0 {
+		if err := c.Bind().JSON(&req); err != nil {
+			log.Error("Failed to parse request body", "error", err)
+			return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+		}
+	}
+
+	result, err := h.receiptService.Recategorize(c.Context(), req)
+	if err != nil {
+		log.Error("Failed to recategorise products", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(result)
+}
+
+// CategorySpending sums what was spent per category, with the list filters
+func (h *ReceiptHandler) CategorySpending(c fiber.Ctx) error {
+	filter, err := receiptFilterFromQuery(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	spending, err := h.receiptService.CategorySpending(c.Context(), filter)
+	if err != nil {
+		log.Error("Failed to sum category spending", "error", err)
+		return c.Status(statusForError(err)).SendString("Failed to sum category spending")
+	}
+
+	return c.JSON(spending)
+}
+
+// SetProductCategory manually sets the category of one or many products
+func (h *ReceiptHandler) SetProductCategory(c fiber.Ctx) error {
+	var req dto.ProductCategoryRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	result, err := h.receiptService.SetProductCategory(c.Context(), req)
+	if err != nil {
+		log.Error("Failed to set product category", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(result)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+)
+
+const (
+	// eventsKeepAlive is how often an idle event stream sends a comment, so
+	// proxies do not close it
+	eventsKeepAlive = 25 * time.Second
+
+	// eventsMaxAge is how long an event stream lasts before the client has to
+	// reconnect, so its token and households are checked again
+	eventsMaxAge = 10 * time.Minute
+)
+
+// StreamEvents streams receipt and processing events as server-sent events:
+// those of the caller's receipts, of their households and of no one in
+// particular
+func (h *ReceiptHandler) StreamEvents(c fiber.Ctx) error {
+	subscription, err := h.receiptService.SubscribeEvents(c.Context(), callerUser(c))
+	if err != nil {
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	return c.SendStreamWriter(func(w *bufio.Writer) {
+		defer h.receiptService.UnsubscribeEvents(subscription)
+
+		keepAlive := time.NewTicker(eventsKeepAlive)
+		defer keepAlive.Stop()
+		expired := time.After(eventsMaxAge)
+
+		// Clients reconnect after a few seconds when the stream drops
+		fmt.Fprint(w, "retry: 3000\n\n")
+		for {
+			if err := w.Flush(); err != nil {
+				log.Debug("Event client disconnected", "error", err)
+				return
+			}
+
+			select {
+			case event := <-subscription.Events():
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Warn("Failed to encode event", "type", event.Type, "error", err)
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case <-expired:
+				w.Flush()
+				return
+			}
+		}
+	})
+}
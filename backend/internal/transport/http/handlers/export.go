@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+)
+
+// ExportItems streams the items of the receipts matching the list filters, as
+// CSV, JSON Lines or XLSX rows, or as ledger, hledger or beancount transactions
+// with one posting per item (postings=item) or per category (postings=category)
+func (h *ReceiptHandler) ExportItems(c fiber.Ctx) error {
+	filter, err := receiptFilterFromQuery(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	postings := c.Query("postings", "item")
+	if postings != "item" && postings != "category" {
+		return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("invalid postings %q (expected item or category)", postings))
+	}
+
+	itemExport, err := h.receiptService.NewItemExport(filter, c.Query("format", "csv"), postings == "category")
+	if err != nil {
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	// Writing outlives the handler, so it gets its own context, cancelled
+	// when the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+
+	filename := "ticketer-" + time.Now().Format("2006-01-02") + itemExport.Format.Extension
+	c.Set("Content-Type", itemExport.Format.ContentType)
+	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	return c.SendStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		// A failed write (the client went away) stops reading from the database
+		if err := itemExport.WriteTo(ctx, &clientWriter{w: w, cancel: cancel}); err != nil {
+			log.Error("Failed to export items", "format", itemExport.Format.Name, "error", err)
+			return
+		}
+		if err := w.Flush(); err != nil {
+			log.Warn("Export client disconnected", "error", err)
+		}
+	})
+}
+
+// clientWriter writes to the client, cancelling the export as soon as a write fails
+type clientWriter struct {
+	w      *bufio.Writer
+	cancel context.CancelFunc
+}
+
+func (f *clientWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err != nil {
+		f.cancel()
+	}
+	return n, err
+}
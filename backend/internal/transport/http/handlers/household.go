@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+type HouseholdHandler struct {
+	householdService *services.HouseholdService
+}
+
+func NewHouseholdHandler(householdService *services.HouseholdService) HouseholdHandler {
+	return HouseholdHandler{
+		householdService: householdService,
+	}
+}
+
+// RequireReceiptRole returns a middleware that only lets through the requests
+// whose caller has at least a role in the household of the receipt of the id
+// parameter, when it is in one
+func (h *HouseholdHandler) RequireReceiptRole(role string) fiber.Handler {
+	return requireRole(role, "id", h.householdService.AuthorizeReceipt)
+}
+
+// RequireItemRole is RequireReceiptRole for the receipt of the item of the
+// itemId parameter
+func (h *HouseholdHandler) RequireItemRole(role string) fiber.Handler {
+	return requireRole(role, "itemId", h.householdService.AuthorizeItem)
+}
+
+// RequireReprocessingRole is RequireReceiptRole for the receipt of the
+// reprocessing of the id parameter
+func (h *HouseholdHandler) RequireReprocessingRole(role string) fiber.Handler {
+	return requireRole(role, "id", h.householdService.AuthorizeReprocessing)
+}
+
+// requireRole returns a middleware that authorises the caller for the ID of a
+// route parameter
+func requireRole(role, param string, authorize func(ctx context.Context, caller *services.Caller, id, role string) error) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if err := authorize(c.Context(), caller(c), c.Params(param), role); err != nil {
+			return c.Status(statusForError(err)).SendString(err.Error())
+		}
+
+		return c.Next()
+	}
+}
+
+// ListHouseholds retrieves the households of the caller
+func (h *HouseholdHandler) ListHouseholds(c fiber.Ctx) error {
+	households, err := h.householdService.ListHouseholds(c.Context(), callerUser(c))
+	if err != nil {
+		log.Error("Failed to list households", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(households)
+}
+
+// GetHousehold retrieves a household with its members
+func (h *HouseholdHandler) GetHousehold(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Household ID is required")
+	}
+
+	household, err := h.householdService.GetHousehold(c.Context(), callerUser(c), id)
+	if err != nil {
+		log.Error("Failed to get household", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(household)
+}
+
+// CreateHousehold creates a household owned by the caller
+func (h *HouseholdHandler) CreateHousehold(c fiber.Ctx) error {
+	var req dto.HouseholdRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	household, err := h.householdService.CreateHousehold(c.Context(), callerUser(c), req)
+	if err != nil {
+		log.Error("Failed to create household", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusCreated).JSON(household)
+}
+
+// RenameHousehold renames a household
+func (h *HouseholdHandler) RenameHousehold(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Household ID is required")
+	}
+
+	var req dto.HouseholdRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	household, err := h.householdService.RenameHousehold(c.Context(), callerUser(c), id, req)
+	if err != nil {
+		log.Error("Failed to rename household", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(household)
+}
+
+// DeleteHousehold deletes a household without receipts
+func (h *HouseholdHandler) DeleteHousehold(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Household ID is required")
+	}
+
+	if err := h.householdService.DeleteHousehold(c.Context(), callerUser(c), id); err != nil {
+		log.Error("Failed to delete household", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// SetMemberRole changes the role of a member of a household
+func (h *HouseholdHandler) SetMemberRole(c fiber.Ctx) error {
+	id, member := c.Params("id"), c.Params("member")
+	if id == "" || member == "" {
+		return c.Status(http.StatusBadRequest).SendString("Household ID and member are required")
+	}
+
+	var req dto.HouseholdMemberRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	household, err := h.householdService.SetMemberRole(c.Context(), callerUser(c), id, member, req)
+	if err != nil {
+		log.Error("Failed to change household member role", "id", id, "member", member, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(household)
+}
+
+// RemoveMember removes a member from a household, or the caller leaves it
+func (h *HouseholdHandler) RemoveMember(c fiber.Ctx) error {
+	id, member := c.Params("id"), c.Params("member")
+	if id == "" || member == "" {
+		return c.Status(http.StatusBadRequest).SendString("Household ID and member are required")
+	}
+
+	if err := h.householdService.RemoveMember(c.Context(), callerUser(c), id, member); err != nil {
+		log.Error("Failed to remove household member", "id", id, "member", member, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// InviteMember invites a user to a household
+func (h *HouseholdHandler) InviteMember(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Household ID is required")
+	}
+
+	var req dto.HouseholdInvitationRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	invitation, err := h.householdService.InviteMember(c.Context(), callerUser(c), id, req)
+	if err != nil {
+		log.Error("Failed to invite household member", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusCreated).JSON(invitation)
+}
+
+// ListInvitations retrieves the pending invitations of the caller
+func (h *HouseholdHandler) ListInvitations(c fiber.Ctx) error {
+	invitations, err := h.householdService.ListInvitations(c.Context(), callerUser(c))
+	if err != nil {
+		log.Error("Failed to list invitations", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(invitations)
+}
+
+// AcceptInvitation makes the caller a member of the household they were invited to
+func (h *HouseholdHandler) AcceptInvitation(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Invitation ID is required")
+	}
+
+	household, err := h.householdService.AcceptInvitation(c.Context(), callerUser(c), id)
+	if err != nil {
+		log.Error("Failed to accept invitation", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(household)
+}
+
+// DeleteInvitation declines or cancels an invitation
+func (h *HouseholdHandler) DeleteInvitation(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Invitation ID is required")
+	}
+
+	if err := h.householdService.DeleteInvitation(c.Context(), callerUser(c), id); err != nil {
+		log.Error("Failed to delete invitation", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// MoveReceipt moves a receipt to a household, or out of any household
+func (h *HouseholdHandler) MoveReceipt(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Receipt ID is required")
+	}
+
+	var req dto.MoveReceiptRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	receipt, err := h.householdService.MoveReceipt(c.Context(), caller(c), id, req)
+	if err != nil {
+		log.Error("Failed to move receipt", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(receipt)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/services/importer"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// ImportReceipts imports CSV files or ticketer JSON backups, each one all or
+// nothing, returning a report per file.
+//
+// Form fields: files (or file), format (csv, json or auto), mapping (the
+// JSON column mapping of CSV files), owner, household and dry_run. The
+// receipts belong to the caller; an admin may name their owner, or import
+// them with the owners of the file by leaving it out.
+func (h *ReceiptHandler) ImportReceipts(c fiber.Ctx) error {
+	form, err := c.MultipartForm()
+	if err != nil {
+		log.Error("Failed to parse multipart form", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Failed to parse form")
+	}
+
+	headers := append(form.File["files"], form.File["file"]...)
+	if len(headers) == 0 {
+		return c.Status(http.StatusBadRequest).SendString("No file uploaded")
+	}
+
+	owner, err := receiptOwner(c, c.FormValue("owner"))
+	if err != nil {
+		return unauthorized(c, err)
+	}
+	if c.FormValue("owner") == "" && authorized(c, models.ScopeAdmin) {
+		owner = ""
+	}
+
+	opts := services.ImportOptions{
+		Format:      c.FormValue("format"),
+		Mapping:     importer.DefaultMapping(),
+		Owner:       owner,
+		HouseholdID: c.FormValue("household"),
+	}
+	if value := c.FormValue("dry_run"); value != "" {
+		if opts.DryRun, err = strconv.ParseBool(value); err != nil {
+			return c.Status(http.StatusBadRequest).SendString("Invalid dry_run, expected true or false")
+		}
+	}
+	if spec := c.FormValue("mapping"); spec != "" {
+		if opts.Mapping, err = importer.ParseMapping([]byte(spec)); err != nil {
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+	}
+
+	reports := make([]*dto.ImportReport, 0, len(headers))
+	for _, fileHeader := range headers {
+		file, err := fileHeader.Open()
+		if err != nil {
+			log.Error("Failed to open uploaded file", "error", err)
+			return c.Status(http.StatusInternalServerError).SendString("Failed to open file")
+		}
+
+		report, err := h.receiptService.ImportFile(c.Context(), fileHeader.Filename, file, opts)
+		file.Close()
+		if err != nil {
+			log.Error("Failed to import file", "file", fileHeader.Filename, "error", err)
+			return c.Status(statusForError(err)).SendString(fmt.Sprintf("Failed to import %s: %v", fileHeader.Filename, err))
+		}
+		reports = append(reports, report)
+	}
+
+	return c.JSON(reports)
+}
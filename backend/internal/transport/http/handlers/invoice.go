@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/services/parser"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// ImportInvoice imports a Facturae or UBL e-invoice, returning the outcome of
+// each invoice of the file
+func (h *ReceiptHandler) ImportInvoice(c fiber.Ctx) error {
+	fileHeader, err := c.FormFile("invoice")
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString("No invoice uploaded")
+	}
+
+	if !parser.IsInvoiceFile(fileHeader.Filename) {
+		return c.Status(http.StatusBadRequest).SendString("Invalid file format. Only XML and XSIG invoices are allowed")
+	}
+
+	owner, err := receiptOwner(c, c.FormValue("owner"))
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Error("Failed to open uploaded file", "error", err)
+		return c.Status(http.StatusInternalServerError).SendString("Failed to open file")
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Error("Failed to read uploaded file", "error", err)
+		return c.Status(http.StatusInternalServerError).SendString("Failed to read file")
+	}
+
+	results, err := h.receiptService.ImportInvoice(c.Context(), data, owner, c.FormValue("household"))
+	if err != nil {
+		log.Error("Failed to import invoice", "file", fileHeader.Filename, "error", err)
+		return c.Status(statusForError(err)).SendString(fmt.Sprintf("Failed to import invoice: %v", err))
+	}
+
+	response := make([]dto.InvoiceImportResult, len(results))
+	for i, result := range results {
+		response[i] = invoiceResultToDTO(result)
+	}
+
+	return c.JSON(response)
+}
+
+// invoiceResultToDTO converts the outcome of importing an invoice to its response
+func invoiceResultToDTO(result services.InvoiceResult) dto.InvoiceImportResult {
+	response := dto.InvoiceImportResult{
+		DocumentNumber: result.DocumentNumber,
+		Receipt:        result.Receipt,
+	}
+
+	switch {
+	case result.DuplicateOf() != "":
+		response.Status = "duplicate"
+		response.DuplicateOf = result.DuplicateOf()
+	case result.SaveErr != nil:
+		response.Status = "error"
+		response.Error = result.SaveErr.Error()
+	default:
+		response.Status = "saved"
+	}
+
+	return response
+}
@@ -1,15 +1,26 @@
 package handlers
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/log"
+	"github.com/google/uuid"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
 	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/services/export"
+	"github.com/vieitesss/ticketer/internal/services/importer"
+	"github.com/vieitesss/ticketer/internal/services/parser"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
 )
 
 type ReceiptHandler struct {
@@ -45,44 +56,141 @@ func (h *ReceiptHandler) UploadAndProcess(c fiber.Ctx) error {
 	defer file.Close()
 
 	// Validate file extension
-	ext := filepath.Ext(fileHeader.Filename)
-	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
-		return c.Status(http.StatusBadRequest).SendString("Invalid file format. Only JPG, JPEG, and PNG are allowed")
+	ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
+	if !services.IsSupportedFile(fileHeader.Filename) {
+		return c.Status(http.StatusBadRequest).SendString("Invalid file format. Only JPG, JPEG, PNG, PDF, TXT, HTML and EML are allowed")
 	}
 
-	// Create uploads directory if it doesn't exist
-	uploadsDir := "/app/uploads"
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-		log.Error("Failed to create uploads directory", "error", err)
-		return c.Status(http.StatusInternalServerError).SendString("Internal server error")
+	if !h.receiptService.CanProcess(fileHeader.Filename) {
+		return c.Status(http.StatusServiceUnavailable).SendString(services.ErrNoExtractor.Error())
 	}
 
-	// Save file temporarily
-	tempPath := filepath.Join(uploadsDir, fileHeader.Filename)
-	dst, err := os.Create(tempPath)
+	owner, err := receiptOwner(c, c.FormValue("owner"))
 	if err != nil {
-		log.Error("Failed to create temp file", "path", tempPath, "error", err)
-		return c.Status(http.StatusInternalServerError).SendString("Failed to save file")
+		return unauthorized(c, err)
 	}
-	defer dst.Close()
 
-	if _, err := io.Copy(dst, file); err != nil {
-		log.Error("Failed to save file", "path", tempPath, "error", err)
+	// Save file under a unique name, it is kept for reprocessing
+	tempPath, err := h.receiptService.SaveUpload(file, ext)
+	if err != nil {
+		log.Error("Failed to save file", "error", err)
 		return c.Status(http.StatusInternalServerError).SendString("Failed to save file")
 	}
 
+	opts := services.ProcessOptions{Owner: owner, HouseholdID: c.FormValue("household")}
+	if fiber.Query[bool](c, "stream") {
+		return h.streamUpload(c, tempPath, opts)
+	}
+
 	// Process receipt through service layer
-	receipt, err := h.receiptService.ProcessReceipt(c.Context(), tempPath)
+	result, err := h.receiptService.ProcessReceiptFileWith(c.Context(), tempPath, opts)
 	if err != nil {
 		log.Error("Failed to process receipt", "path", tempPath, "error", err)
-		return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("Failed to process receipt: %v", err))
+		h.receiptService.DiscardUpload(tempPath)
+		return c.Status(statusForError(err)).SendString(fmt.Sprintf("Failed to process receipt: %v", err))
 	}
 
-	// Clean up temp file
-	os.Remove(tempPath)
+	// Only keep the original image of saved receipts
+	if result.Receipt.ID == "" {
+		h.receiptService.DiscardUpload(tempPath)
+	}
 
 	// Return JSON response
-	return c.JSON(receipt)
+	return c.JSON(result.Receipt)
+}
+
+// streamUpload processes a saved upload, streaming each stage as a JSON line
+// as it happens. The last line is the final stage, with the receipt when it
+// was extracted; closing the connection cancels the processing.
+func (h *ReceiptHandler) streamUpload(c fiber.Ctx, path string, opts services.ProcessOptions) error {
+	// Processing outlives the handler, so it gets its own context, cancelled
+	// when the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	stages := make(chan dto.ProcessingProgress)
+	go func() {
+		defer close(stages)
+
+		opts.Progress = func(progress dto.ProcessingProgress) { stages <- progress }
+		result, err := h.receiptService.ProcessReceiptFileWith(ctx, path, opts)
+		// Only keep the original image of saved receipts
+		if err != nil || result.Receipt.ID == "" {
+			h.receiptService.DiscardUpload(path)
+		}
+	}()
+
+	c.Set("Content-Type", "application/x-ndjson")
+	return c.SendStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		// Drain every stage, even after a disconnect, so processing is not left blocked
+		encoder := json.NewEncoder(w)
+		for progress := range stages {
+			if ctx.Err() != nil {
+				continue
+			}
+			err := encoder.Encode(progress)
+			if err == nil {
+				err = w.Flush()
+			}
+			if err != nil {
+				log.Warn("Upload client disconnected, cancelling", "error", err)
+				cancel()
+			}
+		}
+	})
+}
+
+// CreateReceipt saves a manually entered receipt
+func (h *ReceiptHandler) CreateReceipt(c fiber.Ctx) error {
+	var req dto.CreateReceiptRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	owner, err := receiptOwner(c, req.Owner)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+
+	receipt := req.ToModel()
+	receipt.Owner = owner
+	created, err := h.receiptService.CreateReceipt(c.Context(), receipt)
+	if err != nil {
+		log.Error("Failed to create receipt", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusCreated).JSON(created)
+}
+
+// statusForError maps service errors to HTTP status codes
+func statusForError(err error) int {
+	var validationErr *services.ValidationError
+	var duplicateErr *database.DuplicateReceiptError
+	switch {
+	case errors.As(err, &validationErr), errors.Is(err, parser.ErrInvalidInvoice), errors.Is(err, export.ErrUnknownFormat),
+		errors.Is(err, importer.ErrUnknownFormat), errors.Is(err, database.ErrInvalidPattern), errors.Is(err, database.ErrNotParticipant):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrCategoryNotFound), errors.Is(err, database.ErrStoreNotFound), errors.Is(err, database.ErrRuleNotFound),
+		errors.Is(err, database.ErrReceiptNotFound), errors.Is(err, database.ErrItemNotFound), errors.Is(err, database.ErrBudgetNotFound),
+		errors.Is(err, database.ErrWebhookNotFound), errors.Is(err, database.ErrDeliveryNotFound), errors.Is(err, database.ErrParticipantNotFound),
+		errors.Is(err, database.ErrSettlementNotFound), errors.Is(err, database.ErrTokenNotFound), errors.Is(err, database.ErrReprocessingNotFound),
+		errors.Is(err, database.ErrHouseholdNotFound), errors.Is(err, database.ErrMemberNotFound), errors.Is(err, database.ErrInvitationNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrTokenRequired), errors.Is(err, services.ErrInvalidToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrInsufficientScope), errors.Is(err, services.ErrHouseholdRole):
+		return http.StatusForbidden
+	case errors.As(err, &duplicateErr), errors.Is(err, database.ErrCategoryConflict), errors.Is(err, database.ErrParticipantConflict),
+		errors.Is(err, database.ErrReprocessingResolved), errors.Is(err, database.ErrHouseholdNotEmpty), errors.Is(err, database.ErrMemberConflict),
+		errors.Is(err, database.ErrLastOwner), errors.Is(err, database.ErrSplitItemsDropped):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrNoExtractor), errors.Is(err, services.ErrNoDatabase):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 // GetReceipt retrieves a single receipt with full details
@@ -101,6 +209,38 @@ func (h *ReceiptHandler) GetReceipt(c fiber.Ctx) error {
 	return c.JSON(receipt)
 }
 
+// GetExtraction retrieves the extraction provenance of a receipt
+func (h *ReceiptHandler) GetExtraction(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Receipt ID is required")
+	}
+
+	extraction, err := h.receiptService.GetExtraction(c.Context(), id)
+	if err != nil {
+		log.Error("Failed to get extraction", "id", id, "error", err)
+		return c.Status(http.StatusNotFound).SendString("Extraction not found")
+	}
+
+	return c.JSON(extraction)
+}
+
+// ListExtractions retrieves every extraction attempt of a receipt, including escalations
+func (h *ReceiptHandler) ListExtractions(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Receipt ID is required")
+	}
+
+	extractions, err := h.receiptService.ListExtractions(c.Context(), id)
+	if err != nil {
+		log.Error("Failed to list extractions", "id", id, "error", err)
+		return c.Status(http.StatusInternalServerError).SendString("Failed to list extractions")
+	}
+
+	return c.JSON(extractions)
+}
+
 // ListReceipts retrieves all receipts (for the sidebar list)
 func (h *ReceiptHandler) ListReceipts(c fiber.Ctx) error {
 	limit := 50
@@ -113,7 +253,12 @@ func (h *ReceiptHandler) ListReceipts(c fiber.Ctx) error {
 		fmt.Sscanf(offsetQuery, "%d", &offset)
 	}
 
-	receipts, err := h.receiptService.ListReceipts(c.Context(), limit, offset)
+	filter, err := receiptFilterFromQuery(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	receipts, err := h.receiptService.ListReceipts(c.Context(), filter, limit, offset)
 	if err != nil {
 		log.Error("Failed to list receipts", "error", err)
 		return c.Status(http.StatusInternalServerError).SendString("Failed to list receipts")
@@ -122,6 +267,113 @@ func (h *ReceiptHandler) ListReceipts(c fiber.Ctx) error {
 	return c.JSON(receipts)
 }
 
+// receiptFilterFromQuery reads the store, date range, category, tag and
+// household filters of list, export and aggregate requests, which only see
+// the households of the caller
+func receiptFilterFromQuery(c fiber.Ctx) (database.ReceiptFilter, error) {
+	filter := database.ReceiptFilter{
+		StoreName:   c.Query("store"),
+		StartDate:   c.Query("start_date"),
+		EndDate:     c.Query("end_date"),
+		CategoryID:  c.Query("category"),
+		Tag:         services.NormalizeTag(c.Query("tag")),
+		Member:      callerUser(c),
+		HouseholdID: c.Query("household"),
+		Admin:       authorized(c, models.ScopeAdmin),
+	}
+
+	if filter.CategoryID != "" && uuid.Validate(filter.CategoryID) != nil {
+		return filter, fmt.Errorf("invalid category %q (expected a category ID)", filter.CategoryID)
+	}
+	if filter.HouseholdID != "" && uuid.Validate(filter.HouseholdID) != nil {
+		return filter, fmt.Errorf("invalid household %q (expected a household ID)", filter.HouseholdID)
+	}
+
+	for _, date := range []string{filter.StartDate, filter.EndDate} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return filter, fmt.Errorf("invalid date %q (expected YYYY-MM-DD)", date)
+		}
+	}
+
+	return filter, nil
+}
+
+// ReprocessReceipt re-runs the extraction of a receipt and returns the proposed changes
+func (h *ReceiptHandler) ReprocessReceipt(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Receipt ID is required")
+	}
+
+	reprocessing, err := h.receiptService.ReprocessReceipt(c.Context(), id)
+	if err != nil {
+		log.Error("Failed to reprocess receipt", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(fmt.Sprintf("Failed to reprocess receipt: %v", err))
+	}
+
+	return c.JSON(reprocessing)
+}
+
+// ReprocessReceipts re-runs the extraction of all receipts matching a store and date range
+func (h *ReceiptHandler) ReprocessReceipts(c fiber.Ctx) error {
+	var req dto.ReprocessRequest
+	if len(c.Body()) > 0 {
+		if err := c.Bind().JSON(&req); err != nil {
+			log.Error("Failed to parse request body", "error", err)
+			return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+		}
+	}
+
+	results, err := h.receiptService.ReprocessReceipts(c.Context(), database.ReceiptFilter{
+		StoreName: req.StoreName,
+		StartDate: req.StartDate,
+		EndDate:   req.EndDate,
+		Member:    callerUser(c),
+		Role:      models.RoleEditor,
+		Admin:     authorized(c, models.ScopeAdmin),
+	})
+	if err != nil {
+		log.Error("Failed to reprocess receipts", "error", err)
+		return c.Status(statusForError(err)).SendString(fmt.Sprintf("Failed to reprocess receipts: %v", err))
+	}
+
+	return c.JSON(results)
+}
+
+// AcceptReprocessing applies a proposed re-extraction to its receipt
+func (h *ReceiptHandler) AcceptReprocessing(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Reprocessing ID is required")
+	}
+
+	receipt, err := h.receiptService.AcceptReprocessing(c.Context(), id)
+	if err != nil {
+		log.Error("Failed to accept reprocessing", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(fmt.Sprintf("Failed to accept reprocessing: %v", err))
+	}
+
+	return c.JSON(receipt)
+}
+
+// RejectReprocessing discards a proposed re-extraction
+func (h *ReceiptHandler) RejectReprocessing(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Reprocessing ID is required")
+	}
+
+	if err := h.receiptService.RejectReprocessing(c.Context(), id); err != nil {
+		log.Error("Failed to reject reprocessing", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(fmt.Sprintf("Failed to reject reprocessing: %v", err))
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
 // DeleteReceipt deletes a receipt by ID
 func (h *ReceiptHandler) DeleteReceipt(c fiber.Ctx) error {
 	id := c.Params("id")
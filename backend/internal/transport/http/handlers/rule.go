@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// ListRules retrieves the automation rules
+func (h *ReceiptHandler) ListRules(c fiber.Ctx) error {
+	rules, err := h.receiptService.ListRules(c.Context(), callerScope(c, models.RoleViewer))
+	if err != nil {
+		log.Error("Failed to list rules", "error", err)
+		return c.Status(statusForError(err)).SendString("Failed to list rules")
+	}
+
+	return c.JSON(rules)
+}
+
+// CreateRule adds an automation rule of the caller
+func (h *ReceiptHandler) CreateRule(c fiber.Ctx) error {
+	var req dto.RuleRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	rule, err := h.receiptService.CreateRule(c.Context(), callerScope(c, models.RoleEditor), req)
+	if err != nil {
+		log.Error("Failed to create rule", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusCreated).JSON(rule)
+}
+
+// UpdateRule replaces an automation rule
+func (h *ReceiptHandler) UpdateRule(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Rule ID is required")
+	}
+
+	var req dto.RuleRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	rule, err := h.receiptService.UpdateRule(c.Context(), callerScope(c, models.RoleEditor), id, req)
+	if err != nil {
+		log.Error("Failed to update rule", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(rule)
+}
+
+// DeleteRule deletes an automation rule
+func (h *ReceiptHandler) DeleteRule(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Rule ID is required")
+	}
+
+	if err := h.receiptService.DeleteRule(c.Context(), callerScope(c, models.RoleEditor), id); err != nil {
+		log.Error("Failed to delete rule", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// PreviewRule tests a rule, without saving it, against the most recent
+// receipts matching the query filters (store, start_date, end_date, category,
+// tag), up to limit of them
+func (h *ReceiptHandler) PreviewRule(c fiber.Ctx) error {
+	var req dto.RuleRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	filter, err := receiptFilterFromQuery(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	limit := 0
+	if limitQuery := c.Query("limit"); limitQuery != "" {
+		if _, err := fmt.Sscanf(limitQuery, "%d", &limit); err != nil {
+			return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("invalid limit %q", limitQuery))
+		}
+	}
+
+	preview, err := h.receiptService.PreviewRule(c.Context(), req, filter, limit)
+	if err != nil {
+		log.Error("Failed to preview rule", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(preview)
+}
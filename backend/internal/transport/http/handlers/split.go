@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+type SplitHandler struct {
+	splitService *services.SplitService
+}
+
+func NewSplitHandler(splitService *services.SplitService) SplitHandler {
+	return SplitHandler{
+		splitService: splitService,
+	}
+}
+
+// ListParticipants retrieves the participants the caller sees
+func (h *SplitHandler) ListParticipants(c fiber.Ctx) error {
+	participants, err := h.splitService.ListParticipants(c.Context(), callerScope(c, models.RoleViewer))
+	if err != nil {
+		log.Error("Failed to list participants", "error", err)
+		return c.Status(statusForError(err)).SendString("Failed to list participants")
+	}
+
+	return c.JSON(participants)
+}
+
+// CreateParticipant adds a person receipts can be split with
+func (h *SplitHandler) CreateParticipant(c fiber.Ctx) error {
+	var req dto.ParticipantRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	participant, err := h.splitService.CreateParticipant(c.Context(), callerScope(c, models.RoleViewer), req)
+	if err != nil {
+		log.Error("Failed to create participant", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusCreated).JSON(participant)
+}
+
+// DeleteParticipant deletes a participant who is on no split receipt or settlement
+func (h *SplitHandler) DeleteParticipant(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Participant ID is required")
+	}
+
+	if err := h.splitService.DeleteParticipant(c.Context(), callerScope(c, models.RoleEditor), id); err != nil {
+		log.Error("Failed to delete participant", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// GetReceiptSplit retrieves how a receipt is split and what each participant owes
+func (h *SplitHandler) GetReceiptSplit(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Receipt ID is required")
+	}
+
+	split, err := h.splitService.GetReceiptSplit(c.Context(), id)
+	if err != nil {
+		log.Error("Failed to get receipt split", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(split)
+}
+
+// UpdateReceiptSplit replaces the participants, payer and item shares of a receipt
+func (h *SplitHandler) UpdateReceiptSplit(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Receipt ID is required")
+	}
+
+	var req dto.ReceiptSplitRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	split, err := h.splitService.UpdateReceiptSplit(c.Context(), callerScope(c, models.RoleViewer), id, req)
+	if err != nil {
+		log.Error("Failed to update receipt split", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(split)
+}
+
+// DeleteReceiptSplit stops splitting a receipt
+func (h *SplitHandler) DeleteReceiptSplit(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Receipt ID is required")
+	}
+
+	if err := h.splitService.DeleteReceiptSplit(c.Context(), id); err != nil {
+		log.Error("Failed to delete receipt split", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// UpdateItemSplit replaces the fractions of an item assigned to participants
+func (h *SplitHandler) UpdateItemSplit(c fiber.Ctx) error {
+	itemID := c.Params("itemId")
+	if itemID == "" {
+		return c.Status(http.StatusBadRequest).SendString("Item ID is required")
+	}
+
+	var req dto.ItemSplitRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	split, err := h.splitService.UpdateItemSplit(c.Context(), callerScope(c, models.RoleViewer), itemID, req)
+	if err != nil {
+		log.Error("Failed to update item split", "id", itemID, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(split)
+}
+
+// SplitBalances retrieves who owes whom across the split receipts and
+// settlements the caller sees
+func (h *SplitHandler) SplitBalances(c fiber.Ctx) error {
+	filter := database.ReceiptFilter{Member: callerUser(c), Admin: authorized(c, models.ScopeAdmin)}
+	balances, err := h.splitService.SplitBalances(c.Context(), filter)
+	if err != nil {
+		log.Error("Failed to compute balances", "error", err)
+		return c.Status(statusForError(err)).SendString("Failed to compute balances")
+	}
+
+	return c.JSON(balances)
+}
+
+// ListSettlements retrieves the settlements the caller sees
+func (h *SplitHandler) ListSettlements(c fiber.Ctx) error {
+	settlements, err := h.splitService.ListSettlements(c.Context(), callerScope(c, models.RoleViewer))
+	if err != nil {
+		log.Error("Failed to list settlements", "error", err)
+		return c.Status(statusForError(err)).SendString("Failed to list settlements")
+	}
+
+	return c.JSON(settlements)
+}
+
+// CreateSettlement records a payment from a participant to another
+func (h *SplitHandler) CreateSettlement(c fiber.Ctx) error {
+	var req dto.SettlementRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	settlement, err := h.splitService.CreateSettlement(c.Context(), callerScope(c, models.RoleViewer), req)
+	if err != nil {
+		log.Error("Failed to create settlement", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusCreated).JSON(settlement)
+}
+
+// DeleteSettlement deletes a settlement
+func (h *SplitHandler) DeleteSettlement(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Settlement ID is required")
+	}
+
+	if err := h.splitService.DeleteSettlement(c.Context(), callerScope(c, models.RoleEditor), id); err != nil {
+		log.Error("Failed to delete settlement", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
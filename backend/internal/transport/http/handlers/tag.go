@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// UpdateReceipt edits the tags, notes and business flag of a receipt
+func (h *ReceiptHandler) UpdateReceipt(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Receipt ID is required")
+	}
+
+	var req dto.UpdateReceiptRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	receipt, err := h.receiptService.UpdateReceipt(c.Context(), id, req)
+	if err != nil {
+		log.Error("Failed to update receipt", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(receipt)
+}
+
+// UpdateItemAnnotations edits the tags and notes of an item, returns its receipt
+func (h *ReceiptHandler) UpdateItemAnnotations(c fiber.Ctx) error {
+	itemID := c.Params("itemId")
+	if itemID == "" {
+		return c.Status(http.StatusBadRequest).SendString("Item ID is required")
+	}
+
+	var req dto.UpdateItemAnnotationsRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	receipt, err := h.receiptService.UpdateItemAnnotations(c.Context(), itemID, req)
+	if err != nil {
+		log.Error("Failed to update item", "itemId", itemID, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(receipt)
+}
+
+// ListTags retrieves every tag in use on the receipts the caller sees, with
+// the list filters
+func (h *ReceiptHandler) ListTags(c fiber.Ctx) error {
+	filter, err := receiptFilterFromQuery(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	tags, err := h.receiptService.ListTags(c.Context(), filter)
+	if err != nil {
+		log.Error("Failed to list tags", "error", err)
+		return c.Status(statusForError(err)).SendString("Failed to list tags")
+	}
+
+	return c.JSON(tags)
+}
+
+// TagSpending sums what was spent per tag, with the list filters
+func (h *ReceiptHandler) TagSpending(c fiber.Ctx) error {
+	filter, err := receiptFilterFromQuery(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	spending, err := h.receiptService.TagSpending(c.Context(), filter)
+	if err != nil {
+		log.Error("Failed to sum tag spending", "error", err)
+		return c.Status(statusForError(err)).SendString("Failed to sum tag spending")
+	}
+
+	return c.JSON(spending)
+}
+
+// RenameTag renames a tag on the receipts the caller edits, merging it into
+// the new name if in use
+func (h *ReceiptHandler) RenameTag(c fiber.Ctx) error {
+	tag, err := tagParam(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString("Invalid tag")
+	}
+
+	var req dto.RenameTagRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	changes, err := h.receiptService.RenameTag(c.Context(), editableReceipts(c), tag, req)
+	if err != nil {
+		log.Error("Failed to rename tag", "tag", tag, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(changes)
+}
+
+// MergeTags replaces several tags with one on the receipts the caller edits
+func (h *ReceiptHandler) MergeTags(c fiber.Ctx) error {
+	var req dto.MergeTagsRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	changes, err := h.receiptService.MergeTags(c.Context(), editableReceipts(c), req)
+	if err != nil {
+		log.Error("Failed to merge tags", "tags", req.Tags, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(changes)
+}
+
+// DeleteTag removes a tag from the receipts the caller edits and their items
+func (h *ReceiptHandler) DeleteTag(c fiber.Ctx) error {
+	tag, err := tagParam(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString("Invalid tag")
+	}
+
+	changes, err := h.receiptService.DeleteTag(c.Context(), editableReceipts(c), tag)
+	if err != nil {
+		log.Error("Failed to delete tag", "tag", tag, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(changes)
+}
+
+// editableReceipts returns the filter of the receipts whose tags a request
+// changes: all those the caller edits
+func editableReceipts(c fiber.Ctx) database.ReceiptFilter {
+	return database.ReceiptFilter{Member: callerUser(c), Role: models.RoleEditor, Admin: authorized(c, models.ScopeAdmin)}
+}
+
+// tagParam reads the URL-encoded tag of the path
+func tagParam(c fiber.Ctx) (string, error) {
+	return url.PathUnescape(c.Params("tag"))
+}
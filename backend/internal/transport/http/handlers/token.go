@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+// callerKey is where Authenticate keeps the caller of a request
+const callerKey = "caller"
+
+// eventsPath is the event stream, the one route that also takes its token
+// as ?access_token=: browsers cannot send headers with an EventSource
+const eventsPath = "/events"
+
+type TokenHandler struct {
+	tokenService *services.TokenService
+}
+
+func NewTokenHandler(tokenService *services.TokenService) TokenHandler {
+	return TokenHandler{
+		tokenService: tokenService,
+	}
+}
+
+// Authenticate is a middleware that identifies the caller of every request
+// by the API token of its Authorization header, for RequireScope to check
+func (h *TokenHandler) Authenticate(c fiber.Ctx) error {
+	token, ok := bearerToken(c.Get(fiber.HeaderAuthorization))
+	if !ok {
+		return unauthorized(c, services.ErrInvalidToken)
+	}
+	if token == "" && c.Path() == eventsPath {
+		token = c.Query("access_token")
+	}
+
+	caller, err := h.tokenService.Authenticate(c.Context(), token)
+	if err != nil {
+		return unauthorized(c, err)
+	}
+	c.Locals(callerKey, caller)
+
+	return c.Next()
+}
+
+// RequireScope returns a middleware that only lets through the requests whose
+// caller may use a scope
+func RequireScope(scope string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		caller, ok := c.Locals(callerKey).(*services.Caller)
+		if !ok {
+			return unauthorized(c, services.ErrTokenRequired)
+		}
+		if err := caller.Authorize(scope); err != nil {
+			return unauthorized(c, err)
+		}
+
+		return c.Next()
+	}
+}
+
+// caller returns the caller of a request, one without a token when it was
+// not authenticated
+func caller(c fiber.Ctx) *services.Caller {
+	if caller, ok := c.Locals(callerKey).(*services.Caller); ok {
+		return caller
+	}
+	return &services.Caller{}
+}
+
+// callerUser returns the user of a request, the owner of its API token, or
+// empty without one
+func callerUser(c fiber.Ctx) string {
+	return caller(c).User()
+}
+
+// authorized reports whether the caller of a request may use a scope
+func authorized(c fiber.Ctx, scope string) bool {
+	return caller(c).Authorize(scope) == nil
+}
+
+// callerScope returns the scope of the rules, budgets, participants and
+// settlements a request sees or, with the editor role, changes
+func callerScope(c fiber.Ctx, role string) database.Scope {
+	return database.Scope{Member: callerUser(c), Role: role, Admin: authorized(c, models.ScopeAdmin)}
+}
+
+// receiptOwner returns who owns the receipts a request submits: its caller,
+// or the owner an admin asks for
+func receiptOwner(c fiber.Ctx, requested string) (string, error) {
+	return caller(c).OwnerFor(requested)
+}
+
+// unauthorized answers a request that failed authentication or authorization
+func unauthorized(c fiber.Ctx, err error) error {
+	status := statusForError(err)
+	if status == http.StatusUnauthorized {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="ticketer"`)
+	}
+	return c.Status(status).SendString(err.Error())
+}
+
+// bearerToken extracts the token of an Authorization header, empty when
+// there is no header. It fails for headers of other schemes.
+func bearerToken(header string) (string, bool) {
+	if header == "" {
+		return "", true
+	}
+
+	scheme, token, found := strings.Cut(strings.TrimSpace(header), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// ListAPITokens retrieves the API tokens of the caller, or every one for
+// admins, without the tokens themselves
+func (h *TokenHandler) ListAPITokens(c fiber.Ctx) error {
+	tokens, err := h.tokenService.ListAPITokens(c.Context(), caller(c))
+	if err != nil {
+		log.Error("Failed to list API tokens", "error", err)
+		return c.Status(statusForError(err)).SendString("Failed to list API tokens")
+	}
+
+	return c.JSON(tokens)
+}
+
+// CreateAPIToken creates an API token, returning the token this once
+func (h *TokenHandler) CreateAPIToken(c fiber.Ctx) error {
+	var req dto.APITokenRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	token, err := h.tokenService.CreateAPITokenAs(c.Context(), caller(c), req)
+	if err != nil {
+		log.Error("Failed to create API token", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusCreated).JSON(token)
+}
+
+// DeleteAPIToken revokes an API token
+func (h *TokenHandler) DeleteAPIToken(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("API token ID is required")
+	}
+
+	if err := h.tokenService.DeleteAPIToken(c.Context(), caller(c), id); err != nil {
+		log.Error("Failed to revoke API token", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/vieitesss/ticketer/internal/config"
+	"github.com/vieitesss/ticketer/internal/database"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/services"
+)
+
+// tokenRepository holds a single API token
+type tokenRepository struct {
+	database.TokenRepository
+
+	token *models.APIToken
+}
+
+func (r *tokenRepository) HasAPITokens(ctx context.Context) (bool, error) {
+	return r.token != nil, nil
+}
+
+func (r *tokenRepository) GetAPITokenByHash(ctx context.Context, hash string) (*models.APIToken, error) {
+	if r.token == nil || r.token.Hash != hash {
+		return nil, database.ErrTokenNotFound
+	}
+	return r.token, nil
+}
+
+func (r *tokenRepository) TouchAPIToken(ctx context.Context, id string) error {
+	return nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	sum := sha256.Sum256([]byte("tkt_reader"))
+	reader := &models.APIToken{ID: "reader", Owner: "ana", Hash: hex.EncodeToString(sum[:]), Scopes: []string{models.ScopeRead}}
+
+	tests := []struct {
+		name   string
+		token  *models.APIToken // The only token, nil for a fresh install
+		method string
+		target string
+		header string
+		want   int
+	}{
+		{"fresh install", nil, http.MethodGet, "/receipts", "", http.StatusOK},
+		{"fresh install admin", nil, http.MethodGet, "/tokens", "", http.StatusUnauthorized},
+		{"fresh install admin change", nil, http.MethodPost, "/tokens", "", http.StatusUnauthorized},
+		{"anonymous once tokens exist", reader, http.MethodGet, "/receipts", "", http.StatusUnauthorized},
+		{"bearer token", reader, http.MethodGet, "/receipts", "Bearer tkt_reader", http.StatusOK},
+		{"other scheme", reader, http.MethodGet, "/receipts", "Basic dGt0X3JlYWRlcg==", http.StatusUnauthorized},
+		{"missing scope", reader, http.MethodGet, "/tokens", "Bearer tkt_reader", http.StatusForbidden},
+		{"event stream token in the query", reader, http.MethodGet, "/events?access_token=tkt_reader", "", http.StatusOK},
+		{"invalid event stream token", reader, http.MethodGet, "/events?access_token=tkt_unknown", "", http.StatusUnauthorized},
+		{"token in the query elsewhere", reader, http.MethodGet, "/receipts?access_token=tkt_reader", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenService := services.NewTokenService(&tokenRepository{token: tt.token}, &config.Config{})
+			handler := NewTokenHandler(tokenService)
+			ok := func(c fiber.Ctx) error { return c.SendStatus(http.StatusOK) }
+
+			app := fiber.New()
+			app.Use(handler.Authenticate)
+			app.Get("/receipts", RequireScope(models.ScopeRead), ok)
+			app.Get("/events", RequireScope(models.ScopeRead), ok)
+			tokens := app.Group("/tokens", RequireScope(models.ScopeAdmin))
+			tokens.Get("/", ok)
+			tokens.Post("/", ok)
+
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.header)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if resp.StatusCode == http.StatusUnauthorized && resp.Header.Get(fiber.HeaderWWWAuthenticate) == "" {
+				t.Error("401 without a WWW-Authenticate header")
+			}
+		})
+	}
+}
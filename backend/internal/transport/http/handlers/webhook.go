@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/vieitesss/ticketer/internal/services"
+	"github.com/vieitesss/ticketer/internal/transport/dto"
+)
+
+type WebhookHandler struct {
+	webhookService *services.WebhookService
+}
+
+func NewWebhookHandler(webhookService *services.WebhookService) WebhookHandler {
+	return WebhookHandler{
+		webhookService: webhookService,
+	}
+}
+
+// ListWebhooks retrieves the webhooks
+func (h *WebhookHandler) ListWebhooks(c fiber.Ctx) error {
+	webhooks, err := h.webhookService.ListWebhooks(c.Context())
+	if err != nil {
+		log.Error("Failed to list webhooks", "error", err)
+		return c.Status(statusForError(err)).SendString("Failed to list webhooks")
+	}
+
+	return c.JSON(webhooks)
+}
+
+// CreateWebhook subscribes a URL to events, returning its signing secret
+func (h *WebhookHandler) CreateWebhook(c fiber.Ctx) error {
+	var req dto.WebhookRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	webhook, err := h.webhookService.CreateWebhook(c.Context(), req)
+	if err != nil {
+		log.Error("Failed to create webhook", "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusCreated).JSON(webhook)
+}
+
+// UpdateWebhook replaces a webhook
+func (h *WebhookHandler) UpdateWebhook(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Webhook ID is required")
+	}
+
+	var req dto.WebhookRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	webhook, err := h.webhookService.UpdateWebhook(c.Context(), id, req)
+	if err != nil {
+		log.Error("Failed to update webhook", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(webhook)
+}
+
+// DeleteWebhook deletes a webhook
+func (h *WebhookHandler) DeleteWebhook(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Webhook ID is required")
+	}
+
+	if err := h.webhookService.DeleteWebhook(c.Context(), id); err != nil {
+		log.Error("Failed to delete webhook", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries retrieves the delivery log of a webhook, newest
+// first, optionally filtered by status and bounded by limit
+func (h *WebhookHandler) ListWebhookDeliveries(c fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).SendString("Webhook ID is required")
+	}
+
+	limit := 0
+	if limitQuery := c.Query("limit"); limitQuery != "" {
+		if _, err := fmt.Sscanf(limitQuery, "%d", &limit); err != nil {
+			return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("invalid limit %q", limitQuery))
+		}
+	}
+
+	deliveries, err := h.webhookService.ListWebhookDeliveries(c.Context(), id, c.Query("status"), limit)
+	if err != nil {
+		log.Error("Failed to list webhook deliveries", "id", id, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.JSON(deliveries)
+}
+
+// RetryWebhookDelivery queues a delivery of a webhook again
+func (h *WebhookHandler) RetryWebhookDelivery(c fiber.Ctx) error {
+	id, deliveryID := c.Params("id"), c.Params("deliveryId")
+	if id == "" || deliveryID == "" {
+		return c.Status(http.StatusBadRequest).SendString("Webhook and delivery IDs are required")
+	}
+
+	if err := h.webhookService.RetryWebhookDelivery(c.Context(), id, deliveryID); err != nil {
+		log.Error("Failed to retry webhook delivery", "id", id, "delivery", deliveryID, "error", err)
+		return c.Status(statusForError(err)).SendString(err.Error())
+	}
+
+	return c.SendStatus(http.StatusAccepted)
+}
@@ -0,0 +1,19 @@
+package routers
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/http/handlers"
+)
+
+// NewBudgetRouter sets up the routes of the budgets
+func NewBudgetRouter(server fiber.Router, handler handlers.BudgetHandler) {
+	read := handlers.RequireScope(models.ScopeRead)
+	write := handlers.RequireScope(models.ScopeWrite)
+
+	budget := server.Group("/budgets")
+	budget.Get("/", read, handler.ListBudgets)
+	budget.Post("/", write, handler.CreateBudget)
+	budget.Put("/:id", write, handler.UpdateBudget)
+	budget.Delete("/:id", write, handler.DeleteBudget)
+}
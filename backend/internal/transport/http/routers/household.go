@@ -0,0 +1,33 @@
+package routers
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/http/handlers"
+)
+
+// NewHouseholdRouter sets up the routes of the households of the caller, their
+// members and invitations, and of moving receipts between them. The roles of
+// the caller in the households are checked by the handlers.
+func NewHouseholdRouter(server fiber.Router, handler handlers.HouseholdHandler) {
+	read := handlers.RequireScope(models.ScopeRead)
+	write := handlers.RequireScope(models.ScopeWrite)
+
+	household := server.Group("/households")
+	household.Get("/", read, handler.ListHouseholds)
+	household.Post("/", write, handler.CreateHousehold)
+
+	// Invitations of the caller, before the routes of a household
+	household.Get("/invitations", read, handler.ListInvitations)
+	household.Post("/invitations/:id/accept", write, handler.AcceptInvitation)
+	household.Delete("/invitations/:id", write, handler.DeleteInvitation)
+
+	household.Get("/:id", read, handler.GetHousehold)
+	household.Put("/:id", write, handler.RenameHousehold)
+	household.Delete("/:id", write, handler.DeleteHousehold)
+	household.Post("/:id/invitations", write, handler.InviteMember)
+	household.Put("/:id/members/:member", write, handler.SetMemberRole)
+	household.Delete("/:id/members/:member", write, handler.RemoveMember)
+
+	server.Put("/receipts/:id/household", write, handler.MoveReceipt)
+}
@@ -2,17 +2,77 @@ package routers
 
 import (
 	"github.com/gofiber/fiber/v3"
+	"github.com/vieitesss/ticketer/internal/models"
 	"github.com/vieitesss/ticketer/internal/transport/http/handlers"
 )
 
-func NewReceiptRouter(server fiber.Router, handler handlers.ReceiptHandler) {
+// NewReceiptRouter sets up the routes of the receipts and what they are
+// organised by. Every route requires a scope of the caller, checked before its
+// handler, and the routes of a receipt a role in its household, if any.
+func NewReceiptRouter(server fiber.Router, handler handlers.ReceiptHandler, households handlers.HouseholdHandler) {
+	read := handlers.RequireScope(models.ScopeRead)
+	upload := handlers.RequireScope(models.ScopeUpload)
+	write := handlers.RequireScope(models.ScopeWrite)
+	view := households.RequireReceiptRole(models.RoleViewer)
+	edit := households.RequireReceiptRole(models.RoleEditor)
+
 	receipt := server.Group("/receipts")
 
-	receipt.Post("/upload", handler.UploadAndProcess)
-	receipt.Get("/", handler.ListReceipts)
-	receipt.Get("/:id", handler.GetReceipt)
-	receipt.Delete("/:id", handler.DeleteReceipt)
+	receipt.Post("/", write, handler.CreateReceipt)
+	receipt.Post("/upload", upload, handler.UploadAndProcess)
+	receipt.Post("/upload/batch", upload, handler.UploadBatch)
+	receipt.Post("/import", upload, handler.ImportReceipts)
+	receipt.Post("/import/invoice", upload, handler.ImportInvoice)
+	receipt.Post("/reprocess", write, handler.ReprocessReceipts)
+	receipt.Get("/", read, handler.ListReceipts)
+	receipt.Get("/:id", read, view, handler.GetReceipt)
+	receipt.Get("/:id/extraction", read, view, handler.GetExtraction)
+	receipt.Get("/:id/extractions", read, view, handler.ListExtractions)
+	receipt.Patch("/:id", write, edit, handler.UpdateReceipt)
+	receipt.Delete("/:id", write, edit, handler.DeleteReceipt)
+	receipt.Post("/:id/reprocess", write, edit, handler.ReprocessReceipt)
+
+	// Reprocessing review routes
+	editReprocessing := households.RequireReprocessingRole(models.RoleEditor)
+	reprocessing := server.Group("/reprocessings")
+	reprocessing.Post("/:id/accept", write, editReprocessing, handler.AcceptReprocessing)
+	reprocessing.Post("/:id/reject", write, editReprocessing, handler.RejectReprocessing)
+
+	// Category routes
+	category := server.Group("/categories")
+	category.Get("/", read, handler.ListCategories)
+	category.Post("/", write, handler.CreateCategory)
+	category.Get("/spending", read, handler.CategorySpending)
+	category.Post("/recategorize", write, handler.Recategorize)
+	category.Get("/rules", read, handler.ListCategoryRules)
+	category.Post("/rules", write, handler.CreateCategoryRule)
+	category.Delete("/rules/:id", write, handler.DeleteCategoryRule)
+	category.Put("/:id", write, handler.UpdateCategory)
+	category.Delete("/:id", write, handler.DeleteCategory)
+	server.Put("/products/category", write, handler.SetProductCategory)
+
+	// Automation rule routes
+	rule := server.Group("/rules")
+	rule.Get("/", read, handler.ListRules)
+	rule.Post("/", write, handler.CreateRule)
+	rule.Post("/preview", read, handler.PreviewRule)
+	rule.Put("/:id", write, handler.UpdateRule)
+	rule.Delete("/:id", write, handler.DeleteRule)
+
+	// Tag routes
+	tag := server.Group("/tags")
+	tag.Get("/", read, handler.ListTags)
+	tag.Get("/spending", read, handler.TagSpending)
+	tag.Post("/merge", write, handler.MergeTags)
+	tag.Put("/:tag", write, handler.RenameTag)
+	tag.Delete("/:tag", write, handler.DeleteTag)
+
+	// Event stream
+	server.Get("/events", read, handler.StreamEvents)
 
 	// Item routes
-	server.Put("/items/:itemId", handler.UpdateItem)
+	editItem := households.RequireItemRole(models.RoleEditor)
+	server.Put("/items/:itemId", write, editItem, handler.UpdateItem)
+	server.Patch("/items/:itemId", write, editItem, handler.UpdateItemAnnotations)
+	server.Get("/export", read, handler.ExportItems)
 }
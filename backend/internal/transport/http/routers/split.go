@@ -0,0 +1,35 @@
+package routers
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/http/handlers"
+)
+
+// NewSplitRouter sets up the routes of bill splitting: the participants, the
+// splits of receipts and items, and the settlements between participants
+func NewSplitRouter(server fiber.Router, handler handlers.SplitHandler, households handlers.HouseholdHandler) {
+	read := handlers.RequireScope(models.ScopeRead)
+	write := handlers.RequireScope(models.ScopeWrite)
+	view := households.RequireReceiptRole(models.RoleViewer)
+	edit := households.RequireReceiptRole(models.RoleEditor)
+	editItem := households.RequireItemRole(models.RoleEditor)
+
+	participant := server.Group("/participants")
+	participant.Get("/", read, handler.ListParticipants)
+	participant.Post("/", write, handler.CreateParticipant)
+	participant.Delete("/:id", write, handler.DeleteParticipant)
+
+	receipt := server.Group("/receipts")
+	receipt.Get("/:id/split", read, view, handler.GetReceiptSplit)
+	receipt.Put("/:id/split", write, edit, handler.UpdateReceiptSplit)
+	receipt.Delete("/:id/split", write, edit, handler.DeleteReceiptSplit)
+	server.Put("/items/:itemId/split", write, editItem, handler.UpdateItemSplit)
+
+	settlement := server.Group("/settlements")
+	settlement.Get("/", read, handler.ListSettlements)
+	settlement.Post("/", write, handler.CreateSettlement)
+	settlement.Delete("/:id", write, handler.DeleteSettlement)
+
+	server.Get("/splits/balances", read, handler.SplitBalances)
+}
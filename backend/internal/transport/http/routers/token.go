@@ -0,0 +1,15 @@
+package routers
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/vieitesss/ticketer/internal/transport/http/handlers"
+)
+
+// NewTokenRouter sets up the routes of the API tokens. They need a token of
+// any scope: callers manage their own tokens, and admins everyone's.
+func NewTokenRouter(server fiber.Router, handler handlers.TokenHandler) {
+	token := server.Group("/tokens")
+	token.Get("/", handler.ListAPITokens)
+	token.Post("/", handler.CreateAPIToken)
+	token.Delete("/:id", handler.DeleteAPIToken)
+}
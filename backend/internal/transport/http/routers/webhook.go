@@ -0,0 +1,19 @@
+package routers
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/vieitesss/ticketer/internal/models"
+	"github.com/vieitesss/ticketer/internal/transport/http/handlers"
+)
+
+// NewWebhookRouter sets up the routes of the webhooks and their deliveries,
+// which only admin tokens may use
+func NewWebhookRouter(server fiber.Router, handler handlers.WebhookHandler) {
+	webhook := server.Group("/webhooks", handlers.RequireScope(models.ScopeAdmin))
+	webhook.Get("/", handler.ListWebhooks)
+	webhook.Post("/", handler.CreateWebhook)
+	webhook.Put("/:id", handler.UpdateWebhook)
+	webhook.Delete("/:id", handler.DeleteWebhook)
+	webhook.Get("/:id/deliveries", handler.ListWebhookDeliveries)
+	webhook.Post("/:id/deliveries/:deliveryId/retry", handler.RetryWebhookDelivery)
+}
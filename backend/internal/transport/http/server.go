@@ -8,21 +8,28 @@ import (
 	"github.com/gofiber/fiber/v3/middleware/logger"
 )
 
-func NewServer() *fiber.App {
-	app := fiber.New()
+// bodyLimit allows batch uploads of many receipts, or large ZIP archives
+const bodyLimit = 200 << 20
+
+// NewServer creates the HTTP server. Every request goes through authenticate,
+// which identifies its caller for the routes to check their scopes.
+func NewServer(authenticate fiber.Handler) *fiber.App {
+	app := fiber.New(fiber.Config{
+		BodyLimit: bodyLimit,
+	})
 
 	if os.Getenv("IS_DEV") == "true" {
 		app.Use(cors.New(cors.Config{
 			AllowOrigins: []string{"http://localhost:3000"},
 			AllowHeaders: []string{"Content-Type", "Authorization"},
-			AllowMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 			MaxAge:       3600,
 		}))
 	} else {
 		app.Use(cors.New(cors.Config{
 			AllowOrigins:     []string{"https://kedada.fun"},
 			AllowHeaders:     []string{"Content-Type", "Authorization"},
-			AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 			AllowCredentials: true,
 			MaxAge:           86400,
 		}))
@@ -50,5 +57,7 @@ func NewServer() *fiber.App {
 		},
 	}))
 
+	app.Use(authenticate)
+
 	return app
 }